@@ -0,0 +1,184 @@
+// Package accesslog writes a record for every connection tunneled by the SNI
+// proxy to a file that is rotated when it grows too large.
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Entry is a single access log record.  It is written on a single line in the
+// format from [Config.Format].
+type Entry struct {
+	// Time is the time the tunnel was established.
+	Time time.Time `json:"time"`
+
+	// ClientIP is the IP address of the client.
+	ClientIP string `json:"client_ip"`
+
+	// Listener is the label of the listener that accepted the connection.
+	Listener string `json:"listener"`
+
+	// Host is the hostname from SNI or the Host header.
+	Host string `json:"host"`
+
+	// RemoteAddr is the address the connection was tunneled to, i.e. the host
+	// and the port.
+	RemoteAddr string `json:"remote_addr"`
+
+	// BackendAddr is the address of the backend connection.  For forwarded
+	// connections, it is the address of the forward proxy.
+	BackendAddr string `json:"backend_addr,omitempty"`
+
+	// Duration is the time the tunnel was open in milliseconds.
+	Duration int64 `json:"duration_ms"`
+
+	// BytesReceived is the number of bytes received from the remote host.
+	BytesReceived int64 `json:"bytes_received"`
+
+	// BytesSent is the number of bytes sent to the remote host.
+	BytesSent int64 `json:"bytes_sent"`
+
+	// ID is the connection ID in the operational log of sniproxy.
+	ID uint64 `json:"id"`
+
+	// Forwarded is true if the connection was tunneled through a forward
+	// proxy.
+	Forwarded bool `json:"forwarded,omitempty"`
+
+	// Method is the method of the HTTP request.  The HTTP fields are only set
+	// for plain HTTP connections.
+	Method string `json:"method,omitempty"`
+
+	// RequestURI is the request target from the HTTP request line.
+	RequestURI string `json:"request_uri,omitempty"`
+
+	// Proto is the protocol version from the HTTP request line, e.g.
+	// "HTTP/1.1".
+	Proto string `json:"proto,omitempty"`
+
+	// Status is the status code of the first HTTP response or zero if it is
+	// unknown.
+	Status int `json:"status,omitempty"`
+
+	// Referer is the Referer header of the HTTP request.
+	Referer string `json:"referer,omitempty"`
+
+	// UserAgent is the User-Agent header of the HTTP request.
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// Supported values of [Config.Format].
+const (
+	// FormatJSON writes every entry as a JSON object.
+	FormatJSON = "json"
+
+	// FormatCombined writes every entry in the Combined Log Format of Apache
+	// HTTP Server.
+	FormatCombined = "combined"
+)
+
+// Config is the access log configuration.
+type Config struct {
+	// Path is the path to the access log file.
+	Path string
+
+	// MaxSize is the size in bytes after which the file is rotated, i.e.
+	// renamed to "<Path>.<timestamp>-<seq>" and replaced with a new one, where
+	// seq tells apart the files rotated within the same millisecond.  If not
+	// set, the file is never rotated.
+	MaxSize int64
+
+	// MaxBackups is the maximum number of rotated files that are kept, the
+	// oldest ones are removed.  If not set, all of them are kept.
+	MaxBackups int
+
+	// Compress makes the rotated files compressed with gzip.  Compression
+	// happens in the background right after the rotation.
+	Compress bool
+
+	// Format is the format of the entries, either [FormatJSON] or
+	// [FormatCombined].  If not set, FormatJSON is used.
+	Format string
+}
+
+// Logger writes access log entries.  A nil *Logger discards all entries, so
+// that the callers don't need to check whether the access log is enabled.  It
+// is safe for concurrent use.
+type Logger struct {
+	// mu serializes the writes.
+	mu sync.Mutex
+
+	// w is the rotated file.
+	w *rotatingFile
+
+	// encode returns the line for the entry without the trailing newline.
+	encode func(e *Entry) (b []byte, err error)
+}
+
+// type check
+var _ io.Closer = (*Logger)(nil)
+
+// New creates a new *Logger writing to the file from conf.
+func New(conf *Config) (l *Logger, err error) {
+	var encode func(e *Entry) (b []byte, err error)
+	switch conf.Format {
+	case "", FormatJSON:
+		encode = encodeJSON
+	case FormatCombined:
+		encode = encodeCombined
+	default:
+		return nil, fmt.Errorf("accesslog: unsupported format %q", conf.Format)
+	}
+
+	w, err := openRotatingFile(conf)
+	if err != nil {
+		return nil, fmt.Errorf("accesslog: opening %q: %w", conf.Path, err)
+	}
+
+	return &Logger{w: w, encode: encode}, nil
+}
+
+// Log writes the entry to the file.  Write errors are returned but don't
+// prevent writing further entries.
+func (l *Logger) Log(e *Entry) (err error) {
+	if l == nil {
+		return nil
+	}
+
+	b, err := l.encode(e)
+	if err != nil {
+		return fmt.Errorf("accesslog: encoding entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.w.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("accesslog: writing entry: %w", err)
+	}
+
+	return nil
+}
+
+// Close implements the [io.Closer] interface for *Logger.  It waits for the
+// compression of the rotated files to finish.
+func (l *Logger) Close() (err error) {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Close()
+}
+
+// encodeJSON encodes the entry as a JSON object.
+func encodeJSON(e *Entry) (b []byte, err error) {
+	return json.Marshal(e)
+}
@@ -0,0 +1,85 @@
+package accesslog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// combinedTimeFormat is the format of the timestamp in the Combined Log
+// Format.
+const combinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// encodeCombined encodes the entry in the Combined Log Format:
+//
+//	host - - [time] "request" status bytes "referer" "user-agent"
+//
+// The bytes are the ones received from the remote host.  Entries of TLS
+// tunnels have no HTTP request, so the request is written as if it was a
+// CONNECT to the remote address and the status is unknown.
+func encodeCombined(e *Entry) (b []byte, err error) {
+	method, uri, proto := e.Method, e.RequestURI, e.Proto
+	if method == "" {
+		method, uri, proto = "CONNECT", e.RemoteAddr, "HTTP/1.1"
+	}
+
+	status := "-"
+	if e.Status != 0 {
+		status = strconv.Itoa(e.Status)
+	}
+
+	size := "-"
+	if e.BytesReceived != 0 {
+		size = strconv.FormatInt(e.BytesReceived, 10)
+	}
+
+	line := fmt.Sprintf(
+		"%s - - [%s] %s %s %s %s %s",
+		orDash(e.ClientIP),
+		e.Time.Format(combinedTimeFormat),
+		quote(method+" "+uri+" "+proto),
+		status,
+		size,
+		quote(e.Referer),
+		quote(e.UserAgent),
+	)
+
+	return []byte(line), nil
+}
+
+// orDash returns s or "-" if it is empty, the way missing values are written
+// in the Combined Log Format.
+func orDash(s string) (res string) {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// quote returns s in double quotes with the quotes, backslashes and control
+// characters escaped so that a field can't break the line.  An empty s is
+// written as "-".
+func quote(s string) (res string) {
+	if s == "" {
+		return `"-"`
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(sb, "\\x%02x", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+
+	return sb.String()
+}
@@ -0,0 +1,97 @@
+package accesslog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeCombined(t *testing.T) {
+	ts := time.Date(2024, time.March, 5, 14, 7, 9, 0, time.FixedZone("", 3*60*60))
+
+	testCases := []struct {
+		name  string
+		entry *Entry
+		want  string
+	}{{
+		name: "http",
+		entry: &Entry{
+			Time:          ts,
+			ClientIP:      "192.0.2.1",
+			RemoteAddr:    "example.org:80",
+			BytesReceived: 1234,
+			Method:        "GET",
+			RequestURI:    "/index.html?q=1",
+			Proto:         "HTTP/1.1",
+			Status:        200,
+			Referer:       "https://example.com/",
+			UserAgent:     "curl/8.0",
+		},
+		want: `192.0.2.1 - - [05/Mar/2024:14:07:09 +0300] "GET /index.html?q=1 HTTP/1.1" ` +
+			`200 1234 "https://example.com/" "curl/8.0"`,
+	}, {
+		name: "tls",
+		entry: &Entry{
+			Time:          ts,
+			ClientIP:      "192.0.2.1",
+			RemoteAddr:    "example.org:443",
+			BytesReceived: 0,
+		},
+		want: `192.0.2.1 - - [05/Mar/2024:14:07:09 +0300] "CONNECT example.org:443 HTTP/1.1" ` +
+			`- - "-" "-"`,
+	}, {
+		name: "escaped",
+		entry: &Entry{
+			Time:       ts,
+			ClientIP:   "",
+			RemoteAddr: "example.org:80",
+			Method:     "GET",
+			RequestURI: "/",
+			Proto:      "HTTP/1.1",
+			UserAgent:  "evil\"agent\\\n",
+		},
+		want: `- - - [05/Mar/2024:14:07:09 +0300] "GET / HTTP/1.1" - - "-" ` +
+			`"evil\"agent\\\x0a"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := encodeCombined(tc.entry)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, string(b))
+		})
+	}
+}
+
+func TestLogger_combined(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	l, err := New(&Config{Path: path, Format: FormatCombined})
+	require.NoError(t, err)
+
+	require.NoError(t, l.Log(&Entry{
+		Time:       time.Date(2024, time.March, 5, 14, 7, 9, 0, time.UTC),
+		ClientIP:   "192.0.2.1",
+		RemoteAddr: "example.org:443",
+	}))
+	require.NoError(t, l.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		`192.0.2.1 - - [05/Mar/2024:14:07:09 +0000] "CONNECT example.org:443 HTTP/1.1" - - "-" "-"`+"\n",
+		string(b),
+	)
+}
+
+func TestNew_format(t *testing.T) {
+	_, err := New(&Config{Path: filepath.Join(t.TempDir(), "access.log"), Format: "xml"})
+	assert.ErrorContains(t, err, `unsupported format "xml"`)
+}
@@ -0,0 +1,319 @@
+package accesslog
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// backupTimeFormat is the format of the timestamp appended to the names of
+// the rotated files.  It is followed by the sequence number of the rotation
+// within the same millisecond, see [backupName], so that the names are unique
+// and sort in the order of rotation.
+const backupTimeFormat = "20060102-150405.000"
+
+// compressedExt is the extension added to the rotated files once they are
+// compressed.
+const compressedExt = ".gz"
+
+// rotatingFile is an [io.WriteCloser] that writes to a file and rotates it
+// once it grows larger than the limit.  It is not safe for concurrent use.
+type rotatingFile struct {
+	// file is the current file.
+	file *os.File
+
+	// rotated signals the background goroutine that there are rotated files
+	// to compress and prune.  It has a buffer of one, so that the signals of
+	// several rotations that happen while the goroutine is busy coalesce and
+	// rotation never waits for it.
+	rotated chan struct{}
+
+	// wg tracks the background goroutine.
+	wg sync.WaitGroup
+
+	path       string
+	size       int64
+	maxSize    int64
+	maxBackups int
+	compress   bool
+}
+
+// type check
+var _ io.WriteCloser = (*rotatingFile)(nil)
+
+// openRotatingFile opens the file for appending and starts the goroutine that
+// processes the rotated files.
+func openRotatingFile(conf *Config) (f *rotatingFile, err error) {
+	f = &rotatingFile{
+		rotated:    make(chan struct{}, 1),
+		path:       conf.Path,
+		maxSize:    conf.MaxSize,
+		maxBackups: conf.MaxBackups,
+		compress:   conf.Compress,
+	}
+
+	err = f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	f.wg.Add(1)
+	go f.processRotated()
+
+	return f, nil
+}
+
+// open opens the current file and gets its size.
+func (f *rotatingFile) open() (err error) {
+	f.file, err = os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.file.Stat()
+	if err != nil {
+		return errors.Join(err, f.file.Close())
+	}
+
+	f.size = fi.Size()
+
+	return nil
+}
+
+// Write implements the [io.Writer] interface for *rotatingFile.  The file is
+// rotated before b is written if b doesn't fit into it.
+func (f *rotatingFile) Write(b []byte) (n int, err error) {
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(b)) > f.maxSize {
+		err = f.rotate()
+		if err != nil {
+			return 0, fmt.Errorf("rotating: %w", err)
+		}
+	}
+
+	n, err = f.file.Write(b)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// rotate renames the current file and opens a new one.
+func (f *rotatingFile) rotate() (err error) {
+	err = f.file.Close()
+	if err != nil {
+		return err
+	}
+
+	backup := f.backupPath(time.Now())
+	err = os.Rename(f.path, backup)
+	if err != nil {
+		// Keep writing to the same file rather than losing the entries.
+		return errors.Join(err, f.open())
+	}
+
+	err = f.open()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case f.rotated <- struct{}{}:
+	default:
+		// The goroutine is already signaled and handles this file as well.
+	}
+
+	return nil
+}
+
+// backupPath returns the path the current file is renamed to when it is
+// rotated at now.  The sequence number is increased until the path is not
+// taken by another file rotated in the same millisecond, compressed or not.
+func (f *rotatingFile) backupPath(now time.Time) (path string) {
+	for seq := 0; ; seq++ {
+		path = f.path + "." + backupName(now, seq)
+		if !exists(path) && !exists(path+compressedExt) {
+			return path
+		}
+	}
+}
+
+// backupName returns the suffix of the rotated file that is rotated at t with
+// the sequence number seq.
+func backupName(t time.Time, seq int) (name string) {
+	return fmt.Sprintf("%s-%03d", t.Format(backupTimeFormat), seq)
+}
+
+// exists checks if there is a file at path.
+func exists(path string) (ok bool) {
+	_, err := os.Lstat(path)
+
+	return err == nil
+}
+
+// processRotated compresses the rotated files if needed and removes the
+// oldest ones every time it is signaled.  It returns once f.rotated is closed.
+func (f *rotatingFile) processRotated() {
+	defer f.wg.Done()
+
+	for range f.rotated {
+		backups, err := f.backups()
+		if err != nil {
+			log.Error("accesslog: listing rotated files: %v", err)
+
+			continue
+		}
+
+		if f.compress {
+			backups = compressBackups(backups)
+		}
+
+		err = f.prune(backups)
+		if err != nil {
+			log.Error("accesslog: removing old files: %v", err)
+		}
+	}
+}
+
+// backups returns the paths of the rotated files from the oldest to the
+// newest.
+func (f *rotatingFile) backups() (paths []string, err error) {
+	dir := filepath.Dir(f.path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(f.path) + "."
+	for _, e := range entries {
+		if isBackup(e, prefix) {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// compressBackups compresses the rotated files that aren't compressed yet and
+// returns the paths of the rotated files after that.  The files that fail to
+// compress are left as they are.
+func compressBackups(backups []string) (paths []string) {
+	paths = make([]string, 0, len(backups))
+	for _, backup := range backups {
+		if strings.HasSuffix(backup, compressedExt) {
+			paths = append(paths, backup)
+
+			continue
+		}
+
+		err := compressFile(backup)
+		if err != nil {
+			log.Error("accesslog: compressing %s: %v", backup, err)
+			paths = append(paths, backup)
+
+			continue
+		}
+
+		paths = append(paths, backup+compressedExt)
+	}
+
+	return paths
+}
+
+// prune removes the oldest of the rotated files so that at most f.maxBackups
+// are left.  backups must be sorted from the oldest to the newest.
+func (f *rotatingFile) prune(backups []string) (err error) {
+	if f.maxBackups <= 0 || len(backups) <= f.maxBackups {
+		return nil
+	}
+
+	var errs []error
+	for _, path := range backups[:len(backups)-f.maxBackups] {
+		errs = append(errs, os.Remove(path))
+	}
+
+	return errors.Join(errs...)
+}
+
+// isBackup checks if the directory entry is a rotated file, i.e. if its name
+// is prefix followed by the timestamp, the sequence number and, optionally,
+// [compressedExt].  The files rotated by the previous versions have no
+// sequence number.
+func isBackup(e os.DirEntry, prefix string) (ok bool) {
+	name, ok := strings.CutPrefix(e.Name(), prefix)
+	if !ok || e.IsDir() {
+		return false
+	}
+
+	name = strings.TrimSuffix(name, compressedExt)
+	if _, err := time.Parse(backupTimeFormat, name); err == nil {
+		return true
+	}
+
+	sep := strings.LastIndexByte(name, '-')
+	if sep < 0 {
+		return false
+	}
+
+	ts, seq := name[:sep], name[sep+1:]
+	if _, err := strconv.Atoi(seq); err != nil {
+		return false
+	}
+
+	_, err := time.Parse(backupTimeFormat, ts)
+
+	return err == nil
+}
+
+// compressFile compresses the file at path with gzip into a file with the
+// same name and [compressedExt] appended and removes the original.
+func compressFile(path string) (err error) {
+	dstPath := path + compressedExt
+	err = gzipFile(dstPath, path)
+	if err != nil {
+		// Don't leave a broken archive next to the original.
+		_ = os.Remove(dstPath)
+
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// gzipFile writes the contents of the file at srcPath compressed with gzip to
+// the file at dstPath.
+func gzipFile(dstPath, srcPath string) (err error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, src.Close()) }()
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+
+	return errors.Join(err, zw.Close(), dst.Close())
+}
+
+// Close implements the [io.Closer] interface for *rotatingFile.
+func (f *rotatingFile) Close() (err error) {
+	close(f.rotated)
+	f.wg.Wait()
+
+	return f.file.Close()
+}
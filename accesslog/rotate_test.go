@@ -0,0 +1,160 @@
+package accesslog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readBackup returns the contents of the rotated file at path, decompressed if
+// needed.
+func readBackup(t *testing.T, path string) (b []byte) {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	if !strings.HasSuffix(path, compressedExt) {
+		return b
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+
+	b, err = io.ReadAll(zr)
+	require.NoError(t, err)
+
+	return b
+}
+
+func TestRotatingFile(t *testing.T) {
+	const (
+		line    = "0123456789abcdef\n"
+		entries = 20
+	)
+
+	testCases := []struct {
+		name        string
+		maxBackups  int
+		compress    bool
+		wantBackups int
+	}{{
+		name:        "plain",
+		maxBackups:  0,
+		compress:    false,
+		wantBackups: entries - 1,
+	}, {
+		name:        "compress",
+		maxBackups:  0,
+		compress:    true,
+		wantBackups: entries - 1,
+	}, {
+		name:        "max_backups",
+		maxBackups:  3,
+		compress:    false,
+		wantBackups: 3,
+	}, {
+		name:        "max_backups_compress",
+		maxBackups:  3,
+		compress:    true,
+		wantBackups: 3,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "access.log")
+
+			// Every entry rotates the file, most of them within the same
+			// millisecond.
+			f, err := openRotatingFile(&Config{
+				Path:       path,
+				MaxSize:    int64(len(line)),
+				MaxBackups: tc.maxBackups,
+				Compress:   tc.compress,
+			})
+			require.NoError(t, err)
+
+			for i := 0; i < entries; i++ {
+				_, err = f.Write([]byte(line))
+				require.NoError(t, err)
+			}
+
+			require.NoError(t, f.Close())
+
+			backups, err := f.backups()
+			require.NoError(t, err)
+			require.Len(t, backups, tc.wantBackups)
+
+			for _, b := range backups {
+				assert.Equal(t, tc.compress, strings.HasSuffix(b, compressedExt), b)
+				assert.Equal(t, line, string(readBackup(t, b)), b)
+			}
+
+			current, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, line, string(current))
+		})
+	}
+}
+
+func TestIsBackup(t *testing.T) {
+	testCases := []struct {
+		name string
+		file string
+		want bool
+	}{{
+		name: "plain",
+		file: "access.log.20240102-030405.678-000",
+		want: true,
+	}, {
+		name: "compressed",
+		file: "access.log.20240102-030405.678-012.gz",
+		want: true,
+	}, {
+		name: "no_seq",
+		file: "access.log.20240102-030405.678",
+		want: true,
+	}, {
+		name: "bad_seq",
+		file: "access.log.20240102-030405.678-abc",
+		want: false,
+	}, {
+		name: "bad_time",
+		file: "access.log.2024-000",
+		want: false,
+	}, {
+		name: "other_prefix",
+		file: "error.log.20240102-030405.678-000",
+		want: false,
+	}, {
+		name: "current",
+		file: "access.log",
+		want: false,
+	}}
+
+	dir := t.TempDir()
+	for _, tc := range testCases {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, tc.file), nil, 0o600))
+	}
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	got := map[string]bool{}
+	for _, e := range entries {
+		got[e.Name()] = isBackup(e, "access.log.")
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, got[tc.file])
+		})
+	}
+}
@@ -0,0 +1,192 @@
+// Package audit writes security-relevant events, e.g. blocked connections and
+// dropped DNS queries, to a dedicated sink separate from the operational log.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Sources of the events.
+const (
+	SourceSNIProxy = "sniproxy"
+	SourceDNSProxy = "dnsproxy"
+)
+
+// Actions of the events.
+const (
+	// ActionBlock means that the connection was closed right away.
+	ActionBlock = "block"
+
+	// ActionDrop means that the connection was held open without tunneling or
+	// that the DNS query was left unanswered.
+	ActionDrop = "drop"
+
+	// ActionRefuse means that the DNS query was answered with REFUSED.
+	ActionRefuse = "refuse"
+)
+
+// SyslogSink is the sink value that makes the logger write to the local syslog
+// daemon.
+const SyslogSink = "syslog"
+
+// Event is a single audit record.  It is written as a JSON object on a single
+// line.
+type Event struct {
+	// Time is the time of the event.  If not set, the current time is used.
+	Time time.Time `json:"time"`
+
+	// Source is the component that made the decision, either SourceSNIProxy
+	// or SourceDNSProxy.
+	Source string `json:"source"`
+
+	// Action is what happened to the connection or query, e.g. ActionBlock.
+	Action string `json:"action"`
+
+	// ClientIP is the IP address of the client.
+	ClientIP string `json:"client_ip"`
+
+	// Destination is the requested host or the queried domain name.
+	Destination string `json:"destination"`
+
+	// Rule is the rule that caused the decision.  It is empty if the decision
+	// was not made by a rule.
+	Rule string `json:"rule,omitempty"`
+
+	// Reason is a short description of the decision for the events that are
+	// not caused by a rule, e.g. "rate limit".
+	Reason string `json:"reason,omitempty"`
+
+	// ID is the connection ID in the operational log of sniproxy.
+	ID uint64 `json:"id,omitempty"`
+}
+
+// queueSize is the number of events waiting to be written to the sink.  The
+// events that don't fit are dropped.
+const queueSize = 1024
+
+// Logger writes audit events to the sink.  A nil *Logger discards all events,
+// so that the callers don't need to check whether auditing is enabled.  It is
+// safe for concurrent use.
+//
+// Logging never blocks the callers, e.g. the rate limiter refusing a flood of
+// queries: the events are written by a separate goroutine and the ones that
+// the goroutine can't keep up with are dropped.
+type Logger struct {
+	// w is the sink.
+	w io.WriteCloser
+
+	// queue is the queue of the encoded events to write.
+	queue chan []byte
+
+	// wg tracks the writing goroutine.
+	wg sync.WaitGroup
+
+	// mu protects closed, so that the events logged while the logger is being
+	// closed aren't sent to the closed queue.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// type check
+var _ io.Closer = (*Logger)(nil)
+
+// New creates a new *Logger writing to sink.  sink is either SyslogSink or the
+// path to the file that the events are appended to.
+func New(sink string) (l *Logger, err error) {
+	var w io.WriteCloser
+	if sink == SyslogSink {
+		w, err = newSyslogWriter()
+	} else {
+		w, err = os.OpenFile(sink, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("audit: opening sink %q: %w", sink, err)
+	}
+
+	return newLogger(w, queueSize), nil
+}
+
+// newLogger creates a new *Logger writing to w with a queue of size events
+// and starts the writing goroutine.
+func newLogger(w io.WriteCloser, size int) (l *Logger) {
+	l = &Logger{
+		w:     w,
+		queue: make(chan []byte, size),
+	}
+
+	l.wg.Add(1)
+	go l.write()
+
+	return l
+}
+
+// Log queues the event to be written to the sink.  If the queue is full, the
+// event is dropped.  The write errors are logged to the operational log.
+func (l *Logger) Log(e *Event) (err error) {
+	if l == nil {
+		return nil
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("audit: encoding event: %w", err)
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return nil
+	}
+
+	select {
+	case l.queue <- append(b, '\n'):
+	default:
+		log.Debug("audit: queue is full, event is dropped")
+	}
+
+	return nil
+}
+
+// write writes the queued events until the queue is closed.
+func (l *Logger) write() {
+	defer l.wg.Done()
+
+	for b := range l.queue {
+		_, err := l.w.Write(b)
+		if err != nil {
+			log.Error("audit: writing event: %v", err)
+		}
+	}
+}
+
+// Close implements the [io.Closer] interface for *Logger.  It writes the
+// queued events and closes the sink.
+func (l *Logger) Close() (err error) {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.mu.Unlock()
+
+	l.wg.Wait()
+
+	return l.w.Close()
+}
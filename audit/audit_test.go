@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger_Log(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l, err := New(path)
+	require.NoError(t, err)
+
+	events := []*Event{{
+		Time:        time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		Source:      SourceSNIProxy,
+		Action:      ActionBlock,
+		ClientIP:    "192.0.2.1",
+		Destination: "example.org",
+		Rule:        "*.example.org",
+		ID:          1,
+	}, {
+		Time:        time.Date(2023, 1, 2, 3, 4, 6, 0, time.UTC),
+		Source:      SourceDNSProxy,
+		Action:      ActionRefuse,
+		ClientIP:    "192.0.2.2",
+		Destination: "example.com.",
+		Reason:      "rate limit",
+	}}
+
+	for _, e := range events {
+		require.NoError(t, l.Log(e))
+	}
+
+	// Close writes the queued events.
+	require.NoError(t, l.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+
+	var got []*Event
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		e := &Event{}
+		require.NoError(t, json.Unmarshal(s.Bytes(), e))
+		got = append(got, e)
+	}
+	require.NoError(t, s.Err())
+
+	assert.Equal(t, events, got)
+}
+
+// blockingWriter is an io.WriteCloser that blocks the writes until unblock is
+// closed and counts the written events.
+type blockingWriter struct {
+	unblock chan struct{}
+
+	// mu protects writes.
+	mu     sync.Mutex
+	writes int
+}
+
+// Write implements the io.Writer interface for *blockingWriter.
+func (w *blockingWriter) Write(p []byte) (n int, err error) {
+	<-w.unblock
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writes++
+
+	return len(p), nil
+}
+
+// Close implements the io.Closer interface for *blockingWriter.
+func (w *blockingWriter) Close() (err error) {
+	return nil
+}
+
+func TestLogger_Log_overflow(t *testing.T) {
+	const size = 4
+
+	w := &blockingWriter{unblock: make(chan struct{})}
+	l := newLogger(w, size)
+
+	// The writing goroutine takes one event and blocks on it, so the queue
+	// holds size more.  Log must neither block nor fail on the rest.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 10*size; i++ {
+			assert.NoError(t, l.Log(&Event{Action: ActionRefuse}))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("log blocked on a slow sink")
+	}
+
+	close(w.unblock)
+	require.NoError(t, l.Close())
+
+	assert.GreaterOrEqual(t, w.writes, size)
+	assert.LessOrEqual(t, w.writes, size+1)
+}
+
+func TestLogger_nil(t *testing.T) {
+	var l *Logger
+
+	assert.NoError(t, l.Log(&Event{Action: ActionBlock}))
+	assert.NoError(t, l.Close())
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+
+package audit
+
+import (
+	"errors"
+	"io"
+)
+
+// newSyslogWriter returns an error as syslog is not supported on this
+// platform.
+func newSyslogWriter() (w io.WriteCloser, err error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,13 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// newSyslogWriter returns a writer to the local syslog daemon.
+func newSyslogWriter() (w io.WriteCloser, err error) {
+	return syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTH, "sniproxy")
+}
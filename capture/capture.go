@@ -0,0 +1,199 @@
+// Package capture writes the first bytes of the client connections that the
+// proxy failed to parse to pcap files, so that they can be inspected with the
+// usual tools like Wireshark.
+package capture
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// fileExt is the extension of the capture files.
+const fileExt = ".pcap"
+
+// filePrefix is the prefix of the capture file names.
+const filePrefix = "handshake-"
+
+// fileTimeFormat is the format of the timestamp in the capture file names.
+// The names sort in the order of capturing.
+const fileTimeFormat = "20060102-150405.000000"
+
+// Config is the capture configuration.
+type Config struct {
+	// Dir is the directory the capture files are written to.  It is created
+	// if it doesn't exist.
+	Dir string
+
+	// MaxBytes is the maximum number of bytes of a connection that are
+	// captured, the rest is truncated.
+	MaxBytes int
+
+	// MaxFiles is the maximum number of capture files that are kept in Dir,
+	// the oldest ones are removed.  If not set, all of them are kept.
+	MaxFiles int
+
+	// PerMinute is the maximum number of captures per minute.  Failed
+	// handshakes above the limit aren't captured.  If not set, the number of
+	// captures is not limited.
+	PerMinute float64
+}
+
+// Capture is a captured connection.
+type Capture struct {
+	// Time is the time the connection was captured.
+	Time time.Time
+
+	// Client is the address of the client.
+	Client netip.AddrPort
+
+	// Local is the address of the proxy the client connected to.
+	Local netip.AddrPort
+
+	// Data are the bytes the client sent.
+	Data []byte
+}
+
+// Capturer writes the captures to the files.  A nil *Capturer discards all
+// captures, so that the callers don't need to check whether capturing is
+// enabled.  It is safe for concurrent use.
+type Capturer struct {
+	// mu serializes writing and removing the files.
+	mu sync.Mutex
+
+	// limiter limits the rate of captures.  It is nil if the rate is not
+	// limited.
+	limiter *rate.Limiter
+
+	dir      string
+	maxBytes int
+	maxFiles int
+}
+
+// New creates a new *Capturer writing to the directory from conf.
+func New(conf *Config) (c *Capturer, err error) {
+	if conf.MaxBytes <= 0 {
+		return nil, fmt.Errorf("capture: max bytes must be positive, got %d", conf.MaxBytes)
+	}
+
+	err = os.MkdirAll(conf.Dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("capture: creating directory: %w", err)
+	}
+
+	c = &Capturer{
+		dir:      conf.Dir,
+		maxBytes: conf.MaxBytes,
+		maxFiles: conf.MaxFiles,
+	}
+
+	if conf.PerMinute > 0 {
+		burst := int(conf.PerMinute)
+		if burst < 1 {
+			burst = 1
+		}
+
+		c.limiter = rate.NewLimiter(rate.Limit(conf.PerMinute/60), burst)
+	}
+
+	return c, nil
+}
+
+// Write writes the capture to a new file and removes the oldest files if
+// there are too many of them.  path is the path to the written file, it is
+// empty if the capture is skipped because of the rate limit or because there
+// is no data.
+func (c *Capturer) Write(capt *Capture) (path string, err error) {
+	if c == nil || len(capt.Data) == 0 || (c.limiter != nil && !c.limiter.Allow()) {
+		return "", nil
+	}
+
+	data := capt.Data
+	if len(data) > c.maxBytes {
+		data = data[:c.maxBytes]
+	}
+
+	name := fmt.Sprintf(
+		"%s%s-%s%s",
+		filePrefix,
+		capt.Time.UTC().Format(fileTimeFormat),
+		strings.NewReplacer(":", "_", "[", "", "]", "").Replace(capt.Client.String()),
+		fileExt,
+	)
+	path = filepath.Join(c.dir, name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = writeFile(path, capt, data)
+	if err != nil {
+		return "", fmt.Errorf("capture: writing %s: %w", path, err)
+	}
+
+	err = c.prune()
+	if err != nil {
+		return path, fmt.Errorf("capture: removing old files: %w", err)
+	}
+
+	return path, nil
+}
+
+// writeFile writes the pcap file with data sent by the client of capt to
+// path.
+func writeFile(path string, capt *Capture, data []byte) (err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	err = writePcap(f, capt.Time, capt.Client, capt.Local, data)
+	if err != nil {
+		_ = f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// prune removes the oldest capture files so that at most c.maxFiles are left.
+func (c *Capturer) prune() (err error) {
+	if c.maxFiles <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileExt) {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) <= c.maxFiles {
+		return nil
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names[:len(names)-c.maxFiles] {
+		err = os.Remove(filepath.Join(c.dir, name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
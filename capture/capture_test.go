@@ -0,0 +1,126 @@
+package capture
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pcapOverhead is the size of the pcap headers and the IPv4 and TCP headers
+// of the single packet in a capture file.
+const pcapOverhead = 24 + 16 + ipv4HeaderLen + tcpHeaderLen
+
+// newCapture returns a capture of data from a client with the given port, so
+// that the file names differ.
+func newCapture(port uint16, data []byte) (capt *Capture) {
+	return &Capture{
+		Time:   time.Now(),
+		Client: netip.AddrPortFrom(netip.MustParseAddr("192.0.2.1"), port),
+		Local:  netip.MustParseAddrPort("192.0.2.2:443"),
+		Data:   data,
+	}
+}
+
+func TestCapturer_Write(t *testing.T) {
+	data := bytes.Repeat([]byte{0x16}, 64)
+
+	testCases := []struct {
+		conf      *Config
+		data      []byte
+		name      string
+		captures  int
+		wantFiles int
+		wantSize  int
+	}{{
+		conf:      &Config{MaxBytes: 1024},
+		data:      data,
+		name:      "all",
+		captures:  5,
+		wantFiles: 5,
+		wantSize:  len(data),
+	}, {
+		conf:      &Config{MaxBytes: 16},
+		data:      data,
+		name:      "truncated",
+		captures:  1,
+		wantFiles: 1,
+		wantSize:  16,
+	}, {
+		conf:      &Config{MaxBytes: 1024, PerMinute: 2},
+		data:      data,
+		name:      "rate_limited",
+		captures:  5,
+		wantFiles: 2,
+		wantSize:  len(data),
+	}, {
+		conf:      &Config{MaxBytes: 1024, MaxFiles: 3},
+		data:      data,
+		name:      "max_files",
+		captures:  5,
+		wantFiles: 3,
+		wantSize:  len(data),
+	}, {
+		conf:      &Config{MaxBytes: 1024},
+		data:      nil,
+		name:      "no_data",
+		captures:  1,
+		wantFiles: 0,
+		wantSize:  0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.conf.Dir = filepath.Join(t.TempDir(), "captures")
+
+			c, err := New(tc.conf)
+			require.NoError(t, err)
+
+			var written []string
+			for i := 0; i < tc.captures; i++ {
+				path, wErr := c.Write(newCapture(uint16(10000+i), tc.data))
+				require.NoError(t, wErr)
+
+				if path != "" {
+					written = append(written, path)
+				}
+			}
+
+			entries, err := os.ReadDir(tc.conf.Dir)
+			require.NoError(t, err)
+			require.Len(t, entries, tc.wantFiles)
+
+			for _, e := range entries {
+				fi, iErr := e.Info()
+				require.NoError(t, iErr)
+
+				assert.Equal(t, int64(pcapOverhead+tc.wantSize), fi.Size())
+			}
+
+			// The newest files are kept.
+			if len(entries) > 0 {
+				last := written[len(written)-1]
+				assert.FileExists(t, last)
+			}
+		})
+	}
+}
+
+func TestCapturer_Write_nil(t *testing.T) {
+	var c *Capturer
+
+	path, err := c.Write(newCapture(10000, []byte{0x16}))
+	require.NoError(t, err)
+
+	assert.Empty(t, path)
+}
+
+func TestNew_invalid(t *testing.T) {
+	_, err := New(&Config{Dir: t.TempDir(), MaxBytes: 0})
+	assert.Error(t, err)
+}
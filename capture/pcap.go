@@ -0,0 +1,156 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// Constants of the pcap file format, see
+// https://www.ietf.org/archive/id/draft-gharris-opsawg-pcap-01.html.
+const (
+	pcapMagic        = 0xa1b2c3d4
+	pcapVersionMajor = 2
+	pcapVersionMinor = 4
+	pcapSnapLen      = 65535
+
+	// linkTypeRaw means that the packets start with the IPv4 or IPv6 header.
+	linkTypeRaw = 101
+)
+
+// Constants of the synthesized packet.
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	tcpHeaderLen  = 20
+
+	protoTCP   = 6
+	defaultTTL = 64
+
+	tcpFlagsPSHACK = 0x18
+	tcpWindow      = 65535
+
+	// maxPayload is the maximum payload that fits into a single IPv4 packet
+	// with the TCP header.
+	maxPayload = 65535 - ipv4HeaderLen - tcpHeaderLen
+)
+
+// writePcap writes a pcap file with a single TCP segment from src to dst with
+// the payload to w.  The connection handshake is not captured by the proxy,
+// so there are no packets before it, but it's enough for Wireshark to decode
+// the payload.
+func writePcap(w io.Writer, t time.Time, src, dst netip.AddrPort, payload []byte) (err error) {
+	if len(payload) > maxPayload {
+		payload = payload[:maxPayload]
+	}
+
+	src, dst = normalizeAddrs(src, dst)
+	pkt := tcpPacket(src, dst, payload)
+
+	hdr := make([]byte, 0, 40)
+	hdr = binary.LittleEndian.AppendUint32(hdr, pcapMagic)
+	hdr = binary.LittleEndian.AppendUint16(hdr, pcapVersionMajor)
+	hdr = binary.LittleEndian.AppendUint16(hdr, pcapVersionMinor)
+	hdr = binary.LittleEndian.AppendUint32(hdr, 0)
+	hdr = binary.LittleEndian.AppendUint32(hdr, 0)
+	hdr = binary.LittleEndian.AppendUint32(hdr, pcapSnapLen)
+	hdr = binary.LittleEndian.AppendUint32(hdr, linkTypeRaw)
+
+	hdr = binary.LittleEndian.AppendUint32(hdr, uint32(t.Unix()))
+	hdr = binary.LittleEndian.AppendUint32(hdr, uint32(t.Nanosecond()/1000))
+	hdr = binary.LittleEndian.AppendUint32(hdr, uint32(len(pkt)))
+	hdr = binary.LittleEndian.AppendUint32(hdr, uint32(len(pkt)))
+
+	_, err = w.Write(append(hdr, pkt...))
+
+	return err
+}
+
+// normalizeAddrs returns the addresses of the same family so that they fit
+// into a single IP header.  IPv4-mapped addresses are unmapped and the
+// invalid ones are replaced with the unspecified address.
+func normalizeAddrs(src, dst netip.AddrPort) (nsrc, ndst netip.AddrPort) {
+	srcIP, dstIP := src.Addr().Unmap(), dst.Addr().Unmap()
+	if !srcIP.IsValid() {
+		srcIP = netip.IPv4Unspecified()
+	}
+
+	if !dstIP.IsValid() || dstIP.Is4() != srcIP.Is4() {
+		dstIP = netip.IPv4Unspecified()
+		if srcIP.Is6() {
+			dstIP = netip.IPv6Unspecified()
+		}
+	}
+
+	return netip.AddrPortFrom(srcIP, src.Port()), netip.AddrPortFrom(dstIP, dst.Port())
+}
+
+// tcpPacket returns the IP packet with the TCP segment carrying payload.  The
+// addresses must be of the same family.
+func tcpPacket(src, dst netip.AddrPort, payload []byte) (pkt []byte) {
+	tcp := make([]byte, tcpHeaderLen, tcpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], src.Port())
+	binary.BigEndian.PutUint16(tcp[2:], dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:], 1)
+	binary.BigEndian.PutUint32(tcp[8:], 1)
+	tcp[12] = (tcpHeaderLen / 4) << 4
+	tcp[13] = tcpFlagsPSHACK
+	binary.BigEndian.PutUint16(tcp[14:], tcpWindow)
+	tcp = append(tcp, payload...)
+
+	srcIP, dstIP := src.Addr().AsSlice(), dst.Addr().AsSlice()
+
+	// The pseudo header for the checksum, see RFC 793 and RFC 8200.
+	pseudo := make([]byte, 0, 40)
+	pseudo = append(pseudo, srcIP...)
+	pseudo = append(pseudo, dstIP...)
+	pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(tcp)))
+	pseudo = binary.BigEndian.AppendUint32(pseudo, protoTCP)
+	binary.BigEndian.PutUint16(tcp[16:], checksum(pseudo, tcp))
+
+	var ip []byte
+	if src.Addr().Is4() {
+		ip = make([]byte, ipv4HeaderLen, ipv4HeaderLen+len(tcp))
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(ipv4HeaderLen+len(tcp)))
+		// Don't fragment.
+		binary.BigEndian.PutUint16(ip[6:], 0x4000)
+		ip[8] = defaultTTL
+		ip[9] = protoTCP
+		copy(ip[12:], srcIP)
+		copy(ip[16:], dstIP)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+	} else {
+		ip = make([]byte, ipv6HeaderLen, ipv6HeaderLen+len(tcp))
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = protoTCP
+		ip[7] = defaultTTL
+		copy(ip[8:], srcIP)
+		copy(ip[24:], dstIP)
+	}
+
+	return append(ip, tcp...)
+}
+
+// checksum returns the Internet checksum of the concatenated parts, see RFC
+// 1071.  All parts except the last one must have even length.
+func checksum(parts ...[]byte) (sum uint16) {
+	var s uint32
+	for _, p := range parts {
+		for i := 0; i+1 < len(p); i += 2 {
+			s += uint32(p[i])<<8 | uint32(p[i+1])
+		}
+
+		if len(p)%2 == 1 {
+			s += uint32(p[len(p)-1]) << 8
+		}
+	}
+
+	for s > 0xffff {
+		s = s>>16 + s&0xffff
+	}
+
+	return ^uint16(s)
+}
@@ -0,0 +1,69 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePcap(t *testing.T) {
+	payload := []byte("not a client hello")
+
+	testCases := []struct {
+		name      string
+		src       netip.AddrPort
+		dst       netip.AddrPort
+		ipHdrLen  int
+		wantIPVer byte
+	}{{
+		name:      "ipv4",
+		src:       netip.MustParseAddrPort("192.0.2.1:12345"),
+		dst:       netip.MustParseAddrPort("192.0.2.2:443"),
+		ipHdrLen:  ipv4HeaderLen,
+		wantIPVer: 4,
+	}, {
+		name:      "ipv6",
+		src:       netip.MustParseAddrPort("[2001:db8::1]:12345"),
+		dst:       netip.MustParseAddrPort("[2001:db8::2]:443"),
+		ipHdrLen:  ipv6HeaderLen,
+		wantIPVer: 6,
+	}, {
+		name:      "ipv4_mapped",
+		src:       netip.MustParseAddrPort("[::ffff:192.0.2.1]:12345"),
+		dst:       netip.MustParseAddrPort("192.0.2.2:443"),
+		ipHdrLen:  ipv4HeaderLen,
+		wantIPVer: 4,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			err := writePcap(buf, time.Now(), tc.src, tc.dst, payload)
+			require.NoError(t, err)
+
+			b := buf.Bytes()
+			require.Len(t, b, 24+16+tc.ipHdrLen+tcpHeaderLen+len(payload))
+
+			assert.Equal(t, uint32(pcapMagic), binary.LittleEndian.Uint32(b))
+			assert.Equal(t, uint32(linkTypeRaw), binary.LittleEndian.Uint32(b[20:]))
+
+			pkt := b[24+16:]
+			assert.Equal(t, tc.wantIPVer, pkt[0]>>4)
+
+			if tc.wantIPVer == 4 {
+				// The checksum of a valid header is zero.
+				assert.Zero(t, checksum(pkt[:ipv4HeaderLen]))
+			}
+
+			tcp := pkt[tc.ipHdrLen:]
+			assert.Equal(t, tc.src.Port(), binary.BigEndian.Uint16(tcp))
+			assert.Equal(t, tc.dst.Port(), binary.BigEndian.Uint16(tcp[2:]))
+			assert.Equal(t, payload, tcp[tcpHeaderLen:])
+		})
+	}
+}
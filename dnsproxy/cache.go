@@ -0,0 +1,103 @@
+package dnsproxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+)
+
+// negativeTTLUpstream is an upstream that sets the TTL of the negative
+// responses as described in RFC 2308, section 5, so that the cache keeps them
+// for the right time.  The cache uses the TTL of the SOA record from the
+// authority section, which is lowered to the SOA MINIMUM field and to maxTTL.
+type negativeTTLUpstream struct {
+	upstream.Upstream
+
+	// maxTTL is the maximum TTL of the negative responses in seconds.  If
+	// zero, only the SOA MINIMUM field is respected.
+	maxTTL uint32
+}
+
+// type check
+var _ upstream.Upstream = (*negativeTTLUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for
+// *negativeTTLUpstream.
+func (u *negativeTTLUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	resp, err = u.Upstream.Exchange(req)
+	if err != nil || !isNegative(resp) {
+		return resp, err
+	}
+
+	for _, rr := range resp.Ns {
+		soa, ok := rr.(*dns.SOA)
+		if !ok {
+			continue
+		}
+
+		ttl := soa.Hdr.Ttl
+		if soa.Minttl < ttl {
+			ttl = soa.Minttl
+		}
+
+		if u.maxTTL > 0 && u.maxTTL < ttl {
+			ttl = u.maxTTL
+		}
+
+		soa.Hdr.Ttl = ttl
+	}
+
+	return resp, nil
+}
+
+// isNegative checks if resp is a negative response, i.e. NXDOMAIN or NODATA.
+func isNegative(resp *dns.Msg) (ok bool) {
+	if resp == nil {
+		return false
+	}
+
+	return resp.Rcode == dns.RcodeNameError ||
+		(resp.Rcode == dns.RcodeSuccess && len(resp.Answer) == 0)
+}
+
+// wrapNegativeTTL wraps all the upstreams of upsConf into *negativeTTLUpstream.
+func wrapNegativeTTL(upsConf *proxy.UpstreamConfig, maxTTL time.Duration) {
+	ttl := uint32(maxTTL / time.Second)
+
+	wrap := func(ups []upstream.Upstream) {
+		for i, u := range ups {
+			ups[i] = &negativeTTLUpstream{
+				Upstream: u,
+				maxTTL:   ttl,
+			}
+		}
+	}
+
+	wrap(upsConf.Upstreams)
+	for _, ups := range upsConf.DomainReservedUpstreams {
+		wrap(ups)
+	}
+
+	for _, ups := range upsConf.SpecifiedDomainUpstreams {
+		wrap(ups)
+	}
+}
+
+// validateCache returns an error if the cache settings are invalid.
+func validateCache(size int, negativeTTL time.Duration) (err error) {
+	switch {
+	case size < 0:
+		return fmt.Errorf("negative cache size %d", size)
+	case negativeTTL < 0:
+		return fmt.Errorf("negative ttl %v is negative", negativeTTL)
+	case negativeTTL > 0 && negativeTTL < time.Second:
+		return fmt.Errorf("negative ttl %v is less than a second", negativeTTL)
+	case negativeTTL > 0 && size == 0:
+		return fmt.Errorf("negative ttl %v requires the cache", negativeTTL)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,167 @@
+package dnsproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUpstream is an [upstream.Upstream] that answers every query with resp.
+type fakeUpstream struct {
+	resp *dns.Msg
+}
+
+// type check
+var _ upstream.Upstream = (*fakeUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *fakeUpstream.
+func (u *fakeUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	resp = u.resp.Copy()
+	resp.Id = req.Id
+
+	return resp, nil
+}
+
+// Address implements the [upstream.Upstream] interface for *fakeUpstream.
+func (u *fakeUpstream) Address() (addr string) { return "fake" }
+
+// Close implements the [upstream.Upstream] interface for *fakeUpstream.
+func (u *fakeUpstream) Close() (err error) { return nil }
+
+func TestNegativeTTLUpstream_Exchange(t *testing.T) {
+	req := (&dns.Msg{}).SetQuestion("example.org.", dns.TypeA)
+
+	// newResp returns a response with rcode, the answer if it is not nil, and
+	// an SOA record with ttl and minTTL in the authority section.
+	newResp := func(rcode int, answer dns.RR, ttl, minTTL uint32) (resp *dns.Msg) {
+		resp = (&dns.Msg{}).SetRcode(req, rcode)
+		if answer != nil {
+			resp.Answer = []dns.RR{answer}
+		}
+
+		resp.Ns = []dns.RR{&dns.SOA{
+			Hdr: dns.RR_Header{
+				Name:   "example.org.",
+				Rrtype: dns.TypeSOA,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			Ns:     "ns.example.org.",
+			Mbox:   "hostmaster.example.org.",
+			Minttl: minTTL,
+		}}
+
+		return resp
+	}
+
+	a := &dns.A{
+		Hdr: dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   []byte{192, 0, 2, 1},
+	}
+
+	testCases := []struct {
+		name    string
+		resp    *dns.Msg
+		maxTTL  uint32
+		wantTTL uint32
+	}{{
+		name:    "nxdomain_min_ttl",
+		resp:    newResp(dns.RcodeNameError, nil, 3600, 300),
+		maxTTL:  0,
+		wantTTL: 300,
+	}, {
+		name:    "nxdomain_soa_ttl",
+		resp:    newResp(dns.RcodeNameError, nil, 100, 300),
+		maxTTL:  0,
+		wantTTL: 100,
+	}, {
+		name:    "nxdomain_max_ttl",
+		resp:    newResp(dns.RcodeNameError, nil, 3600, 300),
+		maxTTL:  60,
+		wantTTL: 60,
+	}, {
+		name:    "nodata_max_ttl",
+		resp:    newResp(dns.RcodeSuccess, nil, 3600, 300),
+		maxTTL:  60,
+		wantTTL: 60,
+	}, {
+		name:    "positive",
+		resp:    newResp(dns.RcodeSuccess, a, 3600, 300),
+		maxTTL:  60,
+		wantTTL: 3600,
+	}, {
+		name:    "servfail",
+		resp:    newResp(dns.RcodeServerFailure, nil, 3600, 300),
+		maxTTL:  60,
+		wantTTL: 3600,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &negativeTTLUpstream{
+				Upstream: &fakeUpstream{resp: tc.resp},
+				maxTTL:   tc.maxTTL,
+			}
+
+			resp, err := u.Exchange(req)
+			require.NoError(t, err)
+			require.Len(t, resp.Ns, 1)
+
+			assert.Equal(t, tc.wantTTL, resp.Ns[0].Header().Ttl)
+		})
+	}
+}
+
+func TestValidateCache(t *testing.T) {
+	testCases := []struct {
+		name        string
+		size        int
+		negativeTTL time.Duration
+		wantErrMsg  string
+	}{{
+		name:        "disabled",
+		size:        0,
+		negativeTTL: 0,
+		wantErrMsg:  "",
+	}, {
+		name:        "negative_ttl",
+		size:        4096,
+		negativeTTL: time.Minute,
+		wantErrMsg:  "",
+	}, {
+		name:        "negative_size",
+		size:        -1,
+		negativeTTL: 0,
+		wantErrMsg:  "negative cache size -1",
+	}, {
+		name:        "negative_negative_ttl",
+		size:        4096,
+		negativeTTL: -time.Minute,
+		wantErrMsg:  "negative ttl -1m0s is negative",
+	}, {
+		name:        "sub_second_negative_ttl",
+		size:        4096,
+		negativeTTL: time.Millisecond,
+		wantErrMsg:  "negative ttl 1ms is less than a second",
+	}, {
+		name:        "no_cache",
+		size:        0,
+		negativeTTL: time.Minute,
+		wantErrMsg:  "negative ttl 1m0s requires the cache",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateCache(tc.size, tc.negativeTTL)
+			if tc.wantErrMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.wantErrMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package dnsproxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes.  The check is performed lazily on a TLS handshake.
+const certCheckInterval = 10 * time.Second
+
+// certKeeper keeps the certificate of the encrypted DNS listeners and reloads
+// it when the certificate or key file changes.  It allows rotating the
+// certificate without restarting the listeners.
+type certKeeper struct {
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// cert is the current certificate.
+	cert *tls.Certificate
+
+	// certModTime and keyModTime are the modification times of the files the
+	// current certificate was loaded from.
+	certModTime time.Time
+	keyModTime  time.Time
+
+	// lastCheck is the last time the files were checked for changes.
+	lastCheck time.Time
+
+	certPath string
+	keyPath  string
+}
+
+// newCertKeeper creates a new *certKeeper and loads the certificate.
+func newCertKeeper(certPath, keyPath string) (k *certKeeper, err error) {
+	k = &certKeeper{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+
+	err = k.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Reload loads the certificate and key files.  The current certificate is kept
+// if they cannot be loaded.
+func (k *certKeeper) Reload() (err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.reload()
+}
+
+// reload loads the certificate and key files.  k.mu must be locked.
+func (k *certKeeper) reload() (err error) {
+	k.lastCheck = time.Now()
+
+	certModTime, keyModTime, err := k.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(k.certPath, k.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	k.cert = &cert
+	k.certModTime = certModTime
+	k.keyModTime = keyModTime
+
+	return nil
+}
+
+// modTimes returns the modification times of the certificate and key files.
+func (k *certKeeper) modTimes() (certModTime, keyModTime time.Time, err error) {
+	fi, err := os.Stat(k.certPath)
+	if err != nil {
+		return certModTime, keyModTime, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	certModTime = fi.ModTime()
+
+	fi, err = os.Stat(k.keyPath)
+	if err != nil {
+		return certModTime, keyModTime, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	return certModTime, fi.ModTime(), nil
+}
+
+// getCertificate implements the [tls.Config.GetCertificate] callback.  It
+// reloads the certificate if the files have changed since the last check.
+func (k *certKeeper) getCertificate(_ *tls.ClientHelloInfo) (cert *tls.Certificate, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if time.Since(k.lastCheck) < certCheckInterval {
+		return k.cert, nil
+	}
+
+	k.lastCheck = time.Now()
+
+	certModTime, keyModTime, err := k.modTimes()
+	if err != nil {
+		log.Info("dnsproxy: warning: keeping the current certificate: %v", err)
+
+		return k.cert, nil
+	}
+
+	if certModTime.Equal(k.certModTime) && keyModTime.Equal(k.keyModTime) {
+		return k.cert, nil
+	}
+
+	err = k.reload()
+	if err != nil {
+		log.Info("dnsproxy: warning: keeping the current certificate: %v", err)
+	} else {
+		log.Info("dnsproxy: reloaded certificate from %s", k.certPath)
+	}
+
+	return k.cert, nil
+}
@@ -0,0 +1,117 @@
+package dnsproxy
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handshakeCN performs a TLS handshake with a server that uses k for its
+// certificates and returns the common name of the certificate it presented.
+func handshakeCN(t *testing.T, k *certKeeper) (cn string) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	go func() {
+		_ = tls.Server(server, &tls.Config{GetCertificate: k.getCertificate}).Handshake()
+	}()
+
+	conn := tls.Client(client, &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, conn.Handshake())
+
+	certs := conn.ConnectionState().PeerCertificates
+	require.NotEmpty(t, certs)
+
+	return certs[0].Subject.CommonName
+}
+
+// touch sets the modification time of the files to the future, so that the
+// change is noticed regardless of the file system time resolution.
+func touch(t *testing.T, paths ...string) {
+	t.Helper()
+
+	future := time.Now().Add(time.Minute)
+	for _, p := range paths {
+		require.NoError(t, os.Chtimes(p, future, future))
+	}
+}
+
+func TestCertKeeper_getCertificate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		expire bool
+		want   string
+	}{{
+		name:   "reloaded",
+		expire: true,
+		want:   "new.example.org",
+	}, {
+		name:   "not_checked_yet",
+		expire: false,
+		want:   "old.example.org",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			certPath, keyPath := writeCert(t, dir, "old.example.org")
+
+			k, err := newCertKeeper(certPath, keyPath)
+			require.NoError(t, err)
+			require.Equal(t, "old.example.org", handshakeCN(t, k))
+
+			writeCert(t, dir, "new.example.org")
+			touch(t, certPath, keyPath)
+
+			if tc.expire {
+				k.mu.Lock()
+				k.lastCheck = time.Now().Add(-certCheckInterval)
+				k.mu.Unlock()
+			}
+
+			assert.Equal(t, tc.want, handshakeCN(t, k))
+		})
+	}
+}
+
+func TestCertKeeper_getCertificate_invalid(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeCert(t, dir, "old.example.org")
+
+	k, err := newCertKeeper(certPath, keyPath)
+	require.NoError(t, err)
+
+	// The new certificate is being written and doesn't match the key yet.
+	require.NoError(t, os.WriteFile(certPath, []byte("not a certificate"), 0o600))
+	touch(t, certPath)
+
+	k.mu.Lock()
+	k.lastCheck = time.Time{}
+	k.mu.Unlock()
+
+	assert.Equal(t, "old.example.org", handshakeCN(t, k))
+}
+
+func TestCertKeeper_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeCert(t, dir, "old.example.org")
+
+	k, err := newCertKeeper(certPath, keyPath)
+	require.NoError(t, err)
+
+	writeCert(t, dir, "new.example.org")
+	require.NoError(t, k.Reload())
+
+	assert.Equal(t, "new.example.org", handshakeCN(t, k))
+}
@@ -0,0 +1,281 @@
+package dnsproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/ameshkov/sniproxy/audit"
+)
+
+// Family is an IP address family.
+type Family string
+
+const (
+	// FamilyIPv4 is the IPv4 address family.
+	FamilyIPv4 Family = "ipv4"
+
+	// FamilyIPv6 is the IPv6 address family.
+	FamilyIPv6 Family = "ipv6"
+)
+
+// Config is the DNS proxy configuration.
+type Config struct {
+	// ListenAddrs is the list of addresses the DNS server is supposed to
+	// listen to.
+	ListenAddrs []netip.AddrPort
+
+	// NoPlain disables plain DNS, i.e. the server won't listen for UDP and TCP
+	// queries on ListenAddrs.  Only encrypted DNS listeners are used then.
+	NoPlain bool
+
+	// Freebind allows binding the plain DNS and DNS-over-HTTPS listeners even
+	// if their addresses are not assigned to the host yet (IP_FREEBIND), e.g. a
+	// virtual IP of a failover setup.  It is not supported for the
+	// DNS-over-QUIC listener.  It is only supported on Linux.
+	Freebind bool
+
+	// QUICListenAddr is the address the DNS-over-QUIC server is supposed to
+	// listen to.  If it is not set, DoQ is disabled.
+	QUICListenAddr netip.AddrPort
+
+	// HTTPSListenAddr is the address the DNS-over-HTTPS server is supposed to
+	// listen to.  If it is not set, DoH is disabled.
+	HTTPSListenAddr netip.AddrPort
+
+	// HTTPSPath is the path of the DNS-over-HTTPS endpoint.  Requests to other
+	// paths are answered with 404.  If not set, DefaultDoHPath is used.
+	HTTPSPath string
+
+	// TLSCertPath is the path to the certificate file used by the encrypted
+	// DNS listeners.
+	TLSCertPath string
+
+	// TLSKeyPath is the path to the private key file used by the encrypted
+	// DNS listeners.
+	TLSKeyPath string
+
+	// Upstreams is the list of upstreams that the requests will be forwarded
+	// to.  The format of an upstream is the one that can be consumed by
+	// [proxy.ParseUpstreamsConfig].  If there are several upstreams and one of
+	// them fails or times out, the next one is tried.
+	Upstreams []string
+
+	// UpstreamTimeout is the timeout for upstream queries.  If not set, the
+	// default timeout of the upstream is used.  Set it to a lower value to fail
+	// fast on a slow upstream and quickly fall back to the next one.
+	UpstreamTimeout time.Duration
+
+	// UpstreamRetries is the number of times a query is retried when all the
+	// upstreams failed to answer it, e.g. because of a lost UDP packet.  Each
+	// retry goes through the upstreams in order again.  If not set, the query
+	// is not retried.
+	UpstreamRetries int
+
+	// CacheSize is the size of the cache of the upstream responses in bytes.
+	// Negative responses are cached for the TTL from their SOA record as
+	// described in RFC 2308.  If not set, the responses are not cached.
+	CacheSize int
+
+	// NegativeTTL is the maximum time the negative responses, i.e. NXDOMAIN
+	// and NODATA, are cached for.  It requires CacheSize.  If not set, they
+	// are cached for the TTL from their SOA record.
+	NegativeTTL time.Duration
+
+	// MaxGoroutines is the maximum number of queries that are processed
+	// simultaneously.  If not set, there is no limit.
+	MaxGoroutines int
+
+	// RedirectIPv4To is the IP address A queries will be redirected to.
+	RedirectIPv4To net.IP
+
+	// RedirectIPv6To is the IP address AAAA queries will be redirected to.
+	RedirectIPv6To net.IP
+
+	// RedirectPrefer is the address family the clients are steered toward
+	// when both RedirectIPv4To and RedirectIPv6To are set.  Queries of the
+	// other family for the redirected domains are answered with an empty
+	// response.  If not set, both families are answered.
+	RedirectPrefer Family
+
+	// SynthesizeAAAA defines how AAAA queries for the redirected domains are
+	// answered when only RedirectIPv4To is set, so that IPv6-only clients can
+	// reach the proxy.  If not set, they are answered with an empty response.
+	SynthesizeAAAA Synthesis
+
+	// NAT64Prefix is the prefix the IPv4 address is embedded into when
+	// SynthesizeAAAA is SynthesisNAT64.  If not set, DefaultNAT64Prefix is
+	// used.
+	NAT64Prefix netip.Prefix
+
+	// RedirectRules is a list of wildcards that is used for checking which
+	// domains should be redirected.  A wildcard may be followed by options,
+	// e.g. "*.example.org;ttl=5" sets the TTL of the rewritten records.
+	RedirectRules []string
+
+	// RedirectExclude is a list of wildcards that define domains that are
+	// never redirected even if they match RedirectRules.  Queries for them are
+	// forwarded to the upstream.
+	RedirectExclude []string
+
+	// RedirectExcludeApex defines whether the apex domain should be excluded
+	// from redirection when a wildcard rule is used.  For instance, if it is
+	// true, the "*example.org" rule will redirect "sub.example.org", but not
+	// "example.org" itself.
+	RedirectExcludeApex bool
+
+	// DropRules is a list of wildcards that define DNS queries to which
+	// domains will be dropped. "Dropped" means that the DNS server will not
+	// respond to these queries.
+	DropRules []string
+
+	// DropSOA makes the DNS server answer the queries that match DropRules
+	// with NODATA and a SOA record in the authority section instead of not
+	// responding.  The resolvers cache such responses and stop retrying.
+	DropSOA bool
+
+	// ServedDomains is a list of wildcards that define domains the queries for
+	// which are forwarded to the upstream.  Queries for other domains that are
+	// not redirected are answered with REFUSED, so that the server can't be
+	// abused as an open resolver.  If empty, all queries are forwarded.
+	ServedDomains []string
+
+	// ProxyHostname is the hostname of the proxy itself.  A/AAAA queries for
+	// this name are always answered with RedirectIPv4To and RedirectIPv6To
+	// regardless of RedirectRules and DropRules.
+	ProxyHostname string
+
+	// DiagDomain is the diagnostic domain name.  TXT queries for it are
+	// answered with the client address and the proxy version.
+	DiagDomain string
+
+	// NoCompress disables DNS name compression in the responses.  Some legacy
+	// clients do not handle compressed messages properly.
+	NoCompress bool
+
+	// RateLimit is the maximum number of queries per second from a single
+	// client IP address.  Queries exceeding it are answered with REFUSED.  If
+	// not set, there is no limit.
+	RateLimit int
+
+	// MaxInflight is the maximum number of queries forwarded to the upstreams
+	// simultaneously.  The excess queries are handled according to
+	// InflightMode.  If not set, there is no limit.
+	MaxInflight int
+
+	// InflightMode defines how the queries exceeding MaxInflight are handled.
+	// If not set, InflightModeRefuse is used.
+	InflightMode InflightMode
+
+	// TapAddr is the address of the resolver or the logging endpoint that a
+	// copy of every query is sent to over UDP, e.g. "192.168.1.2:53".  The
+	// copies are sent asynchronously and the responses are ignored, so it
+	// doesn't affect answering the queries.  If not set, the queries are not
+	// mirrored.
+	TapAddr string
+
+	// EDNSKeepalive is the idle timeout advertised in the edns-tcp-keepalive
+	// option of the responses to the TCP queries, see RFC 7828.  It must not
+	// exceed 10 seconds, the idle timeout of the TCP listeners.  If not set,
+	// the option is not sent.
+	EDNSKeepalive time.Duration
+
+	// Audit receives the dropped and refused queries.  If not set, they are
+	// only logged to the operational log.
+	Audit *audit.Logger
+}
+
+// Validate returns an error if c is not a valid configuration.  The error
+// lists all the invalid fields.  [New] calls it, so that the programs that
+// build the configuration themselves get an error rather than a proxy that
+// fails later.  The upstreams and the certificate are only checked by New.
+func (c *Config) Validate() (err error) {
+	errs := c.validateListeners()
+	errs = append(errs, c.validateRedirect()...)
+
+	switch c.InflightMode {
+	case "", InflightModeRefuse, InflightModeDelay:
+	default:
+		errs = append(errs, fmt.Errorf("unknown inflight mode %q", c.InflightMode))
+	}
+
+	if c.UpstreamRetries < 0 {
+		errs = append(errs, fmt.Errorf("negative upstream retries %d", c.UpstreamRetries))
+	}
+
+	if c.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("negative rate limit %d", c.RateLimit))
+	}
+
+	if c.MaxInflight < 0 {
+		errs = append(errs, fmt.Errorf("negative max inflight %d", c.MaxInflight))
+	}
+
+	errs = append(
+		errs,
+		validateCache(c.CacheSize, c.NegativeTTL),
+		validateEDNSKeepalive(c.EDNSKeepalive),
+	)
+
+	err = errors.Join(errs...)
+	if err != nil {
+		return fmt.Errorf("dnsproxy: invalid configuration: %w", err)
+	}
+
+	return nil
+}
+
+// validateListeners returns the errors for the listener fields.
+func (c *Config) validateListeners() (errs []error) {
+	plain := !c.NoPlain && len(c.ListenAddrs) > 0
+	if !plain && !c.QUICListenAddr.IsValid() && !c.HTTPSListenAddr.IsValid() {
+		errs = append(errs, errors.New(
+			"plain DNS is disabled and there are no encrypted DNS listeners",
+		))
+	}
+
+	if c.Freebind && c.QUICListenAddr.IsValid() {
+		errs = append(errs, errors.New("freebind is not supported for the doq listener"))
+	}
+
+	if c.HTTPSPath != "" && !strings.HasPrefix(c.HTTPSPath, "/") {
+		errs = append(errs, fmt.Errorf("doh path %q must start with /", c.HTTPSPath))
+	}
+
+	return errs
+}
+
+// validateRedirect returns the errors for the redirect fields.
+func (c *Config) validateRedirect() (errs []error) {
+	if c.RedirectIPv4To == nil && c.RedirectIPv6To == nil {
+		errs = append(errs, errors.New("either ipv4 or ipv6 redirect address is required"))
+	}
+
+	if c.RedirectIPv4To != nil && c.RedirectIPv4To.To4() == nil {
+		errs = append(errs, fmt.Errorf(
+			"redirect address %s is not an IPv4 address",
+			c.RedirectIPv4To,
+		))
+	}
+
+	// To16 also accepts IPv4 addresses.
+	if ip := c.RedirectIPv6To; ip != nil && (ip.To16() == nil || ip.To4() != nil) {
+		errs = append(errs, fmt.Errorf("redirect address %s is not an IPv6 address", ip))
+	}
+
+	switch c.RedirectPrefer {
+	case "", FamilyIPv4, FamilyIPv6:
+	default:
+		errs = append(errs, fmt.Errorf("unknown address family %q", c.RedirectPrefer))
+	}
+
+	_, err := synthesizedIPv6(c)
+	errs = append(errs, err)
+
+	_, err = parseRedirectRules(c.RedirectRules)
+
+	return append(errs, err)
+}
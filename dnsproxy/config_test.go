@@ -0,0 +1,91 @@
+package dnsproxy
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	// valid returns a valid configuration changed by f.
+	valid := func(f func(c *Config)) (c *Config) {
+		c = &Config{
+			ListenAddrs:    []netip.AddrPort{netip.AddrPortFrom(localhost, 53)},
+			RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+		}
+		if f != nil {
+			f(c)
+		}
+
+		return c
+	}
+
+	testCases := []struct {
+		conf       *Config
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       valid(nil),
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf: valid(func(c *Config) {
+			c.RedirectIPv4To = nil
+			c.RedirectIPv6To = net.ParseIP("2001:db8::1")
+			c.InflightMode = InflightModeDelay
+			c.MaxInflight = 10
+		}),
+		name:       "valid_ipv6",
+		wantErrMsg: "",
+	}, {
+		conf:       valid(func(c *Config) { c.RedirectIPv4To = nil }),
+		name:       "no_redirect",
+		wantErrMsg: "either ipv4 or ipv6 redirect address is required",
+	}, {
+		conf:       valid(func(c *Config) { c.RedirectIPv4To = net.ParseIP("2001:db8::1") }),
+		name:       "ipv6_as_ipv4",
+		wantErrMsg: "redirect address 2001:db8::1 is not an IPv4 address",
+	}, {
+		conf:       valid(func(c *Config) { c.RedirectIPv6To = net.IPv4(127, 0, 0, 2) }),
+		name:       "ipv4_as_ipv6",
+		wantErrMsg: "redirect address 127.0.0.2 is not an IPv6 address",
+	}, {
+		conf:       valid(func(c *Config) { c.NoPlain = true }),
+		name:       "no_listeners",
+		wantErrMsg: "plain DNS is disabled and there are no encrypted DNS listeners",
+	}, {
+		conf: valid(func(c *Config) {
+			c.Freebind = true
+			c.QUICListenAddr = netip.AddrPortFrom(localhost, 853)
+		}),
+		name:       "freebind_doq",
+		wantErrMsg: "freebind is not supported for the doq listener",
+	}, {
+		conf:       valid(func(c *Config) { c.HTTPSPath = "dns-query" }),
+		name:       "relative_doh_path",
+		wantErrMsg: `doh path "dns-query" must start with /`,
+	}, {
+		conf:       valid(func(c *Config) { c.InflightMode = "bad" }),
+		name:       "unknown_inflight_mode",
+		wantErrMsg: `unknown inflight mode "bad"`,
+	}, {
+		conf:       valid(func(c *Config) { c.MaxInflight = -1 }),
+		name:       "negative_max_inflight",
+		wantErrMsg: "negative max inflight -1",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.conf.Validate()
+			if tc.wantErrMsg == "" {
+				assert.NoError(t, err)
+
+				return
+			}
+
+			assert.ErrorContains(t, err, tc.wantErrMsg)
+		})
+	}
+}
@@ -0,0 +1,651 @@
+// Package dnsproxy is responsible for the DNS proxy server that will redirect
+// specified domains to the SNI proxy.
+//
+// Other programs can embed the server by building a [Config] and passing it to
+// [New], which validates it with [Config.Validate].
+package dnsproxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/audit"
+	"github.com/ameshkov/sniproxy/internal/filter"
+	"github.com/ameshkov/sniproxy/internal/metrics"
+	"github.com/ameshkov/sniproxy/internal/version"
+	"github.com/miekg/dns"
+)
+
+// defaultTTL is the default TTL for the rewritten records.
+const defaultTTL = 60
+
+// DNSProxy is a struct that manages the DNS proxy server.  This server's
+// purpose is to redirect queries to a specified SNI proxy.
+type DNSProxy struct {
+	proxy           *proxy.Proxy
+	redirectRules   []*redirectRule
+	redirectExclude []string
+	redirectIPv4To  net.IP
+	redirectIPv6To  net.IP
+	dropRules       []string
+	servedDomains   []string
+	proxyHostname   string
+	diagDomain      string
+	excludeApex     bool
+	noCompress      bool
+	dropSOA         bool
+	redirectPrefer  Family
+	ednsKeepalive   time.Duration
+	upstreamRetries int
+
+	// synthesizedIPv6 is the address AAAA queries for the redirected domains
+	// are answered with when only redirectIPv4To is set.  It is nil if the
+	// answers are not synthesized.
+	synthesizedIPv6 net.IP
+
+	// limiter limits the rate of queries per client.  It is nil if there is no
+	// limit.
+	limiter *clientLimiter
+
+	// inflight limits the number of queries forwarded to the upstreams
+	// simultaneously.  It is nil if there is no limit.
+	inflight *inflightLimiter
+
+	// ruleStats counts the rule matches, see [DNSProxy.RuleStats].
+	ruleStats *filter.Stats
+
+	// auditLog receives the dropped and refused queries.  It may be nil.
+	auditLog *audit.Logger
+
+	// certs keeps the certificate of the encrypted DNS listeners.  It is nil
+	// if there are no encrypted DNS listeners.
+	certs *certKeeper
+
+	// plain is the plain DNS server used instead of the listeners of the
+	// proxy when the listeners are bound with freebind.  It is nil otherwise.
+	plain *plainServer
+
+	// doh is the DNS-over-HTTPS server.  It is nil if DoH is disabled.
+	doh *dohServer
+
+	// tap receives a copy of every query.  It is nil if mirroring is
+	// disabled.
+	tap *tap
+}
+
+// type check
+var _ io.Closer = (*DNSProxy)(nil)
+
+// New creates a new instance of *DNSProxy.
+func New(cfg *Config) (d *DNSProxy, err error) {
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	proxyConfig, certs, err := createProxyConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("dnsproxy: invalid configuration: %w", err)
+	}
+
+	synthesized, err := synthesizedIPv6(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("dnsproxy: invalid configuration: %w", err)
+	}
+
+	redirectRules, err := parseRedirectRules(cfg.RedirectRules)
+	if err != nil {
+		return nil, fmt.Errorf("dnsproxy: invalid configuration: %w", err)
+	}
+
+	d = &DNSProxy{
+		redirectRules:   redirectRules,
+		redirectExclude: cfg.RedirectExclude,
+		redirectIPv4To:  cfg.RedirectIPv4To,
+		redirectIPv6To:  cfg.RedirectIPv6To,
+		dropRules:       cfg.DropRules,
+		servedDomains:   cfg.ServedDomains,
+		proxyHostname:   strings.ToLower(strings.TrimSuffix(cfg.ProxyHostname, ".")),
+		diagDomain:      strings.ToLower(strings.TrimSuffix(cfg.DiagDomain, ".")),
+		excludeApex:     cfg.RedirectExcludeApex,
+		noCompress:      cfg.NoCompress,
+		dropSOA:         cfg.DropSOA,
+		redirectPrefer:  cfg.RedirectPrefer,
+		ednsKeepalive:   cfg.EDNSKeepalive,
+		upstreamRetries: cfg.UpstreamRetries,
+		synthesizedIPv6: synthesized,
+		limiter:         newClientLimiter(cfg.RateLimit),
+		inflight:        newInflightLimiter(cfg.MaxInflight, cfg.InflightMode),
+		ruleStats:       filter.NewStats(),
+		certs:           certs,
+		auditLog:        cfg.Audit,
+	}
+	d.proxy = &proxy.Proxy{
+		Config: proxyConfig,
+	}
+	d.proxy.RequestHandler = d.requestHandler
+
+	if cfg.Freebind && !cfg.NoPlain && len(cfg.ListenAddrs) > 0 {
+		d.plain = newPlainServer(cfg.ListenAddrs, d)
+	}
+
+	if cfg.TapAddr != "" {
+		d.tap, err = newTap(cfg.TapAddr)
+		if err != nil {
+			return nil, fmt.Errorf("dnsproxy: invalid configuration: %w", err)
+		}
+	}
+
+	if cfg.HTTPSListenAddr.IsValid() {
+		d.doh, err = newDoHServer(
+			cfg.HTTPSListenAddr,
+			cfg.HTTPSPath,
+			d.proxy,
+			proxyConfig.TLSConfig,
+			cfg.Freebind,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("dnsproxy: invalid configuration: %w", err)
+		}
+	}
+
+	return d, nil
+}
+
+// Start starts the DNSProxy server.
+func (d *DNSProxy) Start() (err error) {
+	log.Info("dnsproxy: starting")
+
+	if hasListenAddrs(&d.proxy.Config) {
+		err = d.proxy.Start()
+	} else {
+		// Only the plain DNS server bound with freebind and the DNS-over-HTTPS
+		// server are used, the proxy itself doesn't need to listen, but it
+		// still must be initialized to handle queries.
+		err = d.proxy.Init()
+	}
+
+	if err == nil && d.plain != nil {
+		err = d.plain.start()
+	}
+
+	if err == nil && d.doh != nil {
+		err = d.doh.start()
+	}
+
+	log.Info("dnsproxy: started successfully")
+
+	return err
+}
+
+// Close implements the [io.Closer] interface for DNSProxy.
+func (d *DNSProxy) Close() (err error) {
+	log.Info("dnsproxy: stopping")
+
+	err = d.proxy.Stop()
+	if d.plain != nil {
+		err = errors.Join(err, d.plain.close())
+	}
+
+	if d.doh != nil {
+		err = errors.Join(err, d.doh.close())
+	}
+
+	err = errors.Join(err, d.tap.close())
+
+	log.Info("dnsproxy: stopped")
+
+	return err
+}
+
+// ReloadCertificate reloads the certificate of the encrypted DNS listeners from
+// the files.  The listeners keep using the current certificate if the files
+// cannot be loaded.  Besides that, the certificate is reloaded automatically
+// when the files change.
+func (d *DNSProxy) ReloadCertificate() (err error) {
+	if d.certs == nil {
+		return nil
+	}
+
+	err = d.certs.Reload()
+	if err != nil {
+		return fmt.Errorf("dnsproxy: reloading certificate: %w", err)
+	}
+
+	log.Info("dnsproxy: reloaded certificate from %s", d.certs.certPath)
+
+	return nil
+}
+
+// requestHandler is a [proxy.RequestHandler] implementation which purpose is
+// to implement the actual redirection logic.
+func (d *DNSProxy) requestHandler(p *proxy.Proxy, ctx *proxy.DNSContext) (err error) {
+	qName := strings.ToLower(ctx.Req.Question[0].Name)
+	qType := ctx.Req.Question[0].Qtype
+
+	log.Debug("dnsproxy: received DNS query %s %s", dns.Type(qType), qName)
+
+	defer d.addEDNSKeepalive(ctx)
+
+	if ip := clientIP(ctx.Addr); !d.limiter.allow(ip) {
+		log.Debug(
+			"dnsproxy: refusing DNS query %s %s from %s: rate limit exceeded",
+			dns.Type(qType),
+			qName,
+			ip,
+		)
+		metrics.DNSQueries.Inc(metrics.ActionRateLimited)
+		d.audit(ctx, qName, audit.ActionRefuse, "", "rate limit")
+		refuse(ctx)
+
+		return nil
+	}
+
+	d.tap.mirror(ctx.Req)
+
+	domainName := strings.TrimSuffix(qName, ".")
+
+	if qType == dns.TypeTXT && d.diagDomain != "" && domainName == d.diagDomain {
+		d.respondDiag(qName, ctx)
+
+		return nil
+	}
+
+	if qClass := ctx.Req.Question[0].Qclass; qClass != dns.ClassINET {
+		// Only IN records can be rewritten, queries of other classes, e.g.
+		// CH version.bind, are passed to the upstream as is unless they are
+		// dropped or the domain is not served.
+		if d.drop(ctx, qName, qType, domainName) || d.refuseNotServed(ctx, qName, qType, domainName) {
+			return nil
+		}
+
+		log.Debug(
+			"dnsproxy: forwarding DNS query %s %s of class %s",
+			dns.Type(qType),
+			qName,
+			dns.Class(qClass),
+		)
+
+		return d.resolve(p, ctx)
+	}
+
+	if qType == dns.TypePTR {
+		// PTR queries can't be rewritten, but they are passed to the upstream
+		// so that reverse lookups work, e.g. the ones the SNI proxy makes to
+		// match the rules against PTR names.
+		return d.resolve(p, ctx)
+	}
+
+	if qType != dns.TypeA && qType != dns.TypeAAAA {
+		// Doing nothing with the request if it's not A/AAAA, we cannot
+		// rewrite them anyway.
+		return nil
+	}
+
+	if d.proxyHostname != "" && domainName == d.proxyHostname {
+		// The proxy hostname is always resolved to the proxy itself.
+		d.rewrite(qName, qType, "", defaultTTL, ctx)
+		metrics.DNSQueries.Inc(metrics.ActionRedirected)
+
+		return nil
+	}
+
+	if d.drop(ctx, qName, qType, domainName) {
+		return nil
+	}
+
+	if rule, ok := d.redirectRule(domainName); ok {
+		d.ruleStats.Inc(ruleKindRedirect, rule.text)
+		d.rewrite(qName, qType, rule.text, rule.ttl, ctx)
+		metrics.DNSQueries.Inc(metrics.ActionRedirected)
+
+		return nil
+	}
+
+	if d.refuseNotServed(ctx, qName, qType, domainName) {
+		return nil
+	}
+
+	return d.resolve(p, ctx)
+}
+
+// drop answers the query with an empty response, or with NODATA and an SOA
+// record if dropSOA is set, if domainName matches one of the drop rules.  ok is
+// true if the query was dropped.
+func (d *DNSProxy) drop(
+	ctx *proxy.DNSContext,
+	qName string,
+	qType uint16,
+	domainName string,
+) (ok bool) {
+	rule, ok := filter.MatchedWildcard(domainName, d.dropRules)
+	if !ok {
+		return false
+	}
+
+	d.ruleStats.Inc(ruleKindDrop, rule)
+	metrics.DNSQueries.Inc(metrics.ActionDropped)
+
+	log.Info("dnsproxy: dropping DNS query for %s %s by rule %s", dns.Type(qType), qName, rule)
+	d.audit(ctx, qName, audit.ActionDrop, rule, "")
+
+	if d.dropSOA {
+		d.respondDropSOA(qName, ctx)
+	} else {
+		// Return empty response, effectively "dropping" the query.
+		ctx.Res = nil
+	}
+
+	return true
+}
+
+// refuseNotServed answers the query with REFUSED if the served domains are
+// configured and domainName doesn't match any of them.  ok is true if the
+// query was refused.
+func (d *DNSProxy) refuseNotServed(
+	ctx *proxy.DNSContext,
+	qName string,
+	qType uint16,
+	domainName string,
+) (ok bool) {
+	if len(d.servedDomains) == 0 {
+		return false
+	}
+
+	rule, served := filter.MatchedWildcard(domainName, d.servedDomains)
+	if served {
+		d.ruleStats.Inc(ruleKindServed, rule)
+
+		return false
+	}
+
+	log.Debug(
+		"dnsproxy: refusing DNS query for %s %s: domain is not served",
+		dns.Type(qType),
+		qName,
+	)
+	metrics.DNSQueries.Inc(metrics.ActionRefused)
+	d.audit(ctx, qName, audit.ActionRefuse, "", "not served")
+	refuse(ctx)
+
+	return true
+}
+
+// resolve resolves the query with the upstream.  If all the upstreams fail,
+// the query is retried up to d.upstreamRetries times.  If too many queries are
+// already in flight, the query is answered with REFUSED.
+func (d *DNSProxy) resolve(p *proxy.Proxy, ctx *proxy.DNSContext) (err error) {
+	if !d.inflight.acquire() {
+		q := ctx.Req.Question[0]
+		log.Debug(
+			"dnsproxy: refusing DNS query %s %s: too many queries in flight",
+			dns.Type(q.Qtype),
+			q.Name,
+		)
+		metrics.DNSQueries.Inc(metrics.ActionOverloaded)
+		refuse(ctx)
+
+		return nil
+	}
+	defer d.inflight.release()
+
+	metrics.DNSQueries.Inc(metrics.ActionForwarded)
+
+	err = p.Resolve(ctx)
+	for i := 1; err != nil && i <= d.upstreamRetries; i++ {
+		q := ctx.Req.Question[0]
+		log.Debug(
+			"dnsproxy: retrying DNS query %s %s (%d/%d): %v",
+			dns.Type(q.Qtype),
+			q.Name,
+			i,
+			d.upstreamRetries,
+			err,
+		)
+
+		err = p.Resolve(ctx)
+	}
+
+	if ctx.Res != nil && d.noCompress {
+		// The upstream response is always compressed by the proxy, override
+		// it here.
+		ctx.Res.Compress = false
+	}
+
+	return err
+}
+
+// audit writes the decision about the query to the audit log if it is enabled.
+// rule is the rule that caused the decision, reason describes the decisions
+// that are not caused by a rule.
+func (d *DNSProxy) audit(ctx *proxy.DNSContext, qName, action, rule, reason string) {
+	err := d.auditLog.Log(&audit.Event{
+		Source:      audit.SourceDNSProxy,
+		Action:      action,
+		ClientIP:    clientIP(ctx.Addr).String(),
+		Destination: strings.TrimSuffix(qName, "."),
+		Rule:        rule,
+		Reason:      reason,
+	})
+	if err != nil {
+		log.Error("dnsproxy: %v", err)
+	}
+}
+
+// refuse answers the query with REFUSED.
+func refuse(ctx *proxy.DNSContext) {
+	ctx.Res = &dns.Msg{}
+	ctx.Res.SetRcode(ctx.Req, dns.RcodeRefused)
+}
+
+// redirectRule returns the first redirect rule that matches the domain.  If
+// excludeApex is set, the apex domain of a "*.example.org" rule is not
+// considered a match.  Domains that match the redirect exclusions are never
+// redirected.
+func (d *DNSProxy) redirectRule(domainName string) (rule *redirectRule, ok bool) {
+	if exclude, excluded := filter.MatchedWildcard(domainName, d.redirectExclude); excluded {
+		d.ruleStats.Inc(ruleKindRedirectExclude, exclude)
+		log.Debug("dnsproxy: not redirecting %s: excluded by rule %s", domainName, exclude)
+
+		return nil, false
+	}
+
+	for _, r := range d.redirectRules {
+		if d.excludeApex && filter.IsApex(domainName, r.wildcard) {
+			continue
+		}
+
+		if filter.MatchWildcard(domainName, r.wildcard) {
+			return r, true
+		}
+	}
+
+	return nil, false
+}
+
+// rewrite rewrites the specified query and redirects the response to the
+// configured IP addresses.  rule is the matched redirect rule, it is empty for
+// the proxy hostname.  ttl is the TTL of the rewritten records.
+func (d *DNSProxy) rewrite(
+	qName string,
+	qType uint16,
+	rule string,
+	ttl uint32,
+	ctx *proxy.DNSContext,
+) {
+	resp := &dns.Msg{}
+	resp.SetReply(ctx.Req)
+	resp.Compress = !d.noCompress
+
+	if rule != "" {
+		log.Info("dnsproxy: rewriting DNS for %s %s by rule %s", dns.Type(qType), qName, rule)
+	} else {
+		log.Info("dnsproxy: rewriting DNS for %s %s", dns.Type(qType), qName)
+	}
+
+	hdr := dns.RR_Header{
+		Name:   qName,
+		Rrtype: qType,
+		Class:  ctx.Req.Question[0].Qclass,
+		Ttl:    ttl,
+	}
+
+	if d.steeredAway(qType) {
+		// Respond with NODATA so that the client uses the preferred family.
+		log.Debug("dnsproxy: steering %s %s to %s", dns.Type(qType), qName, d.redirectPrefer)
+
+		ctx.Res = resp
+
+		return
+	}
+
+	switch {
+	case qType == dns.TypeA && d.redirectIPv4To != nil:
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: hdr,
+			A:   d.redirectIPv4To,
+		})
+	case qType == dns.TypeAAAA && d.redirectIPv6To != nil:
+		resp.Answer = append(resp.Answer, &dns.AAAA{
+			Hdr:  hdr,
+			AAAA: d.redirectIPv6To,
+		})
+	case qType == dns.TypeAAAA && d.synthesizedIPv6 != nil:
+		log.Debug("dnsproxy: synthesized AAAA %s for %s", d.synthesizedIPv6, qName)
+
+		resp.Answer = append(resp.Answer, &dns.AAAA{
+			Hdr:  hdr,
+			AAAA: d.synthesizedIPv6,
+		})
+	}
+
+	ctx.Res = resp
+}
+
+// steeredAway checks if the clients should be steered away from the address
+// family of the query type.  It is only possible when both redirect addresses
+// are configured.
+func (d *DNSProxy) steeredAway(qType uint16) (ok bool) {
+	if d.redirectIPv4To == nil || d.redirectIPv6To == nil {
+		return false
+	}
+
+	switch d.redirectPrefer {
+	case FamilyIPv4:
+		return qType == dns.TypeAAAA
+	case FamilyIPv6:
+		return qType == dns.TypeA
+	default:
+		return false
+	}
+}
+
+// respondDiag responds to the diagnostic domain query with a TXT record that
+// contains the client address and the proxy version.
+func (d *DNSProxy) respondDiag(qName string, ctx *proxy.DNSContext) {
+	resp := &dns.Msg{}
+	resp.SetReply(ctx.Req)
+	resp.Compress = !d.noCompress
+
+	clientIP := ctx.Addr.String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+
+	log.Info("dnsproxy: responding to diagnostic query from %s", clientIP)
+
+	resp.Answer = append(resp.Answer, &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   qName,
+			Rrtype: dns.TypeTXT,
+			Class:  ctx.Req.Question[0].Qclass,
+			Ttl:    0,
+		},
+		Txt: []string{
+			"client=" + clientIP,
+			"proto=" + string(ctx.Proto),
+			"version=" + version.VersionString,
+		},
+	})
+
+	ctx.Res = resp
+}
+
+// createProxyConfig creates DNS proxy configuration.  certs is the keeper of
+// the certificate of the encrypted DNS listeners, it is nil if there are none.
+func createProxyConfig(cfg *Config) (proxyConfig proxy.Config, certs *certKeeper, err error) {
+	upstreamCfg, err := proxy.ParseUpstreamsConfig(cfg.Upstreams, &upstream.Options{
+		Timeout: cfg.UpstreamTimeout,
+	})
+	if err != nil {
+		return proxyConfig, nil, fmt.Errorf("failed to parse upstreams %v: %w", cfg.Upstreams, err)
+	}
+
+	err = validateCache(cfg.CacheSize, cfg.NegativeTTL)
+	if err != nil {
+		return proxyConfig, nil, err
+	}
+
+	if cfg.CacheSize > 0 {
+		wrapNegativeTTL(upstreamCfg, cfg.NegativeTTL)
+
+		proxyConfig.CacheEnabled = true
+		proxyConfig.CacheSizeBytes = cfg.CacheSize
+	}
+
+	// The plain DNS listeners bound with freebind are created by
+	// [plainServer].
+	if !cfg.NoPlain && !cfg.Freebind {
+		for _, addr := range cfg.ListenAddrs {
+			proxyConfig.UDPListenAddr = append(proxyConfig.UDPListenAddr, net.UDPAddrFromAddrPort(addr))
+			proxyConfig.TCPListenAddr = append(proxyConfig.TCPListenAddr, net.TCPAddrFromAddrPort(addr))
+		}
+	}
+
+	if cfg.QUICListenAddr.IsValid() || cfg.HTTPSListenAddr.IsValid() {
+		certs, err = createCertKeeper(cfg)
+		if err != nil {
+			return proxyConfig, nil, err
+		}
+
+		proxyConfig.TLSConfig = &tls.Config{
+			GetCertificate: certs.getCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+	}
+
+	if cfg.QUICListenAddr.IsValid() {
+		proxyConfig.QUICListenAddr = []*net.UDPAddr{net.UDPAddrFromAddrPort(cfg.QUICListenAddr)}
+	}
+
+	proxyConfig.UpstreamConfig = upstreamCfg
+	proxyConfig.MaxGoroutines = cfg.MaxGoroutines
+
+	return proxyConfig, certs, nil
+}
+
+// hasListenAddrs checks if the proxy configured by proxyConfig has any
+// listeners of its own.  The plain DNS server bound with freebind and the
+// DNS-over-HTTPS server are not one of them.
+func hasListenAddrs(proxyConfig *proxy.Config) (ok bool) {
+	return len(proxyConfig.UDPListenAddr) > 0 ||
+		len(proxyConfig.TCPListenAddr) > 0 ||
+		len(proxyConfig.QUICListenAddr) > 0
+}
+
+// createCertKeeper loads the certificate for encrypted DNS listeners.
+func createCertKeeper(cfg *Config) (certs *certKeeper, err error) {
+	if cfg.TLSCertPath == "" || cfg.TLSKeyPath == "" {
+		return nil, fmt.Errorf("certificate and key are required for encrypted DNS")
+	}
+
+	return newCertKeeper(cfg.TLSCertPath, cfg.TLSKeyPath)
+}
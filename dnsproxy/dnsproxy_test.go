@@ -0,0 +1,733 @@
+package dnsproxy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ameshkov/sniproxy/internal/filter"
+	"github.com/ameshkov/sniproxy/internal/version"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for the tests.
+const testTimeout = 5 * time.Second
+
+// localhost is the loopback address the test listeners use.
+var localhost = netip.MustParseAddr("127.0.0.1")
+
+// writeCert generates a self-signed certificate for commonName and writes it
+// and its key to dir.
+func writeCert(t testing.TB, dir, commonName string) (certPath, keyPath string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	require.NoError(t, os.WriteFile(certPath, certPEM, 0o600))
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0o600))
+
+	return certPath, keyPath
+}
+
+// freePort returns a port that is free for both UDP and TCP on localhost.
+func freePort(t testing.TB) (port uint16) {
+	t.Helper()
+
+	l, err := net.ListenTCP("tcp", net.TCPAddrFromAddrPort(netip.AddrPortFrom(localhost, 0)))
+	require.NoError(t, err)
+
+	port = uint16(l.Addr().(*net.TCPAddr).Port)
+	require.NoError(t, l.Close())
+
+	return port
+}
+
+// exchangeRaw sends req to the plain DNS server at addr over UDP and returns
+// the response as it was received.
+func exchangeRaw(t *testing.T, addr netip.AddrPort, req *dns.Msg) (resp []byte) {
+	t.Helper()
+
+	b, err := req.Pack()
+	require.NoError(t, err)
+
+	conn, err := net.Dial("udp", addr.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write(b)
+	require.NoError(t, err)
+
+	resp = make([]byte, dns.MaxMsgSize)
+	n, err := conn.Read(resp)
+	require.NoError(t, err)
+
+	return resp[:n]
+}
+
+func TestDNSProxy_rewrite_noCompress(t *testing.T) {
+	const qName = "example.org."
+
+	// wireName is qName in the wire format.  A compressed response only has
+	// it once, in the question, the answer points to it.
+	wireName := []byte("\x07example\x03org\x00")
+
+	testCases := []struct {
+		name       string
+		noCompress bool
+		wantNames  int
+	}{{
+		name:       "compressed",
+		noCompress: false,
+		wantNames:  1,
+	}, {
+		name:       "not_compressed",
+		noCompress: true,
+		wantNames:  2,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := netip.AddrPortFrom(localhost, freePort(t))
+
+			d, err := New(&Config{
+				ListenAddrs:    []netip.AddrPort{addr},
+				Upstreams:      []string{"127.0.0.1:53"},
+				RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+				RedirectRules:  []string{"example.org"},
+				NoCompress:     tc.noCompress,
+			})
+			require.NoError(t, err)
+			require.NoError(t, d.Start())
+			t.Cleanup(func() { _ = d.Close() })
+
+			resp := exchangeRaw(t, addr, (&dns.Msg{}).SetQuestion(qName, dns.TypeA))
+
+			msg := &dns.Msg{}
+			require.NoError(t, msg.Unpack(resp))
+			require.Len(t, msg.Answer, 1)
+
+			assert.Equal(t, tc.wantNames, bytes.Count(resp, wireName))
+		})
+	}
+}
+
+func TestDNSProxy_requestHandler_proxyHostname(t *testing.T) {
+	addr := netip.AddrPortFrom(localhost, freePort(t))
+
+	d, err := New(&Config{
+		ListenAddrs:    []netip.AddrPort{addr},
+		Upstreams:      []string{"127.0.0.1:53"},
+		RedirectIPv4To: net.IPv4(127, 0, 0, 2),
+		ProxyHostname:  "Proxy.Example.",
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Start())
+	t.Cleanup(func() { _ = d.Close() })
+
+	testCases := []struct {
+		name  string
+		qName string
+	}{{
+		name:  "exact",
+		qName: "proxy.example.",
+	}, {
+		name:  "case_insensitive",
+		qName: "PROXY.example.",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := exchangeRaw(t, addr, (&dns.Msg{}).SetQuestion(tc.qName, dns.TypeA))
+
+			msg := &dns.Msg{}
+			require.NoError(t, msg.Unpack(resp))
+			require.Len(t, msg.Answer, 1)
+
+			a, ok := msg.Answer[0].(*dns.A)
+			require.True(t, ok)
+
+			assert.Equal(t, net.IPv4(127, 0, 0, 2).To4(), a.A.To4())
+		})
+	}
+}
+
+func TestDNSProxy_Start_noPlain(t *testing.T) {
+	testCases := []struct {
+		name      string
+		noPlain   bool
+		wantPlain bool
+	}{{
+		name:      "plain",
+		noPlain:   false,
+		wantPlain: true,
+	}, {
+		name:      "no_plain",
+		noPlain:   true,
+		wantPlain: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			certPath, keyPath := writeCert(t, t.TempDir(), "dns.example.org")
+			addr := netip.AddrPortFrom(localhost, freePort(t))
+
+			d, err := New(&Config{
+				ListenAddrs:    []netip.AddrPort{addr},
+				NoPlain:        tc.noPlain,
+				QUICListenAddr: netip.AddrPortFrom(localhost, 0),
+				TLSCertPath:    certPath,
+				TLSKeyPath:     keyPath,
+				Upstreams:      []string{"127.0.0.1:53"},
+				RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+			})
+			require.NoError(t, err)
+			require.NoError(t, d.Start())
+			t.Cleanup(func() { _ = d.Close() })
+
+			// The plain listeners are open if the address is taken.
+			udp, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(addr))
+			if err == nil {
+				_ = udp.Close()
+			}
+			assert.Equal(t, tc.wantPlain, err != nil)
+
+			tcp, err := net.ListenTCP("tcp", net.TCPAddrFromAddrPort(addr))
+			if err == nil {
+				_ = tcp.Close()
+			}
+			assert.Equal(t, tc.wantPlain, err != nil)
+		})
+	}
+}
+
+func TestNew_noListeners(t *testing.T) {
+	_, err := New(&Config{
+		ListenAddrs:    []netip.AddrPort{netip.AddrPortFrom(localhost, 0)},
+		NoPlain:        true,
+		Upstreams:      []string{"127.0.0.1:53"},
+		RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+	})
+	assert.ErrorContains(t, err, "no encrypted DNS listeners")
+}
+
+// startUpstream starts a plain DNS server that answers every query with a TXT
+// record "upstream" of the query class and returns its address.
+func startUpstream(t *testing.T) (addr string) {
+	t.Helper()
+
+	return startGatedUpstream(t, nil, nil)
+}
+
+// startGatedUpstream is like startUpstream, but if gate is not nil, the server
+// sends to received when it gets a query and only answers it once gate is
+// closed.
+func startGatedUpstream(t *testing.T, received chan<- struct{}, gate <-chan struct{}) (addr string) {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			if gate != nil {
+				received <- struct{}{}
+				<-gate
+			}
+
+			resp := (&dns.Msg{}).SetReply(req)
+			resp.Answer = append(resp.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{
+					Name:   req.Question[0].Name,
+					Rrtype: dns.TypeTXT,
+					Class:  req.Question[0].Qclass,
+					Ttl:    60,
+				},
+				Txt: []string{"upstream"},
+			})
+
+			_ = w.WriteMsg(resp)
+		}),
+	}
+
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+func TestDNSProxy_requestHandler_ptr(t *testing.T) {
+	addr := netip.AddrPortFrom(localhost, freePort(t))
+
+	d, err := New(&Config{
+		ListenAddrs:    []netip.AddrPort{addr},
+		Upstreams:      []string{startUpstream(t)},
+		RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+		RedirectRules:  []string{"*"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Start())
+	t.Cleanup(func() { _ = d.Close() })
+
+	req := (&dns.Msg{}).SetQuestion("1.0.0.127.in-addr.arpa.", dns.TypePTR)
+	resp := &dns.Msg{}
+	require.NoError(t, resp.Unpack(exchangeRaw(t, addr, req)))
+
+	// The query matches the redirect rule, but it must be answered by the
+	// upstream.
+	require.Len(t, resp.Answer, 1)
+
+	txt, ok := resp.Answer[0].(*dns.TXT)
+	require.True(t, ok)
+
+	assert.Equal(t, []string{"upstream"}, txt.Txt)
+}
+
+func TestDNSProxy_maxGoroutines(t *testing.T) {
+	received := make(chan struct{}, 2)
+	gate := make(chan struct{})
+	upstream := startGatedUpstream(t, received, gate)
+
+	addr := netip.AddrPortFrom(localhost, freePort(t))
+	d, err := New(&Config{
+		ListenAddrs:    []netip.AddrPort{addr},
+		Upstreams:      []string{upstream},
+		MaxGoroutines:  1,
+		RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Start())
+	t.Cleanup(func() { _ = d.Close() })
+
+	errs := make(chan error, 2)
+	for _, name := range []string{"first.example.", "second.example."} {
+		req := (&dns.Msg{}).SetQuestion(name, dns.TypeA)
+		go func() {
+			_, _, exErr := (&dns.Client{Timeout: testTimeout}).Exchange(req, addr.String())
+			errs <- exErr
+		}()
+	}
+
+	// Only one query is processed at a time, the other one waits until it is
+	// finished.
+	<-received
+	select {
+	case <-received:
+		t.Fatal("the second query is processed simultaneously")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(gate)
+	<-received
+
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+}
+
+func TestDNSProxy_requestHandler_diagDomain(t *testing.T) {
+	addr := netip.AddrPortFrom(localhost, freePort(t))
+
+	d, err := New(&Config{
+		ListenAddrs:    []netip.AddrPort{addr},
+		Upstreams:      []string{startUpstream(t)},
+		RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+		DiagDomain:     "Diag.Example.",
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Start())
+	t.Cleanup(func() { _ = d.Close() })
+
+	testCases := []struct {
+		name    string
+		qName   string
+		wantTXT []string
+	}{{
+		name:  "diag",
+		qName: "diag.example.",
+		wantTXT: []string{
+			"client=127.0.0.1",
+			"proto=udp",
+			"version=" + version.VersionString,
+		},
+	}, {
+		name:  "case_insensitive",
+		qName: "DIAG.example.",
+		wantTXT: []string{
+			"client=127.0.0.1",
+			"proto=udp",
+			"version=" + version.VersionString,
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := (&dns.Msg{}).SetQuestion(tc.qName, dns.TypeTXT)
+			resp := &dns.Msg{}
+			require.NoError(t, resp.Unpack(exchangeRaw(t, addr, req)))
+			require.Len(t, resp.Answer, 1)
+
+			txt, ok := resp.Answer[0].(*dns.TXT)
+			require.True(t, ok)
+
+			assert.Equal(t, tc.wantTXT, txt.Txt)
+		})
+	}
+}
+
+func TestDNSProxy_upstreamTimeout(t *testing.T) {
+	// The slow upstream never answers the queries.
+	slow, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = slow.Close() })
+
+	addr := netip.AddrPortFrom(localhost, freePort(t))
+	d, err := New(&Config{
+		ListenAddrs:     []netip.AddrPort{addr},
+		Upstreams:       []string{slow.LocalAddr().String(), startUpstream(t)},
+		UpstreamTimeout: 200 * time.Millisecond,
+		RedirectIPv4To:  net.IPv4(127, 0, 0, 1),
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Start())
+	t.Cleanup(func() { _ = d.Close() })
+
+	start := time.Now()
+	req := (&dns.Msg{}).SetQuestion("example.org.", dns.TypeA)
+	resp := &dns.Msg{}
+	require.NoError(t, resp.Unpack(exchangeRaw(t, addr, req)))
+
+	// The slow upstream is tried first and the query falls back to the next
+	// one as soon as the timeout is exceeded.
+	assert.Less(t, time.Since(start), 2*time.Second)
+	require.Len(t, resp.Answer, 1)
+
+	txt, ok := resp.Answer[0].(*dns.TXT)
+	require.True(t, ok)
+
+	assert.Equal(t, []string{"upstream"}, txt.Txt)
+}
+
+func TestDNSProxy_rewrite_redirectPrefer(t *testing.T) {
+	ipv4 := net.IPv4(127, 0, 0, 1)
+	ipv6 := net.ParseIP("::1")
+
+	testCases := []struct {
+		name        string
+		prefer      Family
+		ipv6To      net.IP
+		qType       uint16
+		wantAnswers int
+	}{{
+		name:        "no_preference_a",
+		prefer:      "",
+		ipv6To:      ipv6,
+		qType:       dns.TypeA,
+		wantAnswers: 1,
+	}, {
+		name:        "no_preference_aaaa",
+		prefer:      "",
+		ipv6To:      ipv6,
+		qType:       dns.TypeAAAA,
+		wantAnswers: 1,
+	}, {
+		name:        "ipv4_a",
+		prefer:      FamilyIPv4,
+		ipv6To:      ipv6,
+		qType:       dns.TypeA,
+		wantAnswers: 1,
+	}, {
+		name:        "ipv4_aaaa",
+		prefer:      FamilyIPv4,
+		ipv6To:      ipv6,
+		qType:       dns.TypeAAAA,
+		wantAnswers: 0,
+	}, {
+		name:        "ipv6_a",
+		prefer:      FamilyIPv6,
+		ipv6To:      ipv6,
+		qType:       dns.TypeA,
+		wantAnswers: 0,
+	}, {
+		name:        "ipv6_aaaa",
+		prefer:      FamilyIPv6,
+		ipv6To:      ipv6,
+		qType:       dns.TypeAAAA,
+		wantAnswers: 1,
+	}, {
+		name:        "ipv6_no_ipv6_address",
+		prefer:      FamilyIPv6,
+		ipv6To:      nil,
+		qType:       dns.TypeA,
+		wantAnswers: 1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := netip.AddrPortFrom(localhost, freePort(t))
+			d, err := New(&Config{
+				ListenAddrs:    []netip.AddrPort{addr},
+				Upstreams:      []string{startUpstream(t)},
+				RedirectIPv4To: ipv4,
+				RedirectIPv6To: tc.ipv6To,
+				RedirectPrefer: tc.prefer,
+				RedirectRules:  []string{"*"},
+			})
+			require.NoError(t, err)
+			require.NoError(t, d.Start())
+			t.Cleanup(func() { _ = d.Close() })
+
+			req := (&dns.Msg{}).SetQuestion("example.org.", tc.qType)
+			resp := &dns.Msg{}
+			require.NoError(t, resp.Unpack(exchangeRaw(t, addr, req)))
+
+			// The response for the other family is NODATA rather than an
+			// error so that the clients don't give up on the domain.
+			assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+			assert.Len(t, resp.Answer, tc.wantAnswers)
+		})
+	}
+}
+
+func TestDNSProxy_requestHandler_redirectExclude(t *testing.T) {
+	addr := netip.AddrPortFrom(localhost, freePort(t))
+	d, err := New(&Config{
+		ListenAddrs:     []netip.AddrPort{addr},
+		Upstreams:       []string{startUpstream(t)},
+		RedirectIPv4To:  net.IPv4(127, 0, 0, 1),
+		RedirectRules:   []string{"*.example.org"},
+		RedirectExclude: []string{"static.example.org"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Start())
+	t.Cleanup(func() { _ = d.Close() })
+
+	testCases := []struct {
+		name         string
+		host         string
+		wantRedirect bool
+	}{{
+		name:         "redirected",
+		host:         "www.example.org.",
+		wantRedirect: true,
+	}, {
+		name:         "excluded",
+		host:         "static.example.org.",
+		wantRedirect: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := (&dns.Msg{}).SetQuestion(tc.host, dns.TypeA)
+			resp := &dns.Msg{}
+			require.NoError(t, resp.Unpack(exchangeRaw(t, addr, req)))
+			require.Len(t, resp.Answer, 1)
+
+			if tc.wantRedirect {
+				a, ok := resp.Answer[0].(*dns.A)
+				require.True(t, ok)
+
+				assert.Equal(t, net.IPv4(127, 0, 0, 1).To4(), a.A.To4())
+			} else {
+				// The excluded domain is answered by the upstream.
+				assert.IsType(t, &dns.TXT{}, resp.Answer[0])
+			}
+		})
+	}
+
+	assert.Contains(t, d.RuleStats(), filter.RuleStat{
+		Kind:    ruleKindRedirectExclude,
+		Rule:    "static.example.org",
+		Matches: 1,
+	})
+}
+
+func TestDNSProxy_requestHandler_class(t *testing.T) {
+	upstream := startUpstream(t)
+
+	testCases := []struct {
+		name          string
+		dropRules     []string
+		servedDomains []string
+		wantRcode     int
+		wantAnswer    bool
+		// wantDropped is true if the query must not be answered at all.
+		wantDropped bool
+	}{{
+		name:          "passed_through",
+		dropRules:     nil,
+		servedDomains: nil,
+		wantRcode:     dns.RcodeSuccess,
+		wantAnswer:    true,
+		wantDropped:   false,
+	}, {
+		name:          "dropped",
+		dropRules:     []string{"version.bind"},
+		servedDomains: nil,
+		wantRcode:     dns.RcodeSuccess,
+		wantAnswer:    false,
+		wantDropped:   true,
+	}, {
+		name:          "not_served",
+		dropRules:     nil,
+		servedDomains: []string{"*.example.org"},
+		wantRcode:     dns.RcodeRefused,
+		wantAnswer:    false,
+		wantDropped:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := netip.AddrPortFrom(localhost, freePort(t))
+
+			d, err := New(&Config{
+				ListenAddrs:    []netip.AddrPort{addr},
+				Upstreams:      []string{upstream},
+				RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+				DropRules:      tc.dropRules,
+				ServedDomains:  tc.servedDomains,
+			})
+			require.NoError(t, err)
+			require.NoError(t, d.Start())
+			t.Cleanup(func() { _ = d.Close() })
+
+			req := (&dns.Msg{}).SetQuestion("version.bind.", dns.TypeTXT)
+			req.Question[0].Qclass = dns.ClassCHAOS
+
+			client := &dns.Client{Timeout: 500 * time.Millisecond}
+			resp, _, err := client.Exchange(req, addr.String())
+			if tc.wantDropped {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+			if !tc.wantAnswer {
+				assert.Empty(t, resp.Answer)
+
+				return
+			}
+
+			require.Len(t, resp.Answer, 1)
+
+			txt, ok := resp.Answer[0].(*dns.TXT)
+			require.True(t, ok)
+
+			assert.Equal(t, uint16(dns.ClassCHAOS), txt.Hdr.Class)
+			assert.Equal(t, []string{"upstream"}, txt.Txt)
+		})
+	}
+}
+
+func TestDNSProxy_upstreamRetries(t *testing.T) {
+	// The plain upstream of dnsproxy retries a timed out exchange once
+	// itself, so every failed attempt to resolve the query takes two queries
+	// to the upstream.
+	testCases := []struct {
+		name     string
+		retries  int
+		failures int32
+		// wantQueries is the number of queries received by the upstream.
+		wantQueries int32
+		wantRcode   int
+	}{{
+		name:        "no_retries",
+		retries:     0,
+		failures:    1,
+		wantQueries: 2,
+		wantRcode:   dns.RcodeServerFailure,
+	}, {
+		name:        "retried",
+		retries:     2,
+		failures:    1,
+		wantQueries: 3,
+		wantRcode:   dns.RcodeSuccess,
+	}, {
+		name:        "retries_exhausted",
+		retries:     1,
+		failures:    2,
+		wantQueries: 4,
+		wantRcode:   dns.RcodeServerFailure,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The upstream doesn't answer the queries of the first
+			// tc.failures attempts, so that they time out.
+			var queries atomic.Int32
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+
+			srv := &dns.Server{
+				PacketConn: pc,
+				Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+					if queries.Add(1) <= 2*tc.failures {
+						return
+					}
+
+					_ = w.WriteMsg((&dns.Msg{}).SetReply(req))
+				}),
+			}
+			go func() { _ = srv.ActivateAndServe() }()
+			t.Cleanup(func() { _ = srv.Shutdown() })
+
+			addr := netip.AddrPortFrom(localhost, freePort(t))
+			d, err := New(&Config{
+				ListenAddrs:     []netip.AddrPort{addr},
+				Upstreams:       []string{pc.LocalAddr().String()},
+				UpstreamTimeout: 200 * time.Millisecond,
+				UpstreamRetries: tc.retries,
+				RedirectIPv4To:  net.IPv4(127, 0, 0, 1),
+			})
+			require.NoError(t, err)
+			require.NoError(t, d.Start())
+			t.Cleanup(func() { _ = d.Close() })
+
+			req := (&dns.Msg{}).SetQuestion("example.org.", dns.TypeA)
+			resp, _, err := (&dns.Client{Timeout: testTimeout}).Exchange(req, addr.String())
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+			assert.Equal(t, tc.wantQueries, queries.Load())
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package dnsproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// DefaultDoHPath is the default path of the DNS-over-HTTPS endpoint, see RFC
+// 8484.
+const DefaultDoHPath = "/dns-query"
+
+// dohTimeout is the read and write timeout of the DNS-over-HTTPS server.
+const dohTimeout = 10 * time.Second
+
+// dohServer is the DNS-over-HTTPS server.  Unlike the one built into
+// [proxy.Proxy], it only serves queries on the configured path and responds
+// with 404 to any other request.
+type dohServer struct {
+	srv  *http.Server
+	addr netip.AddrPort
+	path string
+	lc   *net.ListenConfig
+
+	// listener is nil until the server is started.
+	listener net.Listener
+}
+
+// newDoHServer creates a new *dohServer that passes the queries on path to h.
+// If freebind is true, the listener is bound with IP_FREEBIND.
+func newDoHServer(
+	addr netip.AddrPort,
+	path string,
+	h http.Handler,
+	tlsConfig *tls.Config,
+	freebind bool,
+) (s *dohServer, err error) {
+	if path == "" {
+		path = DefaultDoHPath
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("doh path %q must start with /", path)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, h)
+
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+
+	lc := &net.ListenConfig{}
+	if freebind {
+		lc.Control = freebindControl
+	}
+
+	return &dohServer{
+		srv: &http.Server{
+			Handler:           mux,
+			TLSConfig:         tlsConfig,
+			ReadHeaderTimeout: dohTimeout,
+			WriteTimeout:      dohTimeout,
+		},
+		addr: addr,
+		path: path,
+		lc:   lc,
+	}, nil
+}
+
+// start starts listening and serving DNS-over-HTTPS queries in a separate
+// goroutine.
+func (s *dohServer) start() (err error) {
+	s.listener, err = s.lc.Listen(context.Background(), "tcp", s.addr.String())
+	if err != nil {
+		return fmt.Errorf("dnsproxy: starting doh server: %w", err)
+	}
+
+	log.Info("dnsproxy: listening to https://%s%s", s.listener.Addr(), s.path)
+
+	go func() {
+		// The certificate is provided by TLSConfig.GetCertificate.
+		serveErr := s.srv.ServeTLS(s.listener, "", "")
+		if !errors.Is(serveErr, http.ErrServerClosed) {
+			log.Error("dnsproxy: doh server: %v", serveErr)
+		}
+	}()
+
+	return nil
+}
+
+// close stops the server.
+func (s *dohServer) close() (err error) {
+	if s.listener == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dohTimeout)
+	defer cancel()
+
+	return s.srv.Shutdown(ctx)
+}
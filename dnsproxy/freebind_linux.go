@@ -0,0 +1,34 @@
+//go:build linux
+
+package dnsproxy
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// freebindControl is a [net.ListenConfig.Control] function that sets
+// IP_FREEBIND or IPV6_FREEBIND on the listening socket so that it can be bound
+// to an address that is not assigned to the host yet.
+func freebindControl(network, _ string, c syscall.RawConn) (err error) {
+	var sockErr error
+	err = c.Control(func(fd uintptr) {
+		if strings.HasSuffix(network, "6") {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_FREEBIND, 1)
+		} else {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_FREEBIND, 1)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("dnsproxy: failed to control raw connection: %w", err)
+	}
+
+	if sockErr != nil {
+		return fmt.Errorf("dnsproxy: failed to set freebind: %w", sockErr)
+	}
+
+	return nil
+}
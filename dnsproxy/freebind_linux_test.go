@@ -0,0 +1,154 @@
+//go:build linux
+
+package dnsproxy
+
+import (
+	"net"
+	"net/netip"
+	"syscall"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// nonLocalAddr is an address from TEST-NET-1 that is not assigned to the host.
+var nonLocalAddr = netip.MustParseAddr("192.0.2.1")
+
+// freebindOpt returns the value of IP_FREEBIND of the socket.
+func freebindOpt(t *testing.T, c syscall.Conn) (v int) {
+	t.Helper()
+
+	raw, err := c.SyscallConn()
+	require.NoError(t, err)
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		v, sockErr = unix.GetsockoptInt(int(fd), unix.SOL_IP, unix.IP_FREEBIND)
+	})
+	require.NoError(t, err)
+	require.NoError(t, sockErr)
+
+	return v
+}
+
+func TestDNSProxy_Start_freebind(t *testing.T) {
+	testCases := []struct {
+		name     string
+		freebind bool
+		wantErr  bool
+	}{{
+		name:     "freebind",
+		freebind: true,
+		wantErr:  false,
+	}, {
+		name:     "no_freebind",
+		freebind: false,
+		wantErr:  true,
+	}}
+
+	certPath, keyPath := writeCert(t, t.TempDir(), "dns.example")
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := New(&Config{
+				ListenAddrs:     []netip.AddrPort{netip.AddrPortFrom(nonLocalAddr, freePort(t))},
+				HTTPSListenAddr: netip.AddrPortFrom(nonLocalAddr, freePort(t)),
+				TLSCertPath:     certPath,
+				TLSKeyPath:      keyPath,
+				Freebind:        tc.freebind,
+				Upstreams:       []string{"127.0.0.1:53"},
+				RedirectIPv4To:  net.IPv4(127, 0, 0, 1),
+			})
+			require.NoError(t, err)
+
+			err = d.Start()
+			t.Cleanup(func() { _ = d.Close() })
+
+			if tc.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			for _, srv := range d.plain.servers {
+				if srv.PacketConn != nil {
+					assert.Equal(t, 1, freebindOpt(t, srv.PacketConn.(*net.UDPConn)))
+				} else {
+					assert.Equal(t, 1, freebindOpt(t, srv.Listener.(*net.TCPListener)))
+				}
+			}
+
+			assert.Equal(t, 1, freebindOpt(t, d.doh.listener.(*net.TCPListener)))
+		})
+	}
+}
+
+func TestDNSProxy_serveDNS(t *testing.T) {
+	addr := netip.AddrPortFrom(localhost, freePort(t))
+
+	d, err := New(&Config{
+		ListenAddrs:    []netip.AddrPort{addr},
+		Freebind:       true,
+		Upstreams:      []string{startUpstream(t)},
+		RedirectIPv4To: net.IPv4(192, 0, 2, 2),
+		RedirectRules:  []string{"example.org"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Start())
+	t.Cleanup(func() { _ = d.Close() })
+
+	testCases := []struct {
+		name  string
+		net   string
+		qName string
+		qType uint16
+		want  dns.RR
+	}{{
+		name:  "udp_redirected",
+		net:   "udp",
+		qName: "example.org.",
+		qType: dns.TypeA,
+		want: &dns.A{
+			Hdr: dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: defaultTTL},
+			A:   net.IPv4(192, 0, 2, 2).To4(),
+		},
+	}, {
+		name:  "tcp_redirected",
+		net:   "tcp",
+		qName: "example.org.",
+		qType: dns.TypeA,
+		want: &dns.A{
+			Hdr: dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: defaultTTL},
+			A:   net.IPv4(192, 0, 2, 2).To4(),
+		},
+	}, {
+		name:  "udp_forwarded",
+		net:   "udp",
+		qName: "example.com.",
+		qType: dns.TypeA,
+		want: &dns.TXT{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+			Txt: []string{"upstream"},
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &dns.Client{Net: tc.net}
+			req := (&dns.Msg{}).SetQuestion(tc.qName, tc.qType)
+
+			resp, _, exErr := c.Exchange(req, addr.String())
+			require.NoError(t, exErr)
+			require.Len(t, resp.Answer, 1)
+
+			// Ignore the length of the record.
+			resp.Answer[0].Header().Rdlength = 0
+			assert.Equal(t, tc.want, resp.Answer[0])
+		})
+	}
+}
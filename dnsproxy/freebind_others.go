@@ -0,0 +1,14 @@
+//go:build !linux
+
+package dnsproxy
+
+import (
+	"errors"
+	"syscall"
+)
+
+// freebindControl is a [net.ListenConfig.Control] function that sets
+// IP_FREEBIND on the listening socket.  It is only supported on Linux.
+func freebindControl(_, _ string, _ syscall.RawConn) (err error) {
+	return errors.New("dnsproxy: freebind is only supported on linux")
+}
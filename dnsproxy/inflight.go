@@ -0,0 +1,84 @@
+package dnsproxy
+
+import (
+	"time"
+)
+
+// InflightMode defines how the queries that exceed the limit of the upstream
+// queries in flight are handled.
+type InflightMode string
+
+const (
+	// InflightModeRefuse makes the proxy answer the excess queries with
+	// REFUSED right away.
+	InflightModeRefuse InflightMode = "refuse"
+
+	// InflightModeDelay makes the excess queries wait for a free slot for up
+	// to inflightMaxWait.  The ones that don't get it in time are answered
+	// with REFUSED.
+	InflightModeDelay InflightMode = "delay"
+)
+
+// inflightMaxWait is the maximum time a query waits for a free slot in
+// InflightModeDelay.  The clients usually retry after a second or two, so
+// there is no point in waiting longer.
+const inflightMaxWait = time.Second
+
+// inflightLimiter limits the number of queries forwarded to the upstreams
+// simultaneously.
+type inflightLimiter struct {
+	// slots has a value for every query in flight.
+	slots chan struct{}
+
+	mode InflightMode
+}
+
+// newInflightLimiter creates a new *inflightLimiter that allows max queries in
+// flight.  It returns nil if max is not positive, i.e. there is no limit.
+func newInflightLimiter(max int, mode InflightMode) (l *inflightLimiter) {
+	if max <= 0 {
+		return nil
+	}
+
+	return &inflightLimiter{
+		slots: make(chan struct{}, max),
+		mode:  mode,
+	}
+}
+
+// acquire takes a slot for a query.  ok is false if there is no free slot,
+// otherwise the slot must be released.  It is safe to call it on nil
+// *inflightLimiter.
+func (l *inflightLimiter) acquire() (ok bool) {
+	if l == nil {
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if l.mode != InflightModeDelay {
+		return false
+	}
+
+	timer := time.NewTimer(inflightMaxWait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// release frees the slot taken by acquire.  It is safe to call it on nil
+// *inflightLimiter.
+func (l *inflightLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
@@ -0,0 +1,146 @@
+package dnsproxy
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInflightLimiter_acquire(t *testing.T) {
+	testCases := []struct {
+		name string
+		mode InflightMode
+		// releaseAfter is the time after which the taken slot is released.
+		// If zero, it isn't released.
+		releaseAfter time.Duration
+		want         bool
+		wantWait     time.Duration
+	}{{
+		name:         "refuse",
+		mode:         InflightModeRefuse,
+		releaseAfter: 100 * time.Millisecond,
+		want:         false,
+		wantWait:     0,
+	}, {
+		name:         "default",
+		mode:         "",
+		releaseAfter: 100 * time.Millisecond,
+		want:         false,
+		wantWait:     0,
+	}, {
+		name:         "delay",
+		mode:         InflightModeDelay,
+		releaseAfter: 100 * time.Millisecond,
+		want:         true,
+		wantWait:     100 * time.Millisecond,
+	}, {
+		name:         "delay_timeout",
+		mode:         InflightModeDelay,
+		releaseAfter: 0,
+		want:         false,
+		wantWait:     inflightMaxWait,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newInflightLimiter(1, tc.mode)
+			require.True(t, l.acquire())
+
+			if tc.releaseAfter > 0 {
+				timer := time.AfterFunc(tc.releaseAfter, l.release)
+				t.Cleanup(func() { timer.Stop() })
+			}
+
+			start := time.Now()
+			assert.Equal(t, tc.want, l.acquire())
+
+			elapsed := time.Since(start)
+			assert.GreaterOrEqual(t, elapsed, tc.wantWait*8/10)
+			assert.Less(t, elapsed, tc.wantWait+500*time.Millisecond)
+		})
+	}
+}
+
+func TestInflightLimiter_nil(t *testing.T) {
+	l := newInflightLimiter(0, InflightModeRefuse)
+	require.Nil(t, l)
+
+	assert.True(t, l.acquire())
+	assert.NotPanics(t, l.release)
+}
+
+func TestDNSProxy_forward_inflight(t *testing.T) {
+	testCases := []struct {
+		name string
+		mode InflightMode
+		// wantRcode is the response code of the query made while another one
+		// is in flight.
+		wantRcode int
+	}{{
+		name:      "refuse",
+		mode:      InflightModeRefuse,
+		wantRcode: dns.RcodeRefused,
+	}, {
+		name:      "delay",
+		mode:      InflightModeDelay,
+		wantRcode: dns.RcodeSuccess,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			received := make(chan struct{}, 2)
+			gate := make(chan struct{})
+			upstream := startGatedUpstream(t, received, gate)
+
+			addr := netip.AddrPortFrom(localhost, freePort(t))
+			d, err := New(&Config{
+				ListenAddrs:    []netip.AddrPort{addr},
+				Upstreams:      []string{upstream},
+				RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+				MaxInflight:    1,
+				InflightMode:   tc.mode,
+			})
+			require.NoError(t, err)
+			require.NoError(t, d.Start())
+			t.Cleanup(func() { _ = d.Close() })
+
+			// Queries of other classes are always forwarded.
+			newReq := func(name string) (req *dns.Msg) {
+				req = (&dns.Msg{}).SetQuestion(name, dns.TypeTXT)
+				req.Question[0].Qclass = dns.ClassCHAOS
+
+				return req
+			}
+
+			client := &dns.Client{Timeout: 5 * time.Second}
+
+			firstErrs := make(chan error, 1)
+			go func() {
+				_, _, exErr := client.Exchange(newReq("first.example."), addr.String())
+				firstErrs <- exErr
+			}()
+
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("upstream received no query")
+			}
+
+			// Let the upstream answer the first query a bit later, so that the
+			// delayed query gets the slot.
+			timer := time.AfterFunc(100*time.Millisecond, func() { close(gate) })
+			t.Cleanup(func() { timer.Stop() })
+
+			resp, _, err := client.Exchange(newReq("second.example."), addr.String())
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+
+			require.NoError(t, <-firstErrs)
+		})
+	}
+}
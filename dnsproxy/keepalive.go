@@ -0,0 +1,59 @@
+package dnsproxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+)
+
+// maxEDNSKeepalive is the maximum keepalive timeout that can be advertised.
+// The TCP listeners close the connections that are idle for longer, so
+// advertising a longer timeout would mislead the clients.
+const maxEDNSKeepalive = 10 * time.Second
+
+// validateEDNSKeepalive checks that the keepalive timeout can be advertised.
+func validateEDNSKeepalive(timeout time.Duration) (err error) {
+	if timeout < 0 || timeout > maxEDNSKeepalive {
+		return fmt.Errorf("edns keepalive must be between 0 and %s, got %s", maxEDNSKeepalive, timeout)
+	}
+
+	return nil
+}
+
+// addEDNSKeepalive adds the edns-tcp-keepalive option (RFC 7828) to the
+// response if it is enabled.  According to the RFC, the option is only sent
+// over TCP and TLS and only to the clients that sent an OPT record.
+func (d *DNSProxy) addEDNSKeepalive(ctx *proxy.DNSContext) {
+	if d.ednsKeepalive == 0 || ctx.Res == nil {
+		return
+	}
+
+	if ctx.Proto != proxy.ProtoTCP && ctx.Proto != proxy.ProtoTLS {
+		return
+	}
+
+	reqOpt := ctx.Req.IsEdns0()
+	if reqOpt == nil {
+		return
+	}
+
+	opt := ctx.Res.IsEdns0()
+	if opt == nil {
+		ctx.Res.SetEdns0(reqOpt.UDPSize(), reqOpt.Do())
+		opt = ctx.Res.IsEdns0()
+	}
+
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0TCPKEEPALIVE {
+			return
+		}
+	}
+
+	opt.Option = append(opt.Option, &dns.EDNS0_TCP_KEEPALIVE{
+		Code: dns.EDNS0TCPKEEPALIVE,
+		// The timeout is encoded in units of 100 milliseconds.
+		Timeout: uint16(d.ednsKeepalive / (100 * time.Millisecond)),
+	})
+}
@@ -0,0 +1,103 @@
+package dnsproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSProxy_addEDNSKeepalive(t *testing.T) {
+	// newReq returns a query with an OPT record if edns is true.
+	newReq := func(edns bool) (req *dns.Msg) {
+		req = (&dns.Msg{}).SetQuestion("example.org.", dns.TypeA)
+		if edns {
+			req.SetEdns0(dns.DefaultMsgSize, false)
+		}
+
+		return req
+	}
+
+	testCases := []struct {
+		name      string
+		proto     proxy.Proto
+		keepalive time.Duration
+		edns      bool
+		// wantTimeout is the advertised timeout in units of 100 ms, zero if
+		// the option must not be sent.
+		wantTimeout uint16
+	}{{
+		name:        "disabled",
+		proto:       proxy.ProtoTCP,
+		keepalive:   0,
+		edns:        true,
+		wantTimeout: 0,
+	}, {
+		name:        "tcp",
+		proto:       proxy.ProtoTCP,
+		keepalive:   5 * time.Second,
+		edns:        true,
+		wantTimeout: 50,
+	}, {
+		name:        "tls",
+		proto:       proxy.ProtoTLS,
+		keepalive:   10 * time.Second,
+		edns:        true,
+		wantTimeout: 100,
+	}, {
+		name:        "udp",
+		proto:       proxy.ProtoUDP,
+		keepalive:   5 * time.Second,
+		edns:        true,
+		wantTimeout: 0,
+	}, {
+		name:        "no_edns",
+		proto:       proxy.ProtoTCP,
+		keepalive:   5 * time.Second,
+		edns:        false,
+		wantTimeout: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &DNSProxy{ednsKeepalive: tc.keepalive}
+
+			req := newReq(tc.edns)
+			ctx := &proxy.DNSContext{
+				Proto: tc.proto,
+				Req:   req,
+				Res:   (&dns.Msg{}).SetReply(req),
+			}
+
+			d.addEDNSKeepalive(ctx)
+
+			opt := ctx.Res.IsEdns0()
+			if tc.wantTimeout == 0 {
+				if opt != nil {
+					assert.Empty(t, opt.Option)
+				}
+
+				return
+			}
+
+			require.NotNil(t, opt)
+			require.Len(t, opt.Option, 1)
+
+			keepalive, ok := opt.Option[0].(*dns.EDNS0_TCP_KEEPALIVE)
+			require.True(t, ok)
+
+			assert.Equal(t, tc.wantTimeout, keepalive.Timeout)
+		})
+	}
+}
+
+func TestValidateEDNSKeepalive(t *testing.T) {
+	assert.NoError(t, validateEDNSKeepalive(0))
+	assert.NoError(t, validateEDNSKeepalive(maxEDNSKeepalive))
+
+	assert.Error(t, validateEDNSKeepalive(-time.Second))
+	assert.Error(t, validateEDNSKeepalive(maxEDNSKeepalive+time.Second))
+}
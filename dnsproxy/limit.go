@@ -0,0 +1,137 @@
+package dnsproxy
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiterTTL is how long the token bucket of a client that doesn't send
+// queries is kept.  It must be long enough for the bucket to refill.
+const rateLimiterTTL = time.Minute
+
+// maxClientBuckets is the maximum number of the clients the token buckets are
+// kept for.  Source addresses of UDP queries are easy to spoof, so without the
+// limit a flood of queries from random addresses would exhaust the memory.
+const maxClientBuckets = 100_000
+
+// fullCleanupInterval is the minimum interval between the removals of the
+// stale buckets when there are maxClientBuckets of them, so that a flood of
+// queries from new clients doesn't make every query scan all the buckets.
+const fullCleanupInterval = time.Second
+
+// clientLimiter limits the rate of DNS queries from a single client IP address
+// using a token bucket per client.
+type clientLimiter struct {
+	// mu protects buckets and lastCleanup.
+	mu sync.Mutex
+
+	// buckets are the token buckets per client IP.
+	buckets map[netip.Addr]*clientBucket
+
+	// lastCleanup is the last time the stale buckets were removed.
+	lastCleanup time.Time
+
+	// limit is the number of queries per second allowed for a single client.
+	limit rate.Limit
+
+	// burst is the number of queries a client may send at once.
+	burst int
+
+	// maxBuckets is the maximum number of buckets, see maxClientBuckets.
+	maxBuckets int
+}
+
+// clientBucket is the token bucket of a single client.
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newClientLimiter creates a new *clientLimiter that allows qps queries per
+// second from a single client.  It returns nil if qps is zero, i.e. there is no
+// limit.
+func newClientLimiter(qps int) (l *clientLimiter) {
+	if qps <= 0 {
+		return nil
+	}
+
+	return &clientLimiter{
+		buckets:     map[netip.Addr]*clientBucket{},
+		lastCleanup: time.Now(),
+		limit:       rate.Limit(qps),
+		burst:       qps,
+		maxBuckets:  maxClientBuckets,
+	}
+}
+
+// allow returns true if a query from ip is allowed.  When there are
+// maxClientBuckets clients already and none of them are stale, the queries from
+// new clients are not allowed until the stale buckets are removed.  It is safe
+// to call it on nil *clientLimiter.
+func (l *clientLimiter) allow(ip netip.Addr) (ok bool) {
+	if l == nil {
+		return true
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastCleanup) > rateLimiterTTL {
+		l.cleanup(now)
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		if len(l.buckets) >= l.maxBuckets && now.Sub(l.lastCleanup) > fullCleanupInterval {
+			l.cleanup(now)
+		}
+
+		if len(l.buckets) >= l.maxBuckets {
+			return false
+		}
+
+		b = &clientBucket{
+			limiter: rate.NewLimiter(l.limit, l.burst),
+		}
+		l.buckets[ip] = b
+	}
+
+	b.lastSeen = now
+
+	return b.limiter.AllowN(now, 1)
+}
+
+// cleanup removes the buckets of the clients that haven't sent queries for
+// rateLimiterTTL.  l.mu must be locked.
+func (l *clientLimiter) cleanup(now time.Time) {
+	for ip, b := range l.buckets {
+		if now.Sub(b.lastSeen) > rateLimiterTTL {
+			delete(l.buckets, ip)
+		}
+	}
+
+	l.lastCleanup = now
+}
+
+// clientIP returns the IP address of the client that sent the query.
+func clientIP(addr net.Addr) (ip netip.Addr) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip, _ = netip.AddrFromSlice(a.IP)
+	case *net.TCPAddr:
+		ip, _ = netip.AddrFromSlice(a.IP)
+	default:
+		addrPort, err := netip.ParseAddrPort(addr.String())
+		if err == nil {
+			ip = addrPort.Addr()
+		}
+	}
+
+	return ip.Unmap()
+}
@@ -0,0 +1,56 @@
+package dnsproxy
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientLimiter_allow(t *testing.T) {
+	l := newClientLimiter(2)
+
+	ip := netip.MustParseAddr("192.0.2.1")
+	assert.True(t, l.allow(ip))
+	assert.True(t, l.allow(ip))
+	assert.False(t, l.allow(ip))
+
+	// Other clients have their own buckets.
+	assert.True(t, l.allow(netip.MustParseAddr("192.0.2.2")))
+
+	var nilLimiter *clientLimiter
+	assert.True(t, nilLimiter.allow(ip))
+}
+
+func TestClientLimiter_maxBuckets(t *testing.T) {
+	const maxBuckets = 10
+
+	l := newClientLimiter(1)
+	l.maxBuckets = maxBuckets
+
+	addr := netip.MustParseAddr("192.0.2.0")
+	for i := 0; i < maxBuckets; i++ {
+		addr = addr.Next()
+		assert.True(t, l.allow(addr))
+	}
+
+	// The new clients are refused while the buckets are full.
+	newClient := netip.MustParseAddr("198.51.100.1")
+	assert.False(t, l.allow(newClient))
+	assert.Len(t, l.buckets, maxBuckets)
+
+	// The known clients are still limited as usual.
+	assert.False(t, l.allow(addr))
+
+	// Once the buckets are stale, they are removed and new clients are
+	// allowed again.
+	stale := time.Now().Add(-2 * rateLimiterTTL)
+	for _, b := range l.buckets {
+		b.lastSeen = stale
+	}
+	l.lastCleanup = stale
+
+	assert.True(t, l.allow(newClient))
+	assert.Len(t, l.buckets, 1)
+}
@@ -0,0 +1,146 @@
+package dnsproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// plainTimeout is the read and write timeout of the plain DNS servers.  It is
+// also the idle timeout of the TCP connections, see [Config.EDNSKeepalive].
+const plainTimeout = 10 * time.Second
+
+// plainServer serves plain DNS over UDP and TCP on the listeners it creates
+// itself rather than the ones of [proxy.Proxy], which can't be bound with
+// custom socket options.  It is only used when [Config.Freebind] is set.
+type plainServer struct {
+	// servers are the UDP and TCP servers, they are nil until the server is
+	// started.
+	servers []*dns.Server
+
+	addrs   []netip.AddrPort
+	handler dns.Handler
+	lc      *net.ListenConfig
+}
+
+// newPlainServer creates a new *plainServer that listens on addrs and passes
+// the queries to d.
+func newPlainServer(addrs []netip.AddrPort, d *DNSProxy) (s *plainServer) {
+	return &plainServer{
+		addrs:   addrs,
+		handler: dns.HandlerFunc(d.serveDNS),
+		lc:      &net.ListenConfig{Control: freebindControl},
+	}
+}
+
+// start starts listening on all the addresses and serving the queries in
+// separate goroutines.
+func (s *plainServer) start() (err error) {
+	ctx := context.Background()
+	for _, addr := range s.addrs {
+		pc, lErr := s.lc.ListenPacket(ctx, "udp", addr.String())
+		if lErr != nil {
+			return fmt.Errorf("dnsproxy: listening to udp://%s: %w", addr, lErr)
+		}
+
+		s.serve(&dns.Server{PacketConn: pc, Handler: s.handler, ReadTimeout: plainTimeout})
+
+		l, lErr := s.lc.Listen(ctx, "tcp", addr.String())
+		if lErr != nil {
+			return fmt.Errorf("dnsproxy: listening to tcp://%s: %w", addr, lErr)
+		}
+
+		s.serve(&dns.Server{
+			Listener:     l,
+			Handler:      s.handler,
+			ReadTimeout:  plainTimeout,
+			WriteTimeout: plainTimeout,
+			IdleTimeout:  func() time.Duration { return plainTimeout },
+		})
+	}
+
+	return nil
+}
+
+// serve starts serving srv in a separate goroutine.
+func (s *plainServer) serve(srv *dns.Server) {
+	s.servers = append(s.servers, srv)
+
+	if srv.Listener == nil {
+		log.Info("dnsproxy: listening to udp://%s", srv.PacketConn.LocalAddr())
+	} else {
+		log.Info("dnsproxy: listening to tcp://%s", srv.Listener.Addr())
+	}
+
+	go func() {
+		err := srv.ActivateAndServe()
+		if err != nil {
+			log.Error("dnsproxy: plain dns server: %v", err)
+		}
+	}()
+}
+
+// close stops all the servers.
+func (s *plainServer) close() (err error) {
+	var errs []error
+	for _, srv := range s.servers {
+		errs = append(errs, srv.Shutdown())
+	}
+
+	return errors.Join(errs...)
+}
+
+// serveDNS implements the [dns.Handler] interface for the queries received by
+// the plain DNS servers.  It handles them the same way as [proxy.Proxy] does
+// before passing them to the request handler.
+func (d *DNSProxy) serveDNS(w dns.ResponseWriter, req *dns.Msg) {
+	proto := proxy.ProtoUDP
+	if _, ok := w.LocalAddr().(*net.TCPAddr); ok {
+		proto = proxy.ProtoTCP
+	}
+
+	if req.Response {
+		log.Debug("dnsproxy: dropping incoming reply packet from %s", w.RemoteAddr())
+
+		return
+	}
+
+	ctx := &proxy.DNSContext{
+		Proto:     proto,
+		Req:       req,
+		Addr:      w.RemoteAddr(),
+		StartTime: time.Now(),
+	}
+
+	var err error
+	if len(req.Question) != 1 {
+		log.Debug("dnsproxy: got invalid number of questions: %d", len(req.Question))
+		ctx.Res = (&dns.Msg{}).SetRcode(req, dns.RcodeServerFailure)
+	} else {
+		err = d.requestHandler(d.proxy, ctx)
+	}
+
+	if err != nil {
+		log.Debug("dnsproxy: handling dns query: %v", err)
+	}
+
+	if ctx.Res == nil {
+		// Closes the TCP connection the same way as the proxy does, it's
+		// a no-op for UDP.
+		_ = w.Close()
+
+		return
+	}
+
+	err = w.WriteMsg(ctx.Res)
+	if err != nil {
+		log.Debug("dnsproxy: writing dns response: %v", err)
+	}
+}
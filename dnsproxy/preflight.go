@@ -0,0 +1,28 @@
+package dnsproxy
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// CheckUpstreams resolves the A records of domainName with every general
+// upstream and returns the results keyed by the upstream address.  The error
+// is nil for the upstreams that answered successfully.  It is intended for
+// catching misconfigured upstreams on startup.
+func (d *DNSProxy) CheckUpstreams(domainName string) (results map[string]error) {
+	results = map[string]error{}
+	for _, u := range d.proxy.UpstreamConfig.Upstreams {
+		req := &dns.Msg{}
+		req.SetQuestion(dns.Fqdn(domainName), dns.TypeA)
+
+		resp, err := u.Exchange(req)
+		if err == nil && resp.Rcode != dns.RcodeSuccess {
+			err = fmt.Errorf("unexpected response code %s", dns.RcodeToString[resp.Rcode])
+		}
+
+		results[u.Address()] = err
+	}
+
+	return results
+}
@@ -0,0 +1,34 @@
+package dnsproxy
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSProxy_CheckUpstreams(t *testing.T) {
+	// dead is an upstream that never answers.
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = dead.Close() })
+
+	alive := startUpstream(t)
+
+	d, err := New(&Config{
+		ListenAddrs:     []netip.AddrPort{netip.AddrPortFrom(localhost, 0)},
+		Upstreams:       []string{alive, dead.LocalAddr().String()},
+		UpstreamTimeout: 100 * time.Millisecond,
+		RedirectIPv4To:  net.IPv4(127, 0, 0, 1),
+	})
+	require.NoError(t, err)
+
+	results := d.CheckUpstreams("example.org")
+	require.Len(t, results, 2)
+
+	assert.NoError(t, results[alive])
+	assert.Error(t, results[dead.LocalAddr().String()])
+}
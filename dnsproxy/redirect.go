@@ -0,0 +1,86 @@
+package dnsproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ruleOptionsSep separates the wildcard of a redirect rule from its options,
+// e.g. "*.example.org;ttl=5".
+const ruleOptionsSep = ";"
+
+// redirectRule is a parsed redirect rule.
+type redirectRule struct {
+	// text is the rule as it is configured.  It identifies the rule in the
+	// logs and the statistics.
+	text string
+
+	// wildcard is the wildcard the domain names are matched against.
+	wildcard string
+
+	// ttl is the TTL of the rewritten records.
+	ttl uint32
+}
+
+// parseRedirectRules parses the redirect rules in the
+// "wildcard[;option=value...]" format.  The only supported option is "ttl",
+// the TTL of the rewritten records in seconds.  If not set, [defaultTTL] is
+// used.
+func parseRedirectRules(rules []string) (parsed []*redirectRule, err error) {
+	for _, s := range rules {
+		r, pErr := parseRedirectRule(s)
+		if pErr != nil {
+			return nil, fmt.Errorf("redirect rule %q: %w", s, pErr)
+		}
+
+		parsed = append(parsed, r)
+	}
+
+	return parsed, nil
+}
+
+// parseRedirectRule parses a single redirect rule, see [parseRedirectRules].
+func parseRedirectRule(s string) (r *redirectRule, err error) {
+	parts := strings.Split(s, ruleOptionsSep)
+	r = &redirectRule{
+		text:     s,
+		wildcard: parts[0],
+		ttl:      defaultTTL,
+	}
+
+	if r.wildcard == "" {
+		return nil, fmt.Errorf("empty wildcard")
+	}
+
+	for _, opt := range parts[1:] {
+		key, val, ok := strings.Cut(opt, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected option=value, got %q", opt)
+		}
+
+		switch key {
+		case "ttl":
+			var ttl uint64
+			ttl, err = strconv.ParseUint(val, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ttl: %w", err)
+			}
+
+			r.ttl = uint32(ttl)
+		default:
+			return nil, fmt.Errorf("unknown option %q", key)
+		}
+	}
+
+	return r, nil
+}
+
+// redirectRuleTexts returns the redirect rules as they are configured.
+func (d *DNSProxy) redirectRuleTexts() (texts []string) {
+	for _, r := range d.redirectRules {
+		texts = append(texts, r.text)
+	}
+
+	return texts
+}
@@ -0,0 +1,132 @@
+package dnsproxy
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRedirectRule(t *testing.T) {
+	testCases := []struct {
+		name       string
+		in         string
+		want       *redirectRule
+		wantErrMsg string
+	}{{
+		name: "plain",
+		in:   "*.example.org",
+		want: &redirectRule{
+			text:     "*.example.org",
+			wildcard: "*.example.org",
+			ttl:      defaultTTL,
+		},
+		wantErrMsg: "",
+	}, {
+		name: "ttl",
+		in:   "*.example.org;ttl=5",
+		want: &redirectRule{
+			text:     "*.example.org;ttl=5",
+			wildcard: "*.example.org",
+			ttl:      5,
+		},
+		wantErrMsg: "",
+	}, {
+		name: "zero_ttl",
+		in:   "example.org;ttl=0",
+		want: &redirectRule{
+			text:     "example.org;ttl=0",
+			wildcard: "example.org",
+			ttl:      0,
+		},
+		wantErrMsg: "",
+	}, {
+		name:       "empty_wildcard",
+		in:         ";ttl=5",
+		want:       nil,
+		wantErrMsg: "empty wildcard",
+	}, {
+		name:       "no_value",
+		in:         "example.org;ttl",
+		want:       nil,
+		wantErrMsg: `expected option=value, got "ttl"`,
+	}, {
+		name:       "negative_ttl",
+		in:         "example.org;ttl=-1",
+		want:       nil,
+		wantErrMsg: `invalid ttl: strconv.ParseUint: parsing "-1": invalid syntax`,
+	}, {
+		name:       "too_large_ttl",
+		in:         "example.org;ttl=4294967296",
+		want:       nil,
+		wantErrMsg: `invalid ttl: strconv.ParseUint: parsing "4294967296": value out of range`,
+	}, {
+		name:       "unknown_option",
+		in:         "example.org;ipv4=1.2.3.4",
+		want:       nil,
+		wantErrMsg: `unknown option "ipv4"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := parseRedirectRule(tc.in)
+			if tc.wantErrMsg != "" {
+				assert.EqualError(t, err, tc.wantErrMsg)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, r)
+		})
+	}
+}
+
+func TestDNSProxy_requestHandler_redirectTTL(t *testing.T) {
+	addr := netip.AddrPortFrom(localhost, freePort(t))
+	d, err := New(&Config{
+		ListenAddrs:    []netip.AddrPort{addr},
+		Upstreams:      []string{startUpstream(t)},
+		RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+		RedirectRules:  []string{"short.example.org;ttl=5", "*.example.org"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Start())
+	t.Cleanup(func() { _ = d.Close() })
+
+	testCases := []struct {
+		name    string
+		host    string
+		wantTTL uint32
+	}{{
+		name:    "rule_ttl",
+		host:    "short.example.org.",
+		wantTTL: 5,
+	}, {
+		name:    "default_ttl",
+		host:    "www.example.org.",
+		wantTTL: defaultTTL,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := (&dns.Msg{}).SetQuestion(tc.host, dns.TypeA)
+			resp := &dns.Msg{}
+			require.NoError(t, resp.Unpack(exchangeRaw(t, addr, req)))
+			require.Len(t, resp.Answer, 1)
+
+			assert.Equal(t, tc.wantTTL, resp.Answer[0].Header().Ttl)
+		})
+	}
+
+	_, err = New(&Config{
+		ListenAddrs:    []netip.AddrPort{addr},
+		Upstreams:      []string{"127.0.0.1:53"},
+		RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+		RedirectRules:  []string{"example.org;ttl=abc"},
+	})
+	assert.ErrorContains(t, err, `redirect rule "example.org;ttl=abc": invalid ttl`)
+}
@@ -0,0 +1,60 @@
+package dnsproxy
+
+import (
+	"github.com/ameshkov/sniproxy/internal/filter"
+)
+
+// Rules is a read-only snapshot of the rules that are currently used by the DNS
+// proxy.
+type Rules struct {
+	RedirectRules       []string `json:"redirect_rules"`
+	RedirectExclude     []string `json:"redirect_exclude"`
+	RedirectExcludeApex bool     `json:"redirect_exclude_apex"`
+	RedirectIPv4To      string   `json:"redirect_ipv4_to"`
+	RedirectIPv6To      string   `json:"redirect_ipv6_to"`
+	DropRules           []string `json:"drop_rules"`
+	ServedDomains       []string `json:"served_domains"`
+	ProxyHostname       string   `json:"proxy_hostname"`
+}
+
+// Rules returns a snapshot of the rules that are currently used by the proxy.
+// It is safe to modify the returned value.
+func (d *DNSProxy) Rules() (r *Rules) {
+	r = &Rules{
+		RedirectRules:       d.redirectRuleTexts(),
+		RedirectExclude:     append([]string(nil), d.redirectExclude...),
+		RedirectExcludeApex: d.excludeApex,
+		DropRules:           append([]string(nil), d.dropRules...),
+		ServedDomains:       append([]string(nil), d.servedDomains...),
+		ProxyHostname:       d.proxyHostname,
+	}
+
+	if d.redirectIPv4To != nil {
+		r.RedirectIPv4To = d.redirectIPv4To.String()
+	}
+
+	if d.redirectIPv6To != nil {
+		r.RedirectIPv6To = d.redirectIPv6To.String()
+	}
+
+	return r
+}
+
+// Kinds of the rules in the rule statistics.
+const (
+	ruleKindRedirect        = "redirect"
+	ruleKindRedirectExclude = "redirect_exclude"
+	ruleKindDrop            = "drop"
+	ruleKindServed          = "served"
+)
+
+// RuleStats returns how many times each of the rules matched a query.  The
+// rules that never matched are included with zero matches.
+func (d *DNSProxy) RuleStats() (stats []filter.RuleStat) {
+	stats = append(stats, d.ruleStats.Collect(ruleKindRedirect, d.redirectRuleTexts())...)
+	stats = append(stats, d.ruleStats.Collect(ruleKindRedirectExclude, d.redirectExclude)...)
+
+	stats = append(stats, d.ruleStats.Collect(ruleKindDrop, d.dropRules)...)
+
+	return append(stats, d.ruleStats.Collect(ruleKindServed, d.servedDomains)...)
+}
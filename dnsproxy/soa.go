@@ -0,0 +1,42 @@
+package dnsproxy
+
+import (
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+)
+
+// Fields of the SOA record of the responses to the dropped queries.  The names
+// are under the reserved .invalid TLD so that they never clash with the real
+// ones.
+const (
+	dropSOANs   = "ns.sniproxy.invalid."
+	dropSOAMbox = "hostmaster.sniproxy.invalid."
+)
+
+// respondDropSOA answers the dropped query with NODATA and a SOA record in the
+// authority section.  Unlike an unanswered query, such response is cached by
+// the resolvers for defaultTTL as described in RFC 2308, so that the clients
+// back off instead of retrying.
+func (d *DNSProxy) respondDropSOA(qName string, ctx *proxy.DNSContext) {
+	resp := &dns.Msg{}
+	resp.SetReply(ctx.Req)
+	resp.Compress = !d.noCompress
+
+	resp.Ns = append(resp.Ns, &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   qName,
+			Rrtype: dns.TypeSOA,
+			Class:  ctx.Req.Question[0].Qclass,
+			Ttl:    defaultTTL,
+		},
+		Ns:      dropSOANs,
+		Mbox:    dropSOAMbox,
+		Serial:  1,
+		Refresh: 1800,
+		Retry:   900,
+		Expire:  604800,
+		Minttl:  defaultTTL,
+	})
+
+	ctx.Res = resp
+}
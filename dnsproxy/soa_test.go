@@ -0,0 +1,78 @@
+package dnsproxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/ameshkov/sniproxy/internal/filter"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSProxy_drop_soa(t *testing.T) {
+	testCases := []struct {
+		name    string
+		dropSOA bool
+	}{{
+		name:    "no_response",
+		dropSOA: false,
+	}, {
+		name:    "soa",
+		dropSOA: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &DNSProxy{
+				dropRules: []string{"*.example.org"},
+				dropSOA:   tc.dropSOA,
+				ruleStats: filter.NewStats(),
+			}
+
+			const qName = "ads.example.org."
+			ctx := &proxy.DNSContext{
+				Req:  (&dns.Msg{}).SetQuestion(qName, dns.TypeA),
+				Addr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 53},
+			}
+
+			require.True(t, d.drop(ctx, qName, dns.TypeA, "ads.example.org"))
+
+			if !tc.dropSOA {
+				assert.Nil(t, ctx.Res)
+
+				return
+			}
+
+			require.NotNil(t, ctx.Res)
+
+			assert.Equal(t, dns.RcodeSuccess, ctx.Res.Rcode)
+			assert.Empty(t, ctx.Res.Answer)
+			require.Len(t, ctx.Res.Ns, 1)
+
+			soa, ok := ctx.Res.Ns[0].(*dns.SOA)
+			require.True(t, ok)
+
+			assert.Equal(t, qName, soa.Hdr.Name)
+			assert.Equal(t, uint32(defaultTTL), soa.Hdr.Ttl)
+			assert.Equal(t, uint32(defaultTTL), soa.Minttl)
+			assert.Equal(t, dropSOANs, soa.Ns)
+		})
+	}
+}
+
+func TestDNSProxy_drop_notMatched(t *testing.T) {
+	d := &DNSProxy{
+		dropRules: []string{"*.example.org"},
+		dropSOA:   true,
+		ruleStats: filter.NewStats(),
+	}
+
+	ctx := &proxy.DNSContext{
+		Req: (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA),
+	}
+
+	assert.False(t, d.drop(ctx, "example.com.", dns.TypeA, "example.com"))
+	assert.Nil(t, ctx.Res)
+}
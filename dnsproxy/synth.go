@@ -0,0 +1,90 @@
+package dnsproxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// Synthesis is the way AAAA answers are synthesized from RedirectIPv4To when
+// RedirectIPv6To is not set.
+type Synthesis string
+
+const (
+	// SynthesisMapped answers with the IPv4-mapped IPv6 address, e.g.
+	// ::ffff:1.2.3.4.
+	SynthesisMapped Synthesis = "mapped"
+
+	// SynthesisNAT64 answers with the IPv4 address embedded into the NAT64
+	// prefix as described in RFC 6052, e.g. 64:ff9b::102:304.
+	SynthesisNAT64 Synthesis = "nat64"
+)
+
+// DefaultNAT64Prefix is the well-known NAT64 prefix, see RFC 6052.
+var DefaultNAT64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// synthesizedIPv6 returns the address the AAAA queries for the redirected
+// domains are answered with when only RedirectIPv4To is set.  It returns nil
+// if the answers should not be synthesized.
+func synthesizedIPv6(cfg *Config) (ip net.IP, err error) {
+	if cfg.SynthesizeAAAA == "" || cfg.RedirectIPv6To != nil || cfg.RedirectIPv4To == nil {
+		return nil, nil
+	}
+
+	ip4, ok := netip.AddrFromSlice(cfg.RedirectIPv4To.To4())
+	if !ok {
+		return nil, fmt.Errorf("redirect address %s is not an IPv4 address", cfg.RedirectIPv4To)
+	}
+
+	switch cfg.SynthesizeAAAA {
+	case SynthesisMapped:
+		return net.IP(netip.AddrFrom16(ip4.As16()).AsSlice()), nil
+	case SynthesisNAT64:
+		prefix := cfg.NAT64Prefix
+		if !prefix.IsValid() {
+			prefix = DefaultNAT64Prefix
+		}
+
+		var addr netip.Addr
+		addr, err = embedIPv4(prefix, ip4)
+		if err != nil {
+			return nil, err
+		}
+
+		return net.IP(addr.AsSlice()), nil
+	default:
+		return nil, fmt.Errorf("unknown aaaa synthesis %q", cfg.SynthesizeAAAA)
+	}
+}
+
+// embedIPv4 embeds ip4 into the NAT64 prefix as described in RFC 6052,
+// section 2.2.  The bits 64 to 71 of the result are always zero.
+func embedIPv4(prefix netip.Prefix, ip4 netip.Addr) (addr netip.Addr, err error) {
+	if !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+		return addr, fmt.Errorf("nat64 prefix %s is not an IPv6 prefix", prefix)
+	}
+
+	bits := prefix.Bits()
+	switch bits {
+	case 32, 40, 48, 56, 64, 96:
+		// Go on.
+	default:
+		return addr, fmt.Errorf("nat64 prefix %s must be /32, /40, /48, /56, /64, or /96", prefix)
+	}
+
+	b := prefix.Masked().Addr().As16()
+	v4 := ip4.As4()
+
+	pos := bits / 8
+	for _, octet := range v4 {
+		if pos == 8 {
+			// Skip the "u" octet.
+			pos++
+		}
+
+		b[pos] = octet
+		pos++
+	}
+
+	return netip.AddrFrom16(b), nil
+}
@@ -0,0 +1,111 @@
+package dnsproxy
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// tapQueueSize is the number of queries waiting to be sent to the tap.  The
+// queries that don't fit are not mirrored.
+const tapQueueSize = 1024
+
+// tap mirrors the queries to a secondary resolver or a logging endpoint over
+// UDP.  Its responses are ignored.  Mirroring never blocks the processing of
+// the queries: they are sent by a separate goroutine and the ones that the
+// goroutine can't keep up with are discarded.
+type tap struct {
+	conn net.Conn
+
+	// queue is the queue of the packed queries to send.
+	queue chan []byte
+
+	// wg tracks the sending goroutine.
+	wg sync.WaitGroup
+
+	// mu protects closed, so that the queries that are still being processed
+	// when the proxy is stopped aren't sent to the closed queue.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// newTap creates a new *tap that sends the queries to addr and starts the
+// sending goroutine.
+func newTap(addr string) (t *tap, err error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("tap: %w", err)
+	}
+
+	t = &tap{
+		conn:  conn,
+		queue: make(chan []byte, tapQueueSize),
+	}
+
+	t.wg.Add(1)
+	go t.send()
+
+	return t, nil
+}
+
+// mirror queues a copy of the query to be sent to the tap.  It is safe to call
+// it on nil *tap.
+func (t *tap) mirror(req *dns.Msg) {
+	if t == nil {
+		return
+	}
+
+	b, err := req.Pack()
+	if err != nil {
+		log.Debug("dnsproxy: tap: packing query: %v", err)
+
+		return
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.closed {
+		return
+	}
+
+	select {
+	case t.queue <- b:
+	default:
+		log.Debug("dnsproxy: tap: queue is full, query is not mirrored")
+	}
+}
+
+// send sends the queued queries until the queue is closed.
+func (t *tap) send() {
+	defer t.wg.Done()
+
+	for b := range t.queue {
+		_, err := t.conn.Write(b)
+		if err != nil {
+			log.Debug("dnsproxy: tap: sending query: %v", err)
+		}
+	}
+}
+
+// close sends the queued queries and closes the connection.  It is safe to
+// call it on nil *tap.
+func (t *tap) close() (err error) {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+
+	t.wg.Wait()
+
+	return t.conn.Close()
+}
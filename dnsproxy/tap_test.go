@@ -0,0 +1,160 @@
+package dnsproxy
+
+import (
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingConn is a net.Conn which Write blocks until unblock is closed.
+type blockingConn struct {
+	net.Conn
+
+	unblock chan struct{}
+	writes  atomic.Int32
+}
+
+// Write implements the net.Conn interface for *blockingConn.
+func (c *blockingConn) Write(b []byte) (n int, err error) {
+	<-c.unblock
+	c.writes.Add(1)
+
+	return len(b), nil
+}
+
+// Close implements the net.Conn interface for *blockingConn.
+func (c *blockingConn) Close() (err error) {
+	return nil
+}
+
+func TestTap_mirror_nonBlocking(t *testing.T) {
+	const (
+		queueSize = 2
+		queries   = 100
+	)
+
+	conn := &blockingConn{unblock: make(chan struct{})}
+	tp := &tap{
+		conn:  conn,
+		queue: make(chan []byte, queueSize),
+	}
+
+	tp.wg.Add(1)
+	go tp.send()
+
+	req := (&dns.Msg{}).SetQuestion("example.org.", dns.TypeA)
+
+	// The sending goroutine is stuck, but mirroring doesn't wait for it.
+	start := time.Now()
+	for i := 0; i < queries; i++ {
+		tp.mirror(req)
+	}
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	close(conn.unblock)
+	require.NoError(t, tp.close())
+
+	// The queued queries and the one being sent are delivered, the rest are
+	// discarded.
+	writes := int(conn.writes.Load())
+	assert.GreaterOrEqual(t, writes, queueSize)
+	assert.LessOrEqual(t, writes, queueSize+1)
+
+	// Mirroring after closing is a no-op.
+	assert.NotPanics(t, func() { tp.mirror(req) })
+}
+
+func TestTap_mirror_nil(t *testing.T) {
+	var tp *tap
+
+	assert.NotPanics(t, func() {
+		tp.mirror((&dns.Msg{}).SetQuestion("example.org.", dns.TypeA))
+	})
+	assert.NoError(t, tp.close())
+}
+
+// startTapListener starts a UDP listener that sends the queries it receives
+// to the returned channel and returns its address.
+func startTapListener(t *testing.T) (addr string, reqs <-chan *dns.Msg) {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+
+	ch := make(chan *dns.Msg, 16)
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, _, rErr := pc.ReadFrom(buf)
+			if rErr != nil {
+				return
+			}
+
+			req := &dns.Msg{}
+			if req.Unpack(buf[:n]) == nil {
+				ch <- req
+			}
+		}
+	}()
+
+	return pc.LocalAddr().String(), ch
+}
+
+func TestDNSProxy_requestHandler_tap(t *testing.T) {
+	tapAddr, mirrored := startTapListener(t)
+
+	addr := netip.AddrPortFrom(localhost, freePort(t))
+	d, err := New(&Config{
+		ListenAddrs:    []netip.AddrPort{addr},
+		Upstreams:      []string{startUpstream(t)},
+		RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+		TapAddr:        tapAddr,
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Start())
+	t.Cleanup(func() { _ = d.Close() })
+
+	testCases := []struct {
+		name   string
+		qName  string
+		qType  uint16
+		qClass uint16
+	}{{
+		name:   "redirected",
+		qName:  "example.org.",
+		qType:  dns.TypeA,
+		qClass: dns.ClassINET,
+	}, {
+		name:   "forwarded",
+		qName:  "version.bind.",
+		qType:  dns.TypeTXT,
+		qClass: dns.ClassCHAOS,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := (&dns.Msg{}).SetQuestion(tc.qName, tc.qType)
+			req.Question[0].Qclass = tc.qClass
+
+			resp, err := dns.Exchange(req, addr.String())
+			require.NoError(t, err)
+			assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+
+			select {
+			case got := <-mirrored:
+				require.Len(t, got.Question, 1)
+				assert.Equal(t, req.Question[0], got.Question[0])
+				assert.Equal(t, req.Id, got.Id)
+			case <-time.After(5 * time.Second):
+				t.Fatal("tap received no query")
+			}
+		})
+	}
+}
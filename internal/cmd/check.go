@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/internal/logutil"
+)
+
+// logReachabilityWarnings checks if the clients redirected by the DNS proxy
+// will be able to reach the SNI proxy and logs the problems it finds.  The
+// problems are not fatal as the proxy may be behind a NAT or a port forwarding
+// that the program cannot see.
+func logReachabilityWarnings(options *Options) {
+	for _, w := range reachabilityWarnings(options, localAddrs()) {
+		logutil.Warn("cmd: %s", w)
+	}
+}
+
+// reachabilityWarnings returns the list of misconfigurations that make the SNI
+// proxy unreachable for the clients redirected by the DNS proxy.  local is the
+// list of the addresses of the local network interfaces, if it is empty, the
+// redirect addresses are not checked against it.
+func reachabilityWarnings(options *Options, local []netip.Addr) (warnings []string) {
+	var redirectAddrs []netip.Addr
+	for _, s := range []string{options.DNSRedirectIPV4To, options.DNSRedirectIPV6To} {
+		if addr, err := netip.ParseAddr(s); err == nil {
+			redirectAddrs = append(redirectAddrs, addr.Unmap())
+		}
+	}
+
+	if len(redirectAddrs) == 0 {
+		return nil
+	}
+
+	if options.TLSPort != 443 {
+		warnings = append(warnings, fmt.Sprintf(
+			"redirected clients connect to port 443, but tls-port is %d",
+			options.TLSPort,
+		))
+	}
+
+	if options.HTTPPort != 80 {
+		warnings = append(warnings, fmt.Sprintf(
+			"redirected clients connect to port 80, but http-port is %d",
+			options.HTTPPort,
+		))
+	}
+
+	listeners := []struct {
+		name string
+		addr string
+	}{
+		{name: "tls-address", addr: options.TLSListenAddress},
+		{name: "http-address", addr: options.HTTPListenAddress},
+	}
+
+	for _, redirectAddr := range redirectAddrs {
+		for _, l := range listeners {
+			if !listensOn(l.addr, redirectAddr) {
+				warnings = append(warnings, fmt.Sprintf(
+					"redirected clients connect to %s, but %s is %s",
+					redirectAddr,
+					l.name,
+					l.addr,
+				))
+			}
+		}
+
+		// The whole loopback network is local, no need to check it.
+		if len(local) > 0 && !redirectAddr.IsLoopback() && !containsAddr(local, redirectAddr) {
+			warnings = append(warnings, fmt.Sprintf(
+				"%s is not assigned to any local interface, make sure the "+
+					"traffic is forwarded to sniproxy",
+				redirectAddr,
+			))
+		}
+	}
+
+	return warnings
+}
+
+// listensOn checks if a listener bound to listenAddr accepts connections to
+// addr.  Unspecified addresses, i.e. 0.0.0.0 and ::, accept any connections
+// of the respective family.
+func listensOn(listenAddr string, addr netip.Addr) (ok bool) {
+	l, err := netip.ParseAddr(listenAddr)
+	if err != nil {
+		return true
+	}
+
+	l = l.Unmap()
+	if l.IsUnspecified() {
+		// On most systems "::" accepts IPv4 connections as well.
+		return l.Is6() || addr.Is4()
+	}
+
+	return l == addr
+}
+
+// containsAddr checks if addrs contains addr.
+func containsAddr(addrs []netip.Addr, addr netip.Addr) (ok bool) {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// localAddrs returns the addresses of the local network interfaces.  It
+// returns nil if they cannot be retrieved.
+func localAddrs() (addrs []netip.Addr) {
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Debug("cmd: failed to get interface addresses: %v", err)
+
+		return nil
+	}
+
+	for _, a := range ifaceAddrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		if addr, ok := netip.AddrFromSlice(ipNet.IP); ok {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+
+	return addrs
+}
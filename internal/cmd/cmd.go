@@ -8,9 +8,14 @@ import (
 	"syscall"
 
 	"github.com/AdguardTeam/golibs/log"
-	"github.com/ameshkov/sniproxy/internal/dnsproxy"
-	"github.com/ameshkov/sniproxy/internal/sniproxy"
+	"github.com/ameshkov/sniproxy/accesslog"
+	"github.com/ameshkov/sniproxy/audit"
+	"github.com/ameshkov/sniproxy/dnsproxy"
+	"github.com/ameshkov/sniproxy/internal/logutil"
+	"github.com/ameshkov/sniproxy/internal/metrics"
+	"github.com/ameshkov/sniproxy/internal/status"
 	"github.com/ameshkov/sniproxy/internal/version"
+	"github.com/ameshkov/sniproxy/sniproxy"
 	goFlags "github.com/jessevdk/go-flags"
 )
 
@@ -23,9 +28,7 @@ func Main() {
 		}
 	}
 
-	options := &Options{}
-	parser := goFlags.NewParser(options, goFlags.Default)
-	_, err := parser.Parse()
+	options, err := parseOptions()
 	if err != nil {
 		if flagsErr, ok := err.(*goFlags.Error); ok && flagsErr.Type == goFlags.ErrHelp {
 			os.Exit(0)
@@ -34,6 +37,15 @@ func Main() {
 		os.Exit(1)
 	}
 
+	if options.PrintConfig {
+		err = writeExampleConfig(os.Stdout, goFlags.NewParser(&Options{}, goFlags.None))
+		if err != nil {
+			log.Fatalf("cannot write the configuration file: %s", err)
+		}
+
+		os.Exit(0)
+	}
+
 	if options.Verbose {
 		log.SetLevel(log.DEBUG)
 	}
@@ -50,32 +62,157 @@ func Main() {
 	run(options)
 }
 
+// parseOptions parses the command-line arguments.  If the configuration file is
+// specified, the options are loaded from it first and the command-line
+// arguments override them.
+func parseOptions() (options *Options, err error) {
+	options = &Options{}
+	parser := goFlags.NewParser(options, goFlags.Default)
+	_, err = parser.Parse()
+	if err != nil || options.ConfigPath == "" {
+		return options, err
+	}
+
+	configPath := options.ConfigPath
+
+	options = &Options{}
+	parser = goFlags.NewParser(options, goFlags.Default)
+
+	args, err := loadConfigFile(parser, configPath)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "cannot load the configuration file: %s\n", err)
+
+		return nil, err
+	}
+
+	_, err = parser.ParseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = parser.Parse()
+
+	return options, err
+}
+
 // run starts reads the configuration options and starts the sniproxy.
 func run(options *Options) {
 	log.Info("cmd: run sniproxy with the following configuration:\n%s", options)
 
-	dnsProxy := newDNSProxy(options)
-	err := dnsProxy.Start()
+	if options.DNSNoPlain {
+		logutil.Warn("cmd: --dns-no-plain is deprecated, use --dns-plain=false instead")
+	}
+
+	expandRuleFiles(options)
+
+	logStartupSummary(options)
+
+	logReachabilityWarnings(options)
+
+	checkListenConflicts(options)
+
+	var err error
+	var auditLog *audit.Logger
+	if options.AuditLog != "" {
+		auditLog, err = audit.New(options.AuditLog)
+		check(err)
+	}
+
+	var accessLog *accesslog.Logger
+	if options.AccessLog != "" {
+		accessLog, err = accesslog.New(toAccessLogConfig(options))
+		check(err)
+	}
+
+	dnsProxy := newDNSProxy(options, auditLog)
+	sniProxy := newSNIProxy(options, auditLog, accessLog)
+
+	if options.Preflight || options.PreflightStrict {
+		runPreflight(dnsProxy, sniProxy, options.PreflightStrict)
+	}
+
+	err = dnsProxy.Start()
 	check(err)
 
-	sniProxy := newSNIProxy(options)
 	err = sniProxy.Start()
 	check(err)
 
+	var statusServer *status.Server
+	if options.StatusAddress != "" {
+		statusServer = status.New(&status.Config{
+			ListenAddr:  options.StatusAddress,
+			SNIProxy:    sniProxy,
+			DNSProxy:    dnsProxy,
+			ReloadToken: options.StatusReloadToken,
+		})
+		err = statusServer.Start()
+		check(err)
+	}
+
+	var metricsServer *metrics.Server
+	if options.MetricsAddress != "" {
+		metricsServer = metrics.NewServer(options.MetricsAddress)
+		err = metricsServer.Start()
+		check(err)
+	}
+
+	// Everything is loaded, start serving connections.
+	sniProxy.SetReady(true)
+
 	// Subscribe to the OS events.
 	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM)
-	<-signalChannel
+	signals := []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+	if dumpSignal != nil {
+		signals = append(signals, dumpSignal)
+	}
+
+	signal.Notify(signalChannel, signals...)
+	handleSignals(signalChannel, func() {
+		log.Info("cmd: received SIGHUP, reloading certificate")
+		err = dnsProxy.ReloadCertificate()
+		if err != nil {
+			log.Error("cmd: %v", err)
+		}
+	}, sniProxy.LogConnections)
 
 	log.Info("cmd: stopping sniproxy")
+	if options.ShutdownSummary {
+		logShutdownSummary(sniProxy)
+	}
+
+	if statusServer != nil {
+		log.OnCloserError(statusServer, log.INFO)
+	}
+	if metricsServer != nil {
+		log.OnCloserError(metricsServer, log.INFO)
+	}
 	log.OnCloserError(dnsProxy, log.INFO)
 	log.OnCloserError(sniProxy, log.INFO)
+	log.OnCloserError(auditLog, log.INFO)
+	log.OnCloserError(accessLog, log.INFO)
+}
+
+// handleSignals handles the OS signals received from c until the program must
+// be stopped, i.e. until SIGINT or SIGTERM is received.  SIGHUP calls reload
+// and dumpSignal calls dump.
+func handleSignals(c <-chan os.Signal, reload, dump func()) {
+	for sig := range c {
+		switch sig {
+		case syscall.SIGINT, syscall.SIGTERM:
+			return
+		case dumpSignal:
+			dump()
+		default:
+			reload()
+		}
+	}
 }
 
 // newDNSProxy creates a new instance of [*dnsproxy.DNSProxy] or panics if any
-// error happens.
-func newDNSProxy(options *Options) (d *dnsproxy.DNSProxy) {
+// error happens.  auditLog may be nil.
+func newDNSProxy(options *Options, auditLog *audit.Logger) (d *dnsproxy.DNSProxy) {
 	cfg := toDNSProxyConfig(options)
+	cfg.Audit = auditLog
 
 	d, err := dnsproxy.New(cfg)
 	check(err)
@@ -84,9 +221,15 @@ func newDNSProxy(options *Options) (d *dnsproxy.DNSProxy) {
 }
 
 // newSNIProxy creates a new instance of [*sniproxy.SNIProxy] or panics if any
-// error happens.
-func newSNIProxy(options *Options) (p *sniproxy.SNIProxy) {
+// error happens.  auditLog and accessLog may be nil.
+func newSNIProxy(
+	options *Options,
+	auditLog *audit.Logger,
+	accessLog *accesslog.Logger,
+) (p *sniproxy.SNIProxy) {
 	cfg := toSNIProxyConfig(options)
+	cfg.Audit = auditLog
+	cfg.AccessLog = accessLog
 
 	p, err := sniproxy.New(cfg)
 	check(err)
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleSignals(t *testing.T) {
+	c := make(chan os.Signal, 4)
+	c <- syscall.SIGHUP
+	if dumpSignal != nil {
+		c <- dumpSignal
+	}
+	c <- syscall.SIGTERM
+
+	// This one must not be handled since the program is stopping.
+	c <- syscall.SIGHUP
+
+	var reloads, dumps int
+	handleSignals(c, func() { reloads++ }, func() { dumps++ })
+
+	assert.Equal(t, 1, reloads)
+	if dumpSignal != nil {
+		assert.Equal(t, 1, dumps)
+	} else {
+		assert.Zero(t, dumps)
+	}
+	assert.Len(t, c, 1)
+}
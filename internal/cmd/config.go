@@ -3,36 +3,84 @@ package cmd
 import (
 	"net"
 	"net/netip"
+	"sort"
+	"time"
 
 	"github.com/AdguardTeam/golibs/log"
-	"github.com/ameshkov/sniproxy/internal/dnsproxy"
-	"github.com/ameshkov/sniproxy/internal/sniproxy"
+	"github.com/ameshkov/sniproxy/accesslog"
+	"github.com/ameshkov/sniproxy/capture"
+	"github.com/ameshkov/sniproxy/dnsproxy"
+	"github.com/ameshkov/sniproxy/internal/filter"
+	"github.com/ameshkov/sniproxy/proxyproto"
+	"github.com/ameshkov/sniproxy/shapeio"
+	"github.com/ameshkov/sniproxy/sniproxy"
 )
 
 // toDNSProxyConfig converts command-line arguments to [*dnsproxy.Config] or
 // panics if the arguments aren't valid.
 func toDNSProxyConfig(options *Options) (cfg *dnsproxy.Config) {
-	addr, err := netip.ParseAddr(options.DNSListenAddress)
-	check(err)
+	cfg = &dnsproxy.Config{
+		NoPlain:             !options.plainDNS(),
+		Freebind:            options.Freebind,
+		TLSCertPath:         options.DNSTLSCertPath,
+		TLSKeyPath:          options.DNSTLSKeyPath,
+		Upstreams:           options.DNSUpstream,
+		UpstreamTimeout:     options.DNSUpstreamTimeout,
+		UpstreamRetries:     options.DNSUpstreamRetries,
+		CacheSize:           options.DNSCacheSize,
+		NegativeTTL:         options.DNSNegativeTTL,
+		MaxGoroutines:       options.DNSMaxGoroutines,
+		RedirectPrefer:      dnsproxy.Family(options.DNSRedirectPrefer),
+		SynthesizeAAAA:      dnsproxy.Synthesis(options.DNSSynthesizeAAAA),
+		RedirectRules:       options.DNSRedirectRules,
+		RedirectExclude:     options.DNSRedirectExclude,
+		RedirectExcludeApex: options.DNSRedirectExcludeApex,
+		DropRules:           options.DNSDropRules,
+		DropSOA:             options.DNSDropSOA,
+		ServedDomains:       options.DNSServedDomains,
+		ProxyHostname:       options.DNSProxyHostname,
+		DiagDomain:          options.DNSDiagDomain,
+		NoCompress:          options.DNSNoCompress,
+		RateLimit:           options.DNSRateLimit,
+		MaxInflight:         options.DNSMaxInflight,
+		InflightMode:        dnsproxy.InflightMode(options.DNSMaxInflightMode),
+		TapAddr:             options.DNSTapAddress,
+		EDNSKeepalive:       options.DNSEDNSKeepalive,
+	}
 
-	addrPort := netip.AddrPortFrom(addr, uint16(options.DNSPort))
+	for _, s := range options.DNSListenAddress {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			log.Fatalf("cmd: failed to parse dns-address %s: %v", s, err)
+		}
 
-	cfg = &dnsproxy.Config{
-		ListenAddr:    addrPort,
-		Upstream:      options.DNSUpstream,
-		RedirectRules: options.DNSRedirectRules,
-		DropRules:     options.DNSDropRules,
+		cfg.ListenAddrs = append(cfg.ListenAddrs, netip.AddrPortFrom(addr, uint16(options.DNSPort)))
+	}
+
+	if options.DoQPort != 0 {
+		doqAddr, err := netip.ParseAddr(options.DoQListenAddress)
+		if err != nil {
+			log.Fatalf("cmd: failed to parse doq-address %s: %v", options.DoQListenAddress, err)
+		}
+
+		cfg.QUICListenAddr = netip.AddrPortFrom(doqAddr, uint16(options.DoQPort))
+	}
+
+	if options.DoHPort != 0 {
+		dohAddr, err := netip.ParseAddr(options.DoHListenAddress)
+		if err != nil {
+			log.Fatalf("cmd: failed to parse doh-address %s: %v", options.DoHListenAddress, err)
+		}
+
+		cfg.HTTPSListenAddr = netip.AddrPortFrom(dohAddr, uint16(options.DoHPort))
+		cfg.HTTPSPath = options.DoHPath
 	}
 
 	if options.DNSRedirectIPV4To != "" {
 		ip := net.ParseIP(options.DNSRedirectIPV4To)
 
 		if ip == nil {
-			log.Fatalf(
-				"cmd: failed to parse dns-redirect-ipv4-to %s: %v",
-				options.DNSRedirectIPV4To,
-				err,
-			)
+			log.Fatalf("cmd: failed to parse dns-redirect-ipv4-to %s", options.DNSRedirectIPV4To)
 		}
 
 		if ip.To4() == nil {
@@ -49,14 +97,10 @@ func toDNSProxyConfig(options *Options) (cfg *dnsproxy.Config) {
 		ip := net.ParseIP(options.DNSRedirectIPV6To)
 
 		if ip == nil {
-			log.Fatalf(
-				"cmd: failed to parse dns-redirect-ipv6-to %s: %v",
-				options.DNSRedirectIPV6To,
-				err,
-			)
+			log.Fatalf("cmd: failed to parse dns-redirect-ipv6-to %s", options.DNSRedirectIPV6To)
 		}
 
-		if ip.To16() == nil {
+		if ip.To4() != nil {
 			log.Fatalf(
 				"cmd: dns-redirect-ipv6-to must be an IPv6 address: %s",
 				options.DNSRedirectIPV6To,
@@ -66,10 +110,24 @@ func toDNSProxyConfig(options *Options) (cfg *dnsproxy.Config) {
 		cfg.RedirectIPv6To = ip
 	}
 
+	if options.DNSSynthesizeAAAA != "" {
+		prefix, err := netip.ParsePrefix(options.DNSNAT64Prefix)
+		if err != nil {
+			log.Fatalf("cmd: failed to parse dns-nat64-prefix %s: %v", options.DNSNAT64Prefix, err)
+		}
+
+		cfg.NAT64Prefix = prefix
+	}
+
 	if cfg.RedirectIPv4To == nil && cfg.RedirectIPv6To == nil {
 		log.Fatalf("cmd: either dns-redirect-ipv4-to or dns-redirect-ipv6-to must be specified")
 	}
 
+	err := cfg.Validate()
+	if err != nil {
+		log.Fatalf("cmd: %v", err)
+	}
+
 	return cfg
 }
 
@@ -95,12 +153,268 @@ func toSNIProxyConfig(options *Options) (cfg *sniproxy.Config) {
 			IP:   plainIP,
 			Port: options.HTTPPort,
 		},
-		ForwardProxy:  options.ForwardProxy,
-		ForwardRules:  options.ForwardRules,
-		BlockRules:    options.BlockRules,
-		DropRules:     options.DropRules,
-		BandwidthRate: options.BandwidthRate,
+		Freebind:                options.Freebind,
+		WaitReady:               true,
+		TLSListenerLabel:        options.TLSListenerLabel,
+		HTTPListenerLabel:       options.HTTPListenerLabel,
+		Upstreams:               options.DNSUpstream,
+		UpstreamTimeout:         options.DNSUpstreamTimeout,
+		ResolveFallback:         options.ResolveFallback,
+		ResolveFailureStatus:    options.ResolveFailureStatus,
+		ForwardProxies:          options.ForwardProxies,
+		ForwardProbeTimeout:     options.ForwardProbeTimeout,
+		ForwardConnectTimeout:   options.ForwardConnectTimeout,
+		ForwardFallbackDirect:   options.ForwardFallbackDirect,
+		ForwardResolve:          sniproxy.ForwardResolveMode(options.ForwardResolve),
+		ForwardRequired:         options.ForwardRequired,
+		ForwardRules:            options.ForwardRules,
+		ForwardPathRules:        options.ForwardPathRules,
+		BlockRules:              options.BlockRules,
+		BlockPathRules:          options.BlockPathRules,
+		BlockTLSAlert:           sniproxy.TLSAlert(options.BlockTLSAlert),
+		DropRules:               options.DropRules,
+		DropMode:                sniproxy.DropMode(options.DropMode),
+		DropDelay:               options.DropDelay,
+		ExpectedSNI:             options.ExpectedSNI,
+		HTTPSOnlyRules:          options.HTTPSOnlyDomains,
+		HTTPSOnlyMode:           sniproxy.PolicyMode(options.HTTPSOnlyMode),
+		MinTLSVersionMode:       sniproxy.PolicyMode(options.MinTLSVersionMode),
+		MinCipherStrength:       sniproxy.CipherStrength(options.MinCipherStrength),
+		AllowedPorts:            allowedPorts(options),
+		MatchPTR:                options.MatchPTR,
+		CopyChunkSize:           options.CopyChunkSize,
+		BandwidthRate:           options.BandwidthRate,
+		TunnelErrorMode:         sniproxy.TunnelErrorMode(options.TunnelErrorMode),
+		BandwidthRules:          options.BandwidthRules,
+		ShaperAlgorithm:         shapeio.Algorithm(options.ShaperAlgorithm),
+		NoShapeForwarded:        options.NoShapeForwarded,
+		MaxConnsPerIP:           options.MaxConnsPerIP,
+		LimitRetryAfter:         time.Duration(options.LimitRetryAfter) * time.Second,
+		MaxTunnelDuration:       options.MaxTunnelDuration,
+		SlowThreshold:           options.SlowThreshold,
+		StatsInterval:           options.StatsInterval,
+		IdleTimeout:             options.IdleTimeout,
+		LivenessInterval:        options.LivenessInterval,
+		TCPUserTimeout:          options.TCPUserTimeout,
+		HealthPath:              options.HealthPath,
+		HealthHost:              options.HealthHost,
+		ShutdownTimeout:         options.ShutdownTimeout,
+		Workers:                 options.Workers,
+		Transparent:             options.Transparent,
+		PassthroughOnParseError: options.PassthroughOnParseError,
+		RequireSNI:              options.RequireSNI,
+		PeekMaxReads:            options.PeekMaxReads,
+		PeekTimeout:             options.PeekTimeout,
+		MaxPeekMemory:           options.MaxPeekMemory,
+		LogClientHello:          options.LogClientHello,
+		QuietEmptyTunnels:       options.QuietEmptyTunnels,
+		OTelEndpoint:            options.OTelEndpoint,
+	}
+
+	if options.Linger >= 0 {
+		linger := options.Linger
+		cfg.Linger = &linger
+	}
+
+	for _, s := range options.ForwardProxyRules {
+		r, err := sniproxy.ParseForwardProxyRule(s)
+		if err != nil {
+			log.Fatalf("cmd: invalid forward-proxy-rule: %v", err)
+		}
+
+		cfg.ForwardProxyRules = append(cfg.ForwardProxyRules, r)
+	}
+
+	cfg.LocalHosts = parseLocalHosts(options.LocalHosts)
+
+	if options.TrustXFF {
+		if len(options.TrustedProxies) == 0 {
+			log.Fatalf("cmd: trust-xff requires at least one trusted-proxy")
+		}
+
+		for _, s := range options.TrustedProxies {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				log.Fatalf("cmd: failed to parse trusted-proxy %s: %v", s, err)
+			}
+
+			cfg.TrustedProxies = append(cfg.TrustedProxies, prefix.Masked())
+		}
+	}
+
+	if options.ProxyProtocol {
+		cfg.ProxyProtocol = proxyproto.Version(options.ProxyProtocolVersion)
+	}
+
+	cfg.IdleTimeoutRules = parseTimeoutRules("idle-timeout-rule", options.IdleTimeoutRules)
+	cfg.MaxDurationRules = parseTimeoutRules("max-duration-rule", options.MaxDurationRules)
+
+	for _, s := range options.MinTLSVersionRules {
+		r, err := sniproxy.ParseMinTLSVersionRule(s)
+		if err != nil {
+			log.Fatalf("cmd: failed to parse min-tls-version-domain %s: %v", s, err)
+		}
+
+		cfg.MinTLSVersionRules = append(cfg.MinTLSVersionRules, r)
+	}
+
+	if r := options.DialSourcePortRange; r != "" {
+		var err error
+		cfg.DialSourcePortMin, cfg.DialSourcePortMax, err = sniproxy.ParsePortRange(r)
+		if err != nil {
+			log.Fatalf("cmd: failed to parse dial-source-port-range %s: %v", r, err)
+		}
+	}
+
+	for _, s := range options.ForwardSchedule {
+		r, err := sniproxy.ParseForwardScheduleRule(s)
+		if err != nil {
+			log.Fatalf("cmd: failed to parse forward-schedule %s: %v", s, err)
+		}
+
+		cfg.ForwardSchedule = append(cfg.ForwardSchedule, r)
+	}
+
+	cfg.Capture = toCaptureConfig(options)
+
+	err := cfg.Validate()
+	if err != nil {
+		log.Fatalf("cmd: %v", err)
 	}
 
 	return cfg
 }
+
+// parseLocalHosts parses local virtual hosts in the "host=file" format.  If any
+// of them is invalid, it logs the error and exits the program.
+func parseLocalHosts(hosts []string) (localHosts []sniproxy.LocalHost) {
+	for _, s := range hosts {
+		h, err := sniproxy.ParseLocalHost(s)
+		if err != nil {
+			log.Fatalf("cmd: invalid local-host: %v", err)
+		}
+
+		localHosts = append(localHosts, h)
+	}
+
+	return localHosts
+}
+
+// toCaptureConfig converts command-line arguments to [*capture.Config] or
+// panics if the arguments aren't valid.  It returns nil if capturing is
+// disabled.
+func toCaptureConfig(options *Options) (conf *capture.Config) {
+	if options.CaptureDir == "" {
+		return nil
+	}
+
+	if options.CaptureMaxBytes <= 0 {
+		log.Fatalf("cmd: invalid capture-max-bytes %d", options.CaptureMaxBytes)
+	} else if options.CaptureMaxFiles < 0 {
+		log.Fatalf("cmd: invalid capture-max-files %d", options.CaptureMaxFiles)
+	} else if options.CaptureRate < 0 {
+		log.Fatalf("cmd: invalid capture-rate %g", options.CaptureRate)
+	}
+
+	return &capture.Config{
+		Dir:       options.CaptureDir,
+		MaxBytes:  options.CaptureMaxBytes,
+		MaxFiles:  options.CaptureMaxFiles,
+		PerMinute: options.CaptureRate,
+	}
+}
+
+// toAccessLogConfig converts command-line arguments to [*accesslog.Config] or
+// panics if the arguments aren't valid.
+func toAccessLogConfig(options *Options) (conf *accesslog.Config) {
+	if options.AccessLogMaxSize < 0 {
+		log.Fatalf("cmd: invalid access-log-max-size %d", options.AccessLogMaxSize)
+	} else if options.AccessLogMaxBackups < 0 {
+		log.Fatalf("cmd: invalid access-log-max-backups %d", options.AccessLogMaxBackups)
+	}
+
+	return &accesslog.Config{
+		Path:       options.AccessLog,
+		MaxSize:    int64(options.AccessLogMaxSize) * 1024 * 1024,
+		MaxBackups: options.AccessLogMaxBackups,
+		Compress:   options.AccessLogCompress,
+		Format:     options.AccessLogFormat,
+	}
+}
+
+// expandRuleFiles replaces the "@path" values of the wildcard options with the
+// wildcards from the files, see [filter.ExpandFiles], or panics if any of the
+// files cannot be read.
+func expandRuleFiles(options *Options) {
+	ruleOptions := []struct {
+		rules *[]string
+		name  string
+	}{
+		{rules: &options.DNSRedirectRules, name: "dns-redirect-rule"},
+		{rules: &options.DNSRedirectExclude, name: "dns-redirect-exclude"},
+		{rules: &options.DNSDropRules, name: "dns-drop-rule"},
+		{rules: &options.DNSServedDomains, name: "dns-served-domain"},
+		{rules: &options.ForwardRules, name: "forward-rule"},
+		{rules: &options.BlockRules, name: "block-rule"},
+		{rules: &options.DropRules, name: "drop-rule"},
+		{rules: &options.HTTPSOnlyDomains, name: "https-only-domain"},
+	}
+
+	for _, o := range ruleOptions {
+		rules, err := filter.ExpandFiles(*o.rules)
+		if err != nil {
+			log.Fatalf("cmd: invalid %s: %v", o.name, err)
+		}
+
+		*o.rules = rules
+	}
+}
+
+// parseTimeoutRules parses timeout rules in the "wildcard=duration" format.  If
+// any of them is invalid, it logs the error and exits the program.  name is the
+// name of the option.
+func parseTimeoutRules(name string, rules []string) (timeoutRules []sniproxy.TimeoutRule) {
+	for _, s := range rules {
+		r, err := sniproxy.ParseTimeoutRule(s)
+		if err != nil {
+			log.Fatalf("cmd: invalid %s: %v", name, err)
+		}
+
+		timeoutRules = append(timeoutRules, r)
+	}
+
+	return timeoutRules
+}
+
+// allowedPorts returns the remote ports the SNI proxy is allowed to tunnel
+// connections to.  If they are not set explicitly, the default HTTP and TLS
+// ports and the ports of the listeners are allowed, so that the traffic
+// redirected to the listeners from other ports keeps working.  In the
+// transparent mode, the redirected ports are unknown, so all of them are
+// allowed by default.  nil means all ports are allowed.
+func allowedPorts(options *Options) (ports []int) {
+	if len(options.AllowedPorts) == 0 {
+		if options.Transparent {
+			return nil
+		}
+
+		ports = []int{80, 443, options.HTTPPort, options.TLSPort}
+	} else {
+		ports = append(ports, options.AllowedPorts...)
+	}
+
+	sort.Ints(ports)
+
+	uniq := ports[:0]
+	for i, port := range ports {
+		if port == 0 {
+			return nil
+		}
+
+		if i == 0 || port != ports[i-1] {
+			uniq = append(uniq, port)
+		}
+	}
+
+	return uniq
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowedPorts(t *testing.T) {
+	testCases := []struct {
+		options *Options
+		name    string
+		want    []int
+	}{{
+		options: &Options{HTTPPort: 80, TLSPort: 443},
+		name:    "default",
+		want:    []int{80, 443},
+	}, {
+		options: &Options{HTTPPort: 8080, TLSPort: 8443},
+		name:    "listener_ports",
+		want:    []int{80, 443, 8080, 8443},
+	}, {
+		options: &Options{HTTPPort: 8080, TLSPort: 8443, Transparent: true},
+		name:    "transparent",
+		want:    nil,
+	}, {
+		options: &Options{HTTPPort: 8080, TLSPort: 8443, AllowedPorts: []int{443, 22, 443}},
+		name:    "explicit",
+		want:    []int{22, 443},
+	}, {
+		options: &Options{HTTPPort: 80, TLSPort: 443, AllowedPorts: []int{443, 0}},
+		name:    "all",
+		want:    nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, allowedPorts(tc.options))
+		})
+	}
+}
+
+func TestToSNIProxyConfig_linger(t *testing.T) {
+	zero, ten := 0, 10
+
+	testCases := []struct {
+		want *int
+		name string
+		args []string
+	}{{
+		want: nil,
+		name: "default",
+		args: nil,
+	}, {
+		want: nil,
+		name: "negative",
+		args: []string{"--linger=-1"},
+	}, {
+		want: &zero,
+		name: "zero",
+		args: []string{"--linger=0"},
+	}, {
+		want: &ten,
+		name: "positive",
+		args: []string{"--linger=10"},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			options := parseWithConfig(t, nil, tc.args...)
+			assert.Equal(t, tc.want, toSNIProxyConfig(options).Linger)
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	goFlags "github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+)
+
+// configCommentWidth is the maximum width of the option descriptions in the
+// example configuration file.
+const configCommentWidth = 80
+
+// configFileOptions returns the options that can be set in the configuration
+// file.  The keys of the file are the long names of the options.  The options
+// tagged with no-ini, e.g. the path to the file itself, cannot be set there.
+func configFileOptions(parser *goFlags.Parser) (opts []*goFlags.Option) {
+	for _, g := range parser.Groups() {
+		for _, opt := range g.Options() {
+			if opt.LongName != "" && opt.Field().Tag.Get("no-ini") == "" {
+				opts = append(opts, opt)
+			}
+		}
+	}
+
+	return opts
+}
+
+// loadConfigFile reads the YAML configuration file at path and converts it to
+// the command-line arguments that set the same options.
+func loadConfigFile(parser *goFlags.Parser, path string) (args []string, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return configFileArgs(parser, b)
+}
+
+// configFileArgs converts the YAML configuration file contents b to the
+// command-line arguments.  The values are passed as they are written in the
+// file, so that they are parsed the same way as the arguments.
+func configFileArgs(parser *goFlags.Parser, b []byte) (args []string, err error) {
+	known := map[string]*goFlags.Option{}
+	for _, opt := range configFileOptions(parser) {
+		known[opt.LongName] = opt
+	}
+
+	doc := yaml.Node{}
+	err = yaml.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("parsing yaml: %w", err)
+	} else if len(doc.Content) == 0 {
+		// The file is empty or only has comments.
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a mapping of option names to values", root.Line)
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, val := root.Content[i], root.Content[i+1]
+
+		opt, ok := known[key.Value]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown option %q", key.Line, key.Value)
+		}
+
+		var optArgs []string
+		optArgs, err = optionArgs(opt, val)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: option %q: %w", key.Line, key.Value, err)
+		}
+
+		args = append(args, optArgs...)
+	}
+
+	return args, nil
+}
+
+// optionArgs returns the command-line arguments that set opt to the value of
+// the YAML node.
+func optionArgs(opt *goFlags.Option, val *yaml.Node) (args []string, err error) {
+	name := "--" + opt.LongName
+	kind := opt.Field().Type.Kind()
+
+	switch val.Kind {
+	case yaml.ScalarNode:
+		if val.Tag == "!!null" {
+			return nil, nil
+		}
+
+		if kind != reflect.Bool {
+			return []string{name + "=" + val.Value}, nil
+		}
+
+		switch val.Value {
+		case "true":
+			return []string{name}, nil
+		case "false":
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("expected true or false, got %q", val.Value)
+		}
+	case yaml.SequenceNode:
+		if kind != reflect.Slice && kind != reflect.Map {
+			return nil, fmt.Errorf("expected a single value, got a list")
+		}
+
+		for _, v := range val.Content {
+			if v.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: expected a list of values", v.Line)
+			}
+
+			args = append(args, name+"="+v.Value)
+		}
+
+		return args, nil
+	case yaml.MappingNode:
+		if kind != reflect.Map {
+			return nil, fmt.Errorf("expected a single value, got a mapping")
+		}
+
+		for i := 0; i+1 < len(val.Content); i += 2 {
+			args = append(args, name+"="+val.Content[i].Value+":"+val.Content[i+1].Value)
+		}
+
+		return args, nil
+	default:
+		return nil, fmt.Errorf("unexpected value")
+	}
+}
+
+// exampleConfigHeader is the comment at the top of the example configuration
+// file.
+const exampleConfigHeader = "" +
+	"# sniproxy configuration file, see --config.  The keys are the names of\n" +
+	"# the command-line options, the command-line arguments override the values\n" +
+	"# from this file.  Repeatable options are lists.\n"
+
+// writeExampleConfig writes an example YAML configuration file with all the
+// options that can be set there.  Each option is preceded by its description.
+// The options with defaults are set to them, the rest are commented out.
+func writeExampleConfig(w io.Writer, parser *goFlags.Parser) (err error) {
+	bw := bufio.NewWriter(w)
+
+	_, _ = fmt.Fprint(bw, exampleConfigHeader)
+
+	for _, opt := range configFileOptions(parser) {
+		_, _ = fmt.Fprintln(bw)
+
+		for _, l := range wrapText(opt.Description, configCommentWidth-len("# ")) {
+			_, _ = fmt.Fprintln(bw, "# "+l)
+		}
+
+		writeExampleValue(bw, opt)
+	}
+
+	return bw.Flush()
+}
+
+// writeExampleValue writes the YAML line or lines that set opt to its default
+// value.  If there is no default, an example is written as a comment.
+func writeExampleValue(w io.Writer, opt *goFlags.Option) {
+	kind := opt.Field().Type.Kind()
+	multi := kind == reflect.Slice || kind == reflect.Map
+	noDefault := len(opt.Default) == 0 || (len(opt.Default) == 1 && opt.Default[0] == "")
+
+	switch {
+	case noDefault && kind == reflect.Bool:
+		_, _ = fmt.Fprintf(w, "# %s: true\n", opt.LongName)
+	case noDefault && multi:
+		_, _ = fmt.Fprintf(w, "# %s: []\n", opt.LongName)
+	case noDefault:
+		_, _ = fmt.Fprintf(w, "# %s:\n", opt.LongName)
+	case multi:
+		_, _ = fmt.Fprintf(w, "%s:\n", opt.LongName)
+		for _, d := range opt.Default {
+			_, _ = fmt.Fprintf(w, "  - %s\n", yamlScalar(d))
+		}
+	default:
+		_, _ = fmt.Fprintf(w, "%s: %s\n", opt.LongName, yamlScalar(opt.Default[0]))
+	}
+}
+
+// yamlScalar returns s formatted as a YAML scalar.  It is only quoted if it
+// would be parsed as something else otherwise.
+func yamlScalar(s string) (v string) {
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		// Should not happen for strings.
+		return s
+	}
+
+	v = strings.TrimSuffix(string(b), "\n")
+
+	// Unquote the numbers and booleans, as the values are parsed the same way
+	// as the command-line arguments anyway.
+	if unquoted := strings.Trim(v, `"`); unquoted == s {
+		return unquoted
+	}
+
+	return v
+}
+
+// wrapText splits text into lines no longer than width where possible.
+func wrapText(text string, width int) (lines []string) {
+	var line string
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+
+		if line != "" {
+			line += " "
+		}
+
+		line += word
+	}
+
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
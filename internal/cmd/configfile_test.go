@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	goFlags "github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parseWithConfig parses the configuration file contents conf and then args
+// the same way as [parseOptions] does.
+func parseWithConfig(t *testing.T, conf []byte, args ...string) (options *Options) {
+	t.Helper()
+
+	options = &Options{}
+	parser := goFlags.NewParser(options, goFlags.None)
+
+	fileArgs, err := configFileArgs(parser, conf)
+	require.NoError(t, err)
+
+	_, err = parser.ParseArgs(fileArgs)
+	require.NoError(t, err)
+
+	_, err = parser.ParseArgs(args)
+	require.NoError(t, err)
+
+	return options
+}
+
+func TestWriteExampleConfig_roundTrip(t *testing.T) {
+	defaults := &Options{}
+	_, err := goFlags.NewParser(defaults, goFlags.None).ParseArgs(nil)
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, writeExampleConfig(buf, goFlags.NewParser(&Options{}, goFlags.None)))
+
+	assert.Equal(t, defaults, parseWithConfig(t, buf.Bytes()))
+}
+
+func TestConfigFileArgs(t *testing.T) {
+	const conf = `
+dns-port: 5353
+dns-plain: false
+dns-redirect-ipv4-to: 1.2.3.4
+dns-redirect-rule:
+  - "*.example.org"
+  - "*.example.net"
+dns-upstream: [1.1.1.1, 8.8.8.8]
+bandwidth-rule:
+  "*.example.org": 1024
+dns-upstream-timeout: 2s
+verbose: true
+match-ptr: false
+`
+
+	options := parseWithConfig(t, []byte(conf))
+
+	assert.Equal(t, 5353, options.DNSPort)
+	assert.False(t, options.plainDNS())
+	assert.Equal(t, "1.2.3.4", options.DNSRedirectIPV4To)
+	assert.Equal(t, []string{"*.example.org", "*.example.net"}, options.DNSRedirectRules)
+	assert.Equal(t, []string{"1.1.1.1", "8.8.8.8"}, options.DNSUpstream)
+	assert.Equal(t, map[string]float64{"*.example.org": 1024}, options.BandwidthRules)
+	assert.Equal(t, 2*time.Second, options.DNSUpstreamTimeout)
+	assert.True(t, options.Verbose)
+	assert.False(t, options.MatchPTR)
+
+	// The command-line arguments override the values from the file.
+	options = parseWithConfig(t, []byte(conf), "--dns-port=53", "--dns-redirect-rule=*")
+
+	assert.Equal(t, 53, options.DNSPort)
+	assert.Equal(t, []string{"*"}, options.DNSRedirectRules)
+	assert.Equal(t, []string{"1.1.1.1", "8.8.8.8"}, options.DNSUpstream)
+}
+
+func TestConfigFileArgs_errors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		conf    string
+		wantErr string
+	}{{
+		name:    "unknown",
+		conf:    "dns-prot: 53",
+		wantErr: `line 1: unknown option "dns-prot"`,
+	}, {
+		name:    "not_mapping",
+		conf:    "- dns-port",
+		wantErr: "line 1: expected a mapping of option names to values",
+	}, {
+		name:    "list_for_single",
+		conf:    "dns-port: [53, 54]",
+		wantErr: `line 1: option "dns-port": expected a single value, got a list`,
+	}, {
+		name:    "bad_bool",
+		conf:    "verbose: sometimes",
+		wantErr: `line 1: option "verbose": expected true or false, got "sometimes"`,
+	}, {
+		name:    "no_ini",
+		conf:    "config: other.yaml",
+		wantErr: `line 1: unknown option "config"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := configFileArgs(goFlags.NewParser(&Options{}, goFlags.None), []byte(tc.conf))
+			assert.EqualError(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestExpandRuleFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	ads := "*.ads.example\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ads.txt"), []byte(ads), 0o600))
+
+	block := "# Trackers and ads.\n\n*.tracker.example\ninclude ads.txt\n"
+	blockPath := filepath.Join(dir, "block.txt")
+	require.NoError(t, os.WriteFile(blockPath, []byte(block), 0o600))
+
+	conf := "block-rule:\n  - example.com\n  - \"@" + blockPath + "\"\n"
+	options := parseWithConfig(t, []byte(conf), "--dns-drop-rule=@"+blockPath)
+
+	expandRuleFiles(options)
+
+	assert.Equal(t, []string{"example.com", "*.tracker.example", "*.ads.example"}, options.BlockRules)
+	assert.Equal(t, []string{"*.tracker.example", "*.ads.example"}, options.DNSDropRules)
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// listenEndpoint is a socket that one of the listeners binds to.
+type listenEndpoint struct {
+	// name is the name of the option that defines the endpoint.
+	name string
+
+	// network is either "tcp" or "udp".
+	network string
+
+	// host is the IP address or the hostname the listener binds to.  Empty
+	// host means all addresses.
+	host string
+
+	// port is the port the listener binds to.
+	port int
+}
+
+// String implements the [fmt.Stringer] interface for listenEndpoint.
+func (e listenEndpoint) String() (s string) {
+	return fmt.Sprintf("%s (%s/%s)", e.name, e.network, net.JoinHostPort(e.host, strconv.Itoa(e.port)))
+}
+
+// checkListenConflicts exits the program if any of the listeners configured by
+// options would bind to the same socket as another one, so that the conflict
+// is reported before any of them is started.
+func checkListenConflicts(options *Options) {
+	conflicts := listenConflicts(listenEndpoints(options))
+	if len(conflicts) > 0 {
+		log.Fatalf("cmd: conflicting listen addresses: %s", strings.Join(conflicts, "; "))
+	}
+}
+
+// listenEndpoints returns the sockets of all the listeners enabled by options.
+func listenEndpoints(options *Options) (endpoints []listenEndpoint) {
+	if options.plainDNS() {
+		for _, addr := range options.DNSListenAddress {
+			endpoints = append(
+				endpoints,
+				listenEndpoint{name: "dns-address", network: "udp", host: addr, port: options.DNSPort},
+				listenEndpoint{name: "dns-address", network: "tcp", host: addr, port: options.DNSPort},
+			)
+		}
+	}
+
+	if options.DoQPort != 0 {
+		endpoints = append(endpoints, listenEndpoint{
+			name:    "doq-address",
+			network: "udp",
+			host:    options.DoQListenAddress,
+			port:    options.DoQPort,
+		})
+	}
+
+	if options.DoHPort != 0 {
+		endpoints = append(endpoints, listenEndpoint{
+			name:    "doh-address",
+			network: "tcp",
+			host:    options.DoHListenAddress,
+			port:    options.DoHPort,
+		})
+	}
+
+	endpoints = append(endpoints, listenEndpoint{
+		name:    "tls-address",
+		network: "tcp",
+		host:    options.TLSListenAddress,
+		port:    options.TLSPort,
+	}, listenEndpoint{
+		name:    "http-address",
+		network: "tcp",
+		host:    options.HTTPListenAddress,
+		port:    options.HTTPPort,
+	})
+
+	for _, s := range []struct {
+		name string
+		addr string
+	}{
+		{name: "status-address", addr: options.StatusAddress},
+		{name: "metrics-address", addr: options.MetricsAddress},
+	} {
+		host, portStr, err := net.SplitHostPort(s.addr)
+		if err != nil {
+			// Either not set or invalid, the server reports the latter.
+			continue
+		}
+
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			continue
+		}
+
+		endpoints = append(endpoints, listenEndpoint{
+			name:    s.name,
+			network: "tcp",
+			host:    host,
+			port:    port,
+		})
+	}
+
+	return endpoints
+}
+
+// listenConflicts returns the descriptions of the pairs of endpoints that
+// cannot be bound at the same time.  Port zero means any free port, so such
+// endpoints never conflict.
+func listenConflicts(endpoints []listenEndpoint) (conflicts []string) {
+	for i, a := range endpoints {
+		for _, b := range endpoints[i+1:] {
+			if a.network != b.network || a.port != b.port || a.port == 0 || !hostsOverlap(a.host, b.host) {
+				continue
+			}
+
+			conflicts = append(conflicts, fmt.Sprintf("%s conflicts with %s", a, b))
+		}
+	}
+
+	return conflicts
+}
+
+// hostsOverlap checks if the listeners bound to the hosts a and b on the same
+// port would accept the same connections.  Empty host means all addresses.
+func hostsOverlap(a, b string) (ok bool) {
+	if a == "" || b == "" {
+		return true
+	}
+
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil {
+		// Hostnames are only compared with each other and with the
+		// unspecified addresses.
+		return strings.EqualFold(a, b) ||
+			(errA == nil && addrA.Unmap().IsUnspecified()) ||
+			(errB == nil && addrB.Unmap().IsUnspecified())
+	}
+
+	return listensOn(a, addrB.Unmap()) || listensOn(b, addrA.Unmap())
+}
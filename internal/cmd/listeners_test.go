@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"testing"
+
+	goFlags "github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenConflicts(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+		want []string
+	}{{
+		name: "default",
+		args: nil,
+		want: nil,
+	}, {
+		name: "same_port",
+		args: []string{"--http-port=443"},
+		want: []string{
+			"tls-address (tcp/0.0.0.0:443) conflicts with http-address (tcp/0.0.0.0:443)",
+		},
+	}, {
+		name: "different_hosts",
+		args: []string{
+			"--http-port=443",
+			"--tls-address=127.0.0.1",
+			"--http-address=127.0.0.2",
+		},
+		want: nil,
+	}, {
+		name: "unspecified_host",
+		args: []string{"--status-address=127.0.0.1:80"},
+		want: []string{
+			"http-address (tcp/0.0.0.0:80) conflicts with status-address (tcp/127.0.0.1:80)",
+		},
+	}, {
+		name: "different_networks",
+		args: []string{"--doq-port=443"},
+		want: nil,
+	}, {
+		name: "doh",
+		args: []string{"--doh-port=443"},
+		want: []string{
+			"doh-address (tcp/0.0.0.0:443) conflicts with tls-address (tcp/0.0.0.0:443)",
+		},
+	}, {
+		name: "any_port",
+		args: []string{"--tls-port=0", "--http-port=0"},
+		want: nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			options := &Options{}
+			_, err := goFlags.NewParser(options, goFlags.None).ParseArgs(tc.args)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, listenConflicts(listenEndpoints(options)))
+		})
+	}
+}
+
+func TestHostsOverlap(t *testing.T) {
+	testCases := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{{
+		name: "empty",
+		a:    "",
+		b:    "127.0.0.1",
+		want: true,
+	}, {
+		name: "same",
+		a:    "127.0.0.1",
+		b:    "127.0.0.1",
+		want: true,
+	}, {
+		name: "different",
+		a:    "127.0.0.1",
+		b:    "127.0.0.2",
+		want: false,
+	}, {
+		name: "unspecified_v4",
+		a:    "0.0.0.0",
+		b:    "127.0.0.1",
+		want: true,
+	}, {
+		name: "unspecified_v6",
+		a:    "::",
+		b:    "127.0.0.1",
+		want: true,
+	}, {
+		name: "v4_and_v6",
+		a:    "0.0.0.0",
+		b:    "::1",
+		want: false,
+	}, {
+		name: "hostnames",
+		a:    "localhost",
+		b:    "LOCALHOST",
+		want: true,
+	}, {
+		name: "hostname_and_ip",
+		a:    "localhost",
+		b:    "127.0.0.1",
+		want: false,
+	}, {
+		name: "hostname_and_unspecified",
+		a:    "localhost",
+		b:    "0.0.0.0",
+		want: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, hostsOverlap(tc.a, tc.b))
+			assert.Equal(t, tc.want, hostsOverlap(tc.b, tc.a))
+		})
+	}
+}
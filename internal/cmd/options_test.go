@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"testing"
+
+	goFlags "github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions_plainDNS(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+		want bool
+	}{{
+		name: "default",
+		args: nil,
+		want: true,
+	}, {
+		name: "no_value",
+		args: []string{"--dns-plain"},
+		want: true,
+	}, {
+		name: "true",
+		args: []string{"--dns-plain=true"},
+		want: true,
+	}, {
+		name: "false",
+		args: []string{"--dns-plain=false"},
+		want: false,
+	}, {
+		name: "deprecated",
+		args: []string{"--dns-no-plain"},
+		want: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			options := &Options{}
+			_, err := goFlags.NewParser(options, goFlags.None).ParseArgs(tc.args)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, options.plainDNS())
+
+			var dnsEndpoints int
+			for _, e := range listenEndpoints(options) {
+				if e.name == "dns-address" {
+					dnsEndpoints++
+				}
+			}
+
+			assert.Equal(t, tc.want, dnsEndpoints > 0)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"net"
+	"sort"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/dnsproxy"
+	"github.com/ameshkov/sniproxy/internal/logutil"
+	"github.com/ameshkov/sniproxy/sniproxy"
+)
+
+// preflightDomain is the domain name that the DNS upstreams resolve and the
+// forward proxies connect to during the preflight checks.
+const preflightDomain = "example.org"
+
+// runPreflight checks that the DNS upstreams resolve preflightDomain and that
+// the forward proxies can connect to it and logs the results.  If strict is
+// true and any of the checks fails, the program exits.
+func runPreflight(d *dnsproxy.DNSProxy, p *sniproxy.SNIProxy, strict bool) {
+	log.Info("cmd: running preflight checks")
+
+	failed := logPreflight("dns upstream", d.CheckUpstreams(preflightDomain))
+	forwardAddr := net.JoinHostPort(preflightDomain, "443")
+	failed += logPreflight("forward proxy", p.CheckForwardProxies(forwardAddr))
+
+	if failed == 0 {
+		log.Info("cmd: preflight checks passed")
+
+		return
+	}
+
+	if strict {
+		log.Fatalf("cmd: %d preflight checks failed", failed)
+	}
+
+	logutil.Warn("cmd: %d preflight checks failed", failed)
+}
+
+// logPreflight logs the results of the checks of the kind and returns the
+// number of the failed ones.
+func logPreflight(kind string, results map[string]error) (failed int) {
+	addrs := make([]string, 0, len(results))
+	for addr := range results {
+		addrs = append(addrs, addr)
+	}
+
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		err := results[addr]
+		if err == nil {
+			log.Info("cmd: preflight: %s %s is ok", kind, addr)
+
+			continue
+		}
+
+		failed++
+		logutil.Warn("cmd: preflight: %s %s failed: %v", kind, addr, err)
+	}
+
+	return failed
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogPreflight(t *testing.T) {
+	testCases := []struct {
+		name       string
+		results    map[string]error
+		wantFailed int
+	}{{
+		name:       "empty",
+		results:    nil,
+		wantFailed: 0,
+	}, {
+		name: "passed",
+		results: map[string]error{
+			"1.1.1.1:53": nil,
+			"8.8.8.8:53": nil,
+		},
+		wantFailed: 0,
+	}, {
+		name: "failed",
+		results: map[string]error{
+			"1.1.1.1:53": nil,
+			"8.8.8.8:53": errors.New("timeout"),
+			"9.9.9.9:53": errors.New("refused"),
+		},
+		wantFailed: 2,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.wantFailed, logPreflight("dns upstream", tc.results))
+		})
+	}
+}
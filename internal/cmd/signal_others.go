@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package cmd
+
+import "os"
+
+// dumpSignal is the signal that makes sniproxy log the active connections.
+// There is no such signal on this platform, use the status server instead.
+var dumpSignal os.Signal
@@ -0,0 +1,11 @@
+//go:build !windows && !plan9
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// dumpSignal is the signal that makes sniproxy log the active connections.
+var dumpSignal os.Signal = syscall.SIGUSR2
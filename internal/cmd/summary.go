@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/sniproxy"
+)
+
+// logStartupSummary logs the banner, if any, and a short human-readable
+// summary of the features enabled by options.
+func logStartupSummary(options *Options) {
+	if options.Banner != "" {
+		log.Info("cmd: %s", options.Banner)
+	}
+
+	for _, line := range startupSummary(options) {
+		log.Info("cmd: %s", line)
+	}
+}
+
+// startupSummary returns the lines describing the features enabled by
+// options.  Disabled features are not mentioned.
+func startupSummary(options *Options) (lines []string) {
+	lines = append(lines, dnsSummary(options)...)
+
+	lines = append(lines, fmt.Sprintf(
+		"sni proxy listens for TLS on %s and for HTTP on %s",
+		net.JoinHostPort(options.TLSListenAddress, strconv.Itoa(options.TLSPort)),
+		net.JoinHostPort(options.HTTPListenAddress, strconv.Itoa(options.HTTPPort)),
+	))
+
+	if len(options.AllowedPorts) > 0 {
+		lines = append(lines, fmt.Sprintf("only ports %s are allowed", joinInts(options.AllowedPorts)))
+	}
+
+	forwardRules := len(options.ForwardRules) + len(options.ForwardPathRules)
+	lines = appendRulesSummary(lines, "forwarding", forwardRules)
+	if len(options.ForwardProxies) > 0 {
+		lines = append(lines, fmt.Sprintf("forwarding via %d proxies", len(options.ForwardProxies)))
+	}
+
+	lines = appendRulesSummary(lines, "blocking", len(options.BlockRules)+len(options.BlockPathRules))
+	lines = appendRulesSummary(lines, "dropping", len(options.DropRules))
+	lines = appendRulesSummary(lines, "https-only", len(options.HTTPSOnlyDomains))
+	lines = appendRulesSummary(lines, "minimum TLS version", len(options.MinTLSVersionRules))
+
+	if options.MinCipherStrength != "" {
+		lines = append(lines, fmt.Sprintf(
+			"TLS clients must offer %s cipher suites",
+			options.MinCipherStrength,
+		))
+	}
+
+	if options.BandwidthRate > 0 || len(options.BandwidthRules) > 0 {
+		lines = append(lines, fmt.Sprintf(
+			"shaping at %g bytes/sec with %d rules",
+			options.BandwidthRate,
+			len(options.BandwidthRules),
+		))
+	}
+
+	if options.MaxConnsPerIP > 0 {
+		lines = append(lines, fmt.Sprintf("at most %d connections per client", options.MaxConnsPerIP))
+	}
+
+	if options.RequireSNI {
+		lines = append(lines, "TLS connections without SNI are rejected")
+	}
+
+	if options.Transparent {
+		lines = append(lines, "transparent mode is enabled")
+	}
+
+	if options.ProxyProtocol {
+		lines = append(lines, fmt.Sprintf(
+			"PROXY protocol v%d is sent upstream",
+			options.ProxyProtocolVersion,
+		))
+	}
+
+	if options.AuditLog != "" {
+		lines = append(lines, fmt.Sprintf("audit events are written to %s", options.AuditLog))
+	}
+
+	if options.CaptureDir != "" {
+		lines = append(lines, fmt.Sprintf("unparsed connections are captured to %s", options.CaptureDir))
+	}
+
+	if options.AccessLog != "" {
+		lines = append(lines, fmt.Sprintf("finished tunnels are written to %s", options.AccessLog))
+	}
+
+	for _, s := range []struct {
+		name string
+		addr string
+	}{
+		{name: "status", addr: options.StatusAddress},
+		{name: "metrics", addr: options.MetricsAddress},
+		{name: "traces", addr: options.OTelEndpoint},
+	} {
+		if s.addr != "" {
+			lines = append(lines, fmt.Sprintf("%s: %s", s.name, s.addr))
+		}
+	}
+
+	return lines
+}
+
+// dnsSummary returns the lines describing the DNS proxy features.
+func dnsSummary(options *Options) (lines []string) {
+	var listeners []string
+	if options.plainDNS() {
+		for _, addr := range options.DNSListenAddress {
+			listeners = append(listeners, "dns://"+net.JoinHostPort(addr, strconv.Itoa(options.DNSPort)))
+		}
+	}
+
+	if options.DoQPort > 0 {
+		listeners = append(listeners, "quic://"+net.JoinHostPort(
+			options.DoQListenAddress,
+			strconv.Itoa(options.DoQPort),
+		))
+	}
+
+	if options.DoHPort > 0 {
+		listeners = append(listeners, "https://"+net.JoinHostPort(
+			options.DoHListenAddress,
+			strconv.Itoa(options.DoHPort),
+		)+options.DoHPath)
+	}
+
+	lines = append(lines, fmt.Sprintf(
+		"dns proxy listens on %s, upstreams: %s",
+		strings.Join(listeners, ", "),
+		strings.Join(options.DNSUpstream, ", "),
+	))
+
+	var redirectTo []string
+	for _, addr := range []string{options.DNSRedirectIPV4To, options.DNSRedirectIPV6To} {
+		if addr != "" {
+			redirectTo = append(redirectTo, addr)
+		}
+	}
+
+	if len(redirectTo) > 0 {
+		lines = append(lines, fmt.Sprintf(
+			"dns redirect to %s for %d rules",
+			strings.Join(redirectTo, ", "),
+			len(options.DNSRedirectRules),
+		))
+	}
+
+	if options.DNSSynthesizeAAAA != "" && options.DNSRedirectIPV6To == "" {
+		lines = append(lines, fmt.Sprintf("dns synthesizes AAAA answers (%s)", options.DNSSynthesizeAAAA))
+	}
+
+	lines = appendRulesSummary(lines, "dns drop", len(options.DNSDropRules))
+
+	if options.DNSCacheSize > 0 {
+		lines = append(lines, fmt.Sprintf("dns cache size is %d bytes", options.DNSCacheSize))
+	}
+
+	if options.DNSRateLimit > 0 {
+		lines = append(lines, fmt.Sprintf(
+			"dns rate limit is %d queries/sec per client",
+			options.DNSRateLimit,
+		))
+	}
+
+	if options.DNSTapAddress != "" {
+		lines = append(lines, fmt.Sprintf("dns queries are mirrored to %s", options.DNSTapAddress))
+	}
+
+	if options.DNSMaxInflight > 0 {
+		lines = append(lines, fmt.Sprintf(
+			"at most %d dns queries in flight, %s mode",
+			options.DNSMaxInflight,
+			options.DNSMaxInflightMode,
+		))
+	}
+
+	return lines
+}
+
+// shutdownSummaryHosts is the number of the top destinations in the shutdown
+// summary.
+const shutdownSummaryHosts = 10
+
+// logShutdownSummary logs the totals of the connections handled by p during
+// the run.
+func logShutdownSummary(p *sniproxy.SNIProxy) {
+	for _, line := range shutdownSummary(p.Summary(shutdownSummaryHosts)) {
+		log.Info("cmd: %s", line)
+	}
+}
+
+// shutdownSummary returns the lines describing the totals of the run.
+func shutdownSummary(s *sniproxy.RunSummary) (lines []string) {
+	lines = append(lines, fmt.Sprintf(
+		"run summary: uptime %v, %d connections, received %d bytes, sent %d bytes",
+		s.Uptime.Round(time.Second),
+		s.Connections,
+		s.BytesReceived,
+		s.BytesSent,
+	))
+
+	if len(s.Outcomes) > 0 {
+		outcomes := make([]string, 0, len(s.Outcomes))
+		for o, n := range s.Outcomes {
+			outcomes = append(outcomes, fmt.Sprintf("%s %d", o, n))
+		}
+
+		sort.Strings(outcomes)
+		lines = append(lines, fmt.Sprintf("connections by outcome: %s", strings.Join(outcomes, ", ")))
+	}
+
+	if len(s.TopHosts) > 0 {
+		hosts := make([]string, 0, len(s.TopHosts))
+		for _, h := range s.TopHosts {
+			hosts = append(hosts, fmt.Sprintf("%s (%d tunnels, %d bytes)", h.Host, h.Conns, h.Bytes))
+		}
+
+		lines = append(lines, fmt.Sprintf("top destinations: %s", strings.Join(hosts, ", ")))
+	}
+
+	return lines
+}
+
+// appendRulesSummary appends a line about the feature configured with n rules
+// to lines if there are any.
+func appendRulesSummary(lines []string, feature string, n int) (res []string) {
+	if n == 0 {
+		return lines
+	}
+
+	return append(lines, fmt.Sprintf("%s enabled for %d rules", feature, n))
+}
+
+// joinInts returns a comma-separated list of ints.
+func joinInts(ints []int) (s string) {
+	strs := make([]string, 0, len(ints))
+	for _, i := range ints {
+		strs = append(strs, strconv.Itoa(i))
+	}
+
+	return strings.Join(strs, ", ")
+}
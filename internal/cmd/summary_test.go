@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ameshkov/sniproxy/sniproxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownSummary(t *testing.T) {
+	testCases := []struct {
+		summary *sniproxy.RunSummary
+		name    string
+		want    []string
+	}{{
+		summary: &sniproxy.RunSummary{
+			Uptime:   1500 * time.Millisecond,
+			Outcomes: map[string]uint64{},
+		},
+		name: "empty",
+		want: []string{
+			"run summary: uptime 2s, 0 connections, received 0 bytes, sent 0 bytes",
+		},
+	}, {
+		summary: &sniproxy.RunSummary{
+			Uptime:        time.Hour,
+			Connections:   4,
+			BytesReceived: 300,
+			BytesSent:     30,
+			Outcomes: map[string]uint64{
+				sniproxy.OutcomeTunneled: 3,
+				"block":                  1,
+			},
+			TopHosts: []sniproxy.HostTotals{
+				{Host: "a.example", Conns: 2, Bytes: 220},
+				{Host: "b.example", Conns: 1, Bytes: 110},
+			},
+		},
+		name: "full",
+		want: []string{
+			"run summary: uptime 1h0m0s, 4 connections, received 300 bytes, sent 30 bytes",
+			"connections by outcome: block 1, tunneled 3",
+			"top destinations: a.example (2 tunnels, 220 bytes), b.example (1 tunnels, 110 bytes)",
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, shutdownSummary(tc.summary))
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FilePrefix is the prefix of a rule that is the path to a file with more
+// rules, e.g. "@/etc/sniproxy/block.txt".
+const FilePrefix = "@"
+
+// includeDirective is the directive that includes another rules file, e.g.
+// "include ads.txt".  Relative paths are resolved against the directory of
+// the including file.
+const includeDirective = "include"
+
+// ExpandFiles returns rules with the rules that start with [FilePrefix]
+// replaced by the rules read from the respective files.  A rules file has one
+// rule per line, empty lines and lines starting with "#" are ignored.  A line
+// "include path" is replaced by the rules from the file at path, includes are
+// followed recursively.  An error is returned if a file cannot be read or if
+// the includes form a cycle.
+func ExpandFiles(rules []string) (expanded []string, err error) {
+	for _, r := range rules {
+		path, ok := strings.CutPrefix(r, FilePrefix)
+		if !ok {
+			expanded = append(expanded, r)
+
+			continue
+		}
+
+		expanded, err = readRulesFile(expanded, path, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return expanded, nil
+}
+
+// readRulesFile appends the rules from the file at path to rules.  stack is
+// the chain of the files that include this one, it is used to detect cycles.
+func readRulesFile(rules []string, path string, stack []string) (res []string, err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("rules file %q: %w", path, err)
+	}
+
+	for _, p := range stack {
+		if p == path {
+			return nil, fmt.Errorf(
+				"rules file %q: include cycle: %s",
+				path,
+				strings.Join(append(stack, path), " -> "),
+			)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("rules file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	stack = append(stack, path)
+
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		r := strings.TrimSpace(s.Text())
+		if r == "" || strings.HasPrefix(r, "#") {
+			continue
+		}
+
+		inc, ok := strings.CutPrefix(r, includeDirective+" ")
+		if !ok {
+			rules = append(rules, r)
+
+			continue
+		}
+
+		inc = strings.TrimSpace(inc)
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(path), inc)
+		}
+
+		rules, err = readRulesFile(rules, inc, stack)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+
+	if err = s.Err(); err != nil {
+		return nil, fmt.Errorf("rules file %q: %w", path, err)
+	}
+
+	return rules, nil
+}
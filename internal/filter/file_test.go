@@ -0,0 +1,87 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	// writeFile writes the lines to the file with name in dir and returns its
+	// path.
+	writeFile := func(name string, lines string) (path string) {
+		path = filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(lines), 0o600))
+
+		return path
+	}
+
+	ads := writeFile("ads.txt", "*.ads.example\n")
+	block := writeFile("block.txt", "# Block list.\n\n  *.tracker.example  \ninclude ads.txt\n")
+	nested := writeFile("nested.txt", "include "+block+"\nexample.net\n")
+	cycle := writeFile("cycle.txt", "example.org\ninclude cycle.txt\n")
+	missing := writeFile("missing.txt", "include none.txt\n")
+
+	testCases := []struct {
+		name       string
+		rules      []string
+		want       []string
+		wantErrMsg string
+	}{{
+		name:       "no_files",
+		rules:      []string{"example.org", "*.example.com"},
+		want:       []string{"example.org", "*.example.com"},
+		wantErrMsg: "",
+	}, {
+		name:       "file",
+		rules:      []string{"example.org", "@" + ads},
+		want:       []string{"example.org", "*.ads.example"},
+		wantErrMsg: "",
+	}, {
+		name:       "include",
+		rules:      []string{"@" + block},
+		want:       []string{"*.tracker.example", "*.ads.example"},
+		wantErrMsg: "",
+	}, {
+		name:       "nested_include",
+		rules:      []string{"@" + nested},
+		want:       []string{"*.tracker.example", "*.ads.example", "example.net"},
+		wantErrMsg: "",
+	}, {
+		name:  "cycle",
+		rules: []string{"@" + cycle},
+		want:  nil,
+		wantErrMsg: cycle + ":2: rules file \"" + cycle + "\": include cycle: " +
+			cycle + " -> " + cycle,
+	}, {
+		name:  "missing_include",
+		rules: []string{"@" + missing},
+		want:  nil,
+		wantErrMsg: missing + ":1: rules file: open " + filepath.Join(dir, "none.txt") +
+			": no such file or directory",
+	}, {
+		name:       "missing_file",
+		rules:      []string{"@" + filepath.Join(dir, "none.txt")},
+		want:       nil,
+		wantErrMsg: "rules file: open " + filepath.Join(dir, "none.txt") + ": no such file or directory",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ExpandFiles(tc.rules)
+			if tc.wantErrMsg != "" {
+				assert.EqualError(t, err, tc.wantErrMsg)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -1,16 +1,62 @@
 // Package filter provides helpers for applying all kinds of rules.
 package filter
 
-import "github.com/IGLOU-EU/go-wildcard"
+import (
+	"strings"
+)
 
 // MatchWildcards checks if the string str matches any of the specified
 // wildcards.
 func MatchWildcards(str string, wildcards []string) (ok bool) {
-	for _, w := range wildcards {
-		if wildcard.MatchSimple(w, str) {
-			return true
+	_, ok = MatchedWildcard(str, wildcards)
+
+	return ok
+}
+
+// MatchedWildcard returns the first of the specified wildcards that matches
+// the string str.  ok is false if none of them match.
+func MatchedWildcard(str string, wildcards []string) (w string, ok bool) {
+	for _, w = range wildcards {
+		if MatchWildcard(str, w) {
+			return w, true
 		}
 	}
 
-	return false
+	return "", false
+}
+
+// MatchWildcard checks if the string str matches the wildcard w according to
+// the matching mode of w, see [ParseRule].
+func MatchWildcard(str string, w string) (ok bool) {
+	m, pattern := ParseRule(w)
+
+	return match(m, str, pattern)
+}
+
+// IsApex checks if domainName is the apex domain of the wildcard w, i.e. if w
+// is something like "*.example.org" or "*example.org" and domainName is
+// "example.org".  The comparison is case-insensitive.  The matching mode of w
+// is ignored.
+func IsApex(domainName string, w string) (ok bool) {
+	_, w = ParseRule(w)
+	apex, ok := strings.CutPrefix(strings.ToLower(w), "*")
+	if !ok {
+		return false
+	}
+
+	return strings.TrimPrefix(apex, ".") == strings.ToLower(domainName)
+}
+
+// MoreSpecific reports whether the wildcard a takes precedence over the
+// wildcard b when both of them match the same string.  The longest pattern
+// wins as it is the most specific one, ties are broken alphabetically so that
+// the result doesn't depend on the order of the rules.
+func MoreSpecific(a, b string) (ok bool) {
+	_, pa := ParseRule(a)
+	_, pb := ParseRule(b)
+	if len(pa) != len(pb) {
+		return len(pa) > len(pb)
+	}
+
+	return a < b
 }
@@ -0,0 +1,97 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsApex(t *testing.T) {
+	testCases := []struct {
+		name       string
+		domainName string
+		wildcard   string
+		want       bool
+	}{{
+		name:       "dot",
+		domainName: "example.org",
+		wildcard:   "*.example.org",
+		want:       true,
+	}, {
+		name:       "no_dot",
+		domainName: "example.org",
+		wildcard:   "*example.org",
+		want:       true,
+	}, {
+		name:       "case",
+		domainName: "Example.ORG",
+		wildcard:   "*.EXAMPLE.org",
+		want:       true,
+	}, {
+		name:       "subdomain",
+		domainName: "sub.example.org",
+		wildcard:   "*.example.org",
+		want:       false,
+	}, {
+		name:       "no_star",
+		domainName: "example.org",
+		wildcard:   "example.org",
+		want:       false,
+	}, {
+		name:       "mode_prefix",
+		domainName: "example.org",
+		wildcard:   "label *.example.org",
+		want:       true,
+	}, {
+		name:       "leading_dots",
+		domainName: "example.org",
+		wildcard:   "*..example.org",
+		want:       false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, IsApex(tc.domainName, tc.wildcard))
+		})
+	}
+}
+
+func TestMoreSpecific(t *testing.T) {
+	testCases := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{{
+		name: "longer",
+		a:    "*.video.example.org",
+		b:    "*.example.org",
+		want: true,
+	}, {
+		name: "shorter",
+		a:    "*.example.org",
+		b:    "*.video.example.org",
+		want: false,
+	}, {
+		name: "tie",
+		a:    "a.example.org",
+		b:    "b.example.org",
+		want: true,
+	}, {
+		name: "tie_reversed",
+		a:    "b.example.org",
+		b:    "a.example.org",
+		want: false,
+	}, {
+		name: "mode_prefix_ignored",
+		a:    "substring example",
+		b:    "*.example.org",
+		want: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, MoreSpecific(tc.a, tc.b))
+		})
+	}
+}
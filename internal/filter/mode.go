@@ -0,0 +1,147 @@
+package filter
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/IGLOU-EU/go-wildcard"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Mode defines how a rule is matched against hostnames.  The mode is chosen
+// per rule by prefixing the rule with the mode name and a space, e.g.
+// "etld1 doubleclick.net" or "label *.example.org".  Rules without a prefix
+// use [ModeWildcard].
+type Mode string
+
+const (
+	// ModeWildcard is the default mode.  "*" matches any sequence of
+	// characters including dots, e.g. "*example.org" matches both
+	// "example.org" and "www.example.org", "*.example.org" matches
+	// "a.b.example.org".
+	ModeWildcard Mode = "wildcard"
+
+	// ModeLabel is the strict hostname glob mode.  "*" matches any sequence of
+	// characters within a single label, i.e. it never matches a dot.  For
+	// instance, "*.example.org" matches "www.example.org", but neither
+	// "example.org" nor "a.b.example.org".  "**" matches any sequence of
+	// characters including dots, e.g. "**.example.org" matches
+	// "a.b.example.org".  Note that path rules need an asterisk per label or
+	// "**", e.g. "*.*/ads/*" or "**/ads/*".
+	ModeLabel Mode = "label"
+
+	// ModeSubstring is the simple substring mode.  Asterisks are removed from
+	// the rule and the string matches if it contains the rest, e.g. "example"
+	// and "*example*" both match "www.example.org".
+	ModeSubstring Mode = "substring"
+
+	// ModeRegistrable is the registrable domain mode.  The rule is matched as
+	// a wildcard against the registrable domain (eTLD+1) of the hostname
+	// according to the public suffix list.  For instance, "doubleclick.net"
+	// matches "ad.g.doubleclick.net", and "example.co.uk" matches
+	// "www.example.co.uk".  IP addresses and public suffixes are matched as
+	// is.
+	ModeRegistrable Mode = "etld1"
+)
+
+// ParseRule returns the matching mode of the rule and the rule without the
+// mode prefix.  If the rule has no known mode prefix, m is [ModeWildcard] and
+// pattern is the rule itself.
+func ParseRule(rule string) (m Mode, pattern string) {
+	prefix, rest, ok := strings.Cut(rule, " ")
+	if !ok {
+		return ModeWildcard, rule
+	}
+
+	switch m = Mode(prefix); m {
+	case ModeWildcard, ModeLabel, ModeSubstring, ModeRegistrable:
+		return m, strings.TrimLeft(rest, " ")
+	default:
+		return ModeWildcard, rule
+	}
+}
+
+// match checks if str matches the wildcard w in the mode m.  "*" alone
+// matches any string in every mode.
+func match(m Mode, str, w string) (ok bool) {
+	if w == "*" {
+		return true
+	}
+
+	switch m {
+	case ModeLabel:
+		return matchLabel(w, str)
+	case ModeSubstring:
+		return strings.Contains(str, strings.ReplaceAll(w, "*", ""))
+	case ModeRegistrable:
+		return wildcard.MatchSimple(w, registrable(str))
+	default:
+		return wildcard.MatchSimple(w, str)
+	}
+}
+
+// matchLabel checks if str matches the pattern where "*" matches any sequence
+// of characters except for dots and "**" matches any sequence of characters.
+func matchLabel(pattern, str string) (ok bool) {
+	for len(pattern) > 0 {
+		if strings.HasPrefix(pattern, "**") {
+			pattern = strings.TrimLeft(pattern, "*")
+
+			// Try every sequence the asterisks may match, including the ones
+			// that span several labels.
+			for i := 0; i <= len(str); i++ {
+				if matchLabel(pattern, str[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if pattern[0] == '*' {
+			pattern = pattern[1:]
+
+			// Try every sequence the asterisk may match, it ends at the
+			// label boundary.
+			for i := 0; ; i++ {
+				if matchLabel(pattern, str[i:]) {
+					return true
+				}
+
+				if i == len(str) || str[i] == '.' {
+					return false
+				}
+			}
+		}
+
+		if len(str) == 0 || str[0] != pattern[0] {
+			return false
+		}
+
+		pattern, str = pattern[1:], str[1:]
+	}
+
+	return len(str) == 0
+}
+
+// registrable replaces the hostname in str with its registrable domain.  str
+// may be followed by a path, e.g. "www.example.org/ads/", the path is kept as
+// is.
+func registrable(str string) (r string) {
+	host, path := str, ""
+	if i := strings.IndexByte(str, '/'); i >= 0 {
+		host, path = str[:i], str[i:]
+	}
+
+	if _, err := netip.ParseAddr(host); err == nil {
+		return str
+	}
+
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		// The host is a public suffix itself or is not a valid domain name.
+		return str
+	}
+
+	return domain + path
+}
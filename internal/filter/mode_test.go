@@ -0,0 +1,242 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/publicsuffix"
+)
+
+func TestParseRule(t *testing.T) {
+	testCases := []struct {
+		name        string
+		rule        string
+		wantPattern string
+		wantMode    Mode
+	}{{
+		name:        "no_prefix",
+		rule:        "*.example.org",
+		wantPattern: "*.example.org",
+		wantMode:    ModeWildcard,
+	}, {
+		name:        "wildcard",
+		rule:        "wildcard *.example.org",
+		wantPattern: "*.example.org",
+		wantMode:    ModeWildcard,
+	}, {
+		name:        "label",
+		rule:        "label *.example.org",
+		wantPattern: "*.example.org",
+		wantMode:    ModeLabel,
+	}, {
+		name:        "substring",
+		rule:        "substring example",
+		wantPattern: "example",
+		wantMode:    ModeSubstring,
+	}, {
+		name:        "etld1",
+		rule:        "etld1 doubleclick.net",
+		wantPattern: "doubleclick.net",
+		wantMode:    ModeRegistrable,
+	}, {
+		name:        "extra_spaces",
+		rule:        "label  *.example.org",
+		wantPattern: "*.example.org",
+		wantMode:    ModeLabel,
+	}, {
+		name:        "unknown_prefix",
+		rule:        "regexp .*",
+		wantPattern: "regexp .*",
+		wantMode:    ModeWildcard,
+	}, {
+		name:        "prefix_only",
+		rule:        "label ",
+		wantPattern: "",
+		wantMode:    ModeLabel,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, pattern := ParseRule(tc.rule)
+			assert.Equal(t, tc.wantMode, m)
+			assert.Equal(t, tc.wantPattern, pattern)
+		})
+	}
+}
+
+// TestMatchWildcard_modes contrasts the modes on the same rule and hostname
+// pairs.
+func TestMatchWildcard_modes(t *testing.T) {
+	modes := []Mode{ModeWildcard, ModeLabel, ModeSubstring, ModeRegistrable}
+
+	testCases := []struct {
+		name string
+		rule string
+		host string
+		// want is the result in each of the modes in the order of modes.
+		want [4]bool
+	}{{
+		name: "subdomain",
+		rule: "*.example.org",
+		host: "www.example.org",
+		want: [4]bool{true, true, true, false},
+	}, {
+		name: "deep_subdomain",
+		rule: "*.example.org",
+		host: "a.b.example.org",
+		want: [4]bool{true, false, true, false},
+	}, {
+		name: "double_asterisk",
+		rule: "**.example.org",
+		host: "a.b.example.org",
+		want: [4]bool{true, true, true, false},
+	}, {
+		name: "other_domain",
+		rule: "*.example.org",
+		host: "evilexample.org",
+		want: [4]bool{false, false, false, false},
+	}, {
+		name: "apex",
+		rule: "*example.org",
+		host: "example.org",
+		want: [4]bool{true, true, true, true},
+	}, {
+		name: "suffix",
+		rule: "*example.org",
+		host: "evilexample.org",
+		want: [4]bool{true, true, true, true},
+	}, {
+		name: "part",
+		rule: "example",
+		host: "www.example.org",
+		want: [4]bool{false, false, true, false},
+	}, {
+		name: "bare_domain",
+		rule: "example.org",
+		host: "a.b.example.org",
+		want: [4]bool{false, false, true, true},
+	}, {
+		name: "path",
+		rule: "*/ads/*",
+		host: "a.example.org/ads/1",
+		want: [4]bool{true, false, true, true},
+	}, {
+		name: "double_asterisk_path",
+		rule: "**/ads/*",
+		host: "a.example.org/ads/1",
+		want: [4]bool{true, true, true, true},
+	}, {
+		name: "match_all",
+		rule: "*",
+		host: "a.b.example.org",
+		want: [4]bool{true, true, true, true},
+	}}
+
+	for _, tc := range testCases {
+		for i, m := range modes {
+			t.Run(tc.name+"_"+string(m), func(t *testing.T) {
+				assert.Equal(t, tc.want[i], MatchWildcard(tc.host, string(m)+" "+tc.rule))
+			})
+		}
+	}
+}
+
+func TestMatchWildcard_defaultMode(t *testing.T) {
+	// Rules without a prefix are not affected by the prefixed ones.
+	rules := []string{"label *.example.net", "*.example.org"}
+
+	w, ok := MatchedWildcard("www.example.net", rules)
+	assert.True(t, ok)
+	assert.Equal(t, "label *.example.net", w)
+
+	w, ok = MatchedWildcard("a.b.example.org", rules)
+	assert.True(t, ok)
+	assert.Equal(t, "*.example.org", w)
+
+	assert.False(t, MatchWildcards("a.b.example.net", rules))
+}
+
+func TestMatchWildcard_registrable(t *testing.T) {
+	testCases := []struct {
+		name string
+		rule string
+		host string
+		want bool
+	}{{
+		name: "subdomain",
+		rule: "doubleclick.net",
+		host: "ad.g.doubleclick.net",
+		want: true,
+	}, {
+		name: "apex",
+		rule: "doubleclick.net",
+		host: "doubleclick.net",
+		want: true,
+	}, {
+		name: "multi_label_suffix",
+		rule: "example.co.uk",
+		host: "www.example.co.uk",
+		want: true,
+	}, {
+		name: "other_domain_same_suffix",
+		rule: "example.co.uk",
+		host: "www.other.co.uk",
+		want: false,
+	}, {
+		name: "private_suffix",
+		rule: "example.github.io",
+		host: "www.example.github.io",
+		want: true,
+	}, {
+		name: "private_suffix_sibling",
+		rule: "example.github.io",
+		host: "other.github.io",
+		want: false,
+	}, {
+		name: "wildcard_in_rule",
+		rule: "doubleclick.*",
+		host: "ad.doubleclick.net",
+		want: true,
+	}, {
+		name: "public_suffix",
+		rule: "co.uk",
+		host: "co.uk",
+		want: true,
+	}, {
+		name: "ip",
+		rule: "192.168.0.1",
+		host: "192.168.0.1",
+		want: true,
+	}, {
+		name: "path",
+		rule: "example.org/ads/*",
+		host: "cdn.example.org/ads/1.js",
+		want: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, MatchWildcard(tc.host, "etld1 "+tc.rule))
+		})
+	}
+}
+
+func TestRegistrable_publicSuffix(t *testing.T) {
+	hosts := []string{
+		"ad.g.doubleclick.net",
+		"www.example.co.uk",
+		"a.b.c.example.com.au",
+		"www.example.github.io",
+	}
+
+	for _, host := range hosts {
+		t.Run(host, func(t *testing.T) {
+			want, err := publicsuffix.EffectiveTLDPlusOne(host)
+			require.NoError(t, err)
+
+			assert.Equal(t, want, registrable(host))
+			assert.True(t, MatchWildcard(host, "etld1 "+want))
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package filter
+
+import (
+	"sync"
+)
+
+// RuleStat is the number of times a rule matched.
+type RuleStat struct {
+	// Kind is the kind of the rule, e.g. "block".
+	Kind string `json:"kind"`
+
+	// Rule is the rule itself, e.g. "*.example.org".
+	Rule string `json:"rule"`
+
+	// Matches is the number of times the rule matched.
+	Matches uint64 `json:"matches"`
+}
+
+// statKey identifies a rule in Stats.  The same wildcard may be used by rules
+// of different kinds.
+type statKey struct {
+	kind string
+	rule string
+}
+
+// Stats counts how many times the rules matched.  It is safe for concurrent
+// use.
+type Stats struct {
+	mu      sync.Mutex
+	matches map[statKey]uint64
+}
+
+// NewStats creates a new instance of *Stats.
+func NewStats() (s *Stats) {
+	return &Stats{
+		matches: map[statKey]uint64{},
+	}
+}
+
+// Inc increments the number of matches of the rule of the specified kind.
+func (s *Stats) Inc(kind, rule string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.matches[statKey{kind: kind, rule: rule}]++
+}
+
+// Collect returns the number of matches of the specified rules of the kind.
+// The rules that never matched are included with zero matches so that they
+// are easy to find.
+func (s *Stats) Collect(kind string, rules []string) (stats []RuleStat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range rules {
+		stats = append(stats, RuleStat{
+			Kind:    kind,
+			Rule:    r,
+			Matches: s.matches[statKey{kind: kind, rule: r}],
+		})
+	}
+
+	return stats
+}
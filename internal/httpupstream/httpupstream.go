@@ -7,6 +7,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -14,6 +15,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
@@ -28,12 +30,46 @@ type HTTPProxyDialer struct {
 	tls      bool
 	userinfo *url.Userinfo
 	next     proxy.ContextDialer
+
+	// probeTimeout is the time the dialer waits for the proxy to close the
+	// connection after it responded 200 to CONNECT.  If zero, there is no
+	// probe.
+	probeTimeout time.Duration
+
+	// probed are the targets that recently passed the probe.
+	probed *probeCache
+
+	// connectTimeout is the maximum time the proxy is given to respond to
+	// CONNECT once the connection to it is established.  If zero, only the
+	// context of the dial limits it.
+	connectTimeout time.Duration
 }
 
 // type check
 var _ proxy.Dialer = (*HTTPProxyDialer)(nil)
 var _ proxy.ContextDialer = (*HTTPProxyDialer)(nil)
 
+// TargetError is returned by [HTTPProxyDialer.DialContext] when the proxy
+// itself works, but it could not or did not want to connect to the target,
+// e.g. it responded to CONNECT with 502.  Such errors say nothing about the
+// proxy health.
+type TargetError struct {
+	Err error
+}
+
+// type check
+var _ error = (*TargetError)(nil)
+
+// Error implements the error interface for *TargetError.
+func (e *TargetError) Error() (msg string) {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *TargetError) Unwrap() (err error) {
+	return e.Err
+}
+
 // init registers http and https schemes.
 func init() {
 	proxy.RegisterDialerType("http", HTTPProxyDialerFromURL)
@@ -52,9 +88,29 @@ func NewHTTPProxyDialer(
 		tls:      tls,
 		next:     maybeWrapWithContextDialer(next),
 		userinfo: userinfo,
+		probed:   newProbeCache(),
 	}
 }
 
+// SetProbeTimeout enables the probe that detects proxies that respond 200 to
+// CONNECT and then immediately close the connection, which usually means that
+// the target is not allowed by the proxy ACL.  After a successful CONNECT the
+// dialer waits up to timeout for the connection to be closed and returns a
+// descriptive error if it is.  Note, that it delays the connection by the
+// timeout unless the remote host speaks first, so the targets that passed the
+// probe are not probed again for a while.  Zero disables the probe.
+func (d *HTTPProxyDialer) SetProbeTimeout(timeout time.Duration) {
+	d.probeTimeout = timeout
+}
+
+// SetConnectTimeout limits the time of the CONNECT handshake, i.e. the time
+// between establishing the connection to the proxy and receiving its response
+// to CONNECT.  A slow proxy fails the dial with [context.DeadlineExceeded]
+// after timeout.  Zero means no limit.
+func (d *HTTPProxyDialer) SetConnectTimeout(timeout time.Duration) {
+	d.connectTimeout = timeout
+}
+
 // HTTPProxyDialerFromURL creates an instance of proxy.Dialer from an http:// or
 // https:// URL.
 func HTTPProxyDialerFromURL(u *url.URL, next proxy.Dialer) (d proxy.Dialer, err error) {
@@ -116,6 +172,12 @@ func (d *HTTPProxyDialer) DialContext(
 		})
 	}
 
+	if d.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.connectTimeout)
+		defer cancel()
+	}
+
 	stopGuardEvent := make(chan struct{})
 	guardErr := make(chan error, 1)
 	go func() {
@@ -150,7 +212,7 @@ func (d *HTTPProxyDialer) DialContext(
 		return nil,
 			fmt.Errorf(
 				"httpupstream: unable to write proxy request for remote connection: %w",
-				err,
+				guardCause(ctx, err),
 			)
 	}
 
@@ -158,13 +220,37 @@ func (d *HTTPProxyDialer) DialContext(
 	if err != nil {
 		log.OnCloserError(conn, log.DEBUG)
 
-		return nil, fmt.Errorf("httpupstream: reading proxy response failed: %w", err)
+		return nil, fmt.Errorf("httpupstream: reading proxy response failed: %w", guardCause(ctx, err))
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		log.OnCloserError(conn, log.DEBUG)
 
-		return nil, fmt.Errorf("httpupstream: bad status code from proxy: %d", resp.StatusCode)
+		err = fmt.Errorf("httpupstream: bad status code from proxy: %d", resp.StatusCode)
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			// The proxy is misconfigured rather than the target is not
+			// available.
+			return nil, err
+		}
+
+		return nil, &TargetError{Err: err}
+	}
+
+	if d.probeTimeout > 0 && !d.probed.has(address, time.Now()) {
+		conn, err = probe(conn, d.probeTimeout)
+		if err != nil {
+			log.OnCloserError(conn, log.DEBUG)
+
+			// The proxy itself works, it is the target that is not allowed.
+			return nil, &TargetError{Err: fmt.Errorf(
+				"httpupstream: proxy closed the connection to %s right after CONNECT, "+
+					"the target may not be allowed by the proxy: %w",
+				address,
+				err,
+			)}
+		}
+
+		d.probed.add(address, time.Now())
 	}
 
 	stopGuard()
@@ -176,6 +262,88 @@ func (d *HTTPProxyDialer) DialContext(
 	return conn, nil
 }
 
+// guardCause returns the error of ctx if it is done, since the guard closes
+// the connection when that happens and err is then just a consequence of it.
+// Otherwise, err is returned.
+func guardCause(ctx context.Context, err error) (cause error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	return err
+}
+
+// probe waits for up to timeout for the connection to be closed by the proxy.
+// If the remote host sends some data during that time, the returned connection
+// contains it.
+func probe(conn net.Conn, timeout time.Duration) (probedConn net.Conn, err error) {
+	err = conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return conn, fmt.Errorf("setting read deadline: %w", err)
+	}
+
+	b := make([]byte, 1)
+	n, err := conn.Read(b)
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		// The connection is alive and the remote host waits for the client
+		// to speak first.
+		err = nil
+	}
+
+	if err != nil {
+		return conn, err
+	}
+
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return conn, fmt.Errorf("removing read deadline: %w", err)
+	}
+
+	if n > 0 {
+		conn = &prefixConn{
+			Conn:   conn,
+			prefix: b[:n],
+		}
+	}
+
+	return conn, nil
+}
+
+// prefixConn is a net.Conn which Read returns the prefix first and then
+// continues reading from the underlying connection.
+type prefixConn struct {
+	net.Conn
+
+	prefix []byte
+}
+
+// type check
+var _ net.Conn = (*prefixConn)(nil)
+
+// Read implements the net.Conn interface for *prefixConn.
+func (c *prefixConn) Read(b []byte) (n int, err error) {
+	if len(c.prefix) > 0 {
+		n = copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+
+		return n, nil
+	}
+
+	return c.Conn.Read(b)
+}
+
+// CloseWrite closes the writing side of the underlying connection if it
+// supports that, otherwise it closes the connection.
+func (c *prefixConn) CloseWrite() (err error) {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return c.Conn.Close()
+}
+
 var (
 	responseTerminator = []byte("\r\n\r\n")
 )
@@ -0,0 +1,176 @@
+package httpupstream
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+// testProbeTimeout is the probe timeout used in the tests.
+const testProbeTimeout = 200 * time.Millisecond
+
+// startProxy starts a fake HTTP proxy that responds 200 to CONNECT.  The
+// connections to the targets in closed are closed right after that, the rest
+// are kept open until the client closes them.
+func startProxy(t *testing.T, closed ...string) (addr string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, aErr := l.Accept()
+			if aErr != nil {
+				return
+			}
+
+			go func() {
+				defer func() { _ = conn.Close() }()
+
+				r := bufio.NewReader(conn)
+				req, rErr := http.ReadRequest(r)
+				if rErr != nil {
+					return
+				}
+
+				_, _ = fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				for _, c := range closed {
+					if req.Host == c {
+						return
+					}
+				}
+
+				_, _ = r.ReadByte()
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestHTTPProxyDialer_probe(t *testing.T) {
+	d := NewHTTPProxyDialer(startProxy(t, "denied.example:443"), false, nil, proxy.Direct)
+	d.SetProbeTimeout(testProbeTimeout)
+
+	_, err := d.Dial("tcp", "denied.example:443")
+	require.Error(t, err)
+
+	var targetErr *TargetError
+	assert.ErrorAs(t, err, &targetErr)
+	assert.Contains(t, err.Error(), "right after CONNECT")
+
+	// The first connection to a target is delayed by the probe, the next ones
+	// are not.
+	testCases := []struct {
+		name      string
+		address   string
+		wantProbe bool
+	}{{
+		name:      "first",
+		address:   "allowed.example:443",
+		wantProbe: true,
+	}, {
+		name:      "cached",
+		address:   "allowed.example:443",
+		wantProbe: false,
+	}, {
+		name:      "other_target",
+		address:   "other.example:443",
+		wantProbe: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+			conn, dErr := d.Dial("tcp", tc.address)
+			require.NoError(t, dErr)
+			t.Cleanup(func() { _ = conn.Close() })
+
+			elapsed := time.Since(start)
+			if tc.wantProbe {
+				assert.GreaterOrEqual(t, elapsed, testProbeTimeout)
+			} else {
+				assert.Less(t, elapsed, testProbeTimeout)
+			}
+		})
+	}
+
+	// The failed targets are never cached.
+	_, err = d.Dial("tcp", "denied.example:443")
+	assert.Error(t, err)
+}
+
+// startSilentProxy starts a fake HTTP proxy that accepts the connections, but
+// never responds to CONNECT.
+func startSilentProxy(t *testing.T) (addr string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, aErr := l.Accept()
+			if aErr != nil {
+				return
+			}
+
+			go func() {
+				defer func() { _ = conn.Close() }()
+
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestHTTPProxyDialer_connectTimeout(t *testing.T) {
+	const connectTimeout = 100 * time.Millisecond
+
+	testCases := []struct {
+		name    string
+		addr    string
+		wantErr error
+	}{{
+		name:    "silent",
+		addr:    startSilentProxy(t),
+		wantErr: context.DeadlineExceeded,
+	}, {
+		name:    "responding",
+		addr:    startProxy(t),
+		wantErr: nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewHTTPProxyDialer(tc.addr, false, nil, proxy.Direct)
+			d.SetConnectTimeout(connectTimeout)
+
+			start := time.Now()
+			conn, err := d.Dial("tcp", "example.org:443")
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				assert.Less(t, time.Since(start), 10*connectTimeout)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NoError(t, conn.Close())
+		})
+	}
+}
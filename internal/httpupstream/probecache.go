@@ -0,0 +1,72 @@
+package httpupstream
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// probeCacheTTL is the period of time a target that passed the probe is
+	// not probed again.
+	probeCacheTTL = 10 * time.Minute
+
+	// probeCacheSize is the maximum number of targets the probe results are
+	// kept for.
+	probeCacheSize = 10_000
+)
+
+// probeCache keeps the targets that recently passed the probe, so that only
+// the first connection to a target is delayed by it.  It is safe for
+// concurrent use.
+type probeCache struct {
+	// mu protects passed.
+	mu sync.Mutex
+
+	// passed maps the target addresses to the time their probe results
+	// expire.
+	passed map[string]time.Time
+}
+
+// newProbeCache creates a new *probeCache.
+func newProbeCache() (c *probeCache) {
+	return &probeCache{
+		passed: map[string]time.Time{},
+	}
+}
+
+// has checks if address passed the probe recently.
+func (c *probeCache) has(address string, now time.Time) (ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires, ok := c.passed[address]
+	if ok && now.After(expires) {
+		delete(c.passed, address)
+
+		return false
+	}
+
+	return ok
+}
+
+// add remembers that address passed the probe.  If the cache is full, the
+// expired entries are removed first, and if there are none, the whole cache is
+// reset.
+func (c *probeCache) add(address string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.passed) >= probeCacheSize {
+		for a, expires := range c.passed {
+			if now.After(expires) {
+				delete(c.passed, a)
+			}
+		}
+
+		if len(c.passed) >= probeCacheSize {
+			c.passed = map[string]time.Time{}
+		}
+	}
+
+	c.passed[address] = now.Add(probeCacheTTL)
+}
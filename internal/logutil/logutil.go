@@ -0,0 +1,18 @@
+// Package logutil contains the logging helpers that golibs/log lacks.
+package logutil
+
+import (
+	"fmt"
+	stdlog "log"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Warn writes to the log with the warn level.  golibs/log has no such level,
+// so the message is written whenever the info level is enabled, in the same
+// format as the messages of the other levels.
+func Warn(format string, args ...any) {
+	if log.GetLevel() >= log.INFO {
+		stdlog.Println("[warn] " + fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,212 @@
+// Package metrics contains the metrics of the proxies and the HTTP server that
+// exposes them in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Label values of SNIConnections.
+const (
+	ProtoTLS  = "tls"
+	ProtoHTTP = "http"
+)
+
+// Label values of DNSQueries.
+const (
+	ActionRedirected  = "redirected"
+	ActionDropped     = "dropped"
+	ActionForwarded   = "forwarded"
+	ActionRefused     = "refused"
+	ActionRateLimited = "rate_limited"
+	ActionOverloaded  = "overloaded"
+)
+
+var (
+	// SNIConnections is the number of accepted connections by protocol.
+	SNIConnections = newCounterVec(
+		"sniproxy_connections_total",
+		"Total number of connections accepted by the SNI proxy.",
+		"proto",
+	)
+
+	// SNIBlocked is the number of connections blocked by the rules.
+	SNIBlocked = newCounter(
+		"sniproxy_connections_blocked_total",
+		"Total number of connections blocked by the block rules.",
+	)
+
+	// SNIForwarded is the number of connections tunneled through the forward
+	// proxies.
+	SNIForwarded = newCounter(
+		"sniproxy_connections_forwarded_total",
+		"Total number of connections tunneled through the forward proxies.",
+	)
+
+	// SNIDialErrors is the number of failed attempts to connect to the remote
+	// hosts.
+	SNIDialErrors = newCounter(
+		"sniproxy_dial_errors_total",
+		"Total number of connections that failed because the proxy could not connect to the remote host.",
+	)
+
+	// SNIBytesReceived is the number of bytes received from the remote hosts.
+	SNIBytesReceived = newCounter(
+		"sniproxy_bytes_received_total",
+		"Total number of bytes received from the remote hosts.",
+	)
+
+	// SNIBytesSent is the number of bytes sent to the remote hosts.
+	SNIBytesSent = newCounter(
+		"sniproxy_bytes_sent_total",
+		"Total number of bytes sent to the remote hosts.",
+	)
+
+	// DNSQueries is the number of DNS queries by the action taken.
+	DNSQueries = newCounterVec(
+		"dnsproxy_queries_total",
+		"Total number of DNS queries by the action taken.",
+		"action",
+	)
+)
+
+// all is the list of all metrics in the order they are written.
+var all = []metric{
+	SNIConnections,
+	SNIBlocked,
+	SNIForwarded,
+	SNIDialErrors,
+	SNIBytesReceived,
+	SNIBytesSent,
+	DNSQueries,
+}
+
+// metric is a metric that can be written in the Prometheus text format.
+type metric interface {
+	// writeTo writes the metric including its HELP and TYPE lines.
+	writeTo(w io.Writer) (err error)
+}
+
+// Counter is a monotonically increasing value.  It is safe for concurrent use.
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+// type check
+var _ metric = (*Counter)(nil)
+
+// newCounter creates a new *Counter.
+func newCounter(name, help string) (c *Counter) {
+	return &Counter{
+		name: name,
+		help: help,
+	}
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add increases the counter by n.
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// writeTo implements the metric interface for *Counter.
+func (c *Counter) writeTo(w io.Writer) (err error) {
+	_, err = fmt.Fprintf(
+		w,
+		"# HELP %s %s\n# TYPE %s counter\n%s %d\n",
+		c.name,
+		c.help,
+		c.name,
+		c.name,
+		c.value.Load(),
+	)
+
+	return err
+}
+
+// CounterVec is a set of counters that differ by the value of a single label.
+// It is safe for concurrent use.
+type CounterVec struct {
+	name  string
+	help  string
+	label string
+
+	// mu protects values.
+	mu     sync.Mutex
+	values map[string]*atomic.Uint64
+}
+
+// type check
+var _ metric = (*CounterVec)(nil)
+
+// newCounterVec creates a new *CounterVec.
+func newCounterVec(name, help, label string) (c *CounterVec) {
+	return &CounterVec{
+		name:   name,
+		help:   help,
+		label:  label,
+		values: map[string]*atomic.Uint64{},
+	}
+}
+
+// Inc increments the counter with the label value by one.
+func (c *CounterVec) Inc(labelValue string) {
+	c.mu.Lock()
+	v, ok := c.values[labelValue]
+	if !ok {
+		v = &atomic.Uint64{}
+		c.values[labelValue] = v
+	}
+	c.mu.Unlock()
+
+	v.Add(1)
+}
+
+// writeTo implements the metric interface for *CounterVec.
+func (c *CounterVec) writeTo(w io.Writer) (err error) {
+	c.mu.Lock()
+	labelValues := make([]string, 0, len(c.values))
+	values := make(map[string]uint64, len(c.values))
+	for lv, v := range c.values {
+		labelValues = append(labelValues, lv)
+		values[lv] = v.Load()
+	}
+	c.mu.Unlock()
+
+	sort.Strings(labelValues)
+
+	_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if err != nil {
+		return err
+	}
+
+	for _, lv := range labelValues {
+		_, err = fmt.Fprintf(w, "%s{%s=%q} %d\n", c.name, c.label, lv, values[lv])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write writes all the metrics in the Prometheus text format.
+func Write(w io.Writer) (err error) {
+	for _, m := range all {
+		if err = m.writeTo(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// readHeaderTimeout is the timeout for reading request headers.
+const readHeaderTimeout = 10 * time.Second
+
+// Server is the HTTP server that exposes the metrics on /metrics.  It is
+// independent of the proxy listeners so it can be bound to localhost only.
+type Server struct {
+	listenAddr string
+
+	listener net.Listener
+	srv      *http.Server
+}
+
+// type check
+var _ io.Closer = (*Server)(nil)
+
+// NewServer creates a new instance of *Server that will be listening on
+// listenAddr.
+func NewServer(listenAddr string) (s *Server) {
+	s = &Server{
+		listenAddr: listenAddr,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	s.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	return s
+}
+
+// Start starts the metrics server.
+func (s *Server) Start() (err error) {
+	log.Info("metrics: starting")
+
+	s.listener, err = net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return fmt.Errorf("metrics: failed to start: %w", err)
+	}
+
+	go func() {
+		sErr := s.srv.Serve(s.listener)
+		if !errors.Is(sErr, http.ErrServerClosed) {
+			log.Error("metrics: server stopped unexpectedly: %v", sErr)
+		}
+	}()
+
+	log.Info("metrics: listening on %s", s.listener.Addr())
+
+	return nil
+}
+
+// Close implements the [io.Closer] interface for *Server.
+func (s *Server) Close() (err error) {
+	log.Info("metrics: stopping")
+
+	err = s.srv.Close()
+
+	log.Info("metrics: stopped")
+
+	return err
+}
+
+// handleMetrics writes the metrics in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	err := Write(w)
+	if err != nil {
+		log.Debug("metrics: failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+// Package status is responsible for the HTTP server that exposes the current
+// state of the proxies.  It is intended for debugging and monitoring and
+// should only be exposed to the trusted networks.
+package status
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/dnsproxy"
+	"github.com/ameshkov/sniproxy/internal/filter"
+	"github.com/ameshkov/sniproxy/sniproxy"
+)
+
+const (
+	// readHeaderTimeout is the timeout for reading request headers.
+	readHeaderTimeout = 10 * time.Second
+
+	// maxBodySize is the maximum size of a request body.
+	maxBodySize = 1024 * 1024
+
+	// defaultTopHosts is the default number of hosts returned by the /hosts
+	// endpoint.
+	defaultTopHosts = 10
+)
+
+// Config is the status server configuration.
+type Config struct {
+	// ListenAddr is the address the status server will be listening to.
+	ListenAddr string
+
+	// SNIProxy is the SNI proxy which status is exposed.
+	SNIProxy *sniproxy.SNIProxy
+
+	// DNSProxy is the DNS proxy which status is exposed.
+	DNSProxy *dnsproxy.DNSProxy
+
+	// ReloadToken is the token the /reload requests must carry in the
+	// "Authorization: Bearer" header.  If not set, only the requests from the
+	// loopback addresses are allowed to reload the rules.
+	ReloadToken string
+}
+
+// Server is the status HTTP server.
+type Server struct {
+	listenAddr string
+	sniProxy   *sniproxy.SNIProxy
+	dnsProxy   *dnsproxy.DNSProxy
+
+	reloadToken string
+
+	listener net.Listener
+	srv      *http.Server
+}
+
+// type check
+var _ io.Closer = (*Server)(nil)
+
+// New creates a new instance of *Server.
+func New(cfg *Config) (s *Server) {
+	s = &Server{
+		listenAddr: cfg.ListenAddr,
+		sniProxy:   cfg.SNIProxy,
+		dnsProxy:   cfg.DNSProxy,
+
+		reloadToken: cfg.ReloadToken,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rules", s.handleRules)
+	mux.HandleFunc("/reload", s.handleReload)
+	mux.HandleFunc("/bandwidth", s.handleBandwidth)
+	mux.HandleFunc("/ready", s.handleReady)
+	mux.HandleFunc("/hosts", s.handleHosts)
+	mux.HandleFunc("/connections", s.handleConnections)
+	mux.HandleFunc("/rule-stats", s.handleRuleStats)
+
+	s.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	return s
+}
+
+// Start starts the status server.
+func (s *Server) Start() (err error) {
+	log.Info("status: starting")
+
+	s.listener, err = net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return fmt.Errorf("status: failed to start: %w", err)
+	}
+
+	go func() {
+		sErr := s.srv.Serve(s.listener)
+		if !errors.Is(sErr, http.ErrServerClosed) {
+			log.Error("status: server stopped unexpectedly: %v", sErr)
+		}
+	}()
+
+	log.Info("status: listening on %s", s.listener.Addr())
+
+	return nil
+}
+
+// Close implements the [io.Closer] interface for *Server.
+func (s *Server) Close() (err error) {
+	log.Info("status: stopping")
+
+	err = s.srv.Close()
+
+	log.Info("status: stopped")
+
+	return err
+}
+
+// rulesResponse is the response of the /rules endpoint.
+type rulesResponse struct {
+	SNIProxy *sniproxy.Rules `json:"sniproxy"`
+	DNSProxy *dnsproxy.Rules `json:"dnsproxy"`
+}
+
+// handleRules returns the rules that are currently used by the proxies.
+func (s *Server) handleRules(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, &rulesResponse{
+		SNIProxy: s.sniProxy.Rules(),
+		DNSProxy: s.dnsProxy.Rules(),
+	})
+}
+
+// handleReady responds with 200 OK if the SNI proxy is ready to serve
+// connections and with 503 Service Unavailable otherwise.
+func (s *Server) handleReady(w http.ResponseWriter, _ *http.Request) {
+	if !s.sniProxy.Ready() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	_, _ = io.WriteString(w, "ready\n")
+}
+
+// hostsResponse is the response of the /hosts endpoint.
+type hostsResponse struct {
+	// Hosts is the list of remote hosts with the largest number of active
+	// connections.
+	Hosts []sniproxy.HostConns `json:"hosts"`
+}
+
+// handleHosts returns the remote hosts with the largest number of active
+// connections.  The number of hosts is controlled by the "top" query
+// parameter.
+func (s *Server) handleHosts(w http.ResponseWriter, r *http.Request) {
+	top := defaultTopHosts
+	if v := r.URL.Query().Get("top"); v != "" {
+		var err error
+		top, err = strconv.Atoi(v)
+		if err != nil || top <= 0 {
+			http.Error(w, fmt.Sprintf("invalid top value: %s", v), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	writeJSON(w, &hostsResponse{
+		Hosts: s.sniProxy.TopHosts(top),
+	})
+}
+
+// connectionsResponse is the response of the /connections endpoint.
+type connectionsResponse struct {
+	// Connections is the list of the client connections that are being
+	// handled right now, the longest-running first.
+	Connections []sniproxy.ConnInfo `json:"connections"`
+
+	// Goroutines is the total number of goroutines of the process.
+	Goroutines int `json:"goroutines"`
+}
+
+// handleConnections returns the client connections that are being handled
+// right now.  It helps finding connection and goroutine leaks.
+func (s *Server) handleConnections(w http.ResponseWriter, _ *http.Request) {
+	conns := s.sniProxy.Connections()
+	if conns == nil {
+		conns = []sniproxy.ConnInfo{}
+	}
+
+	writeJSON(w, &connectionsResponse{
+		Connections: conns,
+		Goroutines:  runtime.NumGoroutine(),
+	})
+}
+
+// bandwidthResponse is the response of the /bandwidth endpoint.
+type bandwidthResponse struct {
+	// Rules is the number of bytes tunneled through the connections governed
+	// by each bandwidth rule.
+	Rules map[string]int64 `json:"rules"`
+}
+
+// handleBandwidth returns the per-rule bandwidth statistics.
+func (s *Server) handleBandwidth(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, &bandwidthResponse{
+		Rules: s.sniProxy.BandwidthStats(),
+	})
+}
+
+// ruleStatsResponse is the response of the /rule-stats endpoint.
+type ruleStatsResponse struct {
+	SNIProxy []filter.RuleStat `json:"sniproxy"`
+	DNSProxy []filter.RuleStat `json:"dnsproxy"`
+}
+
+// handleRuleStats returns how many times each rule of the proxies matched.
+func (s *Server) handleRuleStats(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, &ruleStatsResponse{
+		SNIProxy: s.sniProxy.RuleStats(),
+		DNSProxy: s.dnsProxy.RuleStats(),
+	})
+}
+
+// reloadResponse is the response of the /reload endpoint.
+type reloadResponse struct {
+	// Closed is the number of existing connections that were closed because
+	// they are blocked by the new rules.
+	Closed int `json:"closed"`
+}
+
+// handleReload replaces the SNI proxy rules with the ones from the request
+// body.  The new rules apply to the new connections only.  If the "enforce"
+// query parameter is true, the existing connections blocked by the new rules
+// are closed.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if !s.reloadAllowed(r) {
+		log.Info("status: rejected reload request from %s", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+
+		return
+	}
+
+	var enforce bool
+	if v := r.URL.Query().Get("enforce"); v != "" {
+		var err error
+		enforce, err = strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid enforce value: %v", err), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	rules := &sniproxy.RuleSet{}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(rules)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid rules: %v", err), http.StatusBadRequest)
+
+		return
+	}
+
+	closed, err := s.sniProxy.Reload(rules, enforce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	writeJSON(w, &reloadResponse{
+		Closed: closed,
+	})
+}
+
+// reloadAllowed checks if r is allowed to reload the rules.  It must carry the
+// reload token if it is configured, otherwise it must come from a loopback
+// address.
+func (s *Server) reloadAllowed(r *http.Request) (ok bool) {
+	if s.reloadToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.reloadToken)) == 1
+	}
+
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+
+	return err == nil && addr.Addr().Unmap().IsLoopback()
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	err := enc.Encode(v)
+	if err != nil {
+		log.Debug("status: failed to write response: %v", err)
+	}
+}
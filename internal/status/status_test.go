@@ -0,0 +1,214 @@
+package status
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/ameshkov/sniproxy/dnsproxy"
+	"github.com/ameshkov/sniproxy/internal/filter"
+	"github.com/ameshkov/sniproxy/sniproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer returns a status server of proxies with the initial block rule
+// "blocked.example" and reloadToken.  The server isn't started, the requests
+// are served by its handler directly.
+func newTestServer(t *testing.T, reloadToken string) (s *Server) {
+	t.Helper()
+
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	p, err := sniproxy.New(&sniproxy.Config{
+		TLSListenAddr:  addr,
+		HTTPListenAddr: addr,
+		BlockRules:     []string{"blocked.example"},
+	})
+	require.NoError(t, err)
+
+	d, err := dnsproxy.New(&dnsproxy.Config{
+		ListenAddrs:    []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:0")},
+		Upstreams:      []string{"127.0.0.1:53"},
+		RedirectIPv4To: net.IPv4(127, 0, 0, 1),
+		RedirectRules:  []string{"*.example.org"},
+	})
+	require.NoError(t, err)
+
+	return New(&Config{
+		SNIProxy:    p,
+		DNSProxy:    d,
+		ReloadToken: reloadToken,
+	})
+}
+
+// serve sends a request to s and returns the response recorder.
+func serve(s *Server, method, target, remoteAddr, token, body string) (rw *httptest.ResponseRecorder) {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.RemoteAddr = remoteAddr
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rw = httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(rw, r)
+
+	return rw
+}
+
+// getRules returns the rules returned by the /rules endpoint of s.
+func getRules(t *testing.T, s *Server) (resp *rulesResponse) {
+	t.Helper()
+
+	rw := serve(s, http.MethodGet, "/rules", "127.0.0.1:1234", "", "")
+	require.Equal(t, http.StatusOK, rw.Code)
+
+	resp = &rulesResponse{}
+	require.NoError(t, json.Unmarshal(rw.Body.Bytes(), resp))
+
+	return resp
+}
+
+func TestServer_handleRules(t *testing.T) {
+	s := newTestServer(t, "")
+
+	rules := getRules(t, s)
+	assert.Equal(t, []string{"blocked.example"}, rules.SNIProxy.BlockRules)
+	assert.Equal(t, []string{"*.example.org"}, rules.DNSProxy.RedirectRules)
+
+	const body = `{
+		"block_rules": ["new.example"],
+		"forward_schedule": [{"wildcard": "*.example.net", "start": "09:00", "end": "18:30"}]
+	}`
+	rw := serve(s, http.MethodPost, "/reload", "127.0.0.1:1234", "", body)
+	require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+
+	rules = getRules(t, s)
+	assert.Equal(t, []string{"new.example"}, rules.SNIProxy.BlockRules)
+	require.Len(t, rules.SNIProxy.ForwardSchedule, 1)
+
+	rw = serve(s, http.MethodGet, "/rules", "127.0.0.1:1234", "", "")
+	assert.Contains(t, rw.Body.String(), `"start": "09:00"`)
+	assert.Contains(t, rw.Body.String(), `"end": "18:30"`)
+}
+
+func TestServer_handleReload(t *testing.T) {
+	const token = "secret"
+
+	testCases := []struct {
+		name        string
+		reloadToken string
+		remoteAddr  string
+		token       string
+		body        string
+		wantCode    int
+	}{{
+		name:        "localhost",
+		reloadToken: "",
+		remoteAddr:  "127.0.0.1:1234",
+		token:       "",
+		body:        `{"block_rules": ["new.example"]}`,
+		wantCode:    http.StatusOK,
+	}, {
+		name:        "remote",
+		reloadToken: "",
+		remoteAddr:  "192.0.2.1:1234",
+		token:       "",
+		body:        `{"block_rules": ["new.example"]}`,
+		wantCode:    http.StatusForbidden,
+	}, {
+		name:        "remote_token",
+		reloadToken: token,
+		remoteAddr:  "192.0.2.1:1234",
+		token:       token,
+		body:        `{"block_rules": ["new.example"]}`,
+		wantCode:    http.StatusOK,
+	}, {
+		name:        "localhost_no_token",
+		reloadToken: token,
+		remoteAddr:  "127.0.0.1:1234",
+		token:       "",
+		body:        `{"block_rules": ["new.example"]}`,
+		wantCode:    http.StatusForbidden,
+	}, {
+		name:        "bad_token",
+		reloadToken: token,
+		remoteAddr:  "192.0.2.1:1234",
+		token:       "wrong",
+		body:        `{"block_rules": ["new.example"]}`,
+		wantCode:    http.StatusForbidden,
+	}, {
+		name:        "unknown_field",
+		reloadToken: "",
+		remoteAddr:  "127.0.0.1:1234",
+		token:       "",
+		body:        `{"block_rule": ["new.example"]}`,
+		wantCode:    http.StatusBadRequest,
+	}, {
+		name:        "empty_rule",
+		reloadToken: "",
+		remoteAddr:  "127.0.0.1:1234",
+		token:       "",
+		body:        `{"block_rules": [""]}`,
+		wantCode:    http.StatusBadRequest,
+	}, {
+		name:        "bad_schedule",
+		reloadToken: "",
+		remoteAddr:  "127.0.0.1:1234",
+		token:       "",
+		body:        `{"forward_schedule": [{"wildcard": "*", "start": "25:00", "end": "18:00"}]}`,
+		wantCode:    http.StatusBadRequest,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, tc.reloadToken)
+
+			rw := serve(s, http.MethodPost, "/reload", tc.remoteAddr, tc.token, tc.body)
+			assert.Equal(t, tc.wantCode, rw.Code)
+
+			want := []string{"blocked.example"}
+			if tc.wantCode == http.StatusOK {
+				want = []string{"new.example"}
+			}
+
+			assert.Equal(t, want, getRules(t, s).SNIProxy.BlockRules)
+		})
+	}
+}
+
+func TestServer_handleReady(t *testing.T) {
+	s := newTestServer(t, "")
+
+	rw := serve(s, http.MethodGet, "/ready", "127.0.0.1:1234", "", "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	s.sniProxy.SetReady(false)
+
+	rw = serve(s, http.MethodGet, "/ready", "127.0.0.1:1234", "", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+}
+
+func TestServer_handleRuleStats(t *testing.T) {
+	s := newTestServer(t, "")
+
+	rw := serve(s, http.MethodGet, "/rule-stats", "127.0.0.1:1234", "", "")
+	require.Equal(t, http.StatusOK, rw.Code)
+
+	resp := &ruleStatsResponse{}
+	require.NoError(t, json.Unmarshal(rw.Body.Bytes(), resp))
+
+	assert.Equal(t, []filter.RuleStat{{
+		Kind:    "block",
+		Rule:    "blocked.example",
+		Matches: 0,
+	}}, resp.SNIProxy)
+	assert.Equal(t, []filter.RuleStat{{
+		Kind:    "redirect",
+		Rule:    "*.example.org",
+		Matches: 0,
+	}}, resp.DNSProxy)
+}
@@ -0,0 +1,214 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// tracesPath is the default OTLP/HTTP path for traces.
+	tracesPath = "/v1/traces"
+
+	// exportTimeout is the timeout of a single export request.
+	exportTimeout = 10 * time.Second
+
+	// spanKindServer is SPAN_KIND_SERVER in OTLP.
+	spanKindServer = 2
+
+	// statusCodeError is STATUS_CODE_ERROR in OTLP.
+	statusCodeError = 2
+)
+
+// OTLPExporter exports spans to an OTLP/HTTP collector using the JSON
+// encoding.
+type OTLPExporter struct {
+	client      *http.Client
+	endpoint    string
+	serviceName string
+}
+
+// type check
+var _ Exporter = (*OTLPExporter)(nil)
+
+// NewOTLPExporter creates a new *OTLPExporter.  endpoint is the URL of the
+// collector, e.g. http://127.0.0.1:4318.  If it has no path, the default
+// /v1/traces path is used.  serviceName is reported as the service.name
+// resource attribute.
+func NewOTLPExporter(endpoint, serviceName string) (e *OTLPExporter, err error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: invalid endpoint: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("tracing: unsupported endpoint scheme %q", u.Scheme)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = tracesPath
+	}
+
+	return &OTLPExporter{
+		client: &http.Client{
+			Timeout: exportTimeout,
+		},
+		endpoint:    u.String(),
+		serviceName: serviceName,
+	}, nil
+}
+
+// ExportSpans implements the [Exporter] interface for *OTLPExporter.
+func (e *OTLPExporter) ExportSpans(spans []*Span) (err error) {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return fmt.Errorf("tracing: encoding spans: %w", err)
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("tracing: sending spans: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tracing: collector responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// request converts spans to the OTLP ExportTraceServiceRequest message.
+func (e *OTLPExporter) request(spans []*Span) (req *otlpRequest) {
+	otlpSpans := make([]*otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		otlpSpans = append(otlpSpans, toOTLPSpan(s))
+	}
+
+	return &otlpRequest{
+		ResourceSpans: []*otlpResourceSpans{{
+			Resource: &otlpResource{
+				Attributes: []*otlpKeyValue{toOTLPKeyValue("service.name", e.serviceName)},
+			},
+			ScopeSpans: []*otlpScopeSpans{{
+				Scope: &otlpScope{Name: e.serviceName},
+				Spans: otlpSpans,
+			}},
+		}},
+	}
+}
+
+// toOTLPSpan converts s to the OTLP span message.
+func toOTLPSpan(s *Span) (os *otlpSpan) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	os = &otlpSpan{
+		TraceID:           hex.EncodeToString(s.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.SpanID[:]),
+		Name:              s.Name,
+		Kind:              spanKindServer,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+	}
+
+	for _, a := range s.Attributes {
+		os.Attributes = append(os.Attributes, toOTLPKeyValue(a.Key, a.Value))
+	}
+
+	if s.Failed {
+		os.Status = &otlpStatus{
+			Code:    statusCodeError,
+			Message: s.ErrorMessage,
+		}
+	}
+
+	return os
+}
+
+// toOTLPKeyValue converts an attribute to the OTLP KeyValue message.
+func toOTLPKeyValue(key string, value any) (kv *otlpKeyValue) {
+	kv = &otlpKeyValue{Key: key}
+
+	switch v := value.(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case int64:
+		// int64 values are encoded as strings in OTLP JSON.
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case bool:
+		kv.Value.BoolValue = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+
+	return kv
+}
+
+// otlpRequest is the JSON representation of ExportTraceServiceRequest.
+type otlpRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+// otlpResourceSpans is the JSON representation of ResourceSpans.
+type otlpResourceSpans struct {
+	Resource   *otlpResource     `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+// otlpResource is the JSON representation of Resource.
+type otlpResource struct {
+	Attributes []*otlpKeyValue `json:"attributes"`
+}
+
+// otlpScopeSpans is the JSON representation of ScopeSpans.
+type otlpScopeSpans struct {
+	Scope *otlpScope  `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+// otlpScope is the JSON representation of InstrumentationScope.
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+// otlpSpan is the JSON representation of Span.
+type otlpSpan struct {
+	Status            *otlpStatus     `json:"status,omitempty"`
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	Name              string          `json:"name"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []*otlpKeyValue `json:"attributes,omitempty"`
+	Kind              int             `json:"kind"`
+}
+
+// otlpStatus is the JSON representation of Status.
+type otlpStatus struct {
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code"`
+}
+
+// otlpKeyValue is the JSON representation of KeyValue.
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue is the JSON representation of AnyValue.
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
@@ -0,0 +1,248 @@
+// Package tracing implements a minimal OpenTelemetry tracer that exports spans
+// to an OTLP/HTTP endpoint using the JSON encoding.
+package tracing
+
+import (
+	"crypto/rand"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+const (
+	// queueSize is the maximum number of finished spans waiting for the
+	// export.  Spans are dropped when the queue is full.
+	queueSize = 4096
+
+	// batchSize is the maximum number of spans exported at once.
+	batchSize = 256
+
+	// flushInterval is how often the finished spans are exported.
+	flushInterval = 5 * time.Second
+)
+
+// Exporter exports finished spans.
+type Exporter interface {
+	// ExportSpans exports a batch of spans.
+	ExportSpans(spans []*Span) (err error)
+}
+
+// Tracer creates spans and passes them to the exporter in batches.  A nil
+// *Tracer is valid and creates nil spans, so the callers don't need to check
+// whether tracing is enabled.
+type Tracer struct {
+	exporter Exporter
+	queue    chan *Span
+	done     chan struct{}
+	stopped  chan struct{}
+
+	// closeOnce makes sure that done is only closed once.
+	closeOnce sync.Once
+}
+
+// type check
+var _ io.Closer = (*Tracer)(nil)
+
+// New creates a new *Tracer and starts exporting spans with exporter.
+func New(exporter Exporter) (t *Tracer) {
+	t = &Tracer{
+		exporter: exporter,
+		queue:    make(chan *Span, queueSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	go t.exportLoop()
+
+	return t
+}
+
+// Start starts a new root span with the specified name.
+func (t *Tracer) Start(name string) (s *Span) {
+	if t == nil {
+		return nil
+	}
+
+	s = &Span{
+		tracer: t,
+		Name:   name,
+		Start:  time.Now(),
+	}
+
+	// crypto/rand.Read never fails on the supported platforms.
+	_, _ = rand.Read(s.TraceID[:])
+	_, _ = rand.Read(s.SpanID[:])
+
+	return s
+}
+
+// Close implements the [io.Closer] interface for *Tracer.  It stops the tracer
+// and waits until the remaining spans are exported.  Spans ended after Close
+// are discarded.
+func (t *Tracer) Close() (err error) {
+	t.closeOnce.Do(func() {
+		close(t.done)
+	})
+
+	<-t.stopped
+
+	return nil
+}
+
+// enqueue adds a finished span to the export queue.
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case <-t.done:
+		// Don't send to the queue after the tracer has been closed.
+	case t.queue <- s:
+	default:
+		log.Debug("tracing: queue is full, dropping span %s", s.Name)
+	}
+}
+
+// exportLoop exports the finished spans in batches until the tracer is
+// closed.
+func (t *Tracer) exportLoop() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-t.done:
+			t.drain(batch)
+
+			return
+		}
+
+		t.export(batch)
+		batch = batch[:0]
+	}
+}
+
+// drain exports batch and all the spans remaining in the queue.
+func (t *Tracer) drain(batch []*Span) {
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+		default:
+			t.export(batch)
+
+			return
+		}
+	}
+}
+
+// export exports batch if it's not empty.
+func (t *Tracer) export(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+
+	err := t.exporter.ExportSpans(batch)
+	if err != nil {
+		log.Debug("tracing: failed to export %d spans: %v", len(batch), err)
+	}
+}
+
+// Attribute is a key-value pair attached to a span.  Value is either string,
+// int64, or bool.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Span represents a single operation, e.g. a tunneled connection.  A nil *Span
+// is valid and ignores all calls.
+type Span struct {
+	tracer *Tracer
+
+	// Name is the name of the span.
+	Name string
+
+	// Start is the time the span was started.
+	Start time.Time
+
+	// End is the time the span was ended.
+	End time.Time
+
+	// Attributes are the span's attributes in the order they were set.
+	Attributes []Attribute
+
+	// ErrorMessage is the error description.  It is only set when Failed is
+	// true.
+	ErrorMessage string
+
+	// TraceID is the ID of the trace.
+	TraceID [16]byte
+
+	// SpanID is the ID of the span.
+	SpanID [8]byte
+
+	// Failed is true if the operation failed.
+	Failed bool
+
+	// mu protects the fields above as attributes may be set from several
+	// goroutines.
+	mu sync.Mutex
+
+	// ended is true when [Span.Finish] has been called.
+	ended bool
+}
+
+// SetAttribute sets an attribute of the span.  value must be a string, an
+// int64, or a bool.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Attributes = append(s.Attributes, Attribute{Key: key, Value: value})
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Failed = true
+	s.ErrorMessage = err.Error()
+}
+
+// Finish ends the span and queues it for the export.  Subsequent calls are
+// ignored.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	s.tracer.enqueue(s)
+}
@@ -0,0 +1,80 @@
+package tracing_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/ameshkov/sniproxy/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memExporter is a [tracing.Exporter] that keeps the exported spans in
+// memory.
+type memExporter struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+// ExportSpans implements the [tracing.Exporter] interface for *memExporter.
+func (e *memExporter) ExportSpans(spans []*tracing.Span) (err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = append(e.spans, spans...)
+
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	exp := &memExporter{}
+	tracer := tracing.New(exp)
+
+	ok := tracer.Start("ok")
+	ok.SetAttribute("str", "value")
+	ok.SetAttribute("num", int64(1))
+	ok.Finish()
+	// The second call is ignored.
+	ok.Finish()
+
+	failed := tracer.Start("failed")
+	failed.SetError(errors.New("test error"))
+	failed.Finish()
+
+	unfinished := tracer.Start("unfinished")
+
+	require.NoError(t, tracer.Close())
+	unfinished.Finish()
+
+	require.Len(t, exp.spans, 2)
+
+	s := exp.spans[0]
+	assert.Equal(t, "ok", s.Name)
+	assert.Equal(t, []tracing.Attribute{
+		{Key: "str", Value: "value"},
+		{Key: "num", Value: int64(1)},
+	}, s.Attributes)
+	assert.False(t, s.Failed)
+	assert.False(t, s.End.Before(s.Start))
+	assert.NotEqual(t, [16]byte{}, s.TraceID)
+	assert.NotEqual(t, [8]byte{}, s.SpanID)
+
+	s = exp.spans[1]
+	assert.Equal(t, "failed", s.Name)
+	assert.True(t, s.Failed)
+	assert.Equal(t, "test error", s.ErrorMessage)
+}
+
+func TestTracer_nil(t *testing.T) {
+	var tracer *tracing.Tracer
+
+	s := tracer.Start("span")
+	assert.Nil(t, s)
+
+	assert.NotPanics(t, func() {
+		s.SetAttribute("key", "value")
+		s.SetError(errors.New("test error"))
+		s.Finish()
+	})
+}
@@ -0,0 +1,105 @@
+// Package proxyproto implements encoding of the PROXY protocol headers, see
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+package proxyproto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+// Version is the version of the PROXY protocol.
+type Version int
+
+const (
+	// V1 is the human-readable text form of the header.
+	V1 Version = 1
+
+	// V2 is the binary form of the header.
+	V2 Version = 2
+)
+
+// v2Signature is the signature that starts every v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	// v2VersionCommand is the protocol version 2 and the PROXY command.
+	v2VersionCommand = 0x21
+
+	// v2FamilyTCP4 is TCP over IPv4.
+	v2FamilyTCP4 = 0x11
+
+	// v2FamilyTCP6 is TCP over IPv6.
+	v2FamilyTCP6 = 0x21
+)
+
+// Header returns the PROXY protocol header of the specified version for a TCP
+// connection from src to dst.  If one of the addresses is IPv4 and the other
+// one is IPv6, the IPv4 address is converted to an IPv4-mapped IPv6 address
+// since the protocol requires both addresses to be of the same family.
+func Header(v Version, src, dst netip.AddrPort) (b []byte, err error) {
+	if !src.IsValid() || !dst.IsValid() {
+		return nil, fmt.Errorf("proxyproto: invalid addresses %s and %s", src, dst)
+	}
+
+	src, dst = sameFamily(src), sameFamily(dst)
+	if src.Addr().Is4() != dst.Addr().Is4() {
+		src = netip.AddrPortFrom(netip.AddrFrom16(src.Addr().As16()), src.Port())
+		dst = netip.AddrPortFrom(netip.AddrFrom16(dst.Addr().As16()), dst.Port())
+	}
+
+	switch v {
+	case V1:
+		return headerV1(src, dst), nil
+	case V2:
+		return headerV2(src, dst), nil
+	default:
+		return nil, fmt.Errorf("proxyproto: unsupported version %d", v)
+	}
+}
+
+// sameFamily unmaps an IPv4-mapped IPv6 address and drops the zone that
+// cannot be encoded.
+func sameFamily(addrPort netip.AddrPort) (res netip.AddrPort) {
+	return netip.AddrPortFrom(addrPort.Addr().Unmap().WithZone(""), addrPort.Port())
+}
+
+// headerV1 returns the v1 header, e.g.:
+//
+//	PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func headerV1(src, dst netip.AddrPort) (b []byte) {
+	proto := "TCP6"
+	if src.Addr().Is4() {
+		proto = "TCP4"
+	}
+
+	return []byte(fmt.Sprintf(
+		"PROXY %s %s %s %d %d\r\n",
+		proto,
+		src.Addr(),
+		dst.Addr(),
+		src.Port(),
+		dst.Port(),
+	))
+}
+
+// headerV2 returns the v2 header.
+func headerV2(src, dst netip.AddrPort) (b []byte) {
+	family := byte(v2FamilyTCP6)
+	addrLen := 2*16 + 2*2
+	if src.Addr().Is4() {
+		family = v2FamilyTCP4
+		addrLen = 2*4 + 2*2
+	}
+
+	b = make([]byte, 0, len(v2Signature)+4+addrLen)
+	b = append(b, v2Signature...)
+	b = append(b, v2VersionCommand, family)
+	b = binary.BigEndian.AppendUint16(b, uint16(addrLen))
+	b = append(b, src.Addr().AsSlice()...)
+	b = append(b, dst.Addr().AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, src.Port())
+	b = binary.BigEndian.AppendUint16(b, dst.Port())
+
+	return b
+}
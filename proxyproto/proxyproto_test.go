@@ -0,0 +1,123 @@
+package proxyproto_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/ameshkov/sniproxy/proxyproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// v2Prefix is the signature of the v2 header followed by the version and the
+// PROXY command.
+const v2Prefix = "\r\n\r\n\x00\r\nQUIT\n\x21"
+
+func TestHeader(t *testing.T) {
+	src4 := netip.MustParseAddrPort("192.168.0.1:56324")
+	dst4 := netip.MustParseAddrPort("192.168.0.11:443")
+	src6 := netip.MustParseAddrPort("[2001:db8::1]:56324")
+	dst6 := netip.MustParseAddrPort("[2001:db8::2]:443")
+
+	testCases := []struct {
+		name    string
+		src     netip.AddrPort
+		dst     netip.AddrPort
+		want    string
+		version proxyproto.Version
+	}{{
+		name:    "v1_tcp4",
+		src:     src4,
+		dst:     dst4,
+		want:    "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n",
+		version: proxyproto.V1,
+	}, {
+		name:    "v1_tcp6",
+		src:     src6,
+		dst:     dst6,
+		want:    "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n",
+		version: proxyproto.V1,
+	}, {
+		name:    "v1_mixed",
+		src:     src4,
+		dst:     dst6,
+		want:    "PROXY TCP6 ::ffff:192.168.0.1 2001:db8::2 56324 443\r\n",
+		version: proxyproto.V1,
+	}, {
+		name:    "v1_mapped",
+		src:     netip.MustParseAddrPort("[::ffff:192.168.0.1]:56324"),
+		dst:     dst4,
+		want:    "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n",
+		version: proxyproto.V1,
+	}, {
+		name:    "v1_zone",
+		src:     netip.MustParseAddrPort("[fe80::1%eth0]:56324"),
+		dst:     dst6,
+		want:    "PROXY TCP6 fe80::1 2001:db8::2 56324 443\r\n",
+		version: proxyproto.V1,
+	}, {
+		name: "v2_tcp4",
+		src:  src4,
+		dst:  dst4,
+		want: v2Prefix + "\x11\x00\x0c" +
+			"\xc0\xa8\x00\x01" +
+			"\xc0\xa8\x00\x0b" +
+			"\xdc\x04" +
+			"\x01\xbb",
+		version: proxyproto.V2,
+	}, {
+		name: "v2_tcp6",
+		src:  src6,
+		dst:  dst6,
+		want: v2Prefix + "\x21\x00\x24" +
+			"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+			"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02" +
+			"\xdc\x04" +
+			"\x01\xbb",
+		version: proxyproto.V2,
+	}, {
+		name: "v2_mixed",
+		src:  src6,
+		dst:  dst4,
+		want: v2Prefix + "\x21\x00\x24" +
+			"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+			"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff\xc0\xa8\x00\x0b" +
+			"\xdc\x04" +
+			"\x01\xbb",
+		version: proxyproto.V2,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := proxyproto.Header(tc.version, tc.src, tc.dst)
+			require.NoError(t, err)
+
+			assert.Equal(t, []byte(tc.want), b)
+		})
+	}
+}
+
+func TestHeader_errors(t *testing.T) {
+	valid := netip.MustParseAddrPort("192.168.0.1:443")
+
+	testCases := []struct {
+		name    string
+		src     netip.AddrPort
+		version proxyproto.Version
+	}{{
+		name:    "invalid_addr",
+		src:     netip.AddrPort{},
+		version: proxyproto.V1,
+	}, {
+		name:    "bad_version",
+		src:     valid,
+		version: 3,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := proxyproto.Header(tc.version, tc.src, valid)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package shapeio
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Algorithm is the traffic shaping algorithm.
+type Algorithm string
+
+const (
+	// AlgorithmTokenBucket lets the data through as fast as it comes as long
+	// as the average rate is not exceeded, which makes the throughput bursty
+	// at low rates.
+	AlgorithmTokenBucket Algorithm = "token"
+
+	// AlgorithmLeakyBucket lets the data through in small chunks at regular
+	// intervals, which makes the throughput smooth.
+	AlgorithmLeakyBucket Algorithm = "leaky"
+)
+
+// burstLimit is the burst of the token bucket.  The initial burst is spent
+// right away so that it only allows large reads and writes.
+const burstLimit = 1000 * 1000 * 1000
+
+// Limiter limits the rate of reads and writes.  *rate.Limiter implements it.
+type Limiter interface {
+	// WaitN blocks until n bytes are allowed to pass.
+	WaitN(ctx context.Context, n int) (err error)
+
+	// Burst returns the maximum number of bytes that may be passed to WaitN
+	// at once.
+	Burst() (n int)
+}
+
+// type check
+var _ Limiter = (*rate.Limiter)(nil)
+
+// NewLimiter returns a new limiter of the specified algorithm that limits the
+// rate to bytesPerSec.  If alg is empty, AlgorithmTokenBucket is used.  It
+// returns nil if bytesPerSec is not positive.
+func NewLimiter(alg Algorithm, bytesPerSec float64) (l Limiter) {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+
+	if alg == AlgorithmLeakyBucket {
+		return NewLeakyBucket(bytesPerSec)
+	}
+
+	limiter := rate.NewLimiter(rate.Limit(bytesPerSec), burstLimit)
+	// Spend initial burst.
+	limiter.AllowN(time.Now(), burstLimit)
+
+	return limiter
+}
+
+// ValidateAlgorithm returns an error if alg is not a known algorithm.  Empty
+// alg is valid and means AlgorithmTokenBucket.
+func ValidateAlgorithm(alg Algorithm) (err error) {
+	switch alg {
+	case "", AlgorithmTokenBucket, AlgorithmLeakyBucket:
+		return nil
+	default:
+		return fmt.Errorf("shapeio: unknown algorithm %q", alg)
+	}
+}
+
+// leakInterval is the interval at which the leaky bucket lets the data
+// through.  The burst of the leaky bucket is the amount of data leaked in this
+// interval.
+const leakInterval = 10 * time.Millisecond
+
+// LeakyBucket is a [Limiter] that implements the leaky bucket algorithm as a
+// meter: the data leaks out at a constant rate and the callers wait until the
+// data they passed has leaked.  Unlike the token bucket, it does not
+// accumulate unused bandwidth and only lets small chunks through, so the
+// throughput stays smooth even at low rates.
+type LeakyBucket struct {
+	// mu protects emptyAt.
+	mu sync.Mutex
+
+	// emptyAt is the time when all the data passed so far will have leaked.
+	emptyAt time.Time
+
+	// bytesPerSec is the leak rate.
+	bytesPerSec float64
+
+	// burst is the amount of data that leaks in leakInterval.
+	burst int
+}
+
+// type check
+var _ Limiter = (*LeakyBucket)(nil)
+
+// NewLeakyBucket returns a new *LeakyBucket that leaks bytesPerSec bytes per
+// second.  bytesPerSec must be positive.
+func NewLeakyBucket(bytesPerSec float64) (b *LeakyBucket) {
+	burst := int(bytesPerSec * leakInterval.Seconds())
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &LeakyBucket{
+		bytesPerSec: bytesPerSec,
+		burst:       burst,
+	}
+}
+
+// WaitN implements the [Limiter] interface for *LeakyBucket.
+func (b *LeakyBucket) WaitN(ctx context.Context, n int) (err error) {
+	b.mu.Lock()
+	now := time.Now()
+	if b.emptyAt.Before(now) {
+		b.emptyAt = now
+	}
+
+	b.emptyAt = b.emptyAt.Add(time.Duration(float64(n) / b.bytesPerSec * float64(time.Second)))
+	wait := b.emptyAt.Sub(now)
+	b.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Burst implements the [Limiter] interface for *LeakyBucket.
+func (b *LeakyBucket) Burst() (n int) {
+	return b.burst
+}
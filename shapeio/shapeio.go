@@ -0,0 +1,108 @@
+// Package shapeio provides connections throttling functionality.  It is based
+// on https://github.com/fujiwara/shapeio.
+package shapeio
+
+import (
+	"context"
+	"io"
+)
+
+// Reader implements the io.Reader interface and allows limiting reading speed.
+type Reader struct {
+	r       io.Reader
+	limiter Limiter
+}
+
+// Writer implements the io.Reader interface and allows limiting writing speed.
+type Writer struct {
+	w       io.Writer
+	limiter Limiter
+}
+
+// NewReader returns a reader that implements io.Reader with rate limiting.
+// limiter may be nil, in this case the speed is not limited.
+func NewReader(r io.Reader, limiter Limiter) *Reader {
+	return &Reader{
+		r:       r,
+		limiter: limiter,
+	}
+}
+
+// NewWriter returns a writer that implements io.Writer with rate limiting.
+// limiter may be nil, in this case the speed is not limited.
+func NewWriter(w io.Writer, limiter Limiter) *Writer {
+	return &Writer{
+		w:       w,
+		limiter: limiter,
+	}
+}
+
+// SetRateLimit sets rate limit (bytes/sec) to the reader using the specified
+// algorithm.  It overrides the original limiter that was passed in NewReader.
+// Zero removes the limit.
+func (s *Reader) SetRateLimit(alg Algorithm, bytesPerSec float64) {
+	s.limiter = NewLimiter(alg, bytesPerSec)
+}
+
+// SetRateLimit sets rate limit (bytes/sec) to the writer using the specified
+// algorithm.  It overrides the original limiter that was passed in NewWriter.
+// Zero removes the limit.
+func (s *Writer) SetRateLimit(alg Algorithm, bytesPerSec float64) {
+	s.limiter = NewLimiter(alg, bytesPerSec)
+}
+
+// Read implements the io.Reader interface for *Reader.  It never reads more
+// than the limiter's burst at once.
+func (s *Reader) Read(p []byte) (n int, err error) {
+	if s.limiter == nil {
+		return s.r.Read(p)
+	}
+
+	if burst := s.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err = s.r.Read(p)
+	if err != nil {
+		return n, err
+	}
+
+	ctx := context.Background()
+	if err = s.limiter.WaitN(ctx, n); err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+// Write implements the io.Writer interface for *Writer.  It writes p in
+// chunks no larger than the limiter's burst.
+func (s *Writer) Write(p []byte) (n int, err error) {
+	if s.limiter == nil {
+		return s.w.Write(p)
+	}
+
+	ctx := context.Background()
+	burst := s.limiter.Burst()
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > burst {
+			chunk = chunk[:burst]
+		}
+
+		var written int
+		written, err = s.w.Write(chunk)
+		n += written
+		if err != nil {
+			return n, err
+		}
+
+		if err = s.limiter.WaitN(ctx, written); err != nil {
+			return n, err
+		}
+
+		p = p[written:]
+	}
+
+	return n, nil
+}
@@ -0,0 +1,176 @@
+package shapeio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRate is the rate of the pacing tests in bytes per second.
+const testRate = 100 * 1024
+
+// testPayload is the size of the data passed in the pacing tests, it takes
+// half a second at testRate.
+const testPayload = testRate / 2
+
+// pacing describes how the data passed through a shaper.
+type pacing struct {
+	// maxChunk is the largest amount of data passed at once.
+	maxChunk int
+
+	// chunks is the number of the reads or writes.
+	chunks int
+
+	// elapsed is the time it took to pass all the data.
+	elapsed time.Duration
+}
+
+// chunkRecorder is an io.Writer that records the sizes of the writes.
+type chunkRecorder struct {
+	sizes []int
+}
+
+// Write implements the io.Writer interface for *chunkRecorder.
+func (r *chunkRecorder) Write(p []byte) (n int, err error) {
+	r.sizes = append(r.sizes, len(p))
+
+	return len(p), nil
+}
+
+// measure returns the pacing of the sizes recorded in elapsed.
+func measure(sizes []int, elapsed time.Duration) (p pacing) {
+	p.chunks = len(sizes)
+	p.elapsed = elapsed
+	for _, s := range sizes {
+		if s > p.maxChunk {
+			p.maxChunk = s
+		}
+	}
+
+	return p
+}
+
+// leakyBurst is the burst of the leaky bucket at testRate.
+var leakyBurst = NewLeakyBucket(testRate).Burst()
+
+func TestReader_pacing(t *testing.T) {
+	testCases := []struct {
+		name         string
+		alg          Algorithm
+		wantMaxChunk int
+		wantChunks   int
+	}{{
+		name:         "token",
+		alg:          AlgorithmTokenBucket,
+		wantMaxChunk: testPayload,
+		wantChunks:   1,
+	}, {
+		name:         "leaky",
+		alg:          AlgorithmLeakyBucket,
+		wantMaxChunk: leakyBurst,
+		wantChunks:   testPayload / leakyBurst,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewReader(bytes.NewReader(make([]byte, testPayload)), NewLimiter(tc.alg, testRate))
+			rec := &chunkRecorder{}
+			buf := make([]byte, testPayload)
+
+			start := time.Now()
+			for {
+				n, err := r.Read(buf)
+				if n > 0 {
+					_, _ = rec.Write(buf[:n])
+				}
+
+				if err == io.EOF {
+					break
+				}
+
+				require.NoError(t, err)
+			}
+
+			p := measure(rec.sizes, time.Since(start))
+			assert.Equal(t, tc.wantMaxChunk, p.maxChunk)
+			assert.Equal(t, tc.wantChunks, p.chunks)
+
+			// Both of them keep the same average rate.
+			assert.GreaterOrEqual(t, p.elapsed, 400*time.Millisecond)
+			assert.Less(t, p.elapsed, 2*time.Second)
+		})
+	}
+}
+
+func TestWriter_pacing(t *testing.T) {
+	testCases := []struct {
+		name         string
+		alg          Algorithm
+		wantMaxChunk int
+		wantChunks   int
+	}{{
+		name:         "token",
+		alg:          AlgorithmTokenBucket,
+		wantMaxChunk: testPayload,
+		wantChunks:   1,
+	}, {
+		name:         "leaky",
+		alg:          AlgorithmLeakyBucket,
+		wantMaxChunk: leakyBurst,
+		wantChunks:   testPayload / leakyBurst,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &chunkRecorder{}
+			w := NewWriter(rec, NewLimiter(tc.alg, testRate))
+
+			start := time.Now()
+			n, err := w.Write(make([]byte, testPayload))
+			require.NoError(t, err)
+			require.Equal(t, testPayload, n)
+
+			p := measure(rec.sizes, time.Since(start))
+			assert.Equal(t, tc.wantMaxChunk, p.maxChunk)
+			assert.Equal(t, tc.wantChunks, p.chunks)
+
+			assert.GreaterOrEqual(t, p.elapsed, 400*time.Millisecond)
+			assert.Less(t, p.elapsed, 2*time.Second)
+		})
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	testCases := []struct {
+		name        string
+		alg         Algorithm
+		bytesPerSec float64
+		wantNil     bool
+	}{{
+		name:        "zero",
+		alg:         AlgorithmTokenBucket,
+		bytesPerSec: 0,
+		wantNil:     true,
+	}, {
+		name:        "default",
+		alg:         "",
+		bytesPerSec: testRate,
+		wantNil:     false,
+	}, {
+		name:        "leaky",
+		alg:         AlgorithmLeakyBucket,
+		bytesPerSec: testRate,
+		wantNil:     false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := NewLimiter(tc.alg, tc.bytesPerSec)
+			assert.Equal(t, tc.wantNil, l == nil)
+		})
+	}
+}
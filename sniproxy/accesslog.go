@@ -0,0 +1,113 @@
+package sniproxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/accesslog"
+)
+
+// logAccess writes the finished tunnel to the access log if it is enabled.
+// startTime is the time the tunnel was established.  req is the HTTP request
+// of a plain HTTP connection and status is the status code of the response to
+// it, req is nil for TLS connections.
+func (p *SNIProxy) logAccess(
+	ctx *SNIContext,
+	req *http.Request,
+	status int,
+	startTime time.Time,
+	elapsed time.Duration,
+	bytesReceived int64,
+	bytesSent int64,
+) {
+	e := &accesslog.Entry{
+		Time:          startTime,
+		ClientIP:      ctx.ClientAddr.Addr().String(),
+		Listener:      ctx.Listener,
+		Host:          ctx.RemoteHost,
+		RemoteAddr:    ctx.RemoteAddr,
+		Duration:      elapsed.Milliseconds(),
+		BytesReceived: bytesReceived,
+		BytesSent:     bytesSent,
+		ID:            ctx.ID,
+		Forwarded:     ctx.forwarded,
+	}
+
+	if ctx.BackendAddr != nil {
+		e.BackendAddr = ctx.BackendAddr.String()
+	}
+
+	if req != nil {
+		e.Method = req.Method
+		e.RequestURI = req.RequestURI
+		e.Proto = req.Proto
+		e.Status = status
+		e.Referer = req.Referer()
+		e.UserAgent = req.UserAgent()
+	}
+
+	err := p.accessLog.Log(e)
+	if err != nil {
+		log.Error("sniproxy: [%d] %v", ctx.ID, err)
+	}
+}
+
+// statusLineLen is the length of the beginning of the HTTP status line that
+// contains the status code, i.e. "HTTP/1.1 200".
+const statusLineLen = len("HTTP/1.1 200")
+
+// statusReader is an [io.Reader] that parses the status code of the HTTP
+// response from the first bytes read from the backend.  Only the status code
+// of the first response on the connection is recorded.
+type statusReader struct {
+	reader io.Reader
+
+	// head is the beginning of the response.  It is collected until it is
+	// long enough to contain the status code.
+	head []byte
+
+	// status is the parsed status code or zero if the response doesn't start
+	// with a valid status line.
+	status int
+}
+
+// type check
+var _ io.Reader = (*statusReader)(nil)
+
+// Read implements the [io.Reader] interface for *statusReader.
+func (r *statusReader) Read(b []byte) (n int, err error) {
+	n, err = r.reader.Read(b)
+	if len(r.head) < statusLineLen {
+		end := n
+		if need := statusLineLen - len(r.head); end > need {
+			end = need
+		}
+
+		r.head = append(r.head, b[:end]...)
+		if len(r.head) == statusLineLen {
+			r.status = parseStatus(r.head)
+		}
+	}
+
+	return n, err
+}
+
+// parseStatus returns the status code from the beginning of the status line
+// or zero if head isn't one.
+func parseStatus(head []byte) (status int) {
+	proto, code, ok := bytes.Cut(head, []byte{' '})
+	if !ok || !bytes.HasPrefix(proto, []byte("HTTP/")) {
+		return 0
+	}
+
+	status, err := strconv.Atoi(string(code))
+	if err != nil || status < 100 || status > 999 {
+		return 0
+	}
+
+	return status
+}
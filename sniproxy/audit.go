@@ -0,0 +1,26 @@
+package sniproxy
+
+import (
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/audit"
+)
+
+// audit writes the decision about the connection to the audit log if it is
+// enabled and counts it in the run totals.  rule is the rule that caused the
+// decision, reason describes the decisions that are not caused by a rule.
+func (p *SNIProxy) audit(ctx *SNIContext, action, rule, reason string) {
+	p.totals.finished(action)
+
+	err := p.auditLog.Log(&audit.Event{
+		Source:      audit.SourceSNIProxy,
+		Action:      action,
+		ClientIP:    ctx.ClientAddr.Addr().String(),
+		Destination: ctx.RemoteHost,
+		Rule:        rule,
+		Reason:      reason,
+		ID:          ctx.ID,
+	})
+	if err != nil {
+		log.Error("sniproxy: [%d] %v", ctx.ID, err)
+	}
+}
@@ -0,0 +1,98 @@
+package sniproxy
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ameshkov/sniproxy/internal/filter"
+)
+
+// bandwidthStats keeps track of the number of bytes tunneled through the
+// connections governed by each bandwidth rule.  The counters are keyed by the
+// rule wildcard rather than the domain name so that their number is bounded by
+// the number of rules.
+type bandwidthStats struct {
+	// mu protects bytes.
+	mu sync.Mutex
+
+	// bytes is the map of the counters by the rule wildcard.
+	bytes map[string]*atomic.Int64
+}
+
+// newBandwidthStats creates a new *bandwidthStats.
+func newBandwidthStats() (s *bandwidthStats) {
+	return &bandwidthStats{
+		bytes: map[string]*atomic.Int64{},
+	}
+}
+
+// counter returns the counter for the rule, creating it if necessary.
+func (s *bandwidthStats) counter(rule string) (c *atomic.Int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.bytes[rule]
+	if !ok {
+		c = &atomic.Int64{}
+		s.bytes[rule] = c
+	}
+
+	return c
+}
+
+// snapshot returns the current values of the counters.
+func (s *bandwidthStats) snapshot() (bytes map[string]int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bytes = make(map[string]int64, len(s.bytes))
+	for rule, c := range s.bytes {
+		bytes[rule] = c.Load()
+	}
+
+	return bytes
+}
+
+// bandwidthRule finds the bandwidth rule for the connection.  If several rules
+// match, the most specific one wins, see [filter.MoreSpecific].  A zero rate
+// means that the connection is not limited.  ok is false if there is no
+// matching rule and the connection is limited by the common bandwidth rate.
+func bandwidthRule(ctx *SNIContext) (rule string, bytesPerSec float64, ok bool) {
+	for w, v := range ctx.rules.BandwidthRules {
+		if !filter.MatchWildcard(ctx.RemoteHost, w) {
+			continue
+		}
+
+		if !ok || filter.MoreSpecific(w, rule) {
+			rule, bytesPerSec, ok = w, v, true
+		}
+	}
+
+	return rule, bytesPerSec, ok
+}
+
+// BandwidthStats returns the total number of bytes tunneled in both directions
+// through the connections governed by each bandwidth rule.  The map is keyed by
+// the rule wildcard.
+func (p *SNIProxy) BandwidthStats() (bytes map[string]int64) {
+	return p.bandwidthStats.snapshot()
+}
+
+// countingWriter is an io.Writer that adds the number of written bytes to the
+// counter.
+type countingWriter struct {
+	writer  io.Writer
+	counter *atomic.Int64
+}
+
+// type check
+var _ io.Writer = (*countingWriter)(nil)
+
+// Write implements the io.Writer interface for *countingWriter.
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.writer.Write(p)
+	w.counter.Add(int64(n))
+
+	return n, err
+}
@@ -0,0 +1,49 @@
+package sniproxy
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/capture"
+)
+
+// newCapturer creates a new *capture.Capturer for conf.  It returns nil if
+// conf is nil.
+func newCapturer(conf *capture.Config) (c *capture.Capturer, err error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	c, err = capture.New(conf)
+	if err != nil {
+		return nil, fmt.Errorf("sniproxy: %w", err)
+	}
+
+	return c, nil
+}
+
+// captureFailedPeek writes the bytes received from the client before peeking
+// the server name failed with peekErr to a capture file if capturing is
+// enabled.
+func (p *SNIProxy) captureFailedPeek(clientConn net.Conn, peeked []byte, peekErr error) {
+	path, err := p.capturer.Write(&capture.Capture{
+		Time:   time.Now(),
+		Client: addrPortFromNetAddr(clientConn.RemoteAddr()),
+		Local:  addrPortFromNetAddr(clientConn.LocalAddr()),
+		Data:   peeked,
+	})
+	if err != nil {
+		log.Error("sniproxy: capturing connection from %s: %v", clientConn.RemoteAddr(), err)
+	}
+
+	if path != "" {
+		log.Info(
+			"sniproxy: captured connection from %s to %s: %v",
+			clientConn.RemoteAddr(),
+			path,
+			peekErr,
+		)
+	}
+}
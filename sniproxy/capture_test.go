@@ -0,0 +1,68 @@
+package sniproxy
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ameshkov/sniproxy/capture"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_captureFailedPeek(t *testing.T) {
+	const conns = 3
+
+	// garbage is not a TLS record, so peeking the server name fails at once.
+	garbage := []byte("GET / HTTP/1.1\r\nHost: example.org\r\n\r\n")
+
+	testCases := []struct {
+		name      string
+		perMinute float64
+		wantFiles int
+	}{{
+		name:      "unlimited",
+		perMinute: 0,
+		wantFiles: conns,
+	}, {
+		name:      "rate_limited",
+		perMinute: 1,
+		wantFiles: 1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "captures")
+			p := startProxy(t, &Config{
+				Capture: &capture.Config{
+					Dir:       dir,
+					MaxBytes:  16,
+					PerMinute: tc.perMinute,
+				},
+			})
+
+			for i := 0; i < conns; i++ {
+				conn, err := net.Dial("tcp", p.sniListener.Addr().String())
+				require.NoError(t, err)
+				t.Cleanup(func() { _ = conn.Close() })
+
+				_, err = conn.Write(garbage)
+				require.NoError(t, err)
+
+				// The connection is only closed after the capture is written.
+				// The proxy doesn't read the whole request, so the connection
+				// may be reset instead of closed.
+				require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+				_, err = io.ReadAll(conn)
+				assert.False(t, isTimeout(err))
+			}
+
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Len(t, entries, tc.wantFiles)
+		})
+	}
+}
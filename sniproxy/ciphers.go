@@ -0,0 +1,115 @@
+package sniproxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/audit"
+)
+
+// CipherStrength is the strength of a TLS cipher suite.  The proxy does not
+// terminate TLS, so it can only check the cipher suites offered in the
+// ClientHello.
+type CipherStrength string
+
+const (
+	// CipherStrengthWeak are the cipher suites that are considered insecure,
+	// e.g. the ones with RC4, 3DES, export or NULL ciphers, and the ones
+	// unknown to the proxy.
+	CipherStrengthWeak CipherStrength = "weak"
+
+	// CipherStrengthMedium are the cipher suites without known weaknesses
+	// that either don't provide forward secrecy or use CBC mode.
+	CipherStrengthMedium CipherStrength = "medium"
+
+	// CipherStrengthStrong are the AEAD cipher suites with forward secrecy,
+	// including all TLS 1.3 ones.
+	CipherStrengthStrong CipherStrength = "strong"
+)
+
+// cipherStrengthRanks allow comparing the strengths.
+var cipherStrengthRanks = map[CipherStrength]int{
+	CipherStrengthWeak:   0,
+	CipherStrengthMedium: 1,
+	CipherStrengthStrong: 2,
+}
+
+// weakCipherMarkers are the parts of the cipher suite names that make them
+// weak.
+var weakCipherMarkers = []string{"_NULL_", "_EXPORT", "_anon_", "_RC4_", "_DES_", "_3DES_", "_MD5"}
+
+// cipherStrengths are the strengths of the cipher suites known to the
+// crypto/tls package.  The others are considered weak.
+var cipherStrengths = newCipherStrengths()
+
+// newCipherStrengths classifies the cipher suites known to crypto/tls.  The
+// suites are classified by their names rather than by the list they are in,
+// since crypto/tls moves them to [tls.InsecureCipherSuites] over time.
+func newCipherStrengths() (m map[uint16]CipherStrength) {
+	m = map[uint16]CipherStrength{}
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		m[cs.ID] = cipherSuiteStrength(cs)
+	}
+
+	return m
+}
+
+// cipherSuiteStrength returns the strength of the cipher suite.
+func cipherSuiteStrength(cs *tls.CipherSuite) (s CipherStrength) {
+	for _, marker := range weakCipherMarkers {
+		if strings.Contains(cs.Name, marker) {
+			return CipherStrengthWeak
+		}
+	}
+
+	tls13 := len(cs.SupportedVersions) == 1 && cs.SupportedVersions[0] == tls.VersionTLS13
+	aead := strings.Contains(cs.Name, "_GCM_") || strings.Contains(cs.Name, "_CHACHA20_")
+	if tls13 || (aead && strings.HasPrefix(cs.Name, "TLS_ECDHE_")) {
+		return CipherStrengthStrong
+	}
+
+	return CipherStrengthMedium
+}
+
+// maxOfferedCipherStrength returns the strength of the strongest cipher suite
+// offered in the ClientHello.
+func maxOfferedCipherStrength(hello *tls.ClientHelloInfo) (s CipherStrength) {
+	s = CipherStrengthWeak
+	for _, id := range hello.CipherSuites {
+		cs, ok := cipherStrengths[id]
+		if ok && cipherStrengthRanks[cs] > cipherStrengthRanks[s] {
+			s = cs
+		}
+	}
+
+	return s
+}
+
+// blocksWeakCiphers checks if the client only offers cipher suites weaker
+// than the minimum strength, logs it, and returns true if the connection must
+// be blocked.
+func (p *SNIProxy) blocksWeakCiphers(ctx *SNIContext, hello *tls.ClientHelloInfo) (ok bool) {
+	if p.minCipherStrength == "" {
+		return false
+	}
+
+	offered := maxOfferedCipherStrength(hello)
+	if cipherStrengthRanks[offered] >= cipherStrengthRanks[p.minCipherStrength] {
+		return false
+	}
+
+	log.Info(
+		"sniproxy: [%d] blocked connection from %s to %s: strongest offered cipher suite "+
+			"is %s, minimum is %s",
+		ctx.ID,
+		ctx.ClientAddr,
+		ctx.RemoteHost,
+		offered,
+		p.minCipherStrength,
+	)
+	p.audit(ctx, audit.ActionBlock, "", fmt.Sprintf("%s ciphers", offered))
+
+	return true
+}
@@ -0,0 +1,77 @@
+package sniproxy
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSNIProxy_blocksWeakCiphers(t *testing.T) {
+	var (
+		weak   = []uint16{tls.TLS_RSA_WITH_RC4_128_SHA, tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA}
+		medium = []uint16{tls.TLS_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}
+		strong = []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}
+		tls13  = []uint16{tls.TLS_AES_128_GCM_SHA256}
+	)
+
+	testCases := []struct {
+		name        string
+		minStrength CipherStrength
+		suites      []uint16
+		want        bool
+	}{{
+		name:        "disabled",
+		minStrength: "",
+		suites:      weak,
+		want:        false,
+	}, {
+		name:        "medium_weak",
+		minStrength: CipherStrengthMedium,
+		suites:      weak,
+		want:        true,
+	}, {
+		name:        "medium_medium",
+		minStrength: CipherStrengthMedium,
+		suites:      append(weak, medium...),
+		want:        false,
+	}, {
+		name:        "strong_medium",
+		minStrength: CipherStrengthStrong,
+		suites:      medium,
+		want:        true,
+	}, {
+		name:        "strong_strong",
+		minStrength: CipherStrengthStrong,
+		suites:      append(medium, strong...),
+		want:        false,
+	}, {
+		name:        "strong_tls13",
+		minStrength: CipherStrengthStrong,
+		suites:      tls13,
+		want:        false,
+	}, {
+		name:        "unknown",
+		minStrength: CipherStrengthMedium,
+		suites:      []uint16{0xfefe},
+		want:        true,
+	}, {
+		name:        "none",
+		minStrength: CipherStrengthMedium,
+		suites:      nil,
+		want:        true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &SNIProxy{
+				minCipherStrength: tc.minStrength,
+				totals:            newRunTotals(),
+			}
+			ctx := NewSNIContext("example.org", "example.org:443")
+			hello := &tls.ClientHelloInfo{CipherSuites: tc.suites}
+
+			assert.Equal(t, tc.want, p.blocksWeakCiphers(ctx, hello))
+		})
+	}
+}
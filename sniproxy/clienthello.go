@@ -0,0 +1,58 @@
+package sniproxy
+
+import (
+	"crypto/tls"
+	"encoding/json"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// clientHelloLog is the structured representation of a TLS ClientHello that
+// is logged for fingerprinting research.
+type clientHelloLog struct {
+	ServerName        string   `json:"server_name"`
+	CipherSuites      []uint16 `json:"cipher_suites"`
+	SupportedCurves   []uint16 `json:"supported_curves"`
+	SupportedPoints   []uint16 `json:"supported_points"`
+	SignatureSchemes  []uint16 `json:"signature_schemes"`
+	SupportedProtos   []string `json:"supported_protos"`
+	SupportedVersions []uint16 `json:"supported_versions"`
+}
+
+// newClientHelloLog converts hello to *clientHelloLog.
+func newClientHelloLog(hello *tls.ClientHelloInfo) (l *clientHelloLog) {
+	l = &clientHelloLog{
+		ServerName:        hello.ServerName,
+		CipherSuites:      hello.CipherSuites,
+		SupportedProtos:   hello.SupportedProtos,
+		SupportedVersions: hello.SupportedVersions,
+	}
+
+	for _, c := range hello.SupportedCurves {
+		l.SupportedCurves = append(l.SupportedCurves, uint16(c))
+	}
+
+	// Points are converted as well so that they aren't encoded as a base64
+	// string.
+	for _, p := range hello.SupportedPoints {
+		l.SupportedPoints = append(l.SupportedPoints, uint16(p))
+	}
+
+	for _, s := range hello.SignatureSchemes {
+		l.SignatureSchemes = append(l.SignatureSchemes, uint16(s))
+	}
+
+	return l
+}
+
+// logClientHello writes the ClientHello of the connection to the log as JSON.
+func logClientHello(ctx *SNIContext, hello *tls.ClientHelloInfo) {
+	b, err := json.Marshal(newClientHelloLog(hello))
+	if err != nil {
+		log.Debug("sniproxy: [%d] failed to encode clienthello: %v", ctx.ID, err)
+
+		return
+	}
+
+	log.Info("sniproxy: [%d] clienthello %s", ctx.ID, b)
+}
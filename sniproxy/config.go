@@ -0,0 +1,606 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/ameshkov/sniproxy/accesslog"
+	"github.com/ameshkov/sniproxy/audit"
+	"github.com/ameshkov/sniproxy/capture"
+	"github.com/ameshkov/sniproxy/proxyproto"
+	"github.com/ameshkov/sniproxy/shapeio"
+)
+
+// Config is the SNI proxy configuration.
+type Config struct {
+	// TLSListenAddr is the listen address the SNI proxy will be listening to
+	// TLS connections.
+	TLSListenAddr *net.TCPAddr
+
+	// HTTPListenAddr is the listen address the SNI proxy will be listening to
+	// plain HTTP connections.
+	HTTPListenAddr *net.TCPAddr
+
+	// Freebind allows binding TLSListenAddr and HTTPListenAddr even if they
+	// are not assigned to the host yet, e.g. a virtual IP that is moved
+	// between hosts on failover.  It is only supported on Linux.
+	Freebind bool
+
+	// WaitReady makes the proxy refuse new connections after it is started
+	// until [SNIProxy.SetReady] is called.  It prevents serving connections
+	// before all the rules are loaded.
+	WaitReady bool
+
+	// TLSListenerLabel is the label of the TLS listener that is assigned to
+	// the connections it accepts.  If not set, "tls/" followed by the listen
+	// address is used.
+	TLSListenerLabel string
+
+	// HTTPListenerLabel is the label of the plain HTTP listener that is
+	// assigned to the connections it accepts.  If not set, "http/" followed by
+	// the listen address is used.
+	HTTPListenerLabel string
+
+	// Upstreams is a list of DNS upstreams that are used to resolve the
+	// remote hosts instead of the system resolver.  They are queried directly
+	// so that the proxy does not resolve the tunneled domains to itself when
+	// the system resolver goes through the DNS proxy.  If empty, the system
+	// resolver is used.
+	Upstreams []string
+
+	// UpstreamTimeout is the timeout for queries to Upstreams.
+	UpstreamTimeout time.Duration
+
+	// ResolveFallback is the address in the "host:port" format that the
+	// connections are tunneled to when the remote host cannot be resolved.
+	// If not set, such connections are closed.
+	ResolveFallback string
+
+	// ResolveFailureStatus is the status code of the response, e.g. 502, that
+	// is sent to the plain HTTP clients when the remote host cannot be
+	// resolved and there is no ResolveFallback.  If not set, the connection
+	// is closed without a response.
+	ResolveFailureStatus int
+
+	// ForwardProxies is a list of addresses of SOCKS5/HTTP/HTTPS proxies that
+	// the connections will be forwarded to according to ForwardRules.  The
+	// proxies are interchangeable, if the first one fails to connect, the next
+	// one is tried.
+	ForwardProxies []string
+
+	// ForwardProxyRules is a list of rules that define specific forward
+	// proxies for the connections to the matching domains.  They have higher
+	// priority than ForwardProxies and ForwardRules.
+	ForwardProxyRules []ForwardProxyRule
+
+	// ForwardProbeTimeout enables detection of the HTTP forward proxies that
+	// respond 200 to CONNECT and then immediately close the connection.  The
+	// proxy waits for that long after CONNECT and fails with a descriptive
+	// error if the connection is closed.  The targets that passed the probe
+	// are not probed again for a while.  If not set, there is no probe.
+	ForwardProbeTimeout time.Duration
+
+	// ForwardResolve defines where the hostnames of the forwarded connections
+	// are resolved.  If not set, ForwardResolveProxy is used.
+	ForwardResolve ForwardResolveMode
+
+	// ForwardConnectTimeout is the maximum time an HTTP forward proxy is given
+	// to respond to CONNECT once the connection to it is established.  A proxy
+	// that doesn't respond in time is considered failed.  If not set, there is
+	// no limit besides the dial timeout.
+	ForwardConnectTimeout time.Duration
+
+	// ForwardFallbackDirect makes the proxy connect to the remote host
+	// directly when the connection should be forwarded, but none of the
+	// forward proxies are able to establish it.
+	ForwardFallbackDirect bool
+
+	// ForwardRequired makes [SNIProxy.Start] fail if the forward proxies are
+	// not reachable, so that the traffic that must go through them never
+	// leaves directly.  It cannot be used with ForwardFallbackDirect.
+	ForwardRequired bool
+
+	// ForwardRules is a list of wildcards that define what connections will be
+	// forwarded to the proxy using ForwardProxies.  If the list is empty and
+	// ForwardProxies is set, all connections will be forwarded.
+	ForwardRules []string
+
+	// ForwardPathRules is a list of wildcards that define what plain HTTP
+	// connections will be forwarded to the proxy.  The wildcards are matched
+	// against host+path of the HTTP request, e.g. "example.org/api/*".
+	ForwardPathRules []string
+
+	// ForwardSchedule is a list of rules that allow forwarding connections to
+	// the matching domains only within a time-of-day window.  Schedule rules
+	// have higher priority than ForwardRules.
+	ForwardSchedule []ForwardScheduleRule
+
+	// BlockRules is a list of wildcards that define connections to which hosts
+	// will be blocked.
+	BlockRules []string
+
+	// BlockPathRules is a list of wildcards that define what plain HTTP
+	// connections will be blocked.  The wildcards are matched against
+	// host+path of the HTTP request, e.g. "*/ads/*".
+	BlockPathRules []string
+
+	// BlockTLSAlert is the TLS alert that is sent to the clients of the TLS
+	// connections blocked by BlockRules before closing them.  Some clients
+	// retry aggressively when the connection is just closed.  If not set, no
+	// alert is sent.
+	BlockTLSAlert TLSAlert
+
+	// DropRules is a list of wildcards that define connections to which hosts
+	// will be dropped. "Dropped" means that they will be delayed for DropDelay
+	// and then closed without connecting to the remote host.
+	DropRules []string
+
+	// DropDelay is the period of time the connections that match DropRules
+	// are held for in DropModeDelay.  If not set, it is 3 minutes.
+	DropDelay time.Duration
+
+	// DropMode defines how the connections that match DropRules are handled.
+	// If not set, DropModeDelay is used.
+	DropMode DropMode
+
+	// ExpectedSNI is a list of wildcards that define the only server names
+	// allowed for TLS connections.  If it is not empty, TLS connections with
+	// any other SNI are dropped right after the ClientHello is parsed.
+	ExpectedSNI []string
+
+	// HTTPSOnlyRules is a list of wildcards that define the domains that must
+	// only be accessed over HTTPS.  Plain HTTP connections to these domains
+	// are handled according to HTTPSOnlyMode.
+	HTTPSOnlyRules []string
+
+	// HTTPSOnlyMode defines how plain HTTP connections to the domains that
+	// match HTTPSOnlyRules are handled.  If not set, PolicyModeLog is used.
+	HTTPSOnlyMode PolicyMode
+
+	// MinTLSVersionRules define the minimum TLS versions that clients must
+	// offer to the matching domains.  The first matching rule applies.  The
+	// connections that offer lower versions are handled according to
+	// MinTLSVersionMode.
+	MinTLSVersionRules []MinTLSVersionRule
+
+	// MinTLSVersionMode defines how the connections that violate
+	// MinTLSVersionRules are handled.  If not set, PolicyModeLog is used.
+	MinTLSVersionMode PolicyMode
+
+	// MinCipherStrength is the minimum strength of the cipher suites that
+	// clients must offer in the ClientHello.  The connections that only offer
+	// weaker cipher suites are blocked.  If not set, cipher suites aren't
+	// checked.
+	MinCipherStrength CipherStrength
+
+	// AllowedPorts is the list of remote ports the proxy is allowed to tunnel
+	// connections to.  The port may be specified in the SNI or the HTTP Host
+	// header, connections to other ports are refused.  If empty, any port is
+	// allowed.
+	AllowedPorts []int
+
+	// MatchPTR enables matching block and forward rules against the PTR names
+	// of the remote host IP address in addition to the hostname itself.
+	MatchPTR bool
+
+	// TrustedProxies is the list of networks of the reverse proxies in front
+	// of the plain HTTP listener.  For the connections from these networks,
+	// the real client address is taken from the X-Forwarded-For or X-Real-IP
+	// headers and is used for logging and limits.  If empty, the headers are
+	// never trusted.
+	TrustedProxies []netip.Prefix
+
+	// MaxConnsPerIP is the maximum number of simultaneous connections from a
+	// single client IP address.  If not set, there is no limit.
+	MaxConnsPerIP int
+
+	// LimitRetryAfter is the value of the Retry-After header of the 429
+	// response that plain HTTP clients receive when MaxConnsPerIP is
+	// exceeded.  If not set, the header is not sent.
+	LimitRetryAfter time.Duration
+
+	// MaxTunnelDuration is the maximum lifetime of a tunnel.  When it is
+	// exceeded, the connection is closed regardless of its activity.  If not
+	// set, there is no limit.
+	MaxTunnelDuration time.Duration
+
+	// MaxDurationRules override MaxTunnelDuration for the domains that match
+	// the wildcards.  If several rules match, the longest wildcard wins.
+	MaxDurationRules []TimeoutRule
+
+	// SlowThreshold is the duration of a tunnel after which it is logged as a
+	// slow one with a warning when it is finished.  If not set, slow tunnels
+	// are not logged separately.
+	SlowThreshold time.Duration
+
+	// StatsInterval is how often the interim statistics of the open tunnels
+	// are reported to OnStats.  If not set, the statistics are only logged
+	// when a tunnel is finished.
+	StatsInterval time.Duration
+
+	// OnStats receives the interim statistics of the open tunnels every
+	// StatsInterval.  If not set, the statistics are logged.
+	OnStats StatsFunc
+
+	// IdleTimeout is the period of time after which a tunnel is closed if no
+	// data was transferred in either direction.  If zero, idle tunnels are not
+	// closed.
+	IdleTimeout time.Duration
+
+	// IdleTimeoutRules override IdleTimeout for the domains that match the
+	// wildcards.  If several rules match, the longest wildcard wins.
+	IdleTimeoutRules []TimeoutRule
+
+	// LivenessInterval enables probing the peers of the tunnels with TCP
+	// keep-alive probes.  The first probe is sent when the connection is idle
+	// for this period, then probes are repeated with this interval.  If a
+	// peer misses 3 probes in a row, the tunnel is closed.  It allows reaping
+	// the tunnels with dead peers much faster than the OS keep-alive
+	// defaults.  If zero, the defaults are used.
+	LivenessInterval time.Duration
+
+	// TCPUserTimeout is the maximum time the data sent to a peer may remain
+	// unacknowledged before the connection is aborted, see TCP_USER_TIMEOUT.
+	// It is set on the accepted connections and on the connections to the
+	// remote hosts and forward proxies, and detects dead peers during active
+	// transfers faster than the keep-alive probes.  It is only supported on
+	// Linux.  If zero, the OS default is used.
+	TCPUserTimeout time.Duration
+
+	// Linger is the SO_LINGER timeout in seconds of the client and backend
+	// connections of the tunnels, see [net.TCPConn.SetLinger].  Zero makes
+	// the connections close with RST discarding the unsent data.  If nil, the
+	// OS default is used.
+	Linger *int
+
+	// ShutdownTimeout is the period of time [SNIProxy.Close] waits for the
+	// active connections to finish before closing them.  If not set, it is 30
+	// seconds.
+	ShutdownTimeout time.Duration
+
+	// Workers is the number of goroutines that handle the client connections.
+	// They are started once and reused, which saves the goroutine creation
+	// under high connection churn.  As a worker is busy for the whole lifetime
+	// of a tunnel, it also limits the number of simultaneous connections.  Up
+	// to Workers new connections wait in a queue for a free worker, the rest
+	// are refused.  If not set, a new goroutine is started for every
+	// connection.
+	Workers int
+
+	// ProxyProtocol is the version of the PROXY protocol header that is sent to
+	// the remote host before the client data so that it sees the real address
+	// of the client.  If zero, the header is not sent.
+	ProxyProtocol proxyproto.Version
+
+	// Transparent enables the transparent mode for the connections redirected
+	// to the proxy by iptables/nftables.  In this mode the proxy connects to
+	// the original destination of the connection if there is no SNI in the
+	// ClientHello or the HTTP Host header is an IP address.  The original
+	// destination is read from the SO_ORIGINAL_DST socket option so this only
+	// works on Linux.
+	Transparent bool
+
+	// HealthPath is the path of the health probes.  Plain HTTP requests to it
+	// are answered with 200 OK by the proxy itself instead of being tunneled.
+	// If not set, all requests are tunneled.
+	HealthPath string
+
+	// HealthHost limits the health probes to the requests with this host.  If
+	// not set, requests to HealthPath with any host are health probes.
+	HealthHost string
+
+	// LocalHosts are the virtual hosts served by the proxy itself on the HTTP
+	// listener.  Requests to them are answered with the content of their files
+	// instead of being tunneled.
+	LocalHosts []LocalHost
+
+	// PeekMaxReads is the maximum number of reads the proxy makes to receive
+	// the ClientHello or the HTTP request headers.  If not set, the number of
+	// reads is not limited.
+	PeekMaxReads int
+
+	// PeekTimeout is the time the whole ClientHello or the HTTP request
+	// headers must be received within, no matter how many reads it takes.  If
+	// not set, 10 seconds is used.
+	PeekTimeout time.Duration
+
+	// MaxPeekMemory is the maximum total number of bytes buffered by all the
+	// connections while the ClientHello or the HTTP request headers are being
+	// received.  The connection that exceeds it is closed and new connections
+	// are refused until the buffered bytes are released.  If not set, there
+	// is no limit.
+	MaxPeekMemory int64
+
+	// PassthroughOnParseError makes the proxy tunnel connections that it
+	// failed to parse (non-TLS, non-HTTP) to their original destination
+	// instead of dropping them.  The original destination is read from the
+	// SO_ORIGINAL_DST socket option so this only works on Linux for
+	// connections redirected to the proxy by iptables/nftables.
+	PassthroughOnParseError bool
+
+	// RequireSNI makes the proxy reject TLS connections with a ClientHello
+	// that has no SNI, even in the transparent mode.  Connections that could
+	// not be parsed at all are handled according to PassthroughOnParseError.
+	RequireSNI bool
+
+	// LogClientHello enables logging of the ClientHello parameters (cipher
+	// suites, supported groups, signature algorithms, etc) of every TLS
+	// connection as JSON.
+	LogClientHello bool
+
+	// QuietEmptyTunnels makes the proxy log the tunnels that received no data
+	// from the remote host at the debug level.  Such tunnels are usually
+	// opened by probes and scanners and clutter the log.
+	QuietEmptyTunnels bool
+
+	// OTelEndpoint is the URL of the OTLP/HTTP collector, e.g.
+	// http://127.0.0.1:4318.  If set, a span is exported for every client
+	// connection.
+	OTelEndpoint string
+
+	// Inspectors are called for every connection after the server name has
+	// been parsed and before the standard rules are applied, see [Inspector].
+	Inspectors []Inspector
+
+	// Audit receives the blocked and dropped connections.  If not set, they
+	// are only logged to the operational log.
+	Audit *audit.Logger
+
+	// AccessLog receives the finished tunnels.  If not set, they are only
+	// logged to the operational log.
+	AccessLog *accesslog.Logger
+
+	// Capture makes the proxy write the first bytes of the connections it
+	// failed to parse to pcap files.  If not set, nothing is captured.
+	Capture *capture.Config
+
+	// DialSourcePortMin and DialSourcePortMax define the range of local ports
+	// the connections to the remote hosts and forward proxies are made from.
+	// A random port from the range is chosen for every connection.  If
+	// DialSourcePortMin is not set, the OS chooses the port.
+	DialSourcePortMin int
+	DialSourcePortMax int
+
+	// CopyChunkSize is the maximum number of bytes that are read from one side
+	// of a tunnel and written to the other one at once.  Smaller chunks make
+	// interactive traffic more responsive, larger ones reduce the number of
+	// syscalls and improve throughput.  If not set, 32 KiB is used.
+	CopyChunkSize int
+
+	// BandwidthRate is a number of bytes per second the connections speed will
+	// be limited to.  If not set, there is no limit.
+	BandwidthRate float64
+
+	// BandwidthRules is a map that allows to define connection speed for
+	// domains that match the wildcards.  Has higher priority than
+	// BandwidthRate.  If several rules match, the longest wildcard wins.  Zero
+	// rate means that the matching connections are not limited at all.
+	BandwidthRules map[string]float64
+
+	// TunnelErrorMode defines what happens to a tunnel when copying the data
+	// in one of its directions fails, e.g. because the remote host sent RST.
+	// If not set, TunnelErrorModeClose is used.
+	TunnelErrorMode TunnelErrorMode
+
+	// ShaperAlgorithm is the algorithm used to limit the speed according to
+	// BandwidthRate and BandwidthRules.  If not set,
+	// [shapeio.AlgorithmTokenBucket] is used.
+	ShaperAlgorithm shapeio.Algorithm
+
+	// NoShapeForwarded disables BandwidthRate and BandwidthRules for the
+	// connections tunneled through the forward proxies, e.g. when the forward
+	// proxies already limit the speed.
+	NoShapeForwarded bool
+}
+
+// TunnelErrorMode defines what happens to a tunnel when copying the data in one
+// of its directions fails.
+type TunnelErrorMode string
+
+const (
+	// TunnelErrorModeClose makes the proxy close both connections of the
+	// tunnel right away, which interrupts copying in the other direction.
+	TunnelErrorModeClose TunnelErrorMode = "close"
+
+	// TunnelErrorModeHalfClose makes the proxy only shut down the writing
+	// side of the failed direction, the other direction goes on until it is
+	// finished by itself.
+	TunnelErrorModeHalfClose TunnelErrorMode = "half-close"
+)
+
+// ForwardResolveMode defines where the hostnames of the forwarded connections
+// are resolved.
+type ForwardResolveMode string
+
+const (
+	// ForwardResolveProxy makes the proxy send the hostname from SNI or the
+	// Host header to the forward proxy as is, so that it is resolved by the
+	// forward proxy, e.g. as a domain name (ATYP 0x03) to SOCKS5 proxies.
+	// The DNS queries never leave through sniproxy then.
+	ForwardResolveProxy ForwardResolveMode = "proxy"
+
+	// ForwardResolveLocal makes the proxy resolve the hostname itself and
+	// send the IP address to the forward proxy, e.g. for the proxies that
+	// cannot resolve hostnames.  .onion hosts are still sent as is.
+	ForwardResolveLocal ForwardResolveMode = "local"
+)
+
+// PolicyMode defines how the connections that violate a policy, e.g. plain
+// HTTP connections to HTTPS-only domains, are handled.
+type PolicyMode string
+
+const (
+	// PolicyModeLog makes the proxy log such connections and tunnel them as
+	// usual.
+	PolicyModeLog PolicyMode = "log"
+
+	// PolicyModeBlock makes the proxy log and close such connections.
+	PolicyModeBlock PolicyMode = "block"
+)
+
+// Validate returns an error if c is not a valid configuration.  The error
+// lists all the invalid fields.  [New] calls it, so that the programs that
+// build the configuration themselves get an error rather than a proxy that
+// fails later.
+func (c *Config) Validate() (err error) {
+	var errs []error
+	if s := c.ResolveFailureStatus; s != 0 && (s < 100 || s > 599) {
+		errs = append(errs, fmt.Errorf("invalid resolve failure status %d", s))
+	}
+
+	if c.ForwardRequired {
+		if len(c.ForwardProxies) == 0 && len(c.ForwardProxyRules) == 0 {
+			errs = append(errs, errors.New("forward proxy is required, but none is configured"))
+		} else if c.ForwardFallbackDirect {
+			errs = append(errs, errors.New(
+				"direct fallback cannot be used when forward proxy is required",
+			))
+		}
+	}
+
+	errs = append(errs, c.validateRules()...)
+	errs = append(errs, c.validateModes()...)
+	errs = append(errs, c.validateLimits()...)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("sniproxy: invalid configuration: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// validateRules returns the errors for the rules that are incomplete.
+func (c *Config) validateRules() (errs []error) {
+	for _, r := range c.ForwardProxyRules {
+		if r.Wildcard == "" || r.Proxy == "" {
+			errs = append(errs, fmt.Errorf("invalid forward proxy rule %q=%q", r.Wildcard, r.Proxy))
+		}
+	}
+
+	for _, h := range c.LocalHosts {
+		if h.Host == "" || h.Path == "" {
+			errs = append(errs, fmt.Errorf("invalid local host %q=%q", h.Host, h.Path))
+		}
+	}
+
+	for _, r := range c.MinTLSVersionRules {
+		if _, ok := tlsVersionNames[r.Version]; !ok || r.Wildcard == "" {
+			errs = append(errs, fmt.Errorf(
+				"invalid min tls version rule %q=%#04x",
+				r.Wildcard,
+				r.Version,
+			))
+		}
+	}
+
+	timeoutRules := append(append([]TimeoutRule{}, c.IdleTimeoutRules...), c.MaxDurationRules...)
+	for _, r := range timeoutRules {
+		if r.Wildcard == "" || r.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("invalid timeout rule %q=%s", r.Wildcard, r.Timeout))
+		}
+	}
+
+	return errs
+}
+
+// validateModes returns the errors for the unknown values of the enumerated
+// fields.
+func (c *Config) validateModes() (errs []error) {
+	if _, ok := tlsAlertCodes[c.BlockTLSAlert]; !ok && c.BlockTLSAlert != TLSAlertNone {
+		errs = append(errs, fmt.Errorf("unknown tls alert %q", c.BlockTLSAlert))
+	}
+
+	switch c.DropMode {
+	case "", DropModeDelay, DropModeFlaky:
+	default:
+		errs = append(errs, fmt.Errorf("unknown drop mode %q", c.DropMode))
+	}
+
+	switch c.TunnelErrorMode {
+	case "", TunnelErrorModeClose, TunnelErrorModeHalfClose:
+	default:
+		errs = append(errs, fmt.Errorf("unknown tunnel error mode %q", c.TunnelErrorMode))
+	}
+
+	switch c.ForwardResolve {
+	case "", ForwardResolveProxy, ForwardResolveLocal:
+	default:
+		errs = append(errs, fmt.Errorf("unknown forward resolve mode %q", c.ForwardResolve))
+	}
+
+	for _, m := range []PolicyMode{c.HTTPSOnlyMode, c.MinTLSVersionMode} {
+		switch m {
+		case "", PolicyModeLog, PolicyModeBlock:
+		default:
+			errs = append(errs, fmt.Errorf("unknown policy mode %q", m))
+		}
+	}
+
+	if _, ok := cipherStrengthRanks[c.MinCipherStrength]; !ok && c.MinCipherStrength != "" {
+		errs = append(errs, fmt.Errorf("unknown cipher strength %q", c.MinCipherStrength))
+	}
+
+	switch c.ProxyProtocol {
+	case 0, proxyproto.V1, proxyproto.V2:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported proxy protocol version %d", c.ProxyProtocol))
+	}
+
+	err := shapeio.ValidateAlgorithm(c.ShaperAlgorithm)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// validateLimits returns the errors for the out-of-range numeric fields.
+func (c *Config) validateLimits() (errs []error) {
+	if c.TCPUserTimeout < 0 {
+		errs = append(errs, fmt.Errorf("negative tcp user timeout %v", c.TCPUserTimeout))
+	} else if c.TCPUserTimeout > 0 && !userTimeoutSupported {
+		errs = append(errs, errors.New("tcp user timeout is only supported on linux"))
+	}
+
+	min, max := c.DialSourcePortMin, c.DialSourcePortMax
+	if min != 0 || max != 0 {
+		if min < 1 || max > 65535 || min > max {
+			errs = append(errs, fmt.Errorf("invalid dial source port range %d-%d", min, max))
+		}
+	}
+
+	for _, port := range c.AllowedPorts {
+		if port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("invalid allowed port %d", port))
+		}
+	}
+
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{name: "max conns per ip", value: int64(c.MaxConnsPerIP)},
+		{name: "workers", value: int64(c.Workers)},
+		{name: "peek max reads", value: int64(c.PeekMaxReads)},
+		{name: "peek timeout", value: int64(c.PeekTimeout)},
+		{name: "max peek memory", value: c.MaxPeekMemory},
+		{name: "copy chunk size", value: int64(c.CopyChunkSize)},
+		{name: "upstream timeout", value: int64(c.UpstreamTimeout)},
+		{name: "idle timeout", value: int64(c.IdleTimeout)},
+		{name: "max tunnel duration", value: int64(c.MaxTunnelDuration)},
+		{name: "shutdown timeout", value: int64(c.ShutdownTimeout)},
+	} {
+		if f.value < 0 {
+			errs = append(errs, fmt.Errorf("negative %s %d", f.name, f.value))
+		}
+	}
+
+	if c.BandwidthRate < 0 {
+		errs = append(errs, fmt.Errorf("negative bandwidth rate %g", c.BandwidthRate))
+	}
+
+	return errs
+}
@@ -0,0 +1,116 @@
+package sniproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	// valid returns a valid configuration changed by f.
+	valid := func(f func(c *Config)) (c *Config) {
+		c = &Config{TLSListenAddr: localAddr, HTTPListenAddr: localAddr}
+		if f != nil {
+			f(c)
+		}
+
+		return c
+	}
+
+	testCases := []struct {
+		conf       *Config
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       valid(nil),
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf: valid(func(c *Config) {
+			c.ForwardProxies = []string{"socks5://127.0.0.1:1080"}
+			c.ForwardRequired = true
+			c.IdleTimeoutRules = []TimeoutRule{{
+				Wildcard: "*.example.org",
+				Timeout:  time.Minute,
+			}}
+			c.DialSourcePortMin, c.DialSourcePortMax = 10000, 20000
+		}),
+		name:       "valid_full",
+		wantErrMsg: "",
+	}, {
+		conf:       valid(func(c *Config) { c.TunnelErrorMode = "bad" }),
+		name:       "unknown_tunnel_error_mode",
+		wantErrMsg: `unknown tunnel error mode "bad"`,
+	}, {
+		conf:       valid(func(c *Config) { c.ForwardResolve = "bad" }),
+		name:       "unknown_forward_resolve",
+		wantErrMsg: `unknown forward resolve mode "bad"`,
+	}, {
+		conf:       valid(func(c *Config) { c.ForwardRequired = true }),
+		name:       "forward_required_without_proxies",
+		wantErrMsg: "forward proxy is required, but none is configured",
+	}, {
+		conf: valid(func(c *Config) {
+			c.ForwardProxies = []string{"socks5://127.0.0.1:1080"}
+			c.ForwardRequired = true
+			c.ForwardFallbackDirect = true
+		}),
+		name:       "forward_required_with_fallback",
+		wantErrMsg: "direct fallback cannot be used when forward proxy is required",
+	}, {
+		conf: valid(func(c *Config) {
+			c.IdleTimeoutRules = []TimeoutRule{{Wildcard: "example.org", Timeout: -time.Second}}
+		}),
+		name:       "negative_timeout_rule",
+		wantErrMsg: `invalid timeout rule "example.org"=-1s`,
+	}, {
+		conf:       valid(func(c *Config) { c.DropMode = "bad" }),
+		name:       "unknown_drop_mode",
+		wantErrMsg: `unknown drop mode "bad"`,
+	}, {
+		conf: valid(func(c *Config) {
+			c.DialSourcePortMin, c.DialSourcePortMax = 20000, 10000
+		}),
+		name:       "bad_port_range",
+		wantErrMsg: "invalid dial source port range 20000-10000",
+	}, {
+		conf:       valid(func(c *Config) { c.AllowedPorts = []int{0} }),
+		name:       "bad_allowed_port",
+		wantErrMsg: "invalid allowed port 0",
+	}, {
+		conf:       valid(func(c *Config) { c.Workers = -1 }),
+		name:       "negative_workers",
+		wantErrMsg: "negative workers -1",
+	}, {
+		conf:       valid(func(c *Config) { c.ResolveFailureStatus = 42 }),
+		name:       "bad_resolve_failure_status",
+		wantErrMsg: "invalid resolve failure status 42",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.conf.Validate()
+			if tc.wantErrMsg == "" {
+				assert.NoError(t, err)
+
+				return
+			}
+
+			assert.ErrorContains(t, err, tc.wantErrMsg)
+		})
+	}
+}
+
+func TestConfig_Validate_allErrors(t *testing.T) {
+	c := &Config{
+		ResolveFailureStatus: 42,
+		DropMode:             "bad",
+		Workers:              -1,
+	}
+
+	err := c.Validate()
+	assert.ErrorContains(t, err, "invalid resolve failure status 42")
+	assert.ErrorContains(t, err, `unknown drop mode "bad"`)
+	assert.ErrorContains(t, err, "negative workers -1")
+}
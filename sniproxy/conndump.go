@@ -0,0 +1,124 @@
+package sniproxy
+
+import (
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// ConnInfo describes a client connection that is being handled right now.
+type ConnInfo struct {
+	// Accepted is the time the client connection was accepted.
+	Accepted time.Time `json:"accepted"`
+
+	// Client is the address of the client.
+	Client string `json:"client"`
+
+	// Listener is the label of the listener that accepted the connection.
+	Listener string `json:"listener,omitempty"`
+
+	// Destination is the address the client requested.  It is empty until the
+	// server name is parsed.
+	Destination string `json:"destination,omitempty"`
+
+	// State is the stage the handler is at, either "handshake" or "tunnel".
+	State string `json:"state"`
+
+	// Duration is the time since the connection was accepted.
+	Duration string `json:"duration"`
+
+	// TunnelDuration is the time since the tunnel was established.  It is
+	// empty if the connection is not tunneled yet.
+	TunnelDuration string `json:"tunnel_duration,omitempty"`
+
+	// ID is the connection ID.  It is zero until the server name is parsed.
+	ID uint64 `json:"id,omitempty"`
+
+	// Forwarded is true if the connection is tunneled through a forward
+	// proxy.
+	Forwarded bool `json:"forwarded,omitempty"`
+}
+
+// Handler states of [ConnInfo].
+const (
+	connStateHandshake = "handshake"
+	connStateTunnel    = "tunnel"
+)
+
+// Connections returns the client connections that are being handled right
+// now, the longest-running first.  It is intended for debugging goroutine and
+// connection leaks.
+func (p *SNIProxy) Connections() (conns []ConnInfo) {
+	tunnels := map[uint64]*activeConn{}
+	for _, c := range p.conns.list() {
+		tunnels[c.ctx.ID] = c
+	}
+
+	now := time.Now()
+	for _, st := range p.handlers.states() {
+		info := ConnInfo{
+			Accepted:    st.accepted,
+			Client:      st.client,
+			Listener:    st.listener,
+			Destination: st.dest,
+			State:       connStateHandshake,
+			Duration:    now.Sub(st.accepted).Round(time.Millisecond).String(),
+			ID:          st.id,
+		}
+
+		if t, ok := tunnels[st.id]; ok && st.id != 0 {
+			info.State = connStateTunnel
+			info.TunnelDuration = now.Sub(t.started).Round(time.Millisecond).String()
+			info.Forwarded = t.forwarded
+		}
+
+		conns = append(conns, info)
+	}
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].Accepted.Before(conns[j].Accepted)
+	})
+
+	return conns
+}
+
+// LogConnections writes the client connections that are being handled right
+// now and the total number of goroutines to the log.
+func (p *SNIProxy) LogConnections() {
+	conns := p.Connections()
+
+	log.Info(
+		"sniproxy: %d active connection handlers, %d goroutines",
+		len(conns),
+		runtime.NumGoroutine(),
+	)
+
+	for _, c := range conns {
+		dest := c.Destination
+		if dest == "" {
+			dest = "unknown destination"
+		}
+
+		if c.State == connStateTunnel {
+			log.Info(
+				"sniproxy: [%d] connection from %s to %s: open for %s, tunneling for %s, forwarded: %t",
+				c.ID,
+				c.Client,
+				dest,
+				c.Duration,
+				c.TunnelDuration,
+				c.Forwarded,
+			)
+		} else {
+			log.Info(
+				"sniproxy: [%d] connection from %s to %s: open for %s, not tunneling yet",
+				c.ID,
+				c.Client,
+				dest,
+				c.Duration,
+			)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package sniproxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_Connections(t *testing.T) {
+	// backend keeps the tunnels open until the client closes them.
+	backend := startBackend(t, func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = conn.Read(make([]byte, 1024))
+		_, _ = conn.Read(make([]byte, 1024))
+	})
+
+	p := startProxy(t, nil)
+
+	// The handler of this connection waits for the ClientHello.
+	handshakeConn, err := net.Dial("tcp", p.sniListener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = handshakeConn.Close() })
+
+	require.Eventually(t, func() (ok bool) {
+		return len(p.Connections()) == 1
+	}, testTimeout, 10*time.Millisecond)
+
+	_ = dialHTTP(t, p, backend)
+
+	var conns []ConnInfo
+	require.Eventually(t, func() (ok bool) {
+		conns = p.Connections()
+
+		return len(conns) == 2 && conns[1].State == connStateTunnel
+	}, testTimeout, 10*time.Millisecond)
+
+	handshake, tunnel := conns[0], conns[1]
+
+	assert.Equal(t, connStateHandshake, handshake.State)
+	assert.Equal(t, handshakeConn.LocalAddr().String(), handshake.Client)
+	assert.Empty(t, handshake.Destination)
+	assert.Empty(t, handshake.TunnelDuration)
+	assert.Zero(t, handshake.ID)
+
+	assert.Equal(t, connStateTunnel, tunnel.State)
+	assert.Equal(t, backend, tunnel.Destination)
+	assert.NotEmpty(t, tunnel.TunnelDuration)
+	assert.NotZero(t, tunnel.ID)
+	assert.False(t, tunnel.Forwarded)
+
+	buf := captureLog(t)
+	p.LogConnections()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+
+	assert.Contains(t, lines[0], "sniproxy: 2 active connection handlers")
+	assert.Contains(t, lines[1], "to unknown destination")
+	assert.Contains(t, lines[1], "not tunneling yet")
+	assert.Contains(t, lines[2], "to "+backend)
+	assert.Contains(t, lines[2], "tunneling for")
+}
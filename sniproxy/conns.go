@@ -0,0 +1,125 @@
+package sniproxy
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// activeConn is a connection that is being tunneled.
+type activeConn struct {
+	// ctx is the connection context.
+	ctx *SNIContext
+
+	// close closes both the client and the backend connections.
+	close func()
+
+	// started is the time the tunnel was established.
+	started time.Time
+
+	// forwarded is true if the connection is tunneled through a forward
+	// proxy.
+	forwarded bool
+}
+
+// connTracker keeps track of the connections that are being tunneled.
+type connTracker struct {
+	// mu protects conns and hosts.
+	mu sync.Mutex
+
+	// conns is the map of active connections by their ID.
+	conns map[uint64]*activeConn
+
+	// hosts is the number of active connections per remote host.
+	hosts map[string]int
+}
+
+// newConnTracker creates a new *connTracker.
+func newConnTracker() (t *connTracker) {
+	return &connTracker{
+		conns: map[uint64]*activeConn{},
+		hosts: map[string]int{},
+	}
+}
+
+// add starts tracking the connection.  closeFunc must close both the client
+// and the backend connections.
+func (t *connTracker) add(ctx *SNIContext, closeFunc func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.conns[ctx.ID] = &activeConn{
+		ctx:       ctx,
+		close:     closeFunc,
+		started:   time.Now(),
+		forwarded: ctx.forwarded,
+	}
+	t.hosts[ctx.RemoteHost]++
+}
+
+// remove stops tracking the connection.
+func (t *connTracker) remove(ctx *SNIContext) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.conns[ctx.ID]; !ok {
+		return
+	}
+
+	delete(t.conns, ctx.ID)
+
+	t.hosts[ctx.RemoteHost]--
+	if t.hosts[ctx.RemoteHost] <= 0 {
+		delete(t.hosts, ctx.RemoteHost)
+	}
+}
+
+// HostConns is the number of active connections to a remote host.
+type HostConns struct {
+	Host  string `json:"host"`
+	Conns int    `json:"conns"`
+}
+
+// topHosts returns up to n remote hosts with the largest number of active
+// connections in descending order.
+func (t *connTracker) topHosts(n int) (top []HostConns) {
+	t.mu.Lock()
+	top = make([]HostConns, 0, len(t.hosts))
+	for host, conns := range t.hosts {
+		top = append(top, HostConns{Host: host, Conns: conns})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Conns != top[j].Conns {
+			return top[i].Conns > top[j].Conns
+		}
+
+		return top[i].Host < top[j].Host
+	})
+
+	if len(top) > n {
+		top = top[:n]
+	}
+
+	return top
+}
+
+// TopHosts returns up to n remote hosts with the largest number of connections
+// that are being tunneled right now, in descending order.
+func (p *SNIProxy) TopHosts(n int) (top []HostConns) {
+	return p.conns.topHosts(n)
+}
+
+// list returns the active connections.
+func (t *connTracker) list() (conns []*activeConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conns = make([]*activeConn, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c)
+	}
+
+	return conns
+}
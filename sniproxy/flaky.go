@@ -0,0 +1,97 @@
+package sniproxy
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// DropMode defines how the connections that match drop rules are handled.
+type DropMode string
+
+const (
+	// DropModeDelay makes the proxy hold the connection for a period of time
+	// without tunneling any data and then close it.
+	DropModeDelay DropMode = "delay"
+
+	// DropModeFlaky makes the proxy tunnel the connection, but the data flows
+	// in bursts interrupted by stalls of random length.  It emulates a flaky
+	// network with packet loss.
+	DropModeFlaky DropMode = "flaky"
+)
+
+const (
+	// flakyMaxBurst is the maximum number of bytes that pass through a flaky
+	// connection between the stalls.
+	flakyMaxBurst = 16 * 1024
+
+	// flakyMaxStall is the maximum duration of a stall of a flaky
+	// connection.
+	flakyMaxStall = 3 * time.Second
+)
+
+// flakyReader is an io.Reader that passes the data in bursts of random size
+// and stalls for a random period of time between them.
+type flakyReader struct {
+	reader io.Reader
+
+	// done interrupts the stall when it is closed.
+	done <-chan struct{}
+
+	// left is the number of bytes left in the current burst.
+	left int
+}
+
+// type check
+var _ io.Reader = (*flakyReader)(nil)
+
+// newFlakyReader creates a new *flakyReader.  Closing done interrupts the
+// current stall and makes the reads fail with [net.ErrClosed], so that the
+// tunnel isn't held open by a stall after it is closed.
+func newFlakyReader(r io.Reader, done <-chan struct{}) (f *flakyReader) {
+	return &flakyReader{
+		reader: r,
+		done:   done,
+		left:   randomBurst(),
+	}
+}
+
+// Read implements the io.Reader interface for *flakyReader.
+func (f *flakyReader) Read(p []byte) (n int, err error) {
+	if f.left <= 0 {
+		err = f.stall(time.Duration(rand.Int63n(int64(flakyMaxStall))))
+		if err != nil {
+			return 0, err
+		}
+
+		f.left = randomBurst()
+	}
+
+	if len(p) > f.left {
+		p = p[:f.left]
+	}
+
+	n, err = f.reader.Read(p)
+	f.left -= n
+
+	return n, err
+}
+
+// stall waits for d or until f.done is closed.
+func (f *flakyReader) stall(d time.Duration) (err error) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-f.done:
+		return net.ErrClosed
+	}
+}
+
+// randomBurst returns a random burst size for a flaky connection.
+func randomBurst() (n int) {
+	return 1 + rand.Intn(flakyMaxBurst)
+}
@@ -0,0 +1,29 @@
+package sniproxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlakyReader_stall(t *testing.T) {
+	done := make(chan struct{})
+	f := newFlakyReader(strings.NewReader(""), done)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(done)
+	}()
+
+	start := time.Now()
+	err := f.stall(time.Hour)
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Less(t, time.Since(start), testTimeout)
+
+	// Nothing interrupts the short stalls.
+	f = newFlakyReader(strings.NewReader(""), make(chan struct{}))
+	assert.NoError(t, f.stall(time.Millisecond))
+}
@@ -0,0 +1,345 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+
+	// Besides the types used here, the import registers HTTP and HTTPS
+	// proxies.
+	"github.com/ameshkov/sniproxy/internal/httpupstream"
+	"golang.org/x/net/proxy"
+)
+
+// forwardFailureCooldown is a period of time during which a forward proxy that
+// failed to establish a connection is considered unhealthy.  Unhealthy proxies
+// are only used when there are no healthy ones left.
+const forwardFailureCooldown = 30 * time.Second
+
+// forwardSchemes are the supported forward proxy URL schemes.  SOCKS5 schemes
+// are supported by [proxy.FromURL], HTTP ones are registered by httpupstream.
+var forwardSchemes = []string{"socks5", "socks5h", "http", "https"}
+
+// forwardDialer is a forward proxy dialer that keeps track of the proxy
+// health.
+type forwardDialer struct {
+	// dialer is the actual proxy dialer.
+	dialer proxy.Dialer
+
+	// addr is the redacted proxy URL, used for logging.
+	addr string
+
+	// hostPort is the address of the proxy server.
+	hostPort string
+
+	// failedUntil is the Unix time in nanoseconds until which the proxy is
+	// considered unhealthy.
+	failedUntil atomic.Int64
+}
+
+// newForwardDialer creates a new *forwardDialer for the specified proxy URL.
+// The credentials for the proxy, if any, are taken from the URL userinfo.
+// probeTimeout and connectTimeout are only used by HTTP proxies, see
+// [httpupstream.HTTPProxyDialer.SetProbeTimeout] and
+// [httpupstream.HTTPProxyDialer.SetConnectTimeout].
+func newForwardDialer(
+	proxyURL string,
+	dialer proxy.Dialer,
+	probeTimeout time.Duration,
+	connectTimeout time.Duration,
+) (d *forwardDialer, err error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("sniproxy: failed to parse forward-proxy %s: %w", proxyURL, err)
+	}
+
+	if !supportedForwardScheme(u.Scheme) {
+		return nil, fmt.Errorf(
+			"sniproxy: failed to init forward-proxy %s: unsupported scheme %q, supported schemes: %s",
+			u.Redacted(),
+			u.Scheme,
+			strings.Join(forwardSchemes, ", "),
+		)
+	}
+
+	proxyDialer, err := proxy.FromURL(u, dialer)
+	if err != nil {
+		return nil, fmt.Errorf("sniproxy: failed to init forward-proxy %s: %w", u.Redacted(), err)
+	}
+
+	if httpDialer, ok := proxyDialer.(*httpupstream.HTTPProxyDialer); ok {
+		httpDialer.SetProbeTimeout(probeTimeout)
+		httpDialer.SetConnectTimeout(connectTimeout)
+	}
+
+	return &forwardDialer{
+		dialer:   proxyDialer,
+		addr:     u.Redacted(),
+		hostPort: proxyHostPort(u),
+	}, nil
+}
+
+// proxyHostPort returns the address of the proxy server from its URL.  If the
+// URL has no port, the default port of the scheme is used.
+func proxyHostPort(u *url.URL) (hostPort string) {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		default:
+			port = "1080"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// supportedForwardScheme checks if scheme is one of forwardSchemes.
+func supportedForwardScheme(scheme string) (ok bool) {
+	for _, s := range forwardSchemes {
+		if s == scheme {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkForwardProxies makes sure that the forward proxies are reachable, i.e.
+// that at least one of the common forward proxies and every per-rule proxy
+// accept TCP connections.
+func (p *SNIProxy) checkForwardProxies() (err error) {
+	if len(p.forwardDialers) > 0 {
+		var errs []error
+		for _, d := range p.forwardDialers {
+			err = d.checkReachable(p.dialer)
+			if err == nil {
+				break
+			}
+
+			errs = append(errs, err)
+		}
+
+		if len(errs) == len(p.forwardDialers) {
+			return fmt.Errorf("no forward proxy is reachable: %w", errors.Join(errs...))
+		}
+	}
+
+	for _, r := range p.forwardProxyRules {
+		err = r.dialer.checkReachable(p.dialer)
+		if err != nil {
+			return fmt.Errorf("forward proxy for %s: %w", r.wildcard, err)
+		}
+	}
+
+	return nil
+}
+
+// checkReachable checks if the proxy server accepts TCP connections.
+func (d *forwardDialer) checkReachable(dialer proxy.Dialer) (err error) {
+	conn, err := dialer.Dial("tcp", d.hostPort)
+	if err != nil {
+		return fmt.Errorf("forward proxy %s is unreachable: %w", d.addr, err)
+	}
+
+	log.OnCloserError(conn, log.DEBUG)
+
+	log.Debug("sniproxy: forward proxy %s is reachable", d.addr)
+
+	return nil
+}
+
+// ForwardProxyRule defines a forward proxy that is used for the connections
+// to the domains that match Wildcard.
+type ForwardProxyRule struct {
+	// Wildcard defines what connections the rule applies to.
+	Wildcard string `json:"wildcard"`
+
+	// Proxy is the URL of the SOCKS5/HTTP/HTTPS proxy.  It may contain
+	// credentials specific to this proxy.
+	Proxy string `json:"proxy"`
+}
+
+// ParseForwardProxyRule parses a forward proxy rule in the "wildcard=proxyURL"
+// format.
+func ParseForwardProxyRule(s string) (r ForwardProxyRule, err error) {
+	w, proxyURL, ok := strings.Cut(s, "=")
+	if !ok || w == "" || proxyURL == "" {
+		return r, fmt.Errorf("invalid forward proxy rule %q, expected wildcard=proxyURL", s)
+	}
+
+	return ForwardProxyRule{Wildcard: w, Proxy: proxyURL}, nil
+}
+
+// forwardProxyRule is a compiled ForwardProxyRule.
+type forwardProxyRule struct {
+	wildcard string
+	dialer   *forwardDialer
+}
+
+// healthy returns true if the proxy has not failed recently.
+func (d *forwardDialer) healthy(now time.Time) (ok bool) {
+	return now.UnixNano() >= d.failedUntil.Load()
+}
+
+// markFailed marks the proxy as unhealthy for forwardFailureCooldown.
+func (d *forwardDialer) markFailed(now time.Time) {
+	d.failedUntil.Store(now.Add(forwardFailureCooldown).UnixNano())
+}
+
+// markHealthy resets the proxy failure state.
+func (d *forwardDialer) markHealthy() {
+	d.failedUntil.Store(0)
+}
+
+// forwardDialersFor returns the forward proxy dialers that should be used for
+// the connection and the rule that matched it.  Per-rule proxies have priority
+// over the common ones.  It returns nil if the connection should not be
+// forwarded.  rule is empty if the connection is forwarded without a matching
+// rule, e.g. because of an inspector.
+func (p *SNIProxy) forwardDialersFor(ctx *SNIContext) (dialers []*forwardDialer, rule string) {
+	for _, r := range p.forwardProxyRules {
+		if matchHostWildcard(ctx, r.wildcard) {
+			p.ruleStats.Inc(ruleKindForwardProxy, r.wildcard)
+
+			return []*forwardDialer{r.dialer}, r.wildcard
+		}
+	}
+
+	if ctx.forceForward {
+		return p.forwardDialers, ""
+	}
+
+	if rule, ok := p.shouldForward(ctx); ok {
+		return p.forwardDialers, rule
+	}
+
+	return nil, ""
+}
+
+// dialForward opens a connection to the remote address through one of the
+// forward proxies.  Proxies are tried in the order they were configured,
+// healthy proxies go first.  It only fails when none of the proxies were able
+// to establish the connection.  A proxy is only marked failed if the proxy
+// itself doesn't work, not if it reported that the target is unreachable.
+//
+// By default, the remote address is passed to the proxies as is, i.e. the
+// hostname from SNI or the Host header, so that it is resolved by the proxy
+// and not by sniproxy.  SOCKS5 proxies receive it as a domain name (ATYP 0x03)
+// for both socks5:// and socks5h:// URLs.  See [ForwardResolveLocal] for the
+// other way.
+func (p *SNIProxy) dialForward(
+	ctx *SNIContext,
+	dialers []*forwardDialer,
+) (conn net.Conn, err error) {
+	addr, err := p.forwardTarget(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, d := range orderForwardDialers(dialers) {
+		conn, err = d.dialer.Dial("tcp", addr)
+		if err == nil {
+			d.markHealthy()
+
+			return conn, nil
+		}
+
+		log.Debug("sniproxy: [%d] forward proxy %s failed: %v", ctx.ID, d.addr, err)
+
+		if !isTargetError(err) {
+			d.markFailed(time.Now())
+		}
+
+		errs = append(errs, fmt.Errorf("forward proxy %s: %w", d.addr, err))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// forwardTarget returns the address that is sent to the forward proxies.  It is
+// the remote address with the hostname resolved if the hostnames are resolved
+// locally.  Onion hosts are never resolved locally.
+func (p *SNIProxy) forwardTarget(ctx *SNIContext) (addr string, err error) {
+	if p.forwardResolve != ForwardResolveLocal || isOnion(ctx.RemoteHost) {
+		return ctx.RemoteAddr, nil
+	}
+
+	if _, err = netip.ParseAddr(ctx.RemoteHost); err == nil {
+		return ctx.RemoteAddr, nil
+	}
+
+	_, port, err := netutil.SplitHostPort(ctx.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := p.resolveRemoteHost(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return netutil.JoinHostPort(addrs[0].String(), port), nil
+}
+
+// socksTargetReplies are the SOCKS5 reply codes, as formatted by the SOCKS5
+// client, that mean that the proxy works, but the target is not reachable
+// through it.
+var socksTargetReplies = []string{
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+}
+
+// isTargetError checks if err returned by a forward proxy dialer is caused by
+// the target rather than the proxy, so the proxy must not be marked failed.
+func isTargetError(err error) (ok bool) {
+	var targetErr *httpupstream.TargetError
+	if errors.As(err, &targetErr) {
+		return true
+	}
+
+	// The SOCKS5 client doesn't export the reply code, it is only a part of
+	// the message, e.g. "socks connect tcp 1.2.3.4:1080->example.org:443:
+	// unknown error host unreachable".
+	msg := err.Error()
+	for _, r := range socksTargetReplies {
+		if strings.HasSuffix(msg, "unknown error "+r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// orderForwardDialers returns forward proxy dialers in the order they should be
+// tried, i.e. healthy proxies first and then unhealthy ones as the last resort.
+func orderForwardDialers(all []*forwardDialer) (dialers []*forwardDialer) {
+	now := time.Now()
+
+	dialers = make([]*forwardDialer, 0, len(all))
+	var unhealthy []*forwardDialer
+	for _, d := range all {
+		if d.healthy(now) {
+			dialers = append(dialers, d)
+		} else {
+			unhealthy = append(unhealthy, d)
+		}
+	}
+
+	return append(dialers, unhealthy...)
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package sniproxy
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// freebindControl is a [net.ListenConfig.Control] function that sets
+// IP_FREEBIND or IPV6_FREEBIND on the listening socket so that it can be bound
+// to an address that is not assigned to the host yet.
+func freebindControl(network, _ string, c syscall.RawConn) (err error) {
+	var sockErr error
+	err = c.Control(func(fd uintptr) {
+		if network == "tcp6" {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_FREEBIND, 1)
+		} else {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_FREEBIND, 1)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("sniproxy: failed to control raw connection: %w", err)
+	}
+
+	if sockErr != nil {
+		return fmt.Errorf("sniproxy: failed to set freebind: %w", sockErr)
+	}
+
+	return nil
+}
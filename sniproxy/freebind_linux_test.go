@@ -0,0 +1,73 @@
+//go:build linux
+
+package sniproxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// getFreebind returns IP_FREEBIND of the listener.
+func getFreebind(t *testing.T, l *net.TCPListener) (v int) {
+	t.Helper()
+
+	rc, err := l.SyscallConn()
+	require.NoError(t, err)
+
+	var optErr error
+	err = rc.Control(func(fd uintptr) {
+		v, optErr = unix.GetsockoptInt(int(fd), unix.SOL_IP, unix.IP_FREEBIND)
+	})
+	require.NoError(t, err)
+	require.NoError(t, optErr)
+
+	return v
+}
+
+func TestSNIProxy_Start_freebind(t *testing.T) {
+	// nonLocalAddr is an address from TEST-NET-1 that is not assigned to the
+	// host.
+	nonLocalAddr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)}
+
+	testCases := []struct {
+		name     string
+		freebind bool
+		wantErr  bool
+	}{{
+		name:     "freebind",
+		freebind: true,
+		wantErr:  false,
+	}, {
+		name:     "no_freebind",
+		freebind: false,
+		wantErr:  true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := New(&Config{
+				TLSListenAddr:  nonLocalAddr,
+				HTTPListenAddr: nonLocalAddr,
+				Freebind:       tc.freebind,
+			})
+			require.NoError(t, err)
+
+			err = p.Start()
+			if tc.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = p.Close() })
+
+			assert.Equal(t, 1, getFreebind(t, p.sniListener.(*net.TCPListener)))
+			assert.Equal(t, 1, getFreebind(t, p.plainListener.(*net.TCPListener)))
+		})
+	}
+}
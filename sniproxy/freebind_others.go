@@ -0,0 +1,14 @@
+//go:build !linux
+
+package sniproxy
+
+import (
+	"errors"
+	"syscall"
+)
+
+// freebindControl is a [net.ListenConfig.Control] function that sets
+// IP_FREEBIND on the listening socket.  It is only supported on Linux.
+func freebindControl(_, _ string, _ syscall.RawConn) (err error) {
+	return errors.New("sniproxy: freebind is only supported on linux")
+}
@@ -0,0 +1,33 @@
+package sniproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// isHealthProbe returns true if the plain HTTP request is a health probe that
+// must be answered by the proxy itself instead of being tunneled.  host is the
+// host from the request without the port.
+func (p *SNIProxy) isHealthProbe(req *http.Request, host string) (ok bool) {
+	if p.healthPath == "" || req == nil || req.URL.Path != p.healthPath {
+		return false
+	}
+
+	return p.healthHost == "" || strings.EqualFold(host, p.healthHost)
+}
+
+// respondHealth answers a health probe with 200 OK.
+func respondHealth(clientConn net.Conn) (err error) {
+	log.Debug("sniproxy: answering health probe from %s", clientConn.RemoteAddr())
+
+	err = writeHTTPResponse(clientConn, http.StatusOK, nil, []byte("OK\n"))
+	if err != nil {
+		return fmt.Errorf("sniproxy: failed to answer health probe: %w", err)
+	}
+
+	return nil
+}
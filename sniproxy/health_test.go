@@ -0,0 +1,101 @@
+package sniproxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_healthProbe(t *testing.T) {
+	// backend responds 204 to every request, so that the tunneled requests
+	// can be told apart from the health probes answered by the proxy.
+	backend := startBackend(t, func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = http.ReadRequest(bufio.NewReader(conn))
+		_, _ = io.WriteString(conn, "HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n")
+	})
+
+	const healthPath = "/healthz"
+
+	testCases := []struct {
+		name       string
+		healthPath string
+		healthHost string
+		host       string
+		path       string
+		wantCode   int
+	}{{
+		name:       "disabled",
+		healthPath: "",
+		healthHost: "",
+		host:       backend,
+		path:       healthPath,
+		wantCode:   http.StatusNoContent,
+	}, {
+		name:       "any_host",
+		healthPath: healthPath,
+		healthHost: "",
+		host:       backend,
+		path:       healthPath,
+		wantCode:   http.StatusOK,
+	}, {
+		name:       "other_path",
+		healthPath: healthPath,
+		healthHost: "",
+		host:       backend,
+		path:       "/",
+		wantCode:   http.StatusNoContent,
+	}, {
+		name:       "host_match",
+		healthPath: healthPath,
+		healthHost: "127.0.0.1.",
+		host:       backend,
+		path:       healthPath,
+		wantCode:   http.StatusOK,
+	}, {
+		name:       "host_mismatch",
+		healthPath: healthPath,
+		healthHost: "health.example",
+		host:       backend,
+		path:       healthPath,
+		wantCode:   http.StatusNoContent,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := startProxy(t, &Config{
+				HealthPath: tc.healthPath,
+				HealthHost: tc.healthHost,
+			})
+
+			conn, err := net.Dial("tcp", p.plainListener.Addr().String())
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+
+			_, err = fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", tc.path, tc.host)
+			require.NoError(t, err)
+
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = resp.Body.Close() })
+
+			assert.Equal(t, tc.wantCode, resp.StatusCode)
+
+			if tc.wantCode == http.StatusOK {
+				body, rErr := io.ReadAll(resp.Body)
+				require.NoError(t, rErr)
+
+				assert.Equal(t, "OK\n", string(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package sniproxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// writeHTTPResponse writes a simple HTTP/1.1 response to w.  The response
+// always asks the client to close the connection as the proxy does not serve
+// any further requests on it.
+func writeHTTPResponse(w io.Writer, statusCode int, header http.Header, body []byte) (err error) {
+	if header == nil {
+		header = http.Header{}
+	}
+
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	resp := &http.Response{
+		StatusCode:    statusCode,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         true,
+	}
+
+	return resp.Write(w)
+}
@@ -0,0 +1,125 @@
+package sniproxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_expectContinue(t *testing.T) {
+	const body = "request body"
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// The server only sends 100 Continue when the handler reads the body.
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			_, _ = w.Write(b)
+		}),
+	}
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	p := startProxy(t, nil)
+	proxyAddr := p.plainListener.Addr().String()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (conn net.Conn, err error) {
+				return (&net.Dialer{}).DialContext(ctx, network, proxyAddr)
+			},
+			// The body is sent without waiting for 100 Continue only after
+			// this timeout, which is longer than the test timeout.
+			ExpectContinueTimeout: 2 * testTimeout,
+		},
+		Timeout: testTimeout,
+	}
+	t.Cleanup(client.CloseIdleConnections)
+
+	var got100 bool
+	trace := &httptrace.ClientTrace{
+		Got100Continue: func() { got100 = true },
+	}
+
+	ctx := httptrace.WithClientTrace(context.Background(), trace)
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		"http://"+l.Addr().String()+"/upload",
+		strings.NewReader(body),
+	)
+	require.NoError(t, err)
+	req.Header.Set("Expect", "100-continue")
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	assert.True(t, got100)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(b))
+}
+
+func TestSNIProxy_allowedPorts(t *testing.T) {
+	// okBackend responds 200 to every request.
+	okBackend := func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = http.ReadRequest(bufio.NewReader(conn))
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+	}
+
+	host := startBackend(t, okBackend)
+	_, portStr, err := net.SplitHostPort(host)
+	require.NoError(t, err)
+
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		allowed  []int
+		wantCode int
+	}{{
+		name:     "allowed",
+		allowed:  []int{80, 443, port},
+		wantCode: http.StatusOK,
+	}, {
+		name:     "disallowed",
+		allowed:  []int{80, 443},
+		wantCode: http.StatusForbidden,
+	}, {
+		name:     "all",
+		allowed:  nil,
+		wantCode: http.StatusOK,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := startProxy(t, &Config{AllowedPorts: tc.allowed})
+			conn := dialHTTP(t, p, host)
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+
+			resp, rErr := http.ReadResponse(bufio.NewReader(conn), nil)
+			require.NoError(t, rErr)
+			t.Cleanup(func() { _ = resp.Body.Close() })
+
+			assert.Equal(t, tc.wantCode, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package sniproxy
+
+import (
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/audit"
+	"github.com/ameshkov/sniproxy/internal/filter"
+)
+
+// HTTPSOnlyMode defines how plain HTTP connections to the domains that must
+// only be accessed over HTTPS are handled.
+//
+// Deprecated: Use [PolicyMode].
+type HTTPSOnlyMode = PolicyMode
+
+const (
+	// HTTPSOnlyModeLog makes the proxy log such connections and tunnel them
+	// as usual.
+	//
+	// Deprecated: Use [PolicyModeLog].
+	HTTPSOnlyModeLog = PolicyModeLog
+
+	// HTTPSOnlyModeBlock makes the proxy log and close such connections.
+	//
+	// Deprecated: Use [PolicyModeBlock].
+	HTTPSOnlyModeBlock = PolicyModeBlock
+)
+
+// blocksPlainHTTP checks if the plain HTTP connection goes to an HTTPS-only
+// domain, logs it, and returns true if the connection must be blocked.
+func (p *SNIProxy) blocksPlainHTTP(ctx *SNIContext) (ok bool) {
+	rule, matched := filter.MatchedWildcard(ctx.RemoteHost, ctx.rules.HTTPSOnlyRules)
+	if !matched {
+		return false
+	}
+
+	p.ruleStats.Inc(ruleKindHTTPSOnly, rule)
+
+	if p.httpsOnlyMode == PolicyModeBlock {
+		log.Info(
+			"sniproxy: [%d] blocked plain HTTP connection from %s to HTTPS-only domain %s by rule %s",
+			ctx.ID,
+			ctx.ClientAddr,
+			ctx.RemoteHost,
+			rule,
+		)
+		p.audit(ctx, audit.ActionBlock, rule, "plain http")
+
+		return true
+	}
+
+	log.Info(
+		"sniproxy: [%d] plain HTTP connection from %s to HTTPS-only domain %s by rule %s",
+		ctx.ID,
+		ctx.ClientAddr,
+		ctx.RemoteHost,
+		rule,
+	)
+
+	return false
+}
@@ -0,0 +1,66 @@
+package sniproxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_blocksPlainHTTP(t *testing.T) {
+	testCases := []struct {
+		name       string
+		mode       PolicyMode
+		wantTunnel bool
+	}{{
+		name:       "default",
+		mode:       "",
+		wantTunnel: true,
+	}, {
+		name:       "log",
+		mode:       PolicyModeLog,
+		wantTunnel: true,
+	}, {
+		name:       "block",
+		mode:       PolicyModeBlock,
+		wantTunnel: false,
+	}, {
+		name:       "deprecated_log",
+		mode:       HTTPSOnlyModeLog,
+		wantTunnel: true,
+	}, {
+		name:       "deprecated_block",
+		mode:       HTTPSOnlyModeBlock,
+		wantTunnel: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reached := make(chan struct{}, 1)
+			backend := startBackend(t, func(conn net.Conn) {
+				reached <- struct{}{}
+				_ = conn.Close()
+			})
+
+			var mode HTTPSOnlyMode = tc.mode
+			p := startProxy(t, &Config{
+				HTTPSOnlyRules: []string{"127.0.0.1"},
+				HTTPSOnlyMode:  mode,
+			})
+
+			conn := dialHTTP(t, p, backend)
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+			_, _ = io.ReadAll(conn)
+
+			select {
+			case <-reached:
+				assert.True(t, tc.wantTunnel)
+			default:
+				assert.False(t, tc.wantTunnel)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package sniproxy
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// livenessProbes is the number of unanswered liveness probes after which the
+// peer is considered dead.
+const livenessProbes = 3
+
+// activity tracks the time of the last data transfer in a tunnel.
+type activity struct {
+	// last is the time of the last transfer in Unix nanoseconds.
+	last atomic.Int64
+}
+
+// newActivity creates a new *activity that starts at now.
+func newActivity(now time.Time) (a *activity) {
+	a = &activity{}
+	a.touch(now)
+
+	return a
+}
+
+// touch records a data transfer.
+func (a *activity) touch(now time.Time) {
+	a.last.Store(now.UnixNano())
+}
+
+// idle returns the time passed since the last data transfer.
+func (a *activity) idle(now time.Time) (d time.Duration) {
+	return now.Sub(time.Unix(0, a.last.Load()))
+}
+
+// activityWriter is an io.Writer that records every successful write.
+type activityWriter struct {
+	writer   io.Writer
+	activity *activity
+}
+
+// type check
+var _ io.Writer = (*activityWriter)(nil)
+
+// Write implements the io.Writer interface for *activityWriter.
+func (w *activityWriter) Write(b []byte) (n int, err error) {
+	n, err = w.writer.Write(b)
+	if n > 0 {
+		w.activity.touch(time.Now())
+	}
+
+	return n, err
+}
+
+// reapIdle closes the tunnel with closeBoth once no data has been transferred
+// in either direction for idleTimeout.  It returns when the tunnel is closed or
+// done is closed.
+func reapIdle(ctx *SNIContext, idleTimeout time.Duration, closeBoth func(), done <-chan struct{}) {
+	period := idleTimeout / 4
+	if period <= 0 {
+		period = idleTimeout
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			idle := ctx.activity.idle(now)
+			if idle >= idleTimeout {
+				log.Info("sniproxy: [%d] closing tunnel idle for %v", ctx.ID, idle.Round(time.Second))
+
+				closeBoth()
+
+				return
+			}
+		}
+	}
+}
+
+// enableLivenessProbes makes the kernel probe the peer of the connection after
+// it is idle for the liveness interval.  If the peer does not answer
+// livenessProbes probes in a row, the connection fails and the tunnel is
+// closed.  Unlike tunneled data, the probes are not visible to the peer
+// application.  Connections other than TCP ones are skipped.
+func (p *SNIProxy) enableLivenessProbes(ctx *SNIContext, conn net.Conn) {
+	tcpConn, ok := unwrapTCPConn(conn)
+	if !ok {
+		log.Debug("sniproxy: [%d] cannot probe liveness of %T", ctx.ID, conn)
+
+		return
+	}
+
+	err := tcpConn.SetKeepAlive(true)
+	if err == nil {
+		err = tcpConn.SetKeepAlivePeriod(p.livenessInterval)
+	}
+	if err == nil {
+		err = setKeepAliveProbes(tcpConn, p.livenessInterval, livenessProbes)
+	}
+	if err != nil {
+		log.Debug("sniproxy: [%d] failed to enable liveness probes: %v", ctx.ID, err)
+	}
+}
+
+// unwrapTCPConn returns the underlying *net.TCPConn of the connection if there
+// is one.
+func unwrapTCPConn(conn net.Conn) (tcpConn *net.TCPConn, ok bool) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, true
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//go:build linux
+
+package sniproxy
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// tcpOpts returns the keep-alive socket options of conn.
+func tcpOpts(t *testing.T, conn *net.TCPConn) (keepAlive, idle, intvl, cnt int) {
+	t.Helper()
+
+	rc, err := conn.SyscallConn()
+	require.NoError(t, err)
+
+	var errs [4]error
+	err = rc.Control(func(fd uintptr) {
+		keepAlive, errs[0] = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_KEEPALIVE)
+		idle, errs[1] = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPIDLE)
+		intvl, errs[2] = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPINTVL)
+		cnt, errs[3] = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPCNT)
+	})
+	require.NoError(t, err)
+
+	for _, sockErr := range errs {
+		require.NoError(t, sockErr)
+	}
+
+	return keepAlive, idle, intvl, cnt
+}
+
+func TestSNIProxy_enableLivenessProbes(t *testing.T) {
+	const interval = 7 * time.Second
+
+	p := &SNIProxy{livenessInterval: interval}
+	ctx := NewSNIContext("example.org", "example.org:443")
+
+	testCases := []struct {
+		wrap func(conn *net.TCPConn) (c net.Conn)
+		name string
+	}{{
+		wrap: func(conn *net.TCPConn) (c net.Conn) { return conn },
+		name: "tcp",
+	}, {
+		wrap: func(conn *net.TCPConn) (c net.Conn) { return tls.Client(conn, &tls.Config{}) },
+		name: "tls",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, _ := tcpPipe(t)
+			p.enableLivenessProbes(ctx, tc.wrap(conn))
+
+			keepAlive, idle, intvl, cnt := tcpOpts(t, conn)
+			assert.Equal(t, 1, keepAlive)
+			assert.Equal(t, int(interval/time.Second), idle)
+			assert.Equal(t, int(interval/time.Second), intvl)
+			assert.Equal(t, livenessProbes, cnt)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package sniproxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// Verdict is the routing decision of an [Inspector].
+type Verdict int
+
+const (
+	// VerdictContinue passes the connection to the next inspector and then to
+	// the standard rules.
+	VerdictContinue Verdict = iota
+
+	// VerdictAllow tunnels the connection skipping the expected SNI, block,
+	// HTTPS-only, minimum TLS version, and drop rules.  Forward rules still
+	// apply.
+	VerdictAllow
+
+	// VerdictBlock blocks the connection the same way as a block rule does.
+	VerdictBlock
+
+	// VerdictForward tunnels the connection through the forward proxies
+	// regardless of the forward rules.  If there are no forward proxies, it is
+	// the same as VerdictAllow.
+	VerdictForward
+
+	// VerdictRedirect tunnels the connection to Decision.Host instead of the
+	// requested host skipping the same rules as VerdictAllow.
+	VerdictRedirect
+)
+
+// String implements the [fmt.Stringer] interface for Verdict.
+func (v Verdict) String() (s string) {
+	switch v {
+	case VerdictContinue:
+		return "continue"
+	case VerdictAllow:
+		return "allow"
+	case VerdictBlock:
+		return "block"
+	case VerdictForward:
+		return "forward"
+	case VerdictRedirect:
+		return "redirect"
+	default:
+		return "verdict" + strconv.Itoa(int(v))
+	}
+}
+
+// Decision is the result of an [Inspector].
+type Decision struct {
+	// Host is the host the connection is redirected to when Verdict is
+	// VerdictRedirect.  It may contain a port, otherwise the requested port is
+	// used.
+	Host string
+
+	// Verdict is the routing decision.
+	Verdict Verdict
+}
+
+// Inspection is the information about a connection passed to an [Inspector].
+// Inspectors must not modify it.
+type Inspection struct {
+	// Context is the context of the connection.
+	Context *SNIContext
+
+	// ClientHello is the parsed TLS ClientHello.  It is nil for plain HTTP
+	// connections and for the connections passed through without parsing.
+	ClientHello *tls.ClientHelloInfo
+
+	// Request is the parsed HTTP request without the body.  It is nil for TLS
+	// connections.
+	Request *http.Request
+
+	// Peeked are the bytes read from the client to parse the server name.
+	Peeked []byte
+}
+
+// Inspector inspects a connection after the server name has been parsed and
+// before the standard rules are applied.  Inspectors are called in order, the
+// first one that returns a verdict other than VerdictContinue decides what
+// happens to the connection.  Inspectors are called concurrently for
+// different connections.
+type Inspector func(in *Inspection) (d Decision)
+
+// inspect runs the inspectors and returns the first decisive result.  It
+// returns VerdictContinue if there are no inspectors or none of them decided.
+func (p *SNIProxy) inspect(ctx *SNIContext, info *peekInfo) (d Decision) {
+	if len(p.inspectors) == 0 {
+		return Decision{Verdict: VerdictContinue}
+	}
+
+	in := &Inspection{
+		Context:     ctx,
+		ClientHello: info.clientHello,
+		Request:     info.request,
+		Peeked:      info.peeked,
+	}
+
+	for _, inspector := range p.inspectors {
+		d = inspector(in)
+		if d.Verdict != VerdictContinue {
+			log.Debug("sniproxy: [%d] inspector decided to %s", ctx.ID, d.Verdict)
+
+			return d
+		}
+	}
+
+	return Decision{Verdict: VerdictContinue}
+}
+
+// applyInspectors runs the inspectors and applies their decision to the
+// connection.  decided is true if the inspectors' decision overrides the
+// rules.  proceed is false if the connection must not be tunneled.
+func (p *SNIProxy) applyInspectors(
+	ctx *SNIContext,
+	clientConn net.Conn,
+	info *peekInfo,
+	plainHTTP bool,
+) (decided, proceed bool) {
+	d := p.inspect(ctx, info)
+	switch d.Verdict {
+	case VerdictBlock:
+		p.block(ctx, clientConn, plainHTTP, "")
+
+		return true, false
+	case VerdictForward:
+		ctx.forceForward = true
+	case VerdictRedirect:
+		redirect(ctx, d.Host)
+
+		// The port may be changed by the redirect.
+		_, port, err := netutil.SplitHostPort(ctx.RemoteAddr)
+		if err != nil || !p.portAllowed(port) {
+			err = rejectDisallowedPort(ctx, clientConn, info.request)
+			if err != nil {
+				log.Debug("%v", err)
+			}
+
+			return true, false
+		}
+	}
+
+	return d.Verdict != VerdictContinue, true
+}
+
+// redirect changes the remote host of the connection to host.  If host has no
+// port, the requested port is kept.
+func redirect(ctx *SNIContext, host string) {
+	if h, port, err := netutil.SplitHostPort(host); err == nil {
+		ctx.RemoteHost = h
+		ctx.RemoteAddr = netutil.JoinHostPort(h, port)
+	} else if _, port, err = netutil.SplitHostPort(ctx.RemoteAddr); err == nil {
+		ctx.RemoteHost = host
+		ctx.RemoteAddr = netutil.JoinHostPort(host, port)
+	}
+
+	log.Info("sniproxy: [%d] redirected connection to %s", ctx.ID, ctx.RemoteAddr)
+}
@@ -0,0 +1,134 @@
+package sniproxy
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// markerBackend returns a backend that sends marker and closes the
+// connection.
+func markerBackend(marker string) (handle func(conn net.Conn)) {
+	return func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = io.WriteString(conn, marker)
+	}
+}
+
+func TestSNIProxy_inspect(t *testing.T) {
+	requested := startBackend(t, markerBackend("requested"))
+	other := startBackend(t, markerBackend("other"))
+
+	testCases := []struct {
+		name         string
+		decision     Decision
+		blockRules   []string
+		allowedPorts []int
+		want         string
+		wantBlocked  bool
+	}{{
+		name:         "continue",
+		decision:     Decision{Verdict: VerdictContinue},
+		blockRules:   nil,
+		allowedPorts: nil,
+		want:         "requested",
+		wantBlocked:  false,
+	}, {
+		name:         "continue_blocked",
+		decision:     Decision{Verdict: VerdictContinue},
+		blockRules:   []string{"127.0.0.1"},
+		allowedPorts: nil,
+		want:         "",
+		wantBlocked:  true,
+	}, {
+		name:         "allow_overrides_block_rule",
+		decision:     Decision{Verdict: VerdictAllow},
+		blockRules:   []string{"127.0.0.1"},
+		allowedPorts: nil,
+		want:         "requested",
+		wantBlocked:  false,
+	}, {
+		name:         "block",
+		decision:     Decision{Verdict: VerdictBlock},
+		blockRules:   nil,
+		allowedPorts: nil,
+		want:         "",
+		wantBlocked:  true,
+	}, {
+		name:         "redirect",
+		decision:     Decision{Verdict: VerdictRedirect, Host: other},
+		blockRules:   nil,
+		allowedPorts: []int{backendPort(t, requested), backendPort(t, other)},
+		want:         "other",
+		wantBlocked:  false,
+	}, {
+		name:         "redirect_disallowed_port",
+		decision:     Decision{Verdict: VerdictRedirect, Host: other},
+		blockRules:   nil,
+		allowedPorts: []int{backendPort(t, requested)},
+		want:         "HTTP/1.1 403 Forbidden",
+		wantBlocked:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := startProxy(t, &Config{
+				BlockRules:   tc.blockRules,
+				AllowedPorts: tc.allowedPorts,
+				Inspectors: []Inspector{func(_ *Inspection) (d Decision) {
+					return tc.decision
+				}},
+			})
+
+			buf := captureLog(t)
+
+			conn := dialHTTP(t, p, requested)
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+
+			resp, err := io.ReadAll(conn)
+			require.NoError(t, err)
+
+			// Close the proxy so that the handler finishes logging.
+			require.NoError(t, p.Close())
+
+			if tc.want == "" {
+				assert.Empty(t, resp)
+			} else {
+				assert.True(t, strings.HasPrefix(string(resp), tc.want))
+			}
+			assert.Equal(t, tc.wantBlocked, strings.Contains(buf.String(), "blocked connection"))
+		})
+	}
+}
+
+func TestSNIProxy_inspect_peeked(t *testing.T) {
+	backend := startBackend(t, markerBackend("requested"))
+
+	peeked := make(chan []byte, 1)
+	p := startProxy(t, &Config{
+		Inspectors: []Inspector{func(in *Inspection) (d Decision) {
+			peeked <- in.Peeked
+
+			return Decision{Verdict: VerdictContinue}
+		}},
+	})
+
+	conn := dialHTTP(t, p, backend)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+
+	_, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	select {
+	case b := <-peeked:
+		assert.Contains(t, string(b), "GET / HTTP/1.1\r\nHost: "+backend)
+	case <-time.After(testTimeout):
+		t.Fatal("inspector isn't called")
+	}
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package sniproxy
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// setKeepAliveProbes sets the interval between keep-alive probes and the number
+// of unanswered probes after which the connection is dropped.  Depending on the
+// Go version, SetKeepAlivePeriod may only set the idle time before the first
+// probe, so the interval is set explicitly.
+func setKeepAliveProbes(conn *net.TCPConn, interval time.Duration, n int) (err error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("sniproxy: failed to get raw connection: %w", err)
+	}
+
+	secs := int((interval + time.Second - 1) / time.Second)
+
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, secs)
+		if sockErr == nil {
+			sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPCNT, n)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("sniproxy: failed to control raw connection: %w", err)
+	}
+
+	return sockErr
+}
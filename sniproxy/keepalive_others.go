@@ -0,0 +1,15 @@
+//go:build !linux
+
+package sniproxy
+
+import (
+	"net"
+	"time"
+)
+
+// setKeepAliveProbes sets the interval between keep-alive probes and the number
+// of unanswered probes after which the connection is dropped.  It is only
+// supported on Linux, the system defaults are used on other platforms.
+func setKeepAliveProbes(_ *net.TCPConn, _ time.Duration, _ int) (err error) {
+	return nil
+}
@@ -0,0 +1,68 @@
+package sniproxy
+
+import (
+	"net/netip"
+	"sync"
+)
+
+// ipLimiter limits the number of simultaneous connections from a single IP
+// address.
+type ipLimiter struct {
+	// mu protects conns.
+	mu sync.Mutex
+
+	// conns is the number of active connections per client IP.
+	conns map[netip.Addr]int
+
+	// max is the maximum number of connections from a single IP.
+	max int
+}
+
+// newIPLimiter creates a new *ipLimiter.  It returns nil if max is zero, i.e.
+// there is no limit.
+func newIPLimiter(max int) (l *ipLimiter) {
+	if max <= 0 {
+		return nil
+	}
+
+	return &ipLimiter{
+		conns: map[netip.Addr]int{},
+		max:   max,
+	}
+}
+
+// acquire increments the number of connections from ip.  It returns false if
+// the limit is exceeded, in this case the counter is not changed.  It is safe
+// to call it on nil *ipLimiter.
+func (l *ipLimiter) acquire(ip netip.Addr) (ok bool) {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conns[ip] >= l.max {
+		return false
+	}
+
+	l.conns[ip]++
+
+	return true
+}
+
+// release decrements the number of connections from ip.  It is safe to call
+// it on nil *ipLimiter.
+func (l *ipLimiter) release(ip netip.Addr) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.conns[ip]--
+	if l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
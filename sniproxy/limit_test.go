@@ -0,0 +1,74 @@
+package sniproxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_maxConnsPerIP(t *testing.T) {
+	// holdBackend keeps the connections open until the client closes them.
+	holdBackend := func(conn net.Conn) {
+		_, _ = conn.Read(make([]byte, 1024))
+		_, _ = conn.Read(make([]byte, 1024))
+		_ = conn.Close()
+	}
+
+	testCases := []struct {
+		// occupy takes up the only connection allowed for the client.
+		occupy func(t *testing.T, p *SNIProxy, host string)
+		name   string
+	}{{
+		occupy: func(t *testing.T, p *SNIProxy, host string) {
+			dialHTTP(t, p, host)
+		},
+		name: "tunnel",
+	}, {
+		occupy: func(t *testing.T, p *SNIProxy, _ string) {
+			// The client doesn't send anything, so the connection is stuck
+			// in peeking the server name.
+			conn, err := net.Dial("tcp", p.plainListener.Addr().String())
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+		},
+		name: "peek",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := startProxy(t, &Config{
+				MaxConnsPerIP:   1,
+				LimitRetryAfter: 5 * time.Second,
+			})
+			host := startBackend(t, holdBackend)
+
+			tc.occupy(t, p, host)
+
+			// The occupying connection may not be handled yet, so retry until
+			// the limit is reached.
+			var resp *http.Response
+			require.Eventually(t, func() (ok bool) {
+				conn := dialHTTP(t, p, host)
+				_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+				r, err := http.ReadResponse(bufio.NewReader(conn), nil)
+				if err != nil {
+					return false
+				}
+
+				resp = r
+
+				return true
+			}, testTimeout, 10*time.Millisecond)
+			t.Cleanup(func() { _ = resp.Body.Close() })
+
+			assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+			assert.Equal(t, "5", resp.Header.Get("Retry-After"))
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package sniproxy
+
+import (
+	"net"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// setLinger sets SO_LINGER of the tunneled connection to the configured
+// value.  If it is not configured, the OS default is kept.  Connections that
+// aren't TCP, e.g. the ones wrapped by a forward proxy dialer, are left as is.
+func (p *SNIProxy) setLinger(ctx *SNIContext, conn net.Conn) {
+	if p.linger == nil {
+		return
+	}
+
+	tcpConn, ok := unwrapTCPConn(conn)
+	if !ok {
+		log.Debug("sniproxy: [%d] cannot set linger of %T", ctx.ID, conn)
+
+		return
+	}
+
+	err := tcpConn.SetLinger(*p.linger)
+	if err != nil {
+		log.Debug("sniproxy: [%d] failed to set linger: %v", ctx.ID, err)
+	}
+}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package sniproxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// getLinger returns SO_LINGER of conn.
+func getLinger(t *testing.T, conn *net.TCPConn) (l *unix.Linger) {
+	t.Helper()
+
+	rc, err := conn.SyscallConn()
+	require.NoError(t, err)
+
+	var optErr error
+	err = rc.Control(func(fd uintptr) {
+		l, optErr = unix.GetsockoptLinger(int(fd), unix.SOL_SOCKET, unix.SO_LINGER)
+	})
+	require.NoError(t, err)
+	require.NoError(t, optErr)
+
+	return l
+}
+
+func TestSNIProxy_setLinger(t *testing.T) {
+	zero, ten := 0, 10
+
+	testCases := []struct {
+		linger *int
+		want   unix.Linger
+		name   string
+	}{{
+		linger: nil,
+		want:   unix.Linger{Onoff: 0, Linger: 0},
+		name:   "os_default",
+	}, {
+		linger: &zero,
+		want:   unix.Linger{Onoff: 1, Linger: 0},
+		name:   "rst",
+	}, {
+		linger: &ten,
+		want:   unix.Linger{Onoff: 1, Linger: 10},
+		name:   "timeout",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := startBackend(t, func(conn net.Conn) {})
+			conn, err := net.Dial("tcp", backend)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+
+			p := &SNIProxy{linger: tc.linger}
+			p.setLinger(&SNIContext{}, conn)
+
+			assert.Equal(t, tc.want, *getLinger(t, conn.(*net.TCPConn)))
+		})
+	}
+}
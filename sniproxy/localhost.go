@@ -0,0 +1,113 @@
+package sniproxy
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// LocalHost is a virtual host served by the proxy itself on the HTTP listener,
+// e.g. a landing page or a captive portal notice.
+type LocalHost struct {
+	// Host is the host from the Host header without the port.  It is matched
+	// case-insensitively.
+	Host string `json:"host"`
+
+	// Path is the path to the file that is served for every request to Host.
+	Path string `json:"path"`
+}
+
+// ParseLocalHost parses a local virtual host in the "host=file" format.
+func ParseLocalHost(s string) (h LocalHost, err error) {
+	host, path, ok := strings.Cut(s, "=")
+	if !ok || host == "" || path == "" {
+		return h, fmt.Errorf("invalid local host %q, expected host=file", s)
+	}
+
+	return LocalHost{Host: host, Path: path}, nil
+}
+
+// localContent is the content of a local virtual host.
+type localContent struct {
+	contentType string
+	body        []byte
+}
+
+// loadLocalHosts reads the files of the local virtual hosts and returns their
+// contents by the lowercased host.
+func loadLocalHosts(hosts []LocalHost) (contents map[string]*localContent, err error) {
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+
+	contents = make(map[string]*localContent, len(hosts))
+	for _, h := range hosts {
+		host := strings.ToLower(strings.TrimSuffix(h.Host, "."))
+		if host == "" {
+			return nil, fmt.Errorf("sniproxy: empty local host for %s", h.Path)
+		} else if _, ok := contents[host]; ok {
+			return nil, fmt.Errorf("sniproxy: duplicate local host %s", host)
+		}
+
+		var body []byte
+		body, err = os.ReadFile(h.Path)
+		if err != nil {
+			return nil, fmt.Errorf("sniproxy: local host %s: %w", host, err)
+		}
+
+		contentType := mime.TypeByExtension(filepath.Ext(h.Path))
+		if contentType == "" {
+			contentType = http.DetectContentType(body)
+		}
+
+		contents[host] = &localContent{
+			contentType: contentType,
+			body:        body,
+		}
+	}
+
+	return contents, nil
+}
+
+// serveLocally answers the plain HTTP requests that the proxy serves itself,
+// i.e. the health probes and the requests to the local virtual hosts.  host is
+// the host from the request without the port.  served is true if the request
+// has been answered and must not be tunneled.
+func (p *SNIProxy) serveLocally(
+	clientConn net.Conn,
+	req *http.Request,
+	host string,
+) (served bool, err error) {
+	if p.isHealthProbe(req, host) {
+		return true, respondHealth(clientConn)
+	}
+
+	c, ok := p.localHosts[strings.ToLower(strings.TrimSuffix(host, "."))]
+	if !ok {
+		return false, nil
+	}
+
+	return true, respondLocal(clientConn, host, c)
+}
+
+// respondLocal answers the plain HTTP request to a local virtual host with its
+// content.
+func respondLocal(clientConn net.Conn, host string, c *localContent) (err error) {
+	log.Debug("sniproxy: serving local host %s to %s", host, clientConn.RemoteAddr())
+
+	h := http.Header{}
+	h.Set("Content-Type", c.contentType)
+
+	err = writeHTTPResponse(clientConn, http.StatusOK, h, c.body)
+	if err != nil {
+		return fmt.Errorf("sniproxy: failed to serve local host %s: %w", host, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package sniproxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_localHosts(t *testing.T) {
+	// backend responds 204 to every request, so that the tunneled requests
+	// can be told apart from the ones served by the proxy.
+	backend := startBackend(t, func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = http.ReadRequest(bufio.NewReader(conn))
+		_, _ = io.WriteString(conn, "HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n")
+	})
+
+	dir := t.TempDir()
+	htmlPath := filepath.Join(dir, "index.html")
+	require.NoError(t, os.WriteFile(htmlPath, []byte("<html>portal</html>"), 0o600))
+
+	textPath := filepath.Join(dir, "notice")
+	require.NoError(t, os.WriteFile(textPath, []byte("notice"), 0o600))
+
+	p := startProxy(t, &Config{
+		LocalHosts: []LocalHost{{
+			Host: "portal.example",
+			Path: htmlPath,
+		}, {
+			Host: "Notice.Example.",
+			Path: textPath,
+		}},
+	})
+
+	testCases := []struct {
+		name            string
+		host            string
+		wantCode        int
+		wantContentType string
+		wantBody        string
+	}{{
+		name:            "html",
+		host:            "portal.example",
+		wantCode:        http.StatusOK,
+		wantContentType: "text/html; charset=utf-8",
+		wantBody:        "<html>portal</html>",
+	}, {
+		name:            "detected_type",
+		host:            "notice.example:80",
+		wantCode:        http.StatusOK,
+		wantContentType: "text/plain; charset=utf-8",
+		wantBody:        "notice",
+	}, {
+		name:            "other",
+		host:            backend,
+		wantCode:        http.StatusNoContent,
+		wantContentType: "",
+		wantBody:        "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", p.plainListener.Addr().String())
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+
+			_, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", tc.host)
+			require.NoError(t, err)
+
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = resp.Body.Close() })
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantCode, resp.StatusCode)
+			assert.Equal(t, tc.wantContentType, resp.Header.Get("Content-Type"))
+			assert.Equal(t, tc.wantBody, string(body))
+		})
+	}
+}
+
+func TestLoadLocalHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.html")
+	require.NoError(t, os.WriteFile(path, []byte("<html></html>"), 0o600))
+
+	testCases := []struct {
+		name       string
+		hosts      []LocalHost
+		wantErrMsg string
+	}{{
+		name:       "valid",
+		hosts:      []LocalHost{{Host: "portal.example", Path: path}},
+		wantErrMsg: "",
+	}, {
+		name:       "empty_host",
+		hosts:      []LocalHost{{Host: "", Path: path}},
+		wantErrMsg: "sniproxy: empty local host for " + path,
+	}, {
+		name: "duplicate",
+		hosts: []LocalHost{
+			{Host: "portal.example", Path: path},
+			{Host: "PORTAL.example.", Path: path},
+		},
+		wantErrMsg: "sniproxy: duplicate local host portal.example",
+	}, {
+		name:       "no_file",
+		hosts:      []LocalHost{{Host: "portal.example", Path: path + ".missing"}},
+		wantErrMsg: "sniproxy: local host portal.example: open " + path + ".missing: no such file or directory",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadLocalHosts(tc.hosts)
+			if tc.wantErrMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.wantErrMsg)
+			}
+		})
+	}
+}
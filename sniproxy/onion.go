@@ -0,0 +1,20 @@
+package sniproxy
+
+import (
+	"errors"
+	"strings"
+)
+
+// errOnionDirect is returned when an onion host is about to be connected to
+// directly.  Onion hosts cannot be resolved with DNS, see RFC 7686, and trying
+// to do that leaks them to the resolver, so they can only be reached through a
+// Tor SOCKS forward proxy.
+var errOnionDirect = errors.New("onion hosts can only be reached through a forward proxy")
+
+// isOnion checks if host is a Tor onion service name, i.e. a name in the
+// .onion special-use domain.
+func isOnion(host string) (ok bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	return strings.HasSuffix(host, ".onion")
+}
@@ -0,0 +1,54 @@
+package sniproxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsOnion(t *testing.T) {
+	testCases := []struct {
+		name string
+		host string
+		want bool
+	}{{
+		name: "onion",
+		host: "example.onion",
+		want: true,
+	}, {
+		name: "fqdn",
+		host: "www.example.onion.",
+		want: true,
+	}, {
+		name: "upper_case",
+		host: "EXAMPLE.ONION",
+		want: true,
+	}, {
+		name: "tld_only",
+		host: "onion",
+		want: false,
+	}, {
+		name: "suffix_in_label",
+		host: "example-onion",
+		want: false,
+	}, {
+		name: "other",
+		host: "example.org",
+		want: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, isOnion(tc.host))
+		})
+	}
+}
+
+func TestSNIProxy_dial_onion(t *testing.T) {
+	p := &SNIProxy{dialer: &net.Dialer{}, forwardFallbackDirect: true}
+	ctx := NewSNIContext("example.onion", "example.onion:443")
+
+	_, err := p.dial(ctx)
+	assert.ErrorIs(t, err, errOnionDirect)
+}
@@ -0,0 +1,83 @@
+//go:build linux
+
+package sniproxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// originalDst returns the original destination address of a connection that
+// was redirected to the proxy by iptables/nftables (REDIRECT or DNAT).  It
+// uses the SO_ORIGINAL_DST socket option.
+func originalDst(conn net.Conn) (addr netip.AddrPort, err error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return addr, fmt.Errorf("sniproxy: unsupported connection type %T", conn)
+	}
+
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return addr, fmt.Errorf("sniproxy: failed to get raw connection: %w", err)
+	}
+
+	is6 := addrPortFromNetAddr(conn.LocalAddr()).Addr().Is6()
+
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		addr, sockErr = getOriginalDst(int(fd), is6)
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("sniproxy: failed to get original destination: %w", err)
+	}
+
+	return addr, nil
+}
+
+// getOriginalDst reads the SO_ORIGINAL_DST socket option of the specified
+// socket.  There are no dedicated getters for sockaddr options in the unix
+// package so the getters of the structures of a suitable size are used.
+func getOriginalDst(fd int, is6 bool) (addr netip.AddrPort, err error) {
+	if is6 {
+		// IP6T_SO_ORIGINAL_DST has the same value as SO_ORIGINAL_DST.
+		var info *unix.IPv6MTUInfo
+		info, err = unix.GetsockoptIPv6MTUInfo(fd, unix.IPPROTO_IPV6, unix.SO_ORIGINAL_DST)
+		if err != nil {
+			return addr, err
+		}
+
+		port := ntohs(info.Addr.Port)
+
+		return netip.AddrPortFrom(netip.AddrFrom16(info.Addr.Addr).Unmap(), port), nil
+	}
+
+	// The result is struct sockaddr_in: family (2 bytes), port (2 bytes,
+	// network byte order), and the IPv4 address (4 bytes).
+	mreq, err := unix.GetsockoptIPv6Mreq(fd, unix.IPPROTO_IP, unix.SO_ORIGINAL_DST)
+	if err != nil {
+		return addr, err
+	}
+
+	raw := mreq.Multiaddr
+	port := binary.BigEndian.Uint16(raw[2:4])
+	ip := netip.AddrFrom4([4]byte{raw[4], raw[5], raw[6], raw[7]})
+
+	return netip.AddrPortFrom(ip, port), nil
+}
+
+// ntohs converts a port stored in the network byte order in a native integer
+// to the host byte order.
+func ntohs(v uint16) (port uint16) {
+	b := (*[2]byte)(unsafe.Pointer(&v))
+
+	return binary.BigEndian.Uint16(b[:])
+}
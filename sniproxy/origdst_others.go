@@ -0,0 +1,15 @@
+//go:build !linux
+
+package sniproxy
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// originalDst returns the original destination address of a redirected
+// connection.  It is only supported on Linux.
+func originalDst(_ net.Conn) (addr netip.AddrPort, err error) {
+	return addr, errors.New("sniproxy: original destination is only supported on linux")
+}
@@ -0,0 +1,92 @@
+package sniproxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeekServerName_parseError(t *testing.T) {
+	testCases := []struct {
+		name      string
+		data      string
+		plainHTTP bool
+	}{{
+		name:      "tls",
+		data:      "SSH-2.0-OpenSSH_9.6\r\n",
+		plainHTTP: false,
+	}, {
+		name:      "http",
+		data:      "SSH-2.0-OpenSSH_9.6\r\n",
+		plainHTTP: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			t.Cleanup(func() { _ = serverConn.Close() })
+
+			go func() {
+				_, _ = clientConn.Write([]byte(tc.data))
+				_ = clientConn.Close()
+			}()
+
+			info, newReader, err := peekServerName(serverConn, tc.plainHTTP)
+			require.Error(t, err)
+			assert.Nil(t, info)
+
+			// The data must be passed through unmodified.
+			require.NotNil(t, newReader)
+			data, err := io.ReadAll(newReader)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.data, string(data))
+		})
+	}
+}
+
+func TestPassthroughInfo_notRedirected(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	clientConn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = clientConn.Close() })
+
+	serverConn, err := l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = serverConn.Close() })
+
+	// The connection was sent to the proxy directly, so it must not be passed
+	// through and the original parsing error is reported.
+	parseErr := errors.New("parse error")
+	info, err := passthroughInfo(serverConn, parseErr)
+	require.Error(t, err)
+	assert.Nil(t, info)
+
+	assert.ErrorIs(t, err, parseErr)
+	assert.ErrorContains(t, err, "passthrough failed")
+}
+
+func TestSNIProxy_handleConnection_passthroughFailed(t *testing.T) {
+	p, err := New(&Config{PassthroughOnParseError: true})
+	require.NoError(t, err)
+
+	conn, done := serveConn(t, p, false)
+	_, err = conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	select {
+	case err = <-done:
+		assert.ErrorContains(t, err, "passthrough failed")
+	case <-time.After(testTimeout):
+		t.Fatal("connection isn't finished")
+	}
+}
@@ -0,0 +1,129 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// errPeekBudgetExceeded is returned when the total number of bytes buffered by
+// the connections that are being peeked exceeds the limit.
+var errPeekBudgetExceeded = errors.New("sniproxy: peek memory limit exceeded")
+
+// peekBudget limits the total number of bytes buffered by all the connections
+// that are being peeked, so that a flood of slow clients cannot exhaust the
+// memory.  A nil *peekBudget has no limit.
+type peekBudget struct {
+	// used is the number of bytes buffered right now.
+	used atomic.Int64
+
+	// limit is the maximum number of bytes.
+	limit int64
+}
+
+// newPeekBudget creates a new *peekBudget.  It returns nil if limit is not
+// positive.
+func newPeekBudget(limit int64) (b *peekBudget) {
+	if limit <= 0 {
+		return nil
+	}
+
+	return &peekBudget{limit: limit}
+}
+
+// exhausted checks if no more bytes can be buffered.
+func (b *peekBudget) exhausted() (ok bool) {
+	return b != nil && b.used.Load() >= b.limit
+}
+
+// acquire accounts n more buffered bytes.  ok is false if the limit is
+// exceeded, the bytes are accounted anyway and must be released.
+func (b *peekBudget) acquire(n int) (ok bool) {
+	return b == nil || b.used.Add(int64(n)) <= b.limit
+}
+
+// release accounts n bytes that are no longer buffered.
+func (b *peekBudget) release(n int) {
+	if b != nil {
+		b.used.Add(-int64(n))
+	}
+}
+
+// peekReader reads the first bytes of a client connection while the proxy is
+// peeking the server name.  It keeps reading while the client makes progress,
+// so clients that send the ClientHello in many small segments with pauses in
+// between are not cut off.  The number of reads is limited by maxReads, and
+// the whole peek is limited by the read deadline of the connection, which is
+// set once, so that a client cannot trickle data forever.
+//
+// Once peeking is finished, peekReader just reads from the connection.
+type peekReader struct {
+	conn net.Conn
+
+	// maxReads is the maximum number of reads while peeking.  If zero, the
+	// number of reads is not limited.
+	maxReads int
+
+	// reads is the number of reads made while peeking.
+	reads int
+
+	// peeked are the bytes read while peeking.  They are only recorded if
+	// record is true.
+	peeked []byte
+
+	// finished is true when peeking is finished.
+	finished bool
+
+	// record makes the reader keep the bytes read while peeking.
+	record bool
+
+	// budget accounts the bytes read while peeking.  It may be nil.
+	budget *peekBudget
+
+	// acquired is the number of bytes accounted in budget.
+	acquired int
+}
+
+// newPeekReader creates a new *peekReader.  budget may be nil.
+func newPeekReader(conn net.Conn, maxReads int, budget *peekBudget) (r *peekReader) {
+	return &peekReader{
+		conn:     conn,
+		maxReads: maxReads,
+		budget:   budget,
+	}
+}
+
+// Read implements the [io.Reader] interface for *peekReader.
+func (r *peekReader) Read(b []byte) (n int, err error) {
+	if r.finished || (r.maxReads == 0 && !r.record && r.budget == nil) {
+		return r.conn.Read(b)
+	}
+
+	if r.maxReads > 0 && r.reads >= r.maxReads {
+		return 0, fmt.Errorf("sniproxy: server name not received after %d reads", r.reads)
+	}
+
+	r.reads++
+
+	n, err = r.conn.Read(b)
+	if r.record {
+		r.peeked = append(r.peeked, b[:n]...)
+	}
+
+	r.acquired += n
+	if !r.budget.acquire(n) {
+		return n, errPeekBudgetExceeded
+	}
+
+	return n, err
+}
+
+// finish marks peeking finished and releases the bytes accounted in the
+// budget.
+func (r *peekReader) finish() {
+	r.finished = true
+
+	r.budget.release(r.acquired)
+	r.acquired = 0
+}
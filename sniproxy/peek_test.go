@@ -0,0 +1,237 @@
+package sniproxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ameshkov/sniproxy/internal/filter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientHello returns the TLS record with the ClientHello for serverName.  If
+// serverName is empty, the ClientHello has no SNI.
+func clientHello(t *testing.T, serverName string) (b []byte) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+
+	go func() {
+		defer func() { _ = client.Close() }()
+
+		_ = tls.Client(client, &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: serverName == "",
+		}).Handshake()
+	}()
+
+	hdr := make([]byte, 5)
+	_, err := io.ReadFull(server, hdr)
+	require.NoError(t, err)
+
+	body := make([]byte, int(hdr[3])<<8|int(hdr[4]))
+	_, err = io.ReadFull(server, body)
+	require.NoError(t, err)
+
+	return append(hdr, body...)
+}
+
+// trickle writes b to conn in chunks of size with pause between them.
+func trickle(conn net.Conn, b []byte, size int, pause time.Duration) {
+	for len(b) > 0 {
+		n := size
+		if n > len(b) {
+			n = len(b)
+		}
+
+		if _, err := conn.Write(b[:n]); err != nil {
+			return
+		}
+
+		b = b[n:]
+		time.Sleep(pause)
+	}
+}
+
+func TestSNIProxy_peek_slowClient(t *testing.T) {
+	hello := clientHello(t, "example.org")
+
+	testCases := []struct {
+		conf  *Config
+		name  string
+		chunk int
+		pause time.Duration
+		// wantHost is true if the server name is received.
+		wantHost bool
+	}{{
+		conf:     &Config{},
+		name:     "many_segments",
+		chunk:    16,
+		pause:    time.Millisecond,
+		wantHost: true,
+	}, {
+		conf:     &Config{PeekMaxReads: 4},
+		name:     "too_many_reads",
+		chunk:    16,
+		pause:    time.Millisecond,
+		wantHost: false,
+	}, {
+		// The client makes progress with every read, but it must send the
+		// whole ClientHello within the timeout.
+		conf:     &Config{PeekTimeout: 200 * time.Millisecond},
+		name:     "timeout",
+		chunk:    len(hello) / 8,
+		pause:    50 * time.Millisecond,
+		wantHost: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Block the remote host so that the proxy doesn't try to connect
+			// to it.
+			tc.conf.BlockRules = []string{"example.org"}
+			p := startProxy(t, tc.conf)
+
+			conn, err := net.Dial("tcp", p.sniListener.Addr().String())
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+
+			go trickle(conn, hello, tc.chunk, tc.pause)
+
+			// The proxy closes the connection once the ClientHello is
+			// blocked or peeking fails.
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+			_, _ = io.ReadAll(conn)
+
+			var wantMatches uint64
+			if tc.wantHost {
+				wantMatches = 1
+			}
+
+			assert.Equal(t, []filter.RuleStat{{
+				Kind:    ruleKindBlock,
+				Rule:    "example.org",
+				Matches: wantMatches,
+			}}, p.RuleStats())
+		})
+	}
+}
+
+func TestPeekBudget_concurrent(t *testing.T) {
+	const (
+		limit   = 1000
+		workers = 64
+		chunk   = 10
+	)
+
+	b := newPeekBudget(limit)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				// The bytes are accounted even if the limit is exceeded.
+				_ = b.acquire(chunk)
+				b.release(chunk)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Zero(t, b.used.Load())
+	assert.False(t, b.exhausted())
+}
+
+func TestSNIProxy_peek_budget(t *testing.T) {
+	const (
+		clients = 8
+		held    = 2
+	)
+
+	// The limit fits the whole ClientHello, but not the parts sent by more
+	// than held clients.
+	hello := clientHello(t, "example.org")
+	chunk := len(hello) / 2
+	limit := held*chunk + chunk/2
+
+	p := startProxy(t, &Config{
+		BlockRules:    []string{"example.org"},
+		BlockTLSAlert: TLSAlertAccessDenied,
+		MaxPeekMemory: int64(limit),
+		PeekTimeout:   time.Minute,
+	})
+	addr := p.sniListener.Addr().String()
+
+	// Every client sends a part of the ClientHello and stalls, so the bytes
+	// stay buffered until the connection is closed.
+	conns := make([]net.Conn, clients)
+	rejected := make([]bool, clients)
+
+	var wg sync.WaitGroup
+	for i := range conns {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+
+		conns[i] = conn
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			_, _ = conn.Write(hello[:chunk])
+			_ = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+
+			_, rErr := conn.Read(make([]byte, 1))
+			rejected[i] = rErr != nil && !isTimeout(rErr)
+		}(i)
+	}
+
+	wg.Wait()
+
+	var stalled []net.Conn
+	for i, conn := range conns {
+		if !rejected[i] {
+			stalled = append(stalled, conn)
+		}
+	}
+
+	require.Len(t, stalled, held)
+	assert.Eventually(t, func() bool {
+		return p.peekBudget.used.Load() == int64(held*chunk)
+	}, testTimeout, time.Millisecond)
+
+	// Once the stalled clients are gone, the memory is freed and new
+	// connections are served again.
+	for _, conn := range stalled {
+		require.NoError(t, conn.Close())
+	}
+
+	require.Eventually(t, func() bool {
+		return p.peekBudget.used.Load() == 0
+	}, testTimeout, time.Millisecond)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	_, err = conn.Write(hello)
+	require.NoError(t, err)
+
+	// The refused connections are closed right away, the served one is
+	// blocked with the alert.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, got)
+}
@@ -0,0 +1,30 @@
+package sniproxy
+
+import (
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// CheckForwardProxies connects to addr through every forward proxy, including
+// the per-rule ones, i.e. performs the complete CONNECT or SOCKS5 handshake,
+// and returns the results keyed by the redacted proxy URL.  The error is nil
+// for the proxies that established the connection.  It is intended for
+// catching misconfigured proxies on startup, unlike the check performed for
+// ForwardRequired, which only checks that the proxies accept TCP connections.
+func (p *SNIProxy) CheckForwardProxies(addr string) (results map[string]error) {
+	dialers := append([]*forwardDialer{}, p.forwardDialers...)
+	for _, r := range p.forwardProxyRules {
+		dialers = append(dialers, r.dialer)
+	}
+
+	results = map[string]error{}
+	for _, d := range dialers {
+		conn, err := d.dialer.Dial("tcp", addr)
+		if err == nil {
+			log.OnCloserError(conn, log.DEBUG)
+		}
+
+		results[d.addr] = err
+	}
+
+	return results
+}
@@ -0,0 +1,30 @@
+package sniproxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_CheckForwardProxies(t *testing.T) {
+	p, err := New(&Config{
+		ForwardProxies: []string{
+			"http://" + startHTTPProxy(t, http.StatusOK),
+			"http://" + closedAddr(t),
+		},
+		ForwardProxyRules: []ForwardProxyRule{{
+			Wildcard: "*.example.org",
+			Proxy:    "http://" + startHTTPProxy(t, http.StatusForbidden),
+		}},
+	})
+	require.NoError(t, err)
+
+	results := p.CheckForwardProxies("example.org:443")
+	require.Len(t, results, 3)
+
+	assert.NoError(t, results[p.forwardDialers[0].addr])
+	assert.Error(t, results[p.forwardDialers[1].addr])
+	assert.Error(t, results[p.forwardProxyRules[0].dialer.addr])
+}
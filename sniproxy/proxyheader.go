@@ -0,0 +1,47 @@
+package sniproxy
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/ameshkov/sniproxy/proxyproto"
+)
+
+// writeProxyHeader sends the PROXY protocol header to the backend so that it
+// sees the real address of the client.  It must be called before any client
+// data is sent to the backend.  For forwarded connections the header is sent
+// through the established tunnel so it reaches the remote host and not the
+// forward proxy.
+func (p *SNIProxy) writeProxyHeader(ctx *SNIContext, clientConn, backendConn net.Conn) (err error) {
+	dst := proxyHeaderDst(ctx, clientConn, backendConn)
+	hdr, err := proxyproto.Header(p.proxyProtocol, ctx.ClientAddr, dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = backendConn.Write(hdr)
+
+	return err
+}
+
+// proxyHeaderDst returns the destination address for the PROXY protocol
+// header, i.e. the address of the remote host.  The address of a forwarded
+// connection is not known unless the remote host is an IP address, the address
+// the client connected to is used then.
+func proxyHeaderDst(ctx *SNIContext, clientConn, backendConn net.Conn) (dst netip.AddrPort) {
+	if !ctx.forwarded {
+		return addrPortFromNetAddr(backendConn.RemoteAddr())
+	}
+
+	host, port, err := netutil.SplitHostPort(ctx.RemoteAddr)
+	if err == nil {
+		var ip netip.Addr
+		ip, err = netip.ParseAddr(host)
+		if err == nil {
+			return netip.AddrPortFrom(ip, uint16(port))
+		}
+	}
+
+	return addrPortFromNetAddr(clientConn.LocalAddr())
+}
@@ -0,0 +1,44 @@
+package sniproxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ameshkov/sniproxy/proxyproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_writeProxyHeader(t *testing.T) {
+	const req = "GET / HTTP/1.1\r\nHost: %s\r\n\r\n"
+
+	received := make(chan []byte, 1)
+	backend := startBackend(t, func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_ = conn.SetReadDeadline(time.Now().Add(testTimeout))
+		b, _ := io.ReadAll(conn)
+		received <- b
+	})
+
+	p := startProxy(t, &Config{ProxyProtocol: proxyproto.V1})
+	conn := dialHTTP(t, p, backend)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	var b []byte
+	select {
+	case b = <-received:
+	case <-time.After(testTimeout):
+		t.Fatal("backend did not receive the request")
+	}
+
+	client := conn.LocalAddr().(*net.TCPAddr)
+	_, backendPort, err := net.SplitHostPort(backend)
+	require.NoError(t, err)
+
+	wantHdr := fmt.Sprintf("PROXY TCP4 127.0.0.1 127.0.0.1 %d %s\r\n", client.Port, backendPort)
+	assert.Equal(t, wantHdr+fmt.Sprintf(req, backend), string(b))
+}
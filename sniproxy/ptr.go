@@ -0,0 +1,93 @@
+package sniproxy
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/internal/filter"
+)
+
+// ptrLookupTimeout is the timeout for resolving the remote host and looking up
+// its PTR records.
+const ptrLookupTimeout = 5 * time.Second
+
+// Resolver is used by the proxy to resolve hostnames and IP addresses.
+// [*net.Resolver] implements it.
+type Resolver interface {
+	// LookupNetIP looks up host and returns its IP addresses.
+	LookupNetIP(ctx context.Context, network, host string) (addrs []netip.Addr, err error)
+
+	// LookupAddr performs a reverse lookup for the given address.
+	LookupAddr(ctx context.Context, addr string) (names []string, err error)
+}
+
+// lookupPTR resolves the remote host and returns the PTR names of its first IP
+// address.  It is best-effort, i.e. errors are only logged.  Onion hosts are
+// not resolved.
+func (p *SNIProxy) lookupPTR(ctx *SNIContext) (names []string) {
+	if isOnion(ctx.RemoteHost) {
+		return nil
+	}
+
+	lookupCtx, cancel := context.WithTimeout(context.Background(), ptrLookupTimeout)
+	defer cancel()
+
+	ip, err := netip.ParseAddr(ctx.RemoteHost)
+	if err != nil {
+		var addrs []netip.Addr
+		addrs, err = p.resolver.LookupNetIP(lookupCtx, "ip", ctx.RemoteHost)
+		if err != nil || len(addrs) == 0 {
+			log.Debug("sniproxy: [%d] failed to resolve %s for PTR lookup: %v", ctx.ID, ctx.RemoteHost, err)
+
+			return nil
+		}
+
+		ip = addrs[0]
+	}
+
+	ptrNames, err := p.resolver.LookupAddr(lookupCtx, ip.String())
+	if err != nil {
+		log.Debug("sniproxy: [%d] failed to lookup PTR for %s: %v", ctx.ID, ip, err)
+
+		return nil
+	}
+
+	for _, name := range ptrNames {
+		names = append(names, strings.TrimSuffix(strings.ToLower(name), "."))
+	}
+
+	log.Debug("sniproxy: [%d] PTR names for %s: %v", ctx.ID, ip, names)
+
+	return names
+}
+
+// matchHost checks if the remote host or any of its PTR names match the
+// wildcards and returns the first matching one.
+func matchHost(ctx *SNIContext, wildcards []string) (rule string, ok bool) {
+	for _, w := range wildcards {
+		if matchHostWildcard(ctx, w) {
+			return w, true
+		}
+	}
+
+	return "", false
+}
+
+// matchHostWildcard checks if the remote host or any of its PTR names match
+// the wildcard.
+func matchHostWildcard(ctx *SNIContext, w string) (ok bool) {
+	if filter.MatchWildcard(ctx.RemoteHost, w) {
+		return true
+	}
+
+	for _, name := range ctx.PTRNames {
+		if filter.MatchWildcard(name, w) {
+			return true
+		}
+	}
+
+	return false
+}
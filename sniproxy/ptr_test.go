@@ -0,0 +1,90 @@
+package sniproxy
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testResolver is a Resolver for tests.
+type testResolver struct {
+	addrs map[string][]netip.Addr
+	ptrs  map[string][]string
+}
+
+// type check
+var _ Resolver = (*testResolver)(nil)
+
+// LookupNetIP implements the Resolver interface for *testResolver.
+func (r *testResolver) LookupNetIP(
+	_ context.Context,
+	_ string,
+	host string,
+) (addrs []netip.Addr, err error) {
+	return r.addrs[host], nil
+}
+
+// LookupAddr implements the Resolver interface for *testResolver.
+func (r *testResolver) LookupAddr(_ context.Context, addr string) (names []string, err error) {
+	return r.ptrs[addr], nil
+}
+
+func TestSNIProxy_lookupPTR(t *testing.T) {
+	p, err := New(&Config{
+		BlockRules: []string{"*.tracker.example"},
+		MatchPTR:   true,
+	})
+	require.NoError(t, err)
+
+	p.resolver = &testResolver{
+		addrs: map[string][]netip.Addr{
+			"cdn.example.org": {netip.MustParseAddr("192.0.2.1")},
+		},
+		ptrs: map[string][]string{
+			"192.0.2.1": {"Host-1.Tracker.Example."},
+			"192.0.2.2": {"host-2.example.net."},
+		},
+	}
+
+	testCases := []struct {
+		name      string
+		host      string
+		wantNames []string
+		wantBlock bool
+	}{{
+		name:      "hostname",
+		host:      "cdn.example.org",
+		wantNames: []string{"host-1.tracker.example"},
+		wantBlock: true,
+	}, {
+		name:      "ip",
+		host:      "192.0.2.2",
+		wantNames: []string{"host-2.example.net"},
+		wantBlock: false,
+	}, {
+		name:      "no_ptr",
+		host:      "192.0.2.3",
+		wantNames: nil,
+		wantBlock: false,
+	}, {
+		name:      "not_resolved",
+		host:      "unknown.example.org",
+		wantNames: nil,
+		wantBlock: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := NewSNIContext(tc.host, tc.host+":443")
+			ctx.rules = p.rules.Load()
+			ctx.PTRNames = p.lookupPTR(ctx)
+
+			assert.Equal(t, tc.wantNames, ctx.PTRNames)
+			_, blocked := p.shouldBlock(ctx)
+			assert.Equal(t, tc.wantBlock, blocked)
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package sniproxy
+
+import (
+	"net"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/audit"
+	"github.com/ameshkov/sniproxy/internal/metrics"
+)
+
+// rejectNoSNI closes the TLS connection that has no SNI.  The client receives
+// the configured block alert, if any.
+func (p *SNIProxy) rejectNoSNI(ctx *SNIContext, clientConn net.Conn) {
+	log.Info("sniproxy: [%d] rejected TLS connection without SNI from %s", ctx.ID, ctx.ClientAddr)
+	p.audit(ctx, audit.ActionBlock, "", "no sni")
+	metrics.SNIBlocked.Inc()
+
+	p.sendBlockAlert(ctx, clientConn)
+}
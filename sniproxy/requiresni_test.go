@@ -0,0 +1,70 @@
+package sniproxy
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_requireSNI(t *testing.T) {
+	testCases := []struct {
+		name         string
+		serverName   string
+		requireSNI   bool
+		wantRejected bool
+	}{{
+		name:         "no_sni",
+		serverName:   "",
+		requireSNI:   true,
+		wantRejected: true,
+	}, {
+		name:         "no_sni_not_required",
+		serverName:   "",
+		requireSNI:   false,
+		wantRejected: false,
+	}, {
+		name:         "sni",
+		serverName:   "example.org",
+		requireSNI:   true,
+		wantRejected: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := startProxy(t, &Config{
+				RequireSNI:    tc.requireSNI,
+				BlockTLSAlert: TLSAlertUnrecognizedName,
+				// Make sure that the connections with SNI aren't tunneled
+				// anywhere.
+				BlockRules: []string{"example.org"},
+			})
+
+			buf := captureLog(t)
+
+			conn, err := net.Dial("tcp", p.sniListener.Addr().String())
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+
+			_, err = conn.Write(clientHello(t, tc.serverName))
+			require.NoError(t, err)
+
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+			got, err := io.ReadAll(conn)
+			require.NoError(t, err)
+
+			// Close the proxy so that the handler finishes logging.
+			require.NoError(t, p.Close())
+
+			rejected := strings.Contains(buf.String(), "rejected TLS connection without SNI")
+			assert.Equal(t, tc.wantRejected, rejected)
+			if tc.wantRejected {
+				assert.Equal(t, []byte{0x15, 0x03, 0x03, 0x00, 0x02, 0x02, 112}, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// errNoAddresses is returned when the remote host is resolved, but there are
+// no addresses for it.
+var errNoAddresses = errors.New("no addresses")
+
+// resolveError is returned by [SNIProxy.resolveRemoteHost] when the remote host
+// cannot be resolved.
+type resolveError struct {
+	host string
+	err  error
+}
+
+// type check
+var _ error = (*resolveError)(nil)
+
+// Error implements the error interface for *resolveError.
+func (e *resolveError) Error() (msg string) {
+	return fmt.Sprintf("failed to resolve %s: %v", e.host, e.err)
+}
+
+// Unwrap returns the underlying error.
+func (e *resolveError) Unwrap() (err error) { return e.err }
+
+// dialResolveFallback connects to the resolve fallback address in place of the
+// remote host that could not be resolved.
+func (p *SNIProxy) dialResolveFallback(
+	ctx *SNIContext,
+	resErr *resolveError,
+) (conn net.Conn, err error) {
+	log.Info("sniproxy: [%d] %v, connecting to fallback %s", ctx.ID, resErr, p.resolveFallback)
+
+	conn, err = p.dialer.Dial("tcp", p.resolveFallback)
+	if err != nil {
+		return nil, fmt.Errorf("%w, fallback %s: %w", resErr, p.resolveFallback, err)
+	}
+
+	return conn, nil
+}
+
+// rejectUnresolved closes the connection to the remote host that could not be
+// resolved.  Plain HTTP clients receive a response with the configured status
+// code first, if any.
+func (p *SNIProxy) rejectUnresolved(
+	ctx *SNIContext,
+	clientConn net.Conn,
+	plainHTTP bool,
+	resErr *resolveError,
+) (err error) {
+	log.Info(
+		"sniproxy: [%d] unresolved host, closing connection from %s: %v",
+		ctx.ID,
+		ctx.ClientAddr,
+		resErr,
+	)
+	p.totals.finished(OutcomeUnresolved)
+
+	if !plainHTTP || p.resolveFailureStatus == 0 {
+		return nil
+	}
+
+	body := fmt.Sprintf("sniproxy: host %s cannot be resolved\n", resErr.host)
+	err = writeHTTPResponse(clientConn, p.resolveFailureStatus, nil, []byte(body))
+	if err != nil {
+		return fmt.Errorf("sniproxy: [%d] failed to send resolution failure response: %w", ctx.ID, err)
+	}
+
+	return nil
+}
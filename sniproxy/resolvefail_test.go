@@ -0,0 +1,75 @@
+package sniproxy
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_resolveFailure(t *testing.T) {
+	fallback := startBackend(t, markerBackend("fallback"))
+
+	testCases := []struct {
+		name            string
+		resolveFallback string
+		failureStatus   int
+		want            string
+	}{{
+		name:            "close",
+		resolveFallback: "",
+		failureStatus:   0,
+		want:            "",
+	}, {
+		name:            "status",
+		resolveFallback: "",
+		failureStatus:   502,
+		want:            "HTTP/1.1 502 Bad Gateway",
+	}, {
+		name:            "fallback",
+		resolveFallback: fallback,
+		failureStatus:   502,
+		want:            "fallback",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := New(&Config{
+				TLSListenAddr:        localAddr,
+				HTTPListenAddr:       localAddr,
+				ResolveFallback:      tc.resolveFallback,
+				ResolveFailureStatus: tc.failureStatus,
+			})
+			require.NoError(t, err)
+
+			// The resolver has no addresses for any host.
+			p.resolver = &testResolver{}
+
+			require.NoError(t, p.Start())
+			t.Cleanup(func() { _ = p.Close() })
+
+			conn := dialHTTP(t, p, "unresolved.example")
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+
+			resp, err := io.ReadAll(conn)
+			require.NoError(t, err)
+
+			if tc.want == "" {
+				assert.Empty(t, resp)
+			} else {
+				assert.True(t, strings.HasPrefix(string(resp), tc.want), string(resp))
+			}
+		})
+	}
+}
+
+func TestNew_resolveFailureStatus(t *testing.T) {
+	_, err := New(&Config{ResolveFailureStatus: 42})
+	assert.Error(t, err)
+
+	_, err = New(&Config{ResolveFailureStatus: 600})
+	assert.Error(t, err)
+}
@@ -0,0 +1,195 @@
+package sniproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// upstreamResolver is a [Resolver] that sends queries to the DNS upstreams
+// directly.  Unlike the system resolver, it is not affected by /etc/hosts and
+// by the rewrites of the DNS proxy that may run on the same machine and
+// resolve the tunneled domains back to the SNI proxy.
+type upstreamResolver struct {
+	// upstreams are tried in order, if one of them fails, the next one is
+	// used.
+	upstreams []upstream.Upstream
+}
+
+// type check
+var _ Resolver = (*upstreamResolver)(nil)
+
+// newUpstreamResolver creates a new *upstreamResolver.  Domain-specific
+// upstreams ("[/domain/]upstream") are only meaningful to the DNS proxy and
+// are skipped.
+func newUpstreamResolver(addrs []string, timeout time.Duration) (r *upstreamResolver, err error) {
+	r = &upstreamResolver{}
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, "[/") {
+			continue
+		}
+
+		var u upstream.Upstream
+		u, err = upstream.AddressToUpstream(addr, &upstream.Options{Timeout: timeout})
+		if err != nil {
+			return nil, fmt.Errorf("sniproxy: failed to parse upstream %s: %w", addr, err)
+		}
+
+		r.upstreams = append(r.upstreams, u)
+	}
+
+	if len(r.upstreams) == 0 {
+		return nil, fmt.Errorf("sniproxy: no upstreams in %v", addrs)
+	}
+
+	return r, nil
+}
+
+// LookupNetIP implements the [Resolver] interface for *upstreamResolver.
+// The A and AAAA queries are sent in parallel, IPv4 addresses are returned
+// first.
+func (r *upstreamResolver) LookupNetIP(
+	_ context.Context,
+	network string,
+	host string,
+) (addrs []netip.Addr, err error) {
+	var qTypes []uint16
+	switch network {
+	case "ip":
+		qTypes = []uint16{dns.TypeA, dns.TypeAAAA}
+	case "ip4":
+		qTypes = []uint16{dns.TypeA}
+	case "ip6":
+		qTypes = []uint16{dns.TypeAAAA}
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+
+	resps := make([]*dns.Msg, len(qTypes))
+	errs := make([]error, len(qTypes))
+
+	wg := &sync.WaitGroup{}
+	for i, qType := range qTypes {
+		wg.Add(1)
+		go func(i int, qType uint16) {
+			defer wg.Done()
+
+			resps[i], errs[i] = r.exchange(dns.Fqdn(host), qType)
+		}(i, qType)
+	}
+	wg.Wait()
+
+	for _, resp := range resps {
+		if resp == nil {
+			continue
+		}
+
+		for _, rr := range resp.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				addr, _ := netip.AddrFromSlice(rr.A.To4())
+				addrs = append(addrs, addr)
+			case *dns.AAAA:
+				addr, _ := netip.AddrFromSlice(rr.AAAA)
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		if err = errors.Join(errs...); err != nil {
+			return nil, fmt.Errorf("resolving %s: %w", host, err)
+		}
+
+		return nil, fmt.Errorf("resolving %s: upstream returned no addresses", host)
+	}
+
+	return addrs, nil
+}
+
+// preferFamily reorders addrs in place so that the addresses of the preferred
+// family go first.  The order within each family is kept.
+func preferFamily(addrs []netip.Addr, preferIPv6 bool) {
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return addrs[i].Is6() == preferIPv6 && addrs[j].Is6() != preferIPv6
+	})
+}
+
+// listenersPreferIPv6 returns true if all the listen addresses are IPv6, so
+// the proxy is expected to run on an IPv6 network.  The unspecified IPv6
+// address is also counted as IPv6 even though it usually accepts IPv4 too.
+func listenersPreferIPv6(addrs ...*net.TCPAddr) (ok bool) {
+	for _, addr := range addrs {
+		if addr == nil || addr.IP == nil || addr.IP.To4() != nil {
+			return false
+		}
+	}
+
+	return len(addrs) > 0
+}
+
+// LookupAddr implements the [Resolver] interface for *upstreamResolver.
+func (r *upstreamResolver) LookupAddr(_ context.Context, addr string) (names []string, err error) {
+	arpa, err := dns.ReverseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.exchange(arpa, dns.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rr := range resp.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			names = append(names, ptr.Ptr)
+		}
+	}
+
+	return names, nil
+}
+
+// exchange sends the query to the upstreams one by one until one of them
+// responds with NOERROR or NXDOMAIN.
+func (r *upstreamResolver) exchange(name string, qType uint16) (resp *dns.Msg, err error) {
+	req := &dns.Msg{}
+	req.SetQuestion(name, qType)
+	req.RecursionDesired = true
+
+	for _, u := range r.upstreams {
+		resp, err = u.Exchange(req)
+		if err != nil {
+			log.Debug("sniproxy: upstream %s failed to resolve %s: %v", u.Address(), name, err)
+
+			continue
+		}
+
+		if resp.Rcode == dns.RcodeSuccess || resp.Rcode == dns.RcodeNameError {
+			return resp, nil
+		}
+
+		err = fmt.Errorf("upstream %s returned %s", u.Address(), dns.RcodeToString[resp.Rcode])
+	}
+
+	return nil, fmt.Errorf("%s %s: %w", dns.TypeToString[qType], name, err)
+}
+
+// Close closes the upstreams.
+func (r *upstreamResolver) Close() (err error) {
+	var errs []error
+	for _, u := range r.upstreams {
+		errs = append(errs, u.Close())
+	}
+
+	return errors.Join(errs...)
+}
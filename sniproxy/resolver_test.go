@@ -0,0 +1,135 @@
+package sniproxy
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startDNSServer starts a DNS server that answers A and AAAA queries with
+// ip4 and ip6 after delay and returns its address.
+func startDNSServer(t *testing.T, delay time.Duration, ip4, ip6 net.IP) (addr string) {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			time.Sleep(delay)
+
+			resp := (&dns.Msg{}).SetReply(req)
+			q := req.Question[0]
+			hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 60}
+			switch q.Qtype {
+			case dns.TypeA:
+				resp.Answer = append(resp.Answer, &dns.A{Hdr: hdr, A: ip4})
+			case dns.TypeAAAA:
+				resp.Answer = append(resp.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip6})
+			}
+
+			_ = w.WriteMsg(resp)
+		}),
+	}
+
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+func TestUpstreamResolver_LookupNetIP(t *testing.T) {
+	const delay = 300 * time.Millisecond
+
+	addr := startDNSServer(t, delay, net.IPv4(1, 2, 3, 4), net.ParseIP("2001:db8::1"))
+
+	r, err := newUpstreamResolver([]string{addr}, testTimeout)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = r.Close() })
+
+	start := time.Now()
+	addrs, err := r.LookupNetIP(context.Background(), "ip", "example.org")
+	require.NoError(t, err)
+
+	assert.Equal(t, []netip.Addr{
+		netip.MustParseAddr("1.2.3.4"),
+		netip.MustParseAddr("2001:db8::1"),
+	}, addrs)
+
+	// The queries are sent in parallel, so both of them take one delay.
+	assert.Less(t, time.Since(start), 2*delay)
+}
+
+func TestPreferFamily(t *testing.T) {
+	v4a, v4b := netip.MustParseAddr("1.1.1.1"), netip.MustParseAddr("2.2.2.2")
+	v6a, v6b := netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db8::2")
+
+	testCases := []struct {
+		name       string
+		addrs      []netip.Addr
+		want       []netip.Addr
+		preferIPv6 bool
+	}{{
+		name:       "ipv4",
+		addrs:      []netip.Addr{v6a, v4a, v6b, v4b},
+		want:       []netip.Addr{v4a, v4b, v6a, v6b},
+		preferIPv6: false,
+	}, {
+		name:       "ipv6",
+		addrs:      []netip.Addr{v4a, v6a, v4b, v6b},
+		want:       []netip.Addr{v6a, v6b, v4a, v4b},
+		preferIPv6: true,
+	}, {
+		name:       "single_family",
+		addrs:      []netip.Addr{v4b, v4a},
+		want:       []netip.Addr{v4b, v4a},
+		preferIPv6: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			preferFamily(tc.addrs, tc.preferIPv6)
+			assert.Equal(t, tc.want, tc.addrs)
+		})
+	}
+}
+
+func TestListenersPreferIPv6(t *testing.T) {
+	v4 := &net.TCPAddr{IP: net.IPv4zero}
+	v6 := &net.TCPAddr{IP: net.IPv6unspecified}
+
+	testCases := []struct {
+		name  string
+		addrs []*net.TCPAddr
+		want  bool
+	}{{
+		name:  "ipv4",
+		addrs: []*net.TCPAddr{v4, v4},
+		want:  false,
+	}, {
+		name:  "ipv6",
+		addrs: []*net.TCPAddr{v6, v6},
+		want:  true,
+	}, {
+		name:  "mixed",
+		addrs: []*net.TCPAddr{v6, v4},
+		want:  false,
+	}, {
+		name:  "no_ip",
+		addrs: []*net.TCPAddr{{Port: 443}, v6},
+		want:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, listenersPreferIPv6(tc.addrs...))
+		})
+	}
+}
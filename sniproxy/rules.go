@@ -0,0 +1,164 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/internal/filter"
+)
+
+// RuleSet is the set of rules that can be replaced at runtime without
+// restarting the proxy, see [SNIProxy.Reload].
+type RuleSet struct {
+	ForwardRules     []string              `json:"forward_rules"`
+	ForwardPathRules []string              `json:"forward_path_rules"`
+	ForwardSchedule  []ForwardScheduleRule `json:"forward_schedule"`
+	BlockRules       []string              `json:"block_rules"`
+	BlockPathRules   []string              `json:"block_path_rules"`
+	DropRules        []string              `json:"drop_rules"`
+	ExpectedSNI      []string              `json:"expected_sni"`
+	HTTPSOnlyRules   []string              `json:"https_only_rules"`
+	BandwidthRules   map[string]float64    `json:"bandwidth_rules"`
+}
+
+// clone returns a deep copy of the rule set.
+func (r *RuleSet) clone() (c *RuleSet) {
+	c = &RuleSet{
+		ForwardRules:     cloneStrings(r.ForwardRules),
+		ForwardPathRules: cloneStrings(r.ForwardPathRules),
+		ForwardSchedule:  append([]ForwardScheduleRule(nil), r.ForwardSchedule...),
+		BlockRules:       cloneStrings(r.BlockRules),
+		BlockPathRules:   cloneStrings(r.BlockPathRules),
+		DropRules:        cloneStrings(r.DropRules),
+		ExpectedSNI:      cloneStrings(r.ExpectedSNI),
+		HTTPSOnlyRules:   cloneStrings(r.HTTPSOnlyRules),
+		BandwidthRules:   make(map[string]float64, len(r.BandwidthRules)),
+	}
+
+	for k, v := range r.BandwidthRules {
+		c.BandwidthRules[k] = v
+	}
+
+	return c
+}
+
+// Validate returns an error if r contains invalid rules.  The error lists all
+// of them.
+func (r *RuleSet) Validate() (err error) {
+	var errs []error
+	lists := []struct {
+		name  string
+		rules []string
+	}{
+		{"forward rule", r.ForwardRules},
+		{"forward path rule", r.ForwardPathRules},
+		{"block rule", r.BlockRules},
+		{"block path rule", r.BlockPathRules},
+		{"drop rule", r.DropRules},
+		{"expected sni", r.ExpectedSNI},
+		{"https-only rule", r.HTTPSOnlyRules},
+	}
+
+	for _, l := range lists {
+		for i, w := range l.rules {
+			if _, pattern := filter.ParseRule(w); pattern == "" {
+				errs = append(errs, fmt.Errorf("%s at index %d is empty", l.name, i))
+			}
+		}
+	}
+
+	for i, sr := range r.ForwardSchedule {
+		if _, pattern := filter.ParseRule(sr.Wildcard); pattern == "" {
+			errs = append(errs, fmt.Errorf("forward schedule rule at index %d has no wildcard", i))
+		}
+
+		if sr.Start < 0 || sr.Start >= 24*time.Hour || sr.End < 0 || sr.End >= 24*time.Hour {
+			errs = append(errs, fmt.Errorf("forward schedule rule %s is out of the day", sr.Wildcard))
+		}
+	}
+
+	for w, rate := range r.BandwidthRules {
+		if _, pattern := filter.ParseRule(w); pattern == "" || rate < 0 {
+			errs = append(errs, fmt.Errorf("invalid bandwidth rule %q: %v", w, rate))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// blocks checks if the connection is blocked by the rule set.
+func (r *RuleSet) blocks(ctx *SNIContext) (ok bool) {
+	if _, ok = matchHost(ctx, r.BlockRules); ok {
+		return true
+	}
+
+	_, ok = matchPath(ctx, r.BlockPathRules)
+
+	return ok
+}
+
+// Rules is a read-only snapshot of the rules that are currently used by the
+// SNI proxy.
+type Rules struct {
+	ForwardProxyRules []ForwardProxyRule `json:"forward_proxy_rules"`
+
+	RuleSet
+}
+
+// Rules returns a snapshot of the rules that are currently used by the proxy.
+// It is safe to modify the returned value.
+func (p *SNIProxy) Rules() (r *Rules) {
+	r = &Rules{
+		RuleSet: *p.rules.Load().clone(),
+	}
+
+	for _, fr := range p.forwardProxyRules {
+		// Use the redacted URL so that the credentials are not exposed.
+		r.ForwardProxyRules = append(r.ForwardProxyRules, ForwardProxyRule{
+			Wildcard: fr.wildcard,
+			Proxy:    fr.dialer.addr,
+		})
+	}
+
+	return r
+}
+
+// Reload replaces the rules of the proxy.  The new rules only apply to the new
+// connections, the existing ones keep using the rules they were started with
+// and are allowed to finish.  If enforce is true, the existing connections
+// that are blocked by the new rules are closed right away.  closed is the
+// number of such connections.  The rules are not replaced if they are not
+// valid, see [RuleSet.Validate].
+func (p *SNIProxy) Reload(rules *RuleSet, enforce bool) (closed int, err error) {
+	err = rules.Validate()
+	if err != nil {
+		return 0, fmt.Errorf("sniproxy: invalid rules: %w", err)
+	}
+
+	rules = rules.clone()
+	p.rules.Store(rules)
+
+	log.Info("sniproxy: reloaded rules")
+
+	if !enforce {
+		return 0, nil
+	}
+
+	for _, c := range p.conns.list() {
+		if rules.blocks(c.ctx) {
+			log.Info("sniproxy: [%d] closing connection blocked by the new rules", c.ctx.ID)
+
+			c.close()
+			closed++
+		}
+	}
+
+	return closed, nil
+}
+
+// cloneStrings returns a copy of the slice.
+func cloneStrings(s []string) (c []string) {
+	return append([]string(nil), s...)
+}
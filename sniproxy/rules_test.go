@@ -0,0 +1,125 @@
+package sniproxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_Reload(t *testing.T) {
+	// echoBackend keeps the connections open until the client closes them.
+	echoBackend := func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = io.Copy(conn, conn)
+	}
+
+	testCases := []struct {
+		name       string
+		enforce    bool
+		wantClosed int
+	}{{
+		name:       "enforce",
+		enforce:    true,
+		wantClosed: 1,
+	}, {
+		name:       "no_enforce",
+		enforce:    false,
+		wantClosed: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := startProxy(t, nil)
+			conn := dialHTTP(t, p, startBackend(t, echoBackend))
+
+			require.Eventually(t, func() bool {
+				return len(p.conns.list()) == 1
+			}, testTimeout, 10*time.Millisecond)
+
+			closed, err := p.Reload(&RuleSet{BlockRules: []string{"127.0.0.1"}}, tc.enforce)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantClosed, closed)
+
+			// The new connections are blocked in both cases.
+			blocked := dialHTTP(t, p, startBackend(t, echoBackend))
+			require.NoError(t, blocked.SetReadDeadline(time.Now().Add(testTimeout)))
+			_, err = io.ReadAll(blocked)
+			require.NoError(t, err)
+
+			if !tc.enforce {
+				assert.Len(t, p.conns.list(), 1)
+
+				return
+			}
+
+			// The existing connection is closed by the proxy.
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+			_, err = io.ReadAll(conn)
+			assert.False(t, isTimeout(err))
+
+			assert.Eventually(t, func() bool {
+				return len(p.conns.list()) == 0
+			}, testTimeout, 10*time.Millisecond)
+		})
+	}
+}
+
+// isTimeout checks if err is a network timeout.
+func isTimeout(err error) (ok bool) {
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func TestRuleSet_Validate(t *testing.T) {
+	testCases := []struct {
+		rules   *RuleSet
+		name    string
+		wantErr bool
+	}{{
+		rules:   &RuleSet{BlockRules: []string{"*.example.org"}},
+		name:    "valid",
+		wantErr: false,
+	}, {
+		rules:   &RuleSet{DropRules: []string{""}},
+		name:    "empty_rule",
+		wantErr: true,
+	}, {
+		rules:   &RuleSet{BlockRules: []string{"label *.example.org"}},
+		name:    "mode_prefix",
+		wantErr: false,
+	}, {
+		rules:   &RuleSet{BlockRules: []string{"label "}},
+		name:    "mode_prefix_only",
+		wantErr: true,
+	}, {
+		rules:   &RuleSet{BandwidthRules: map[string]float64{"*": -1}},
+		name:    "negative_rate",
+		wantErr: true,
+	}, {
+		rules: &RuleSet{ForwardSchedule: []ForwardScheduleRule{{
+			Wildcard: "*",
+			Start:    0,
+			End:      25 * time.Hour,
+		}}},
+		name:    "schedule_out_of_day",
+		wantErr: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rules.Validate()
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
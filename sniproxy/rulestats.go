@@ -0,0 +1,61 @@
+package sniproxy
+
+import (
+	"github.com/ameshkov/sniproxy/internal/filter"
+)
+
+// Kinds of the rules in the rule statistics.
+const (
+	ruleKindForward         = "forward"
+	ruleKindForwardPath     = "forward_path"
+	ruleKindForwardSchedule = "forward_schedule"
+	ruleKindForwardProxy    = "forward_proxy"
+	ruleKindBlock           = "block"
+	ruleKindBlockPath       = "block_path"
+	ruleKindDrop            = "drop"
+	ruleKindExpectedSNI     = "expected_sni"
+	ruleKindHTTPSOnly       = "https_only"
+	ruleKindMinTLSVersion   = "min_tls_version"
+	ruleKindIdleTimeout     = "idle_timeout"
+	ruleKindMaxDuration     = "max_duration"
+)
+
+// RuleStats returns how many times each of the current rules matched a
+// connection.  The rules that never matched are included with zero matches.
+// Bandwidth rules are not included, see [SNIProxy.BandwidthStats].
+func (p *SNIProxy) RuleStats() (stats []filter.RuleStat) {
+	rules := p.rules.Load()
+
+	var scheduleRules []string
+	for _, r := range rules.ForwardSchedule {
+		scheduleRules = append(scheduleRules, r.Wildcard)
+	}
+
+	var minTLSVersionRules []string
+	for _, r := range p.minTLSVersionRules {
+		minTLSVersionRules = append(minTLSVersionRules, r.Wildcard)
+	}
+
+	var forwardProxyRules []string
+	for _, r := range p.forwardProxyRules {
+		forwardProxyRules = append(forwardProxyRules, r.wildcard)
+	}
+
+	idleTimeoutRules := timeoutRuleWildcards(p.idleTimeoutRules)
+	maxDurationRules := timeoutRuleWildcards(p.maxDurationRules)
+
+	stats = append(stats, p.ruleStats.Collect(ruleKindForward, rules.ForwardRules)...)
+	stats = append(stats, p.ruleStats.Collect(ruleKindForwardPath, rules.ForwardPathRules)...)
+	stats = append(stats, p.ruleStats.Collect(ruleKindForwardSchedule, scheduleRules)...)
+	stats = append(stats, p.ruleStats.Collect(ruleKindForwardProxy, forwardProxyRules)...)
+	stats = append(stats, p.ruleStats.Collect(ruleKindBlock, rules.BlockRules)...)
+	stats = append(stats, p.ruleStats.Collect(ruleKindBlockPath, rules.BlockPathRules)...)
+	stats = append(stats, p.ruleStats.Collect(ruleKindDrop, rules.DropRules)...)
+	stats = append(stats, p.ruleStats.Collect(ruleKindExpectedSNI, rules.ExpectedSNI)...)
+	stats = append(stats, p.ruleStats.Collect(ruleKindHTTPSOnly, rules.HTTPSOnlyRules)...)
+	stats = append(stats, p.ruleStats.Collect(ruleKindMinTLSVersion, minTLSVersionRules)...)
+	stats = append(stats, p.ruleStats.Collect(ruleKindIdleTimeout, idleTimeoutRules)...)
+	stats = append(stats, p.ruleStats.Collect(ruleKindMaxDuration, maxDurationRules)...)
+
+	return stats
+}
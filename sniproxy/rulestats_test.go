@@ -0,0 +1,41 @@
+package sniproxy
+
+import (
+	"testing"
+
+	"github.com/ameshkov/sniproxy/internal/filter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_RuleStats(t *testing.T) {
+	p, err := New(&Config{
+		ForwardProxies: []string{"socks5://127.0.0.1:1080"},
+		ForwardRules:   []string{"*.example.org"},
+		BlockRules:     []string{"*.example.com", "example.net"},
+	})
+	require.NoError(t, err)
+
+	for _, host := range []string{"a.example.com", "b.example.com", "example.org", "a.example.org"} {
+		ctx := NewSNIContext(host, host+":443")
+		ctx.rules = p.rules.Load()
+
+		if _, blocked := p.shouldBlock(ctx); !blocked {
+			_, _ = p.shouldForward(ctx)
+		}
+	}
+
+	assert.Equal(t, []filter.RuleStat{{
+		Kind:    ruleKindForward,
+		Rule:    "*.example.org",
+		Matches: 1,
+	}, {
+		Kind:    ruleKindBlock,
+		Rule:    "*.example.com",
+		Matches: 2,
+	}, {
+		Kind:    ruleKindBlock,
+		Rule:    "example.net",
+		Matches: 0,
+	}}, p.RuleStats())
+}
@@ -0,0 +1,171 @@
+package sniproxy
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Outcomes of the connections in [RunSummary.Outcomes].  Besides these, the
+// connections rejected by the rules and policies are counted by the action in
+// the audit log, i.e. "block" or "drop".
+const (
+	// OutcomeTunneled is the outcome of the connections that were tunneled to
+	// the remote host.
+	OutcomeTunneled = "tunneled"
+
+	// OutcomeError is the outcome of the connections that failed, e.g.
+	// because the proxy could not parse the ClientHello or connect to the
+	// remote host.
+	OutcomeError = "error"
+
+	// OutcomeUnresolved is the outcome of the connections to the remote hosts
+	// that could not be resolved.
+	OutcomeUnresolved = "unresolved"
+
+	// OutcomeBusy is the outcome of the connections that were refused because
+	// all the workers were busy.
+	OutcomeBusy = "busy"
+)
+
+// maxSummaryHosts is the maximum number of the remote hosts the totals are
+// kept for.  The tunnels to other hosts are only counted in the overall
+// totals, so that the memory is bounded on a long run.
+const maxSummaryHosts = 10_000
+
+// HostTotals are the totals of the tunnels to a remote host.
+type HostTotals struct {
+	Host  string `json:"host"`
+	Conns uint64 `json:"conns"`
+	Bytes int64  `json:"bytes"`
+}
+
+// RunSummary are the totals of the connections handled since the proxy was
+// created.
+type RunSummary struct {
+	// Uptime is the time since the proxy was created.
+	Uptime time.Duration
+
+	// Connections is the number of the accepted connections.
+	Connections uint64
+
+	// BytesReceived is the number of bytes received from the remote hosts.
+	BytesReceived int64
+
+	// BytesSent is the number of bytes sent to the remote hosts.
+	BytesSent int64
+
+	// Outcomes is the number of the connections by their outcome, see
+	// [OutcomeTunneled].
+	Outcomes map[string]uint64
+
+	// TopHosts are the remote hosts with the largest number of tunnels in
+	// descending order.
+	TopHosts []HostTotals
+}
+
+// runTotals keeps the totals of the connections for [RunSummary].  It is safe
+// for concurrent use.
+type runTotals struct {
+	// mu protects all the fields below.
+	mu sync.Mutex
+
+	started       time.Time
+	connections   uint64
+	bytesReceived int64
+	bytesSent     int64
+	outcomes      map[string]uint64
+	hosts         map[string]*HostTotals
+}
+
+// newRunTotals creates a new *runTotals.
+func newRunTotals() (t *runTotals) {
+	return &runTotals{
+		started:  time.Now(),
+		outcomes: map[string]uint64{},
+		hosts:    map[string]*HostTotals{},
+	}
+}
+
+// accepted counts an accepted connection.
+func (t *runTotals) accepted() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.connections++
+}
+
+// finished counts the outcome of a connection.
+func (t *runTotals) finished(outcome string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.outcomes[outcome]++
+}
+
+// tunneled counts a finished tunnel to host.
+func (t *runTotals) tunneled(host string, received, sent int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.outcomes[OutcomeTunneled]++
+	t.bytesReceived += received
+	t.bytesSent += sent
+
+	h, ok := t.hosts[host]
+	if !ok {
+		if len(t.hosts) >= maxSummaryHosts {
+			return
+		}
+
+		h = &HostTotals{Host: host}
+		t.hosts[host] = h
+	}
+
+	h.Conns++
+	h.Bytes += received + sent
+}
+
+// summary returns the totals with up to n top hosts.
+func (t *runTotals) summary(n int) (s *RunSummary) {
+	t.mu.Lock()
+	s = &RunSummary{
+		Uptime:        time.Since(t.started),
+		Connections:   t.connections,
+		BytesReceived: t.bytesReceived,
+		BytesSent:     t.bytesSent,
+		Outcomes:      make(map[string]uint64, len(t.outcomes)),
+		TopHosts:      make([]HostTotals, 0, len(t.hosts)),
+	}
+
+	for o, c := range t.outcomes {
+		s.Outcomes[o] = c
+	}
+
+	for _, h := range t.hosts {
+		s.TopHosts = append(s.TopHosts, *h)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(s.TopHosts, func(i, j int) bool {
+		a, b := s.TopHosts[i], s.TopHosts[j]
+		if a.Conns != b.Conns {
+			return a.Conns > b.Conns
+		}
+
+		return a.Host < b.Host
+	})
+
+	if len(s.TopHosts) > n {
+		s.TopHosts = s.TopHosts[:n]
+	}
+
+	return s
+}
+
+// Summary returns the totals of the connections handled since the proxy was
+// created with up to n remote hosts with the largest number of tunnels.  It is
+// intended for the report logged on shutdown.
+func (p *SNIProxy) Summary(n int) (s *RunSummary) {
+	return p.totals.summary(n)
+}
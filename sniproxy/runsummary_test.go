@@ -0,0 +1,128 @@
+package sniproxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunTotals_summary(t *testing.T) {
+	totals := newRunTotals()
+	for i := 0; i < 3; i++ {
+		totals.accepted()
+		totals.tunneled("a.example", 10, 1)
+	}
+
+	for _, host := range []string{"c.example", "b.example"} {
+		totals.accepted()
+		totals.tunneled(host, 100, 10)
+	}
+
+	totals.accepted()
+	totals.finished(OutcomeError)
+
+	testCases := []struct {
+		name      string
+		wantHosts []HostTotals
+		n         int
+	}{{
+		name:      "none",
+		wantHosts: []HostTotals{},
+		n:         0,
+	}, {
+		name: "top",
+		wantHosts: []HostTotals{
+			{Host: "a.example", Conns: 3, Bytes: 33},
+		},
+		n: 1,
+	}, {
+		// The hosts with the same number of tunnels are sorted by name.
+		name: "all",
+		wantHosts: []HostTotals{
+			{Host: "a.example", Conns: 3, Bytes: 33},
+			{Host: "b.example", Conns: 1, Bytes: 110},
+			{Host: "c.example", Conns: 1, Bytes: 110},
+		},
+		n: 10,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := totals.summary(tc.n)
+
+			assert.Equal(t, uint64(6), s.Connections)
+			assert.Equal(t, int64(230), s.BytesReceived)
+			assert.Equal(t, int64(23), s.BytesSent)
+			assert.Equal(t, map[string]uint64{OutcomeTunneled: 5, OutcomeError: 1}, s.Outcomes)
+			assert.Equal(t, tc.wantHosts, s.TopHosts)
+		})
+	}
+}
+
+func TestRunTotals_maxHosts(t *testing.T) {
+	totals := newRunTotals()
+	for i := 0; i < maxSummaryHosts+10; i++ {
+		totals.tunneled(fmt.Sprintf("host-%d.example", i), 1, 1)
+	}
+
+	s := totals.summary(maxSummaryHosts + 10)
+	assert.Len(t, s.TopHosts, maxSummaryHosts)
+
+	// The tunnels to the hosts over the limit are still counted in the
+	// overall totals.
+	assert.Equal(t, uint64(maxSummaryHosts+10), s.Outcomes[OutcomeTunneled])
+	assert.Equal(t, int64(maxSummaryHosts+10), s.BytesReceived)
+}
+
+func TestSNIProxy_Summary(t *testing.T) {
+	const (
+		tunnels = 2
+		resp    = "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	)
+
+	p := startProxy(t, &Config{BlockRules: []string{"blocked.example"}})
+	backend := startBackend(t, func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = conn.Read(make([]byte, 1024))
+		_, _ = io.WriteString(conn, resp)
+	})
+
+	for i := 0; i < tunnels; i++ {
+		conn := dialHTTP(t, p, backend)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+
+		b, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		require.Equal(t, resp, string(b))
+		require.NoError(t, conn.Close())
+	}
+
+	conn := dialHTTP(t, p, "blocked.example")
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+	_, _ = io.ReadAll(conn)
+
+	var s *RunSummary
+	require.Eventually(t, func() bool {
+		s = p.Summary(10)
+
+		return s.Outcomes[OutcomeTunneled] == tunnels && s.Outcomes["block"] == 1
+	}, testTimeout, time.Millisecond)
+
+	assert.Equal(t, uint64(tunnels+1), s.Connections)
+	assert.Len(t, s.Outcomes, 2)
+	assert.Equal(t, int64(tunnels*len(resp)), s.BytesReceived)
+	assert.Positive(t, s.BytesSent)
+
+	host, _, err := net.SplitHostPort(backend)
+	require.NoError(t, err)
+	require.Len(t, s.TopHosts, 1)
+	assert.Equal(t, host, s.TopHosts[0].Host)
+	assert.Equal(t, uint64(tunnels), s.TopHosts[0].Conns)
+	assert.Equal(t, s.BytesReceived+s.BytesSent, s.TopHosts[0].Bytes)
+}
@@ -0,0 +1,138 @@
+package sniproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ameshkov/sniproxy/internal/filter"
+)
+
+// ForwardScheduleRule limits forwarding of the connections to the domains that
+// match Wildcard to a time-of-day window.  Within the window the connections
+// are forwarded to the forward proxy, outside of it they go directly.
+type ForwardScheduleRule struct {
+	// Wildcard defines what connections the rule applies to.
+	Wildcard string
+
+	// Start is the beginning of the window as an offset from the local
+	// midnight.
+	Start time.Duration
+
+	// End is the end of the window as an offset from the local midnight.  If
+	// End is less than Start, the window spans midnight.
+	End time.Duration
+}
+
+// jsonForwardScheduleRule is the JSON representation of [ForwardScheduleRule]
+// with the times of day in the HH:MM format.
+type jsonForwardScheduleRule struct {
+	Wildcard string `json:"wildcard"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+}
+
+// type check
+var (
+	_ json.Marshaler   = ForwardScheduleRule{}
+	_ json.Unmarshaler = (*ForwardScheduleRule)(nil)
+)
+
+// MarshalJSON implements the [json.Marshaler] interface for
+// ForwardScheduleRule.
+func (r ForwardScheduleRule) MarshalJSON() (b []byte, err error) {
+	return json.Marshal(jsonForwardScheduleRule{
+		Wildcard: r.Wildcard,
+		Start:    formatTimeOfDay(r.Start),
+		End:      formatTimeOfDay(r.End),
+	})
+}
+
+// UnmarshalJSON implements the [json.Unmarshaler] interface for
+// *ForwardScheduleRule.
+func (r *ForwardScheduleRule) UnmarshalJSON(b []byte) (err error) {
+	j := jsonForwardScheduleRule{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+
+	rule := ForwardScheduleRule{Wildcard: j.Wildcard}
+	if rule.Start, err = parseTimeOfDay(j.Start); err != nil {
+		return err
+	}
+	if rule.End, err = parseTimeOfDay(j.End); err != nil {
+		return err
+	}
+
+	*r = rule
+
+	return nil
+}
+
+// ParseForwardScheduleRule parses a forward schedule rule in the
+// "wildcard@HH:MM-HH:MM" format.
+func ParseForwardScheduleRule(s string) (r ForwardScheduleRule, err error) {
+	w, window, ok := strings.Cut(s, "@")
+	if !ok || w == "" {
+		return r, fmt.Errorf("expected wildcard@HH:MM-HH:MM")
+	}
+
+	start, end, ok := strings.Cut(window, "-")
+	if !ok {
+		return r, fmt.Errorf("expected time window HH:MM-HH:MM, got %s", window)
+	}
+
+	r.Wildcard = w
+	if r.Start, err = parseTimeOfDay(start); err != nil {
+		return r, err
+	}
+	if r.End, err = parseTimeOfDay(end); err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+// parseTimeOfDay parses HH:MM and returns it as an offset from midnight.
+func parseTimeOfDay(s string) (d time.Duration, err error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %s: %w", s, err)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// formatTimeOfDay formats an offset from midnight as HH:MM.
+func formatTimeOfDay(d time.Duration) (s string) {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+// contains checks if the time of day of t is within the rule window.
+func (r ForwardScheduleRule) contains(t time.Time) (ok bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	timeOfDay := t.Sub(midnight)
+
+	if r.Start <= r.End {
+		return timeOfDay >= r.Start && timeOfDay < r.End
+	}
+
+	return timeOfDay >= r.Start || timeOfDay < r.End
+}
+
+// matchSchedule finds the first schedule rule that matches the connection and
+// checks if the connection should be forwarded now.  matched is false if there
+// is no schedule rule for this connection.
+func (p *SNIProxy) matchSchedule(ctx *SNIContext) (rule string, forward, matched bool) {
+	for _, r := range ctx.rules.ForwardSchedule {
+		if filter.MatchWildcard(ctx.RemoteHost, r.Wildcard) {
+			p.ruleStats.Inc(ruleKindForwardSchedule, r.Wildcard)
+
+			return r.Wildcard, r.contains(p.now()), true
+		}
+	}
+
+	return "", false, false
+}
@@ -0,0 +1,106 @@
+package sniproxy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_shouldForward_schedule(t *testing.T) {
+	rule := ForwardScheduleRule{
+		Wildcard: "*.example.org",
+		Start:    9 * time.Hour,
+		End:      18 * time.Hour,
+	}
+
+	p, err := New(&Config{
+		ForwardProxies:  []string{"socks5://" + closedAddr(t)},
+		ForwardRules:    []string{"*"},
+		ForwardSchedule: []ForwardScheduleRule{rule},
+	})
+	require.NoError(t, err)
+
+	day := time.Date(2023, 6, 1, 0, 0, 0, 0, time.Local)
+
+	testCases := []struct {
+		now         time.Time
+		name        string
+		host        string
+		wantForward bool
+	}{{
+		now:         day.Add(9*time.Hour - time.Minute),
+		name:        "before_window",
+		host:        "www.example.org",
+		wantForward: false,
+	}, {
+		now:         day.Add(9 * time.Hour),
+		name:        "window_start",
+		host:        "www.example.org",
+		wantForward: true,
+	}, {
+		now:         day.Add(18*time.Hour - time.Second),
+		name:        "window_end",
+		host:        "www.example.org",
+		wantForward: true,
+	}, {
+		now:         day.Add(18 * time.Hour),
+		name:        "after_window",
+		host:        "www.example.org",
+		wantForward: false,
+	}, {
+		now:         day.Add(3 * time.Hour),
+		name:        "no_schedule",
+		host:        "example.com",
+		wantForward: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p.now = func() (now time.Time) { return tc.now }
+
+			ctx := NewSNIContext(tc.host, tc.host+":443")
+			ctx.rules = p.rules.Load()
+			_, forwarded := p.shouldForward(ctx)
+			assert.Equal(t, tc.wantForward, forwarded)
+		})
+	}
+}
+
+func TestForwardScheduleRule_contains_midnight(t *testing.T) {
+	rule := ForwardScheduleRule{
+		Wildcard: "example.org",
+		Start:    22 * time.Hour,
+		End:      6 * time.Hour,
+	}
+
+	day := time.Date(2023, 6, 1, 0, 0, 0, 0, time.Local)
+
+	assert.True(t, rule.contains(day.Add(23*time.Hour)))
+	assert.True(t, rule.contains(day.Add(5*time.Hour)))
+	assert.False(t, rule.contains(day.Add(6*time.Hour)))
+	assert.False(t, rule.contains(day.Add(12*time.Hour)))
+}
+
+func TestForwardScheduleRule_MarshalJSON(t *testing.T) {
+	rule := ForwardScheduleRule{
+		Wildcard: "*.example.org",
+		Start:    9*time.Hour + 5*time.Minute,
+		End:      18*time.Hour + 30*time.Minute,
+	}
+
+	b, err := json.Marshal(rule)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"wildcard": "*.example.org", "start": "09:05", "end": "18:30"}`, string(b))
+
+	var got ForwardScheduleRule
+	require.NoError(t, json.Unmarshal(b, &got))
+
+	assert.Equal(t, rule, got)
+
+	err = json.Unmarshal([]byte(`{"wildcard": "*", "start": "25:00", "end": "18:00"}`), &got)
+	assert.Error(t, err)
+}
@@ -0,0 +1,176 @@
+package sniproxy
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// defaultShutdownTimeout is the default period of time [SNIProxy.Close] waits
+// for the active connections to finish.
+const defaultShutdownTimeout = 30 * time.Second
+
+// handlerGroup tracks the goroutines that handle client connections so that
+// the proxy can wait for them to finish on shutdown.
+type handlerGroup struct {
+	wg sync.WaitGroup
+
+	// mu protects closed and conns.
+	mu sync.Mutex
+
+	// closed is true once the proxy is shutting down and no new handlers can
+	// be added.
+	closed bool
+
+	// conns are the client connections of the active handlers.
+	conns map[net.Conn]*handlerState
+}
+
+// handlerState is the state of a handler exposed for debugging.
+type handlerState struct {
+	// accepted is the time the client connection was accepted.
+	accepted time.Time
+
+	// id is the connection ID.  It is zero until the handler has parsed the
+	// server name.
+	id uint64
+
+	// client is the address of the client.
+	client string
+
+	// listener is the label of the listener that accepted the connection.
+	listener string
+
+	// dest is the address the client requested.
+	dest string
+}
+
+// newHandlerGroup creates a new instance of *handlerGroup.
+func newHandlerGroup() (g *handlerGroup) {
+	return &handlerGroup{
+		conns: map[net.Conn]*handlerState{},
+	}
+}
+
+// add registers the handler of the client connection accepted by the listener
+// with the label.  It must be called before the handler goroutine is started.
+// ok is false if the proxy is shutting down and the connection must not be
+// handled.
+func (g *handlerGroup) add(conn net.Conn, label string) (ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return false
+	}
+
+	g.conns[conn] = &handlerState{
+		accepted: time.Now(),
+		client:   conn.RemoteAddr().String(),
+		listener: label,
+	}
+	g.wg.Add(1)
+
+	return true
+}
+
+// describe records the connection details of the handler of the client
+// connection once they are known.
+func (g *handlerGroup) describe(conn net.Conn, ctx *SNIContext) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	st, ok := g.conns[conn]
+	if !ok {
+		return
+	}
+
+	st.id = ctx.ID
+	st.client = ctx.ClientAddr.String()
+	if ctx.ClientAddr.Port() == 0 {
+		// The real client address from the request headers has no port.
+		st.client = ctx.ClientAddr.Addr().String()
+	}
+
+	st.dest = ctx.RemoteAddr
+}
+
+// states returns the copies of the states of the active handlers.
+func (g *handlerGroup) states() (states []handlerState) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	states = make([]handlerState, 0, len(g.conns))
+	for _, st := range g.conns {
+		states = append(states, *st)
+	}
+
+	return states
+}
+
+// done must be called when the handler of the client connection returns.
+func (g *handlerGroup) done(conn net.Conn) {
+	g.mu.Lock()
+	delete(g.conns, conn)
+	g.mu.Unlock()
+
+	g.wg.Done()
+}
+
+// shutdown waits for the active handlers to finish for timeout.  After that,
+// the tunnels are closed with closeTunnels, the remaining client connections
+// are closed, and shutdown waits until their handlers return.  No new handlers
+// can be added after shutdown is called.
+func (g *handlerGroup) shutdown(timeout time.Duration, closeTunnels func()) {
+	g.mu.Lock()
+	g.closed = true
+	active := len(g.conns)
+	g.mu.Unlock()
+
+	if active == 0 {
+		return
+	}
+
+	log.Info("sniproxy: waiting up to %v for %d active connections to finish", timeout, active)
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		g.wg.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-finished:
+		return
+	case <-timer.C:
+	}
+
+	// Closing only the client connection of a tunnel is not enough, in the
+	// half-close mode the direction from an idle backend would never finish.
+	closeTunnels()
+
+	g.mu.Lock()
+	for conn := range g.conns {
+		log.Info("sniproxy: force-closing connection from %s after shutdown timeout", conn.RemoteAddr())
+
+		// Closing the client connection interrupts the handler whatever it
+		// is doing before the tunnel is established.
+		log.OnCloserError(conn, log.DEBUG)
+	}
+	g.mu.Unlock()
+
+	<-finished
+}
+
+// closeTunnels closes both ends of every tunnel.
+func (p *SNIProxy) closeTunnels() {
+	for _, c := range p.conns.list() {
+		c.close()
+	}
+}
@@ -0,0 +1,51 @@
+package sniproxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_Close_halfClosedTunnel(t *testing.T) {
+	// idleBackend reads the request and never responds nor closes the
+	// connection, so that the direction from the backend is stuck.
+	received := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+
+	idleBackend := func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = conn.Read(make([]byte, 1024))
+		received <- struct{}{}
+
+		_, _ = io.Copy(io.Discard, conn)
+		<-stop
+	}
+	backendAddr := startBackend(t, idleBackend)
+
+	p := startProxy(t, &Config{
+		TunnelErrorMode: TunnelErrorModeHalfClose,
+		ShutdownTimeout: 100 * time.Millisecond,
+	})
+	_ = dialHTTP(t, p, backendAddr)
+
+	select {
+	case <-received:
+	case <-time.After(testTimeout):
+		t.Fatal("tunnel isn't established")
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- p.Close() }()
+
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(testTimeout):
+		t.Fatal("shutdown is stuck")
+	}
+}
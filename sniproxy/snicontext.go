@@ -0,0 +1,91 @@
+package sniproxy
+
+import (
+	"net"
+	"net/netip"
+	"sync/atomic"
+
+	"github.com/ameshkov/sniproxy/internal/tracing"
+)
+
+var lastID uint64
+
+// SNIContext represents a single tunnel connection context.
+type SNIContext struct {
+	// ID is a unique connection ID.
+	ID uint64
+
+	// ClientAddr is the address of the client.  When the real client IP is
+	// taken from X-Forwarded-For or X-Real-IP, the port is still the one the
+	// connection came from.
+	ClientAddr netip.AddrPort
+
+	// Listener is the label of the listener that accepted the connection.  It
+	// allows telling apart connections of different tenants.
+	Listener string
+
+	// RemoteHost is the hostname that was parsed from the connection's TLS
+	// ClientHello.
+	RemoteHost string
+
+	// RemoteAddr is the address the proxy will connect to.  Basically, it is
+	// just remoteHost:remotePort.
+	RemoteAddr string
+
+	// PTRNames is the list of PTR names of the remote host's IP address.  It
+	// is only filled when PTR matching is enabled.
+	PTRNames []string
+
+	// RequestPath is the path of the HTTP request.  It is only set for plain
+	// HTTP connections.
+	RequestPath string
+
+	// BackendAddr is the actual address the proxy has connected to, i.e. the
+	// resolved IP address of RemoteHost.  Note, that when the connection is
+	// forwarded, this is the address of the forward proxy.  It is nil until
+	// the connection is established.
+	BackendAddr net.Addr
+
+	// OriginalDst is the original destination address of a connection that
+	// was redirected to the proxy.  It is only set when the proxy uses it to
+	// connect to the remote host.
+	OriginalDst netip.AddrPort
+
+	// rules are the rules that apply to the connection.  They are the rules
+	// that were current when the connection was accepted, see
+	// [SNIProxy.Reload].
+	rules *RuleSet
+
+	// closed is closed when the tunnel is torn down.  It is nil until the
+	// tunnel is established.
+	closed chan struct{}
+
+	// activity tracks the data transfers in the tunnel.  It is only set when
+	// the idle timeout is enabled.
+	activity *activity
+
+	// span is the tracing span of the connection.  It is nil when tracing is
+	// disabled.
+	span *tracing.Span
+
+	// forwarded is true if the connection is tunneled through a forward
+	// proxy.
+	forwarded bool
+
+	// forceForward is true if an inspector decided to tunnel the connection
+	// through the forward proxies regardless of the forward rules.
+	forceForward bool
+
+	// flaky is true if the connection matches a drop rule and the proxy
+	// emulates a flaky network for it.
+	flaky bool
+}
+
+// NewSNIContext creates a new instance of *SNIContext.
+func NewSNIContext(remoteHost string, remoteAddr string) (c *SNIContext) {
+	return &SNIContext{
+		ID:         atomic.AddUint64(&lastID, 1),
+		RemoteHost: remoteHost,
+		RemoteAddr: remoteAddr,
+	}
+}
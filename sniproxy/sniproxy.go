@@ -0,0 +1,1712 @@
+// Package sniproxy is responsible for the SNI and plain HTTP proxy that will
+// listen for incoming TLS/HTTP connections, read the server name either from
+// the SNI field of ClientHello or from the HTTP Host header, and tunnel traffic
+// to the respective hosts.
+//
+// Other programs can embed the proxy by building a [Config] and passing it to
+// [New], which validates it with [Config.Validate].
+package sniproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/ameshkov/sniproxy/accesslog"
+	"github.com/ameshkov/sniproxy/audit"
+	"github.com/ameshkov/sniproxy/capture"
+	"github.com/ameshkov/sniproxy/internal/filter"
+	"github.com/ameshkov/sniproxy/internal/logutil"
+	"github.com/ameshkov/sniproxy/internal/metrics"
+	"github.com/ameshkov/sniproxy/internal/tracing"
+	"github.com/ameshkov/sniproxy/proxyproto"
+	"github.com/ameshkov/sniproxy/shapeio"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// readTimeout is the default timeout for a connection read deadline.
+	readTimeout = 10 * time.Second
+
+	// connectionTimeout is a timeout for connecting to a remote host.
+	connectionTimeout = 10 * time.Second
+
+	// rejectDrainTimeout is the maximum time the proxy reads the request of a
+	// plain HTTP client it rejects before the request is parsed.
+	rejectDrainTimeout = 1 * time.Second
+
+	// rejectDrainSize is the maximum number of bytes the proxy reads from a
+	// plain HTTP client it rejects before the request is parsed.
+	rejectDrainSize = 64 * 1024
+
+	// defaultDropDelay is the default period of time the proxy waits before
+	// closing the connection if there is a matching "drop rule".
+	defaultDropDelay = 3 * time.Minute
+
+	// remotePortPlain is the port the proxy will be connecting for plain HTTP
+	// connections.
+	remotePortPlain = 80
+
+	// remotePortTLS is the port the proxy will be connecting to for TLS
+	// connection.
+	remotePortTLS = 443
+
+	// defaultCopyChunkSize is the default size of the chunks the data is read
+	// from one side of the tunnel and written to the other one.
+	defaultCopyChunkSize = 32 * 1024
+)
+
+// SNIProxy is a struct that manages the SNI proxy server.  This server's
+// purpose is to handle TLS connections and tunnel them to the respective
+// hosts.  Also, it can handle plain HTTP connections, parse the target host
+// and tunnel traffic there.
+type SNIProxy struct {
+	tlsListenAddr  *net.TCPAddr
+	httpListenAddr *net.TCPAddr
+
+	tlsListenerLabel  string
+	httpListenerLabel string
+
+	sniListener   net.Listener
+	plainListener net.Listener
+
+	dialer         proxy.Dialer
+	resolver       Resolver
+	forwardDialers []*forwardDialer
+
+	// preferIPv6 is true if the remote IPv6 addresses are tried before the
+	// IPv4 ones.  It is set when the proxy listens on IPv6 addresses.
+	preferIPv6 bool
+
+	forwardProxyRules []forwardProxyRule
+
+	forwardFallbackDirect bool
+
+	// forwardResolve defines where the hostnames of the forwarded
+	// connections are resolved.
+	forwardResolve ForwardResolveMode
+
+	// forwardRequired makes Start fail if the forward proxies are unreachable.
+	forwardRequired bool
+
+	// noShapeForwarded disables bandwidth limits for forwarded connections.
+	noShapeForwarded bool
+
+	// rules are the rules that can be reloaded at runtime.  Each connection
+	// uses the rules that were current when it was accepted.
+	rules    atomic.Pointer[RuleSet]
+	matchPTR bool
+
+	// conns are the connections that are being tunneled.
+	conns *connTracker
+
+	// handlers are the goroutines that handle client connections, see
+	// [SNIProxy.Close].
+	handlers        *handlerGroup
+	shutdownTimeout time.Duration
+
+	// workers handle the accepted connections.  It is nil if a new goroutine
+	// is started for every connection.
+	workers *workerPool
+
+	// ready is false while the proxy refuses new connections, see
+	// [SNIProxy.SetReady].
+	ready atomic.Bool
+
+	limiter        shapeio.Limiter
+	bandwidthStats *bandwidthStats
+
+	tunnelErrorMode TunnelErrorMode
+
+	// totals are the totals of the connections for [SNIProxy.Summary].
+	totals *runTotals
+
+	// shaperAlgorithm is the algorithm of the bandwidth limiters.
+	shaperAlgorithm shapeio.Algorithm
+
+	// ruleStats counts the rule matches, see [SNIProxy.RuleStats].
+	ruleStats *filter.Stats
+
+	ipLimiter *ipLimiter
+
+	// trustedProxies are the networks of the reverse proxies whose
+	// X-Forwarded-For and X-Real-IP headers are trusted.
+	trustedProxies  []netip.Prefix
+	limitRetryAfter time.Duration
+
+	maxTunnelDuration time.Duration
+	slowThreshold     time.Duration
+	maxDurationRules  []TimeoutRule
+
+	idleTimeout      time.Duration
+	idleTimeoutRules []TimeoutRule
+	livenessInterval time.Duration
+
+	linger *int
+
+	healthPath string
+	healthHost string
+
+	passthroughOnParseError bool
+	requireSNI              bool
+
+	// resolveFallback is the address the connections to the remote hosts
+	// that cannot be resolved are tunneled to.  If empty, such connections
+	// are closed.
+	resolveFallback string
+
+	// resolveFailureStatus is the status code of the response sent to the
+	// plain HTTP clients when the remote host cannot be resolved.  If zero,
+	// the connection is closed without a response.
+	resolveFailureStatus int
+
+	peekMaxReads int
+	peekTimeout  time.Duration
+
+	// peekBudget limits the bytes buffered by all the connections that are
+	// being peeked.  It is nil if there is no limit.
+	peekBudget *peekBudget
+
+	logClientHello    bool
+	quietEmptyTunnels bool
+
+	// tracer exports a span for every connection.  It is nil when tracing is
+	// disabled.
+	tracer *tracing.Tracer
+
+	// inspectors are called before the standard rules, see [Inspector].
+	inspectors []Inspector
+
+	// auditLog receives the blocked and dropped connections.  It may be nil.
+	auditLog *audit.Logger
+
+	// accessLog receives the finished tunnels.  It may be nil.
+	accessLog *accesslog.Logger
+
+	// capturer receives the first bytes of the connections that could not be
+	// parsed.  It may be nil.
+	capturer *capture.Capturer
+
+	// freebind allows binding the listeners to the addresses that are not
+	// assigned to the host.
+	freebind bool
+
+	// localHosts are the contents of the local virtual hosts by the lowercased
+	// host.
+	localHosts map[string]*localContent
+
+	// tcpUserTimeout is TCP_USER_TIMEOUT of the accepted connections.  If
+	// zero, the OS default is used.
+	tcpUserTimeout time.Duration
+
+	// statsInterval is how often the interim statistics of the tunnels are
+	// reported.  If zero, they are not reported.
+	statsInterval time.Duration
+
+	// onStats receives the interim statistics of the tunnels.  If nil, they
+	// are logged.
+	onStats StatsFunc
+
+	dropMode  DropMode
+	dropDelay time.Duration
+
+	allowedPorts []int
+
+	blockTLSAlert TLSAlert
+
+	httpsOnlyMode PolicyMode
+
+	minTLSVersionRules []MinTLSVersionRule
+	minTLSVersionMode  PolicyMode
+	minCipherStrength  CipherStrength
+
+	transparent bool
+
+	proxyProtocol proxyproto.Version
+
+	// copyBufPool is the pool of buffers used for copying data in tunnels.
+	// All the buffers have the configured copy chunk size.
+	copyBufPool *sync.Pool
+
+	// now returns the current time.  It is used by the time-dependent rules
+	// and can be replaced in tests.
+	now func() time.Time
+}
+
+// type check
+var _ io.Closer = (*SNIProxy)(nil)
+
+// New creates a new instance of *SNIProxy.
+func New(cfg *Config) (d *SNIProxy, err error) {
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var resolver Resolver = &net.Resolver{}
+	if len(cfg.Upstreams) > 0 {
+		resolver, err = newUpstreamResolver(cfg.Upstreams, cfg.UpstreamTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	localHosts, err := loadLocalHosts(cfg.LocalHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	capturer, err := newCapturer(cfg.Capture)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := newDialer(cfg)
+
+	forwardDialers, forwardProxyRules, err := newForwardDialers(cfg, dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracer *tracing.Tracer
+	if cfg.OTelEndpoint != "" {
+		var exporter *tracing.OTLPExporter
+		exporter, err = tracing.NewOTLPExporter(cfg.OTelEndpoint, "sniproxy")
+		if err != nil {
+			return nil, err
+		}
+
+		tracer = tracing.New(exporter)
+	}
+
+	p := &SNIProxy{
+		tlsListenAddr:           cfg.TLSListenAddr,
+		httpListenAddr:          cfg.HTTPListenAddr,
+		tlsListenerLabel:        listenerLabel(cfg.TLSListenerLabel, "tls", cfg.TLSListenAddr),
+		httpListenerLabel:       listenerLabel(cfg.HTTPListenerLabel, "http", cfg.HTTPListenAddr),
+		dialer:                  dialer,
+		resolver:                resolver,
+		preferIPv6:              listenersPreferIPv6(cfg.TLSListenAddr, cfg.HTTPListenAddr),
+		forwardDialers:          forwardDialers,
+		forwardProxyRules:       forwardProxyRules,
+		forwardFallbackDirect:   cfg.ForwardFallbackDirect,
+		forwardResolve:          cfg.ForwardResolve,
+		forwardRequired:         cfg.ForwardRequired,
+		noShapeForwarded:        cfg.NoShapeForwarded,
+		conns:                   newConnTracker(),
+		handlers:                newHandlerGroup(),
+		workers:                 newWorkerPool(cfg.Workers),
+		shutdownTimeout:         cfg.ShutdownTimeout,
+		bandwidthStats:          newBandwidthStats(),
+		totals:                  newRunTotals(),
+		ruleStats:               filter.NewStats(),
+		matchPTR:                cfg.MatchPTR,
+		limiter:                 shapeio.NewLimiter(cfg.ShaperAlgorithm, cfg.BandwidthRate),
+		tunnelErrorMode:         cfg.TunnelErrorMode,
+		shaperAlgorithm:         cfg.ShaperAlgorithm,
+		ipLimiter:               newIPLimiter(cfg.MaxConnsPerIP),
+		trustedProxies:          cfg.TrustedProxies,
+		limitRetryAfter:         cfg.LimitRetryAfter,
+		maxTunnelDuration:       cfg.MaxTunnelDuration,
+		maxDurationRules:        cfg.MaxDurationRules,
+		slowThreshold:           cfg.SlowThreshold,
+		idleTimeout:             cfg.IdleTimeout,
+		idleTimeoutRules:        cfg.IdleTimeoutRules,
+		livenessInterval:        cfg.LivenessInterval,
+		linger:                  cfg.Linger,
+		healthPath:              cfg.HealthPath,
+		healthHost:              strings.TrimSuffix(cfg.HealthHost, "."),
+		passthroughOnParseError: cfg.PassthroughOnParseError,
+		resolveFallback:         cfg.ResolveFallback,
+		resolveFailureStatus:    cfg.ResolveFailureStatus,
+		requireSNI:              cfg.RequireSNI,
+		peekMaxReads:            cfg.PeekMaxReads,
+		peekTimeout:             cfg.PeekTimeout,
+		peekBudget:              newPeekBudget(cfg.MaxPeekMemory),
+		logClientHello:          cfg.LogClientHello,
+		quietEmptyTunnels:       cfg.QuietEmptyTunnels,
+		tracer:                  tracer,
+		inspectors:              cfg.Inspectors,
+		auditLog:                cfg.Audit,
+		accessLog:               cfg.AccessLog,
+		capturer:                capturer,
+		statsInterval:           cfg.StatsInterval,
+		tcpUserTimeout:          cfg.TCPUserTimeout,
+		localHosts:              localHosts,
+		freebind:                cfg.Freebind,
+		onStats:                 cfg.OnStats,
+		dropMode:                cfg.DropMode,
+		blockTLSAlert:           cfg.BlockTLSAlert,
+		dropDelay:               cfg.DropDelay,
+		allowedPorts:            cfg.AllowedPorts,
+		httpsOnlyMode:           cfg.HTTPSOnlyMode,
+		minTLSVersionRules:      cfg.MinTLSVersionRules,
+		minTLSVersionMode:       cfg.MinTLSVersionMode,
+		minCipherStrength:       cfg.MinCipherStrength,
+		transparent:             cfg.Transparent,
+		proxyProtocol:           cfg.ProxyProtocol,
+		copyBufPool:             newCopyBufPool(cfg.CopyChunkSize),
+		now:                     time.Now,
+	}
+
+	if p.dropDelay <= 0 {
+		p.dropDelay = defaultDropDelay
+	}
+
+	if p.shutdownTimeout <= 0 {
+		p.shutdownTimeout = defaultShutdownTimeout
+	}
+
+	if p.peekTimeout <= 0 {
+		p.peekTimeout = readTimeout
+	}
+
+	p.ready.Store(!cfg.WaitReady)
+
+	p.rules.Store((&RuleSet{
+		ForwardRules:     cfg.ForwardRules,
+		ForwardPathRules: cfg.ForwardPathRules,
+		ForwardSchedule:  cfg.ForwardSchedule,
+		BlockRules:       cfg.BlockRules,
+		BlockPathRules:   cfg.BlockPathRules,
+		DropRules:        cfg.DropRules,
+		ExpectedSNI:      cfg.ExpectedSNI,
+		HTTPSOnlyRules:   cfg.HTTPSOnlyRules,
+		BandwidthRules:   cfg.BandwidthRules,
+	}).clone())
+
+	return p, nil
+}
+
+// Start starts the SNIProxy server.
+func (p *SNIProxy) Start() (err error) {
+	log.Info("sniproxy: starting")
+
+	if p.forwardRequired {
+		err = p.checkForwardProxies()
+		if err != nil {
+			return fmt.Errorf("sniproxy: failed to start SNIProxy: %w", err)
+		}
+	}
+
+	p.sniListener, err = p.listen(p.tlsListenAddr)
+	if err != nil {
+		return fmt.Errorf("sniproxy: failed to start SNIProxy: %w", err)
+	}
+
+	p.plainListener, err = p.listen(p.httpListenAddr)
+	if err != nil {
+		return fmt.Errorf("sniproxy: failed to start SNIProxy: %w", err)
+	}
+
+	p.startWorkers()
+
+	go p.acceptLoop(p.sniListener, false, p.tlsListenerLabel)
+	go p.acceptLoop(p.plainListener, true, p.httpListenerLabel)
+
+	log.Info("sniproxy: started successfully")
+
+	return nil
+}
+
+// newDialer creates the dialer used to connect to the remote hosts and the
+// forward proxies.
+func newDialer(cfg *Config) (dialer proxy.Dialer) {
+	netDialer := &net.Dialer{
+		Timeout: connectionTimeout,
+	}
+	if cfg.TCPUserTimeout > 0 {
+		netDialer.Control = userTimeoutControl(cfg.TCPUserTimeout)
+	}
+
+	if cfg.DialSourcePortMin > 0 {
+		return &sourcePortDialer{
+			dialer: netDialer,
+			min:    cfg.DialSourcePortMin,
+			max:    cfg.DialSourcePortMax,
+		}
+	}
+
+	return netDialer
+}
+
+// newForwardDialers creates the dialers for the forward proxies and the
+// forward proxy rules of cfg.
+func newForwardDialers(
+	cfg *Config,
+	dialer proxy.Dialer,
+) (forwardDialers []*forwardDialer, forwardProxyRules []forwardProxyRule, err error) {
+	for _, forwardProxy := range cfg.ForwardProxies {
+		var d *forwardDialer
+		d, err = newForwardDialer(
+			forwardProxy,
+			dialer,
+			cfg.ForwardProbeTimeout,
+			cfg.ForwardConnectTimeout,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		forwardDialers = append(forwardDialers, d)
+	}
+
+	for _, r := range cfg.ForwardProxyRules {
+		var d *forwardDialer
+		d, err = newForwardDialer(
+			r.Proxy,
+			dialer,
+			cfg.ForwardProbeTimeout,
+			cfg.ForwardConnectTimeout,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		forwardProxyRules = append(forwardProxyRules, forwardProxyRule{
+			wildcard: r.Wildcard,
+			dialer:   d,
+		})
+	}
+
+	return forwardDialers, forwardProxyRules, nil
+}
+
+// listen starts listening for TCP connections on addr.
+func (p *SNIProxy) listen(addr *net.TCPAddr) (l net.Listener, err error) {
+	var setUserTimeout func(network, address string, c syscall.RawConn) (err error)
+	if p.tcpUserTimeout > 0 {
+		setUserTimeout = userTimeoutControl(p.tcpUserTimeout)
+	}
+
+	lc := &net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) (err error) {
+			if p.freebind {
+				err = freebindControl(network, address, c)
+			}
+
+			if err == nil && setUserTimeout != nil {
+				err = setUserTimeout(network, address, c)
+			}
+
+			return err
+		},
+	}
+
+	return lc.Listen(context.Background(), "tcp", addr.String())
+}
+
+// SetReady sets whether the proxy is ready to serve connections.  New
+// connections are closed right away while the proxy is not ready.
+func (p *SNIProxy) SetReady(ready bool) {
+	p.ready.Store(ready)
+
+	log.Info("sniproxy: ready: %t", ready)
+}
+
+// Ready returns true if the proxy is ready to serve connections.
+func (p *SNIProxy) Ready() (ok bool) {
+	return p.ready.Load()
+}
+
+// Close implements the [io.Closer] interface for SNIProxy.
+//
+// It stops accepting new connections and waits for the active ones to finish
+// for the shutdown timeout.  The connections that are still active after that
+// are closed.
+func (p *SNIProxy) Close() (err error) {
+	log.Info("sniproxy: stopping")
+
+	sniErr := p.sniListener.Close()
+	plainErr := p.plainListener.Close()
+
+	// The workers are stopped after the handlers so that the connections
+	// waiting in the queue are handled as well.
+	p.handlers.shutdown(p.shutdownTimeout, p.closeTunnels)
+	p.stopWorkers()
+
+	var resolverErr error
+	if c, ok := p.resolver.(io.Closer); ok {
+		resolverErr = c.Close()
+	}
+
+	var tracerErr error
+	if p.tracer != nil {
+		tracerErr = p.tracer.Close()
+	}
+
+	log.Info("sniproxy: stopped")
+
+	return errors.Join(sniErr, plainErr, resolverErr, tracerErr)
+}
+
+// listenerLabel returns the label of the listener.  If it is not configured,
+// the protocol and the listen address are used.
+func listenerLabel(label, proto string, addr *net.TCPAddr) (l string) {
+	if label != "" {
+		return label
+	}
+
+	return proto + "/" + addr.String()
+}
+
+// acceptLoop accepts incoming TCP connections and starts goroutines processing
+// them.  label is the listener label that is assigned to the connections.
+func (p *SNIProxy) acceptLoop(l net.Listener, plainHTTP bool, label string) {
+	if plainHTTP {
+		log.Info("sniproxy: listening for HTTP connections on %s", l.Addr())
+	} else {
+		log.Info("sniproxy: listening for TLS connections on %s", l.Addr())
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil && strings.Contains(err.Error(), "closed network connection") {
+			log.Info("sniproxy: exiting listener loop as it has been closed")
+
+			return
+		} else if err != nil {
+			log.Error("sniproxy: accepting connection: %v", err)
+
+			continue
+		}
+
+		if !p.ready.Load() {
+			log.Debug("sniproxy: refusing connection from %s as the proxy is not ready", conn.RemoteAddr())
+			log.OnCloserError(conn, log.DEBUG)
+
+			continue
+		}
+
+		if p.peekBudget.exhausted() {
+			log.Debug(
+				"sniproxy: refusing connection from %s as the peek memory limit is reached",
+				conn.RemoteAddr(),
+			)
+			log.OnCloserError(conn, log.DEBUG)
+
+			continue
+		}
+
+		if !p.handlers.add(conn, label) {
+			log.Debug("sniproxy: refusing connection from %s as the proxy is stopping", conn.RemoteAddr())
+			log.OnCloserError(conn, log.DEBUG)
+
+			continue
+		}
+
+		p.totals.accepted()
+		if plainHTTP {
+			metrics.SNIConnections.Inc(metrics.ProtoHTTP)
+		} else {
+			metrics.SNIConnections.Inc(metrics.ProtoTLS)
+		}
+
+		p.dispatch(acceptedConn{
+			conn:      conn,
+			label:     label,
+			plainHTTP: plainHTTP,
+		})
+	}
+}
+
+// newConnContext creates the context of the client connection with the parsed
+// info.  label is the label of the listener that accepted the connection.
+func (p *SNIProxy) newConnContext(
+	clientConn net.Conn,
+	info *peekInfo,
+	serverName string,
+	remotePort int,
+	label string,
+) (ctx *SNIContext) {
+	ctx = NewSNIContext(serverName, netutil.JoinHostPort(serverName, remotePort))
+	ctx.ClientAddr = addrPortFromNetAddr(clientConn.RemoteAddr())
+	ctx.OriginalDst = info.originalDst
+	ctx.Listener = label
+	ctx.rules = p.rules.Load()
+	if info.request != nil {
+		ctx.RequestPath = info.request.URL.Path
+
+		if addr, ok := p.realClientAddr(ctx.ClientAddr, info.request.Header); ok {
+			log.Debug("sniproxy: [%d] real client address of %s is %s", ctx.ID, ctx.ClientAddr, addr.Addr())
+
+			ctx.ClientAddr = addr
+		}
+	}
+
+	return ctx
+}
+
+// handleConnection handles a new incoming client connection, parses SNI or
+// HTTP request and tunnels traffic to the specified upstream.  label is the
+// label of the listener that accepted the connection.
+func (p *SNIProxy) handleConnection(
+	clientConn net.Conn,
+	plainHTTP bool,
+	label string,
+) (err error) {
+	defer log.OnCloserError(clientConn, log.DEBUG)
+
+	// The per-IP limit is checked before the connection is peeked, so that the
+	// clients over it don't take up the peek memory.  The connections from the
+	// trusted proxies are limited by the real client address once the request
+	// is parsed.
+	peerIP := addrPortFromNetAddr(clientConn.RemoteAddr()).Addr()
+	limitedEarly := !p.trustedProxy(peerIP)
+	if limitedEarly {
+		if !p.ipLimiter.acquire(peerIP) {
+			return p.rejectLimitExceeded(clientConn, peerIP, plainHTTP)
+		}
+		defer p.ipLimiter.release(peerIP)
+	}
+
+	info, clientReader, err := p.peekConn(clientConn, plainHTTP)
+	if errors.Is(err, errSSLv2ClientHello) {
+		log.Info("sniproxy: rejected connection from %s: %v", clientConn.RemoteAddr(), err)
+
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	serverName, remotePort := splitServerName(info.serverName, plainHTTP)
+	if plainHTTP {
+		if served, sErr := p.serveLocally(clientConn, info.request, serverName); served {
+			return sErr
+		}
+	}
+
+	ctx := p.newConnContext(clientConn, info, serverName, remotePort, label)
+	p.handlers.describe(clientConn, ctx)
+
+	p.startSpan(ctx)
+	defer func() { finishSpan(ctx, err) }()
+
+	if p.logClientHello && info.clientHello != nil {
+		logClientHello(ctx, info.clientHello)
+	}
+
+	if p.requireSNI && info.clientHello != nil && info.clientHello.ServerName == "" {
+		p.rejectNoSNI(ctx, clientConn)
+
+		return nil
+	}
+
+	if !p.portAllowed(remotePort) {
+		return rejectDisallowedPort(ctx, clientConn, info.request)
+	}
+
+	if !limitedEarly {
+		clientIP := ctx.ClientAddr.Addr()
+		if !p.ipLimiter.acquire(clientIP) {
+			return p.rejectLimitExceeded(clientConn, clientIP, plainHTTP)
+		}
+		defer p.ipLimiter.release(clientIP)
+	}
+
+	decided, proceed := p.applyInspectors(ctx, clientConn, info, plainHTTP)
+	if !proceed || !p.applyRules(ctx, clientConn, info, plainHTTP, decided) {
+		return nil
+	}
+
+	backendConn, err := p.connectBackend(ctx, clientConn)
+	var resErr *resolveError
+	if errors.As(err, &resErr) && p.resolveFallback == "" {
+		return p.rejectUnresolved(ctx, clientConn, plainHTTP, resErr)
+	}
+	if err != nil {
+		return err
+	}
+	defer log.OnCloserError(backendConn, log.DEBUG)
+
+	startTime := time.Now()
+	bytesReceived, bytesSent, status := p.relay(
+		ctx,
+		clientConn,
+		clientReader,
+		backendConn,
+		info.request,
+	)
+
+	metrics.SNIBytesReceived.Add(uint64(bytesReceived))
+	metrics.SNIBytesSent.Add(uint64(bytesSent))
+	p.totals.tunneled(ctx.RemoteHost, bytesReceived, bytesSent)
+
+	ctx.span.SetAttribute("sniproxy.bytes_received", bytesReceived)
+	ctx.span.SetAttribute("sniproxy.bytes_sent", bytesSent)
+
+	elapsed := time.Now().Sub(startTime)
+
+	p.logTunnelFinished(ctx, bytesReceived, bytesSent, elapsed)
+	p.logAccess(ctx, info.request, status, startTime, elapsed, bytesReceived, bytesSent)
+	p.logSlowTunnel(ctx, bytesReceived, bytesSent, elapsed)
+
+	return nil
+}
+
+// logTunnelFinished logs the statistics of the finished tunnel.
+func (p *SNIProxy) logTunnelFinished(
+	ctx *SNIContext,
+	bytesReceived int64,
+	bytesSent int64,
+	elapsed time.Duration,
+) {
+	bandwidthRate := float64(bytesReceived+bytesSent) / elapsed.Seconds()
+
+	// Tunnels that received nothing from the remote host are usually opened
+	// by probes and scanners.
+	logFinished := log.Info
+	if p.quietEmptyTunnels && bytesReceived == 0 {
+		logFinished = log.Debug
+	}
+
+	logFinished(
+		"sniproxy: [%d] finished tunneling to %s (%s), listener %s. received %d, "+
+			"sent %d, elapsed: %v, rate (bytes/sec): %f",
+		ctx.ID,
+		ctx.RemoteAddr,
+		ctx.BackendAddr,
+		ctx.Listener,
+		bytesReceived,
+		bytesSent,
+		elapsed,
+		bandwidthRate,
+	)
+}
+
+// logSlowTunnel logs a warning about the tunnel if it lasted longer than the
+// slow threshold.
+func (p *SNIProxy) logSlowTunnel(
+	ctx *SNIContext,
+	bytesReceived int64,
+	bytesSent int64,
+	elapsed time.Duration,
+) {
+	if p.slowThreshold <= 0 || elapsed <= p.slowThreshold {
+		return
+	}
+
+	logutil.Warn(
+		"sniproxy: [%d] slow connection to %s from %s: received %d, sent %d, elapsed: %v",
+		ctx.ID,
+		ctx.RemoteAddr,
+		ctx.ClientAddr,
+		bytesReceived,
+		bytesSent,
+		elapsed,
+	)
+}
+
+// connectBackend connects to the remote host of the connection and sends the
+// PROXY protocol header to it if needed.
+func (p *SNIProxy) connectBackend(
+	ctx *SNIContext,
+	clientConn net.Conn,
+) (backendConn net.Conn, err error) {
+	backendConn, err = p.dial(ctx)
+	var resErr *resolveError
+	if errors.As(err, &resErr) && p.resolveFallback != "" {
+		backendConn, err = p.dialResolveFallback(ctx, resErr)
+	}
+
+	if err != nil {
+		metrics.SNIDialErrors.Inc()
+
+		return nil, fmt.Errorf("sniproxy: [%d] failed to connect to %s: %w", ctx.ID, ctx.RemoteAddr, err)
+	}
+
+	ctx.BackendAddr = backendConn.RemoteAddr()
+	log.Debug("sniproxy: [%d] connected to %s", ctx.ID, ctx.BackendAddr)
+
+	if ctx.forwarded {
+		metrics.SNIForwarded.Inc()
+	}
+
+	if p.proxyProtocol != 0 {
+		if err = p.writeProxyHeader(ctx, clientConn, backendConn); err != nil {
+			log.OnCloserError(backendConn, log.DEBUG)
+
+			return nil, fmt.Errorf("sniproxy: [%d] failed to send PROXY protocol header: %w", ctx.ID, err)
+		}
+	}
+
+	return backendConn, nil
+}
+
+// peekConn peeks on the first bytes of the client connection within the peek
+// timeout and parses the remote server name.  If parsing fails and the
+// proxy is configured to pass such connections through, or if there is no
+// server name in the transparent mode, the info points to the original
+// destination of the connection.
+func (p *SNIProxy) peekConn(
+	clientConn net.Conn,
+	plainHTTP bool,
+) (info *peekInfo, clientReader io.Reader, err error) {
+	// The deadline is set once for the whole peek, so that the clients that
+	// trickle data cannot hold the connection longer than that.
+	if err = clientConn.SetReadDeadline(time.Now().Add(p.peekTimeout)); err != nil {
+		return nil, nil, fmt.Errorf("sniproxy: failed to set read deadline: %w", err)
+	}
+
+	peekReader := newPeekReader(clientConn, p.peekMaxReads, p.peekBudget)
+	peekReader.record = len(p.inspectors) > 0 || p.capturer != nil
+	info, clientReader, err = peekServerName(peekReader, plainHTTP)
+	peekReader.finish()
+	if err != nil && p.passthroughOnParseError {
+		info, err = passthroughInfo(clientConn, err)
+	}
+
+	if err != nil {
+		p.captureFailedPeek(clientConn, peekReader.peeked, err)
+	}
+
+	if errors.Is(err, errSSLv2ClientHello) {
+		return nil, nil, err
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("sniproxy: failed to peek server name: %w", err)
+	}
+
+	info.peeked = peekReader.peeked
+
+	if p.transparent && !info.originalDst.IsValid() && needsOriginalDst(info) {
+		err = useOriginalDst(clientConn, info)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sniproxy: transparent mode: %w", err)
+		}
+	}
+
+	if err = clientConn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, nil, fmt.Errorf("sniproxy: failed to remove read deadline: %w", err)
+	}
+
+	return info, clientReader, nil
+}
+
+// applyRules checks the connection against the rules and logs the decision.
+// proceed is false if the connection must not be tunneled, i.e. it is blocked
+// or dropped.  If decided is true, an inspector has already decided what to
+// do with the connection and the rules are not checked.
+func (p *SNIProxy) applyRules(
+	ctx *SNIContext,
+	clientConn net.Conn,
+	info *peekInfo,
+	plainHTTP bool,
+	decided bool,
+) (proceed bool) {
+	if !decided && !plainHTTP && !p.matchesExpectedSNI(ctx) {
+		return false
+	}
+
+	log.Info(
+		"sniproxy: [%d] start tunneling to %s, listener %s",
+		ctx.ID,
+		ctx.RemoteAddr,
+		ctx.Listener,
+	)
+
+	if p.matchPTR {
+		ctx.PTRNames = p.lookupPTR(ctx)
+	}
+
+	if decided {
+		return true
+	}
+
+	if rule, ok := p.shouldBlock(ctx); ok {
+		p.block(ctx, clientConn, plainHTTP, rule)
+
+		return false
+	}
+
+	if plainHTTP && p.blocksPlainHTTP(ctx) {
+		return false
+	}
+
+	if info.clientHello != nil && p.blocksLegacyTLS(ctx, info.clientHello) {
+		return false
+	}
+
+	if info.clientHello != nil && p.blocksWeakCiphers(ctx, info.clientHello) {
+		return false
+	}
+
+	if rule, ok := filter.MatchedWildcard(ctx.RemoteHost, ctx.rules.DropRules); ok {
+		p.ruleStats.Inc(ruleKindDrop, rule)
+
+		if p.dropMode != DropModeFlaky {
+			log.Info("sniproxy: [%d] dropped connection to %s by rule %s", ctx.ID, ctx.RemoteHost, rule)
+			p.audit(ctx, audit.ActionDrop, rule, "")
+
+			// Emulate the situation with a connection that was "dropped".
+			p.stall(ctx, clientConn)
+
+			return false
+		}
+
+		log.Info("sniproxy: [%d] connection to %s will be flaky by rule %s", ctx.ID, ctx.RemoteHost, rule)
+
+		ctx.flaky = true
+	}
+
+	return true
+}
+
+// matchesExpectedSNI returns false if the connection must be dropped because
+// the server name doesn't match the expected SNI rules.
+func (p *SNIProxy) matchesExpectedSNI(ctx *SNIContext) (ok bool) {
+	expectedSNI := ctx.rules.ExpectedSNI
+	if len(expectedSNI) == 0 {
+		return true
+	}
+
+	rule, ok := filter.MatchedWildcard(ctx.RemoteHost, expectedSNI)
+	if !ok {
+		log.Info("sniproxy: [%d] dropped connection with unexpected SNI %q", ctx.ID, ctx.RemoteHost)
+		p.audit(ctx, audit.ActionDrop, "", "unexpected sni")
+
+		return false
+	}
+
+	p.ruleStats.Inc(ruleKindExpectedSNI, rule)
+
+	return true
+}
+
+// block logs and counts the blocked connection.  rule is the matched block
+// rule, it is empty if the connection was blocked by an inspector.  TLS
+// clients receive the configured alert.
+func (p *SNIProxy) block(ctx *SNIContext, clientConn net.Conn, plainHTTP bool, rule string) {
+	if rule != "" {
+		log.Info("sniproxy: [%d] blocked connection to %s by rule %s", ctx.ID, ctx.RemoteHost, rule)
+		p.audit(ctx, audit.ActionBlock, rule, "")
+	} else {
+		log.Info("sniproxy: [%d] blocked connection to %s by inspector", ctx.ID, ctx.RemoteHost)
+		p.audit(ctx, audit.ActionBlock, "", "inspector")
+	}
+	metrics.SNIBlocked.Inc()
+
+	if !plainHTTP {
+		p.sendBlockAlert(ctx, clientConn)
+	}
+}
+
+// relay tunnels the traffic between the client and the backend in both
+// directions until both of them are finished.  clientReader must contain the
+// data peeked from clientConn.  req is the HTTP request of a plain HTTP
+// connection, status is the status code of the response to it if the access
+// log is enabled.
+func (p *SNIProxy) relay(
+	ctx *SNIContext,
+	clientConn net.Conn,
+	clientReader io.Reader,
+	backendConn net.Conn,
+	req *http.Request,
+) (bytesReceived, bytesSent int64, status int) {
+	idleTimeout := p.timeoutFor(ctx, ruleKindIdleTimeout, p.idleTimeoutRules, p.idleTimeout)
+	maxDuration := p.timeoutFor(ctx, ruleKindMaxDuration, p.maxDurationRules, p.maxTunnelDuration)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// If one of the directions fails (for instance, the backend sent RST),
+	// there is usually no point in waiting for the other one so close both
+	// connections right away to interrupt it, unless configured otherwise.
+	var closeOnce sync.Once
+	ctx.closed = make(chan struct{})
+	closeBoth := func() {
+		closeOnce.Do(func() {
+			close(ctx.closed)
+			_ = clientConn.Close()
+			_ = backendConn.Close()
+		})
+	}
+
+	p.conns.add(ctx, closeBoth)
+	defer p.conns.remove(ctx)
+
+	startTime := time.Now()
+	if idleTimeout > 0 {
+		ctx.activity = newActivity(startTime)
+	}
+
+	if p.livenessInterval > 0 {
+		p.enableLivenessProbes(ctx, clientConn)
+		p.enableLivenessProbes(ctx, backendConn)
+	}
+
+	p.setLinger(ctx, clientConn)
+	p.setLinger(ctx, backendConn)
+
+	var backendReader io.Reader = backendConn
+	if p.statsInterval > 0 {
+		counters := &tunnelCounters{}
+		backendReader = &countingReader{reader: backendConn, counter: &counters.received}
+		clientReader = &countingReader{reader: clientReader, counter: &counters.sent}
+
+		done := make(chan struct{})
+		defer close(done)
+
+		go p.reportStats(ctx, counters, startTime, done)
+	}
+
+	// The status code of the response is only needed for the access log.
+	var statusRdr *statusReader
+	if p.accessLog != nil && req != nil {
+		statusRdr = &statusReader{reader: backendReader}
+		backendReader = statusRdr
+	}
+
+	go func() {
+		defer wg.Done()
+
+		var tunnelErr error
+		bytesReceived, tunnelErr = p.tunnel(ctx, clientConn, backendReader)
+		if tunnelErr != nil && p.tunnelErrorMode != TunnelErrorModeHalfClose {
+			closeBoth()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+
+		var tunnelErr error
+		bytesSent, tunnelErr = p.tunnel(ctx, backendConn, clientReader)
+		if tunnelErr != nil && p.tunnelErrorMode != TunnelErrorModeHalfClose {
+			closeBoth()
+		}
+	}()
+
+	if maxDuration > 0 {
+		timer := time.AfterFunc(maxDuration, func() {
+			log.Info("sniproxy: [%d] tunnel exceeded max duration %v", ctx.ID, maxDuration)
+
+			closeBoth()
+		})
+		defer timer.Stop()
+	}
+
+	if idleTimeout > 0 {
+		done := make(chan struct{})
+		defer close(done)
+
+		go reapIdle(ctx, idleTimeout, closeBoth, done)
+	}
+
+	wg.Wait()
+
+	if statusRdr != nil {
+		status = statusRdr.status
+	}
+
+	return bytesReceived, bytesSent, status
+}
+
+// stall holds the client connection open without doing anything for the drop
+// delay.  It returns earlier if the client closes the connection.
+func (p *SNIProxy) stall(ctx *SNIContext, clientConn net.Conn) {
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+
+		// Whatever the client sends is discarded, the read only fails when
+		// the client closes the connection or when it is closed by the
+		// caller after the stall.
+		_, _ = io.Copy(io.Discard, clientConn)
+	}()
+
+	timer := time.NewTimer(p.dropDelay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-closed:
+		log.Debug("sniproxy: [%d] client closed dropped connection", ctx.ID)
+	}
+}
+
+// splitServerName splits the server name into the hostname and port.  The
+// server name may contain both host and port, if it does not, the default port
+// for the protocol is used.  IP literals are supported, IPv6 addresses are
+// returned without brackets so that the proxy connects to them directly.  The
+// trailing dot of a fully-qualified hostname is removed so that it matches the
+// rules the same way the hostname without it does.
+func splitServerName(serverName string, plainHTTP bool) (host string, port int) {
+	host, port, err := netutil.SplitHostPort(serverName)
+	if err == nil {
+		return strings.TrimSuffix(host, "."), port
+	}
+
+	if plainHTTP {
+		port = remotePortPlain
+	} else {
+		port = remotePortTLS
+	}
+
+	// Consider a bracketed IPv6 address without port, e.g. "[::1]".
+	host = strings.TrimSuffix(strings.TrimPrefix(serverName, "["), "]")
+
+	return strings.TrimSuffix(host, "."), port
+}
+
+// portAllowed checks if the proxy is allowed to tunnel connections to the
+// remote port.
+func (p *SNIProxy) portAllowed(port int) (ok bool) {
+	if len(p.allowedPorts) == 0 {
+		return true
+	}
+
+	for _, allowed := range p.allowedPorts {
+		if port == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rejectDisallowedPort rejects the connection to a port that is not allowed.
+// Plain HTTP clients, including the ones that send CONNECT requests to the
+// HTTP listener, receive a 403 response, TLS connections are simply closed.
+func rejectDisallowedPort(ctx *SNIContext, clientConn net.Conn, req *http.Request) (err error) {
+	log.Info("sniproxy: [%d] refused connection to disallowed port %s", ctx.ID, ctx.RemoteAddr)
+
+	if req == nil {
+		return nil
+	}
+
+	err = writeHTTPResponse(clientConn, http.StatusForbidden, nil, []byte("Port is not allowed\n"))
+	if err != nil {
+		return fmt.Errorf("sniproxy: [%d] failed to write response: %w", ctx.ID, err)
+	}
+
+	return nil
+}
+
+// rejectLimitExceeded rejects the connection from clientIP that exceeded the
+// per-IP connections limit.  Plain HTTP clients receive a 429 response, TLS
+// connections are simply closed.
+func (p *SNIProxy) rejectLimitExceeded(
+	clientConn net.Conn,
+	clientIP netip.Addr,
+	plainHTTP bool,
+) (err error) {
+	log.Info("sniproxy: too many connections from %s", clientIP)
+
+	if !plainHTTP {
+		return nil
+	}
+
+	h := http.Header{}
+	if p.limitRetryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(int(p.limitRetryAfter.Seconds())))
+	}
+
+	body := []byte("Too many connections\n")
+	err = writeHTTPResponse(clientConn, http.StatusTooManyRequests, h, body)
+	if err != nil {
+		return fmt.Errorf("sniproxy: failed to write response to %s: %w", clientIP, err)
+	}
+
+	drainRequest(clientConn)
+
+	return nil
+}
+
+// drainRequest reads and discards the request the client may still be sending
+// after the response is written.  Otherwise, closing the connection with unread
+// data resets it and the client may lose the response.
+func drainRequest(clientConn net.Conn) {
+	if c, ok := clientConn.(closeWriter); ok {
+		_ = c.CloseWrite()
+	}
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(rejectDrainTimeout))
+	_, _ = io.Copy(io.Discard, io.LimitReader(clientConn, rejectDrainSize))
+}
+
+// addrPortFromNetAddr converts a TCP address to netip.AddrPort.  It returns an
+// empty value if the address is of an unexpected type.
+func addrPortFromNetAddr(addr net.Addr) (addrPort netip.AddrPort) {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		addrPort = tcpAddr.AddrPort()
+
+		return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+	}
+
+	return netip.AddrPort{}
+}
+
+// dial opens a TCP connection to the remote address specified in the context.
+// It also applies forward rules in the case if proxy dialer is specified.
+// Forwarded connections are resolved by the forward proxy, otherwise the
+// hostname is resolved with the proxy resolver and its addresses are tried in
+// order, the ones of the listeners' address family first.  Onion hosts are
+// never resolved locally and never connected to directly, even with
+// forwardFallbackDirect.
+func (p *SNIProxy) dial(ctx *SNIContext) (conn net.Conn, err error) {
+	if dialers, rule := p.forwardDialersFor(ctx); len(dialers) > 0 {
+		ctx.forwarded = true
+
+		if rule != "" {
+			log.Info("sniproxy: [%d] forwarding connection to %s by rule %s", ctx.ID, ctx.RemoteAddr, rule)
+		} else {
+			log.Debug("sniproxy: [%d] forwarding connection to %s", ctx.ID, ctx.RemoteAddr)
+		}
+
+		conn, err = p.dialForward(ctx, dialers)
+		if err == nil || !p.forwardFallbackDirect || isOnion(ctx.RemoteHost) {
+			return conn, err
+		}
+
+		log.Info("sniproxy: [%d] forward proxies failed, connecting directly: %v", ctx.ID, err)
+
+		ctx.forwarded = false
+	}
+
+	return p.dialDirect(ctx)
+}
+
+// dialDirect opens a TCP connection to the remote address without forward
+// proxies.
+func (p *SNIProxy) dialDirect(ctx *SNIContext) (conn net.Conn, err error) {
+	if isOnion(ctx.RemoteHost) {
+		return nil, errOnionDirect
+	}
+
+	if _, err = netip.ParseAddr(ctx.RemoteHost); err == nil {
+		return p.dialer.Dial("tcp", ctx.RemoteAddr)
+	}
+
+	_, port, err := netutil.SplitHostPort(ctx.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := p.resolveRemoteHost(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		conn, err = p.dialer.Dial("tcp", netutil.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+
+		log.Debug("sniproxy: [%d] failed to connect to %s: %v", ctx.ID, addr, err)
+	}
+
+	return nil, err
+}
+
+// resolveRemoteHost resolves the remote hostname with the proxy resolver.  The
+// addresses of the listeners' address family go first.
+func (p *SNIProxy) resolveRemoteHost(ctx *SNIContext) (addrs []netip.Addr, err error) {
+	lookupCtx, cancel := context.WithTimeout(context.Background(), connectionTimeout)
+	defer cancel()
+
+	addrs, err = p.resolver.LookupNetIP(lookupCtx, "ip", ctx.RemoteHost)
+	if err != nil {
+		return nil, &resolveError{host: ctx.RemoteHost, err: err}
+	} else if len(addrs) == 0 {
+		return nil, &resolveError{host: ctx.RemoteHost, err: errNoAddresses}
+	}
+
+	preferFamily(addrs, p.preferIPv6)
+
+	log.Debug("sniproxy: [%d] resolved %s to %v", ctx.ID, ctx.RemoteHost, addrs)
+
+	return addrs, nil
+}
+
+// shouldBlock checks if the connection should be blocked and returns the
+// matched block rule.
+func (p *SNIProxy) shouldBlock(ctx *SNIContext) (rule string, ok bool) {
+	if rule, ok = matchHost(ctx, ctx.rules.BlockRules); ok {
+		p.ruleStats.Inc(ruleKindBlock, rule)
+
+		return rule, true
+	}
+
+	if rule, ok = matchPath(ctx, ctx.rules.BlockPathRules); ok {
+		p.ruleStats.Inc(ruleKindBlockPath, rule)
+
+		return rule, true
+	}
+
+	return "", false
+}
+
+// shouldForward checks if the connection should be forwarded to the next proxy
+// and returns the matched forward or schedule rule.  rule is empty if all
+// connections are forwarded.
+func (p *SNIProxy) shouldForward(ctx *SNIContext) (rule string, ok bool) {
+	if len(p.forwardDialers) == 0 {
+		return "", false
+	}
+
+	if rule, forward, matched := p.matchSchedule(ctx); matched {
+		return rule, forward
+	}
+
+	rules := ctx.rules
+	if len(rules.ForwardRules) == 0 && len(rules.ForwardPathRules) == 0 {
+		// forward all connections if there are no rules.
+		return "", true
+	}
+
+	if rule, ok = matchHost(ctx, rules.ForwardRules); ok {
+		p.ruleStats.Inc(ruleKindForward, rule)
+
+		return rule, true
+	}
+
+	if rule, ok = matchPath(ctx, rules.ForwardPathRules); ok {
+		p.ruleStats.Inc(ruleKindForwardPath, rule)
+
+		return rule, true
+	}
+
+	return "", false
+}
+
+// matchPath checks if the HTTP request path of the connection matches any of
+// the path rules and returns the first matching one.  The rules are matched
+// against host+path, e.g. "example.org/ads/*".  Never matches TLS connections
+// as there is no path.
+func matchPath(ctx *SNIContext, pathRules []string) (rule string, ok bool) {
+	if ctx.RequestPath == "" || len(pathRules) == 0 {
+		return "", false
+	}
+
+	return filter.MatchedWildcard(ctx.RemoteHost+ctx.RequestPath, pathRules)
+}
+
+// newCopyBufPool creates a pool of buffers of the specified size that are used
+// to copy data in tunnels.  If size is not positive, the default size is used.
+func newCopyBufPool(size int) (pool *sync.Pool) {
+	if size <= 0 {
+		size = defaultCopyChunkSize
+	}
+
+	return &sync.Pool{
+		New: func() (v any) {
+			buf := make([]byte, size)
+
+			return &buf
+		},
+	}
+}
+
+// closeWriter is a helper interface which only purpose is to check if the
+// object has CloseWrite function or not and call it if it exists.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// writerOnly hides all the methods of the io.Writer except Write.
+type writerOnly struct {
+	io.Writer
+}
+
+// readerOnly hides all the methods of the io.Reader except Read.
+type readerOnly struct {
+	io.Reader
+}
+
+// tunnel copies data from src to dst and returns the number of bytes written.
+// err is not nil if copying was interrupted by an error rather than EOF.
+func (p *SNIProxy) tunnel(
+	ctx *SNIContext,
+	dst net.Conn,
+	src io.Reader,
+) (written int64, err error) {
+	defer func() {
+		// In the case of *tcp.Conn and *tls.Conn we should call CloseWriter, so
+		// we're using closeWriter interface to check for that function
+		// presence.
+		switch c := dst.(type) {
+		case closeWriter:
+			_ = c.CloseWrite()
+		default:
+			_ = c.Close()
+		}
+	}()
+
+	if ctx.flaky {
+		src = newFlakyReader(src, ctx.closed)
+	}
+
+	var r io.Reader = src
+	var w io.Writer = dst
+	if p.noShapeForwarded && ctx.forwarded {
+		log.Debug("sniproxy: [%d] not limiting speed of forwarded connection", ctx.ID)
+	} else {
+		r, w = p.shape(ctx, src, dst)
+	}
+
+	if ctx.activity != nil {
+		w = &activityWriter{
+			writer:   w,
+			activity: ctx.activity,
+		}
+	}
+
+	bufPtr := p.copyBufPool.Get().(*[]byte)
+	defer p.copyBufPool.Put(bufPtr)
+
+	// Hide io.ReaderFrom of *net.TCPConn and io.WriterTo of the peeked
+	// reader, otherwise io.CopyBuffer ignores the buffer and the data is
+	// copied in chunks of its own size.
+	written, err = io.CopyBuffer(writerOnly{w}, readerOnly{r}, *bufPtr)
+
+	if err != nil {
+		log.Debug("sniproxy: [%d] finished copying due to %v", ctx.ID, err)
+	}
+
+	return written, err
+}
+
+// shape wraps the reader and the writer of a tunnel direction so that the
+// speed is limited by the common bandwidth rate or by the matching bandwidth
+// rule.  The reader and the writer use the same common limiter, so the common
+// rate is shared between reading and writing of all the tunnels.
+func (p *SNIProxy) shape(
+	ctx *SNIContext,
+	src io.Reader,
+	dst io.Writer,
+) (r io.Reader, w io.Writer) {
+	var reader = shapeio.NewReader(src, p.limiter)
+	var writer = shapeio.NewWriter(dst, p.limiter)
+
+	// Both directions of the tunnel resolve the same rule so they are
+	// limited to the same rate.
+	w = writer
+	if rule, bytesPerSec, ok := bandwidthRule(ctx); ok {
+		log.Debug(
+			"sniproxy: [%d] limiting speed to %f bytes/sec by rule %s",
+			ctx.ID,
+			bytesPerSec,
+			rule,
+		)
+		reader.SetRateLimit(p.shaperAlgorithm, bytesPerSec)
+		writer.SetRateLimit(p.shaperAlgorithm, bytesPerSec)
+
+		w = &countingWriter{
+			writer:  writer,
+			counter: p.bandwidthStats.counter(rule),
+		}
+	}
+
+	return reader, w
+}
+
+// peekInfo is the information about the connection that was parsed from its
+// first bytes.
+type peekInfo struct {
+	// serverName is the remote server name.  It may also contain the port.
+	serverName string
+
+	// clientHello is the parsed TLS ClientHello.  It is nil for plain HTTP
+	// connections.
+	clientHello *tls.ClientHelloInfo
+
+	// request is the parsed HTTP request.  It is nil for TLS connections.
+	// Note, that the request body is not read.
+	request *http.Request
+
+	// originalDst is the original destination of the connection.  It is only
+	// set when the proxy uses it instead of the parsed server name, i.e. when
+	// the connection is passed through or in the transparent mode.
+	originalDst netip.AddrPort
+
+	// peeked are the bytes read from the client while peeking.  They are only
+	// recorded if there are inspectors.
+	peeked []byte
+}
+
+// peekServerName peeks on the first bytes from the reader and tries to parse
+// the remote server name.  Depending on whether this is a TLS or a plain HTTP
+// connection it will use different ways of parsing.  If parsing fails,
+// newReader still contains all the unmodified data so that the connection can
+// be passed through as is.
+func peekServerName(
+	reader io.Reader,
+	plainHTTP bool,
+) (info *peekInfo, newReader io.Reader, err error) {
+	info = &peekInfo{}
+
+	if plainHTTP {
+		info.request, newReader, err = peekHTTPRequest(reader)
+
+		if err != nil {
+			return nil, newReader, err
+		}
+
+		info.serverName = info.request.Host
+	} else {
+		info.clientHello, newReader, err = peekClientHello(reader)
+
+		if err != nil {
+			return nil, newReader, err
+		}
+
+		info.serverName = info.clientHello.ServerName
+	}
+
+	return info, newReader, nil
+}
+
+// peekHTTPRequest peeks on the first bytes from the reader and tries to parse
+// the HTTP request.  Once it's done, it returns the request and a new reader
+// that contains unmodified data.  The new reader is returned even if parsing
+// fails.
+//
+// Only the request headers are read, the body is left for the backend.  This
+// is what makes "Expect: 100-continue" work: the client waits for the interim
+// response before sending the body, and the backend sends it through the
+// tunnel once it receives the headers.
+func peekHTTPRequest(reader io.Reader) (r *http.Request, newReader io.Reader, err error) {
+	peekedBytes := new(bytes.Buffer)
+	teeReader := bufio.NewReader(io.TeeReader(reader, peekedBytes))
+	newReader = io.MultiReader(peekedBytes, reader)
+
+	r, err = http.ReadRequest(teeReader)
+	if err != nil {
+		return nil, newReader, fmt.Errorf("sniproxy: failed to read http request: %w", err)
+	}
+
+	return r, newReader, nil
+}
+
+// peekClientHello peeks on the first bytes from the reader and tries to parse
+// the TLS ClientHello.  Once it's done, it returns the client hello information
+// and a new reader that contains unmodified data.  The new reader is returned
+// even if parsing fails.
+func peekClientHello(
+	reader io.Reader,
+) (hello *tls.ClientHelloInfo, newReader io.Reader, err error) {
+	peekedBytes := new(bytes.Buffer)
+	teeReader := io.TeeReader(reader, peekedBytes)
+	newReader = io.MultiReader(peekedBytes, reader)
+
+	hdr := make([]byte, recordHeaderPeekLen)
+	_, err = io.ReadFull(teeReader, hdr)
+	if err != nil {
+		return nil, newReader, fmt.Errorf("sniproxy: failed to read record header: %w", err)
+	}
+
+	err = checkRecordHeader(hdr)
+	if err != nil {
+		return nil, newReader, err
+	}
+
+	hello, err = readClientHello(io.MultiReader(bytes.NewReader(hdr), teeReader))
+	if err != nil {
+		hello, err = readTrailingDotClientHello(peekedBytes.Bytes(), err)
+	}
+
+	if err != nil {
+		return nil, newReader, err
+	}
+
+	return hello, newReader, nil
+}
+
+const (
+	// recordHeaderPeekLen is the number of bytes that is enough to tell a TLS
+	// handshake record from an SSLv2-compatible ClientHello.
+	recordHeaderPeekLen = 3
+
+	// recordTypeHandshake is the TLS handshake record type.
+	recordTypeHandshake = 0x16
+
+	// sslv2ClientHello is the SSLv2 CLIENT-HELLO message type.
+	sslv2ClientHello = 0x01
+)
+
+// errSSLv2ClientHello is returned when the client sends an SSLv2-compatible
+// ClientHello.  Such ClientHello cannot contain SNI so there is no way to
+// proxy the connection.
+var errSSLv2ClientHello = errors.New("sniproxy: SSLv2-compatible ClientHello is not supported")
+
+// checkRecordHeader checks the first bytes of the TLS connection and returns
+// a descriptive error if it does not start with a TLS handshake record.
+func checkRecordHeader(hdr []byte) (err error) {
+	// SSLv2 record header has the most significant bit set and is two bytes
+	// long, the message type goes right after it.
+	if hdr[0]&0x80 != 0 && hdr[2] == sslv2ClientHello {
+		return errSSLv2ClientHello
+	}
+
+	if hdr[0] != recordTypeHandshake {
+		return fmt.Errorf("sniproxy: not a TLS handshake, record type 0x%02x", hdr[0])
+	}
+
+	return nil
+}
+
+// readClientHello reads client hello information from the specified reader.
+func readClientHello(reader io.Reader) (hello *tls.ClientHelloInfo, err error) {
+	err = tls.Server(readOnlyConn{reader: reader}, &tls.Config{
+		GetConfigForClient: func(argHello *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = new(tls.ClientHelloInfo)
+			*hello = *argHello
+			return nil, nil
+		},
+	}).Handshake()
+
+	if hello == nil {
+		return nil, err
+	}
+
+	return hello, nil
+}
+
+// readOnlyConn implements net.Conn but overrides all it's methods so that
+// only reading could work.  The purpose is to make sure that the Handshake
+// method of [tls.Server] does not write any data to the underlying connection.
+type readOnlyConn struct {
+	reader io.Reader
+}
+
+// type check
+var _ net.Conn = (*readOnlyConn)(nil)
+
+// Read implements the net.Conn interface for *readOnlyConn.
+func (conn readOnlyConn) Read(p []byte) (int, error) { return conn.reader.Read(p) }
+
+// Write implements the net.Conn interface for *readOnlyConn.
+func (conn readOnlyConn) Write(_ []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+// Close implements the net.Conn interface for *readOnlyConn.
+func (conn readOnlyConn) Close() error { return nil }
+
+// LocalAddr implements the net.Conn interface for *readOnlyConn.
+func (conn readOnlyConn) LocalAddr() net.Addr { return nil }
+
+// RemoteAddr implements the net.Conn interface for *readOnlyConn.
+func (conn readOnlyConn) RemoteAddr() net.Addr { return nil }
+
+// SetDeadline implements the net.Conn interface for *readOnlyConn.
+func (conn readOnlyConn) SetDeadline(_ time.Time) error { return nil }
+
+// SetReadDeadline implements the net.Conn interface for *readOnlyConn.
+func (conn readOnlyConn) SetReadDeadline(_ time.Time) error { return nil }
+
+// SetWriteDeadline implements the net.Conn interface for *readOnlyConn.
+func (conn readOnlyConn) SetWriteDeadline(_ time.Time) error { return nil }
@@ -0,0 +1,797 @@
+package sniproxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ameshkov/sniproxy/shapeio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for the tests.
+const testTimeout = 5 * time.Second
+
+// localAddr is the TCP address of the loopback interface with a random port.
+var localAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+// startProxy creates and starts a proxy with conf that listens on random ports
+// of the loopback interface.  conf may be nil.
+func startProxy(t testing.TB, conf *Config) (p *SNIProxy) {
+	t.Helper()
+
+	if conf == nil {
+		conf = &Config{}
+	}
+
+	conf.TLSListenAddr = localAddr
+	conf.HTTPListenAddr = localAddr
+
+	p, err := New(conf)
+	require.NoError(t, err)
+	require.NoError(t, p.Start())
+	t.Cleanup(func() { _ = p.Close() })
+
+	return p
+}
+
+// dialHTTP connects to the plain HTTP listener of p and sends the head of a
+// request to host.
+func dialHTTP(t testing.TB, p *SNIProxy, host string) (conn net.Conn) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", p.plainListener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	_, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", host)
+	require.NoError(t, err)
+
+	return conn
+}
+
+// startBackend starts a TCP server that passes every accepted connection to
+// handle and returns its address.
+func startBackend(t testing.TB, handle func(conn net.Conn)) (addr string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, aErr := l.Accept()
+			if aErr != nil {
+				return
+			}
+
+			go handle(conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// serveConn makes p handle a new TCP connection and returns the client side of
+// it.  done receives the result of handling once it's finished.
+func serveConn(
+	t testing.TB,
+	p *SNIProxy,
+	plainHTTP bool,
+) (conn net.Conn, done <-chan error) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	conn, err = net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	serverConn, err := l.Accept()
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- p.handleConnection(serverConn, plainHTTP, "test") }()
+
+	return conn, errCh
+}
+
+func TestSNIProxy_handleConnection_tunnelErrorMode(t *testing.T) {
+	// resetBackend reads the request and resets the connection.
+	resetBackend := func(conn net.Conn) {
+		_, _ = conn.Read(make([]byte, 1024))
+		_ = conn.(*net.TCPConn).SetLinger(0)
+		_ = conn.Close()
+	}
+	backendAddr := startBackend(t, resetBackend)
+
+	testCases := []struct {
+		name         string
+		mode         TunnelErrorMode
+		wantFinished bool
+	}{{
+		name:         "default",
+		mode:         "",
+		wantFinished: true,
+	}, {
+		name:         "close",
+		mode:         TunnelErrorModeClose,
+		wantFinished: true,
+	}, {
+		name:         "half_close",
+		mode:         TunnelErrorModeHalfClose,
+		wantFinished: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := New(&Config{TunnelErrorMode: tc.mode})
+			require.NoError(t, err)
+
+			conn, done := serveConn(t, p, true)
+			_, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", backendAddr)
+			require.NoError(t, err)
+
+			// The client gets EOF in both modes as the direction from the
+			// backend is finished.
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+			_, err = io.ReadAll(conn)
+			require.NoError(t, err)
+
+			if !tc.wantFinished {
+				// The direction from the client is still open and waits for
+				// the client to finish it.
+				select {
+				case <-done:
+					t.Fatal("tunnel finished before the client closed it")
+				case <-time.After(200 * time.Millisecond):
+				}
+
+				require.NoError(t, conn.Close())
+			}
+
+			select {
+			case err = <-done:
+				assert.NoError(t, err)
+			case <-time.After(testTimeout):
+				t.Fatal("tunnel isn't finished")
+			}
+		})
+	}
+}
+
+func TestSNIProxy_pathRules(t *testing.T) {
+	p, err := New(&Config{
+		ForwardProxies:   []string{"socks5://127.0.0.1:1080"},
+		ForwardPathRules: []string{"example.org/api/*"},
+		BlockPathRules:   []string{"*/ads/*"},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		host        string
+		path        string
+		wantBlock   bool
+		wantForward bool
+	}{{
+		name:        "block",
+		host:        "example.com",
+		path:        "/ads/banner.png",
+		wantBlock:   true,
+		wantForward: false,
+	}, {
+		name:        "forward",
+		host:        "example.org",
+		path:        "/api/v1",
+		wantBlock:   false,
+		wantForward: true,
+	}, {
+		name:        "forward_other_host",
+		host:        "example.com",
+		path:        "/api/v1",
+		wantBlock:   false,
+		wantForward: false,
+	}, {
+		name:        "no_match",
+		host:        "example.org",
+		path:        "/index.html",
+		wantBlock:   false,
+		wantForward: false,
+	}, {
+		name:        "tls",
+		host:        "example.org",
+		path:        "",
+		wantBlock:   false,
+		wantForward: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := NewSNIContext(tc.host, tc.host+":80")
+			ctx.rules = p.rules.Load()
+			ctx.RequestPath = tc.path
+
+			_, blocked := p.shouldBlock(ctx)
+			assert.Equal(t, tc.wantBlock, blocked)
+
+			_, forwarded := p.shouldForward(ctx)
+			assert.Equal(t, tc.wantForward, forwarded)
+		})
+	}
+}
+
+func TestSNIProxy_handleConnection_blockPathRules(t *testing.T) {
+	p, err := New(&Config{BlockPathRules: []string{"*/ads/*"}})
+	require.NoError(t, err)
+
+	var dialed atomic.Bool
+	backendAddr := startBackend(t, func(conn net.Conn) {
+		dialed.Store(true)
+		_ = conn.Close()
+	})
+
+	conn, done := serveConn(t, p, true)
+	_, err = fmt.Fprintf(conn, "GET /ads/banner.png HTTP/1.1\r\nHost: %s\r\n\r\n", backendAddr)
+	require.NoError(t, err)
+
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(testTimeout):
+		t.Fatal("blocked connection isn't closed")
+	}
+
+	assert.False(t, dialed.Load())
+}
+
+// startConnectRecorder starts a fake HTTP proxy that sends the target of every
+// CONNECT request to the returned channel and then closes the connection.
+func startConnectRecorder(t testing.TB) (proxyURL string, targets <-chan string) {
+	t.Helper()
+
+	ch := make(chan string, 10)
+	addr := startBackend(t, func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err == nil {
+			ch <- req.Host
+		}
+	})
+
+	return "http://" + addr, ch
+}
+
+// sendClientHello sends a TLS ClientHello with the specified server name to
+// conn.
+func sendClientHello(conn net.Conn, serverName string) {
+	go func() {
+		_ = tls.Client(conn, &tls.Config{ServerName: serverName}).Handshake()
+	}()
+}
+
+func TestSNIProxy_handleConnection_expectedSNI(t *testing.T) {
+	testCases := []struct {
+		name       string
+		serverName string
+		wantTarget string
+	}{{
+		name:       "expected",
+		serverName: "www.example.org",
+		wantTarget: "www.example.org:443",
+	}, {
+		name:       "unexpected",
+		serverName: "example.com",
+		wantTarget: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxyURL, targets := startConnectRecorder(t)
+			p, err := New(&Config{
+				ForwardProxies: []string{proxyURL},
+				ExpectedSNI:    []string{"*.example.org"},
+			})
+			require.NoError(t, err)
+
+			conn, done := serveConn(t, p, false)
+			sendClientHello(conn, tc.serverName)
+
+			select {
+			case <-done:
+			case <-time.After(testTimeout):
+				t.Fatal("connection isn't handled")
+			}
+
+			var target string
+			select {
+			case target = <-targets:
+			default:
+			}
+
+			assert.Equal(t, tc.wantTarget, target)
+		})
+	}
+}
+
+// captureLog makes the log write to the returned buffer until the test is
+// finished.  The buffer must only be read once the logging code is finished.
+func captureLog(t testing.TB) (buf *bytes.Buffer) {
+	t.Helper()
+
+	buf = &bytes.Buffer{}
+	w := stdlog.Writer()
+	stdlog.SetOutput(buf)
+	t.Cleanup(func() { stdlog.SetOutput(w) })
+
+	return buf
+}
+
+func TestSNIProxy_handleConnection_backendAddr(t *testing.T) {
+	backendAddr := startBackend(t, func(conn net.Conn) {
+		_, _ = conn.Read(make([]byte, 1024))
+		_ = conn.Close()
+	})
+
+	p, err := New(&Config{})
+	require.NoError(t, err)
+
+	buf := captureLog(t)
+	host := fmt.Sprintf("localhost:%d", backendPort(t, backendAddr))
+
+	conn, done := serveConn(t, p, true)
+	_, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", host)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(testTimeout):
+		t.Fatal("tunnel isn't finished")
+	}
+
+	// The log must contain the resolved address along with the hostname.
+	assert.Contains(t, buf.String(), fmt.Sprintf("to %s (%s)", host, backendAddr))
+}
+
+// backendPort returns the port of the backend address.
+func backendPort(t testing.TB, addr string) (port int) {
+	t.Helper()
+
+	_, portStr, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+
+	port, err = strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	return port
+}
+
+func TestSNIProxy_handleConnection_maxTunnelDuration(t *testing.T) {
+	// echoBackend keeps the connection active by echoing everything back.
+	echoBackend := func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = io.Copy(conn, conn)
+	}
+	backendAddr := startBackend(t, echoBackend)
+
+	const maxDuration = 200 * time.Millisecond
+
+	p, err := New(&Config{MaxTunnelDuration: maxDuration})
+	require.NoError(t, err)
+
+	conn, done := serveConn(t, p, true)
+	start := time.Now()
+	_, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", backendAddr)
+	require.NoError(t, err)
+
+	// Keep the tunnel active so that only the duration limit can close it.
+	go func() {
+		for {
+			if _, wErr := conn.Write([]byte("ping")); wErr != nil {
+				return
+			}
+
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+	_, _ = io.Copy(io.Discard, conn)
+
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(testTimeout):
+		t.Fatal("tunnel isn't finished")
+	}
+
+	assert.GreaterOrEqual(t, time.Since(start), maxDuration)
+}
+
+func TestSNIProxy_handleConnection_slowThreshold(t *testing.T) {
+	const tunnelDuration = 100 * time.Millisecond
+
+	// slowBackend keeps the tunnel open for tunnelDuration.
+	slowBackend := func(conn net.Conn) {
+		_, _ = conn.Read(make([]byte, 1024))
+		time.Sleep(tunnelDuration)
+		_ = conn.Close()
+	}
+	backendAddr := startBackend(t, slowBackend)
+
+	testCases := []struct {
+		name      string
+		threshold time.Duration
+		wantWarn  bool
+	}{{
+		name:      "disabled",
+		threshold: 0,
+		wantWarn:  false,
+	}, {
+		name:      "slow",
+		threshold: tunnelDuration / 2,
+		wantWarn:  true,
+	}, {
+		name:      "fast",
+		threshold: time.Hour,
+		wantWarn:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := New(&Config{SlowThreshold: tc.threshold})
+			require.NoError(t, err)
+
+			buf := captureLog(t)
+
+			conn, done := serveConn(t, p, true)
+			_, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", backendAddr)
+			require.NoError(t, err)
+
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+			_, _ = io.Copy(io.Discard, conn)
+			require.NoError(t, conn.Close())
+
+			select {
+			case err = <-done:
+				require.NoError(t, err)
+			case <-time.After(testTimeout):
+				t.Fatal("tunnel isn't finished")
+			}
+
+			if tc.wantWarn {
+				assert.Contains(t, buf.String(), "slow connection to "+backendAddr)
+				assert.Contains(t, buf.String(), "[warn] sniproxy:")
+			} else {
+				assert.NotContains(t, buf.String(), "[warn]")
+			}
+		})
+	}
+}
+
+// sslv2Hello is the beginning of an SSLv2-compatible ClientHello: a two-byte
+// record header with the most significant bit set, CLIENT-HELLO message type,
+// and version TLS 1.0.
+var sslv2Hello = []byte{0x80, 0x2e, 0x01, 0x03, 0x01, 0x00, 0x15}
+
+func TestPeekClientHello(t *testing.T) {
+	testCases := []struct {
+		name           string
+		data           []byte
+		wantErrMsg     string
+		wantServerName string
+	}{{
+		name:           "tls",
+		data:           nil,
+		wantErrMsg:     "",
+		wantServerName: "example.org",
+	}, {
+		name:           "sslv2",
+		data:           sslv2Hello,
+		wantErrMsg:     errSSLv2ClientHello.Error(),
+		wantServerName: "",
+	}, {
+		name:           "not_handshake",
+		data:           []byte("GET / HTTP/1.1\r\n"),
+		wantErrMsg:     "sniproxy: not a TLS handshake, record type 0x47",
+		wantServerName: "",
+	}, {
+		name:           "short",
+		data:           []byte{0x16},
+		wantErrMsg:     "sniproxy: failed to read record header: unexpected EOF",
+		wantServerName: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			t.Cleanup(func() {
+				_ = clientConn.Close()
+				_ = serverConn.Close()
+			})
+
+			if tc.data == nil {
+				sendClientHello(clientConn, tc.wantServerName)
+			} else {
+				go func() {
+					_, _ = clientConn.Write(tc.data)
+					_ = clientConn.Close()
+				}()
+			}
+
+			hello, _, err := peekClientHello(serverConn)
+			if tc.wantErrMsg != "" {
+				require.Error(t, err)
+				assert.Equal(t, tc.wantErrMsg, err.Error())
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantServerName, hello.ServerName)
+		})
+	}
+}
+
+func TestSNIProxy_handleConnection_sslv2(t *testing.T) {
+	p, err := New(&Config{})
+	require.NoError(t, err)
+
+	conn, done := serveConn(t, p, false)
+	_, err = conn.Write(sslv2Hello)
+	require.NoError(t, err)
+
+	select {
+	case err = <-done:
+		// The connection is rejected, but it's not an error of the proxy.
+		assert.NoError(t, err)
+	case <-time.After(testTimeout):
+		t.Fatal("connection isn't rejected")
+	}
+
+	// The connection is closed, depending on the unread data it's either EOF
+	// or a reset.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestSNIProxy_SetReady(t *testing.T) {
+	p := startProxy(t, &Config{WaitReady: true})
+	require.False(t, p.Ready())
+
+	backendAddr := startBackend(t, func(conn net.Conn) {
+		_, _ = io.WriteString(conn, "hello")
+		_ = conn.Close()
+	})
+
+	conn := dialHTTP(t, p, backendAddr)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+
+	data, err := io.ReadAll(conn)
+	require.False(t, os.IsTimeout(err), "connection isn't refused")
+	assert.Empty(t, data)
+
+	p.SetReady(true)
+	require.True(t, p.Ready())
+
+	conn = dialHTTP(t, p, backendAddr)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+
+	data, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestSNIProxy_shape(t *testing.T) {
+	const (
+		rate    = 100 * 1024
+		payload = rate / 4
+	)
+
+	testCases := []struct {
+		name        string
+		rules       map[string]float64
+		wantElapsed time.Duration
+	}{{
+		// The common limiter is shared by the reader and the writer, so every
+		// byte is counted twice.
+		name:        "common_rate",
+		rules:       nil,
+		wantElapsed: 500 * time.Millisecond,
+	}, {
+		name:        "rule",
+		rules:       map[string]float64{"example.org": rate},
+		wantElapsed: 250 * time.Millisecond,
+	}, {
+		name:        "unlimited_rule",
+		rules:       map[string]float64{"example.org": 0},
+		wantElapsed: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &SNIProxy{
+				limiter:        shapeio.NewLimiter(shapeio.AlgorithmTokenBucket, rate),
+				bandwidthStats: newBandwidthStats(),
+			}
+			ctx := NewSNIContext("example.org", "example.org:443")
+			ctx.rules = &RuleSet{BandwidthRules: tc.rules}
+
+			r, w := p.shape(ctx, bytes.NewReader(make([]byte, payload)), io.Discard)
+
+			start := time.Now()
+			n, err := io.Copy(w, r)
+			require.NoError(t, err)
+			require.Equal(t, int64(payload), n)
+
+			elapsed := time.Since(start)
+			assert.GreaterOrEqual(t, elapsed, tc.wantElapsed*8/10)
+			assert.Less(t, elapsed, tc.wantElapsed+time.Second)
+		})
+	}
+}
+
+// readCounter counts the calls to Read of the underlying reader, i.e. the read
+// syscalls when it is a connection.
+type readCounter struct {
+	io.Reader
+	reads int
+}
+
+// Read implements the io.Reader interface for *readCounter.
+func (r *readCounter) Read(p []byte) (n int, err error) {
+	r.reads++
+
+	return r.Reader.Read(p)
+}
+
+// keepOpenConn is a *net.TCPConn that is not closed for writing at the end of
+// a tunnel so that it can be reused by the benchmark iterations.  It still
+// implements io.ReaderFrom.
+type keepOpenConn struct {
+	*net.TCPConn
+}
+
+// CloseWrite implements the closeWriter interface for keepOpenConn.
+func (keepOpenConn) CloseWrite() (err error) { return nil }
+
+// tcpPipe returns both ends of a loopback TCP connection.
+func tcpPipe(t testing.TB) (client, server *net.TCPConn) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = l.Close() }()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+
+	s, err := l.Accept()
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = c.Close()
+		_ = s.Close()
+	})
+
+	return c.(*net.TCPConn), s.(*net.TCPConn)
+}
+
+func BenchmarkSNIProxy_tunnel_chunkSize(b *testing.B) {
+	const payload = 4 * 1024 * 1024
+
+	for _, size := range []int{1024, 4 * 1024, 32 * 1024, 256 * 1024} {
+		b.Run(fmt.Sprintf("%dKiB", size/1024), func(b *testing.B) {
+			p := &SNIProxy{copyBufPool: newCopyBufPool(size)}
+			ctx := &SNIContext{rules: &RuleSet{}}
+
+			srcWriter, srcConn := tcpPipe(b)
+			dstConn, dstReader := tcpPipe(b)
+
+			go func() { _, _ = io.Copy(io.Discard, dstReader) }()
+			go func() { _, _ = io.Copy(srcWriter, zeroReader{}) }()
+
+			src := &readCounter{Reader: srcConn}
+
+			b.SetBytes(payload)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				_, err := p.tunnel(ctx, keepOpenConn{dstConn}, io.LimitReader(src, payload))
+				require.NoError(b, err)
+			}
+
+			b.ReportMetric(float64(src.reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+// zeroReader is an infinite source of zero bytes.
+type zeroReader struct{}
+
+// Read implements the io.Reader interface for zeroReader.
+func (zeroReader) Read(p []byte) (n int, err error) {
+	for i := range p {
+		p[i] = 0
+	}
+
+	return len(p), nil
+}
+
+func TestSNIProxy_tunnel_noShapeForwarded(t *testing.T) {
+	const (
+		rule    = "*.example.org"
+		payload = "hello"
+	)
+
+	testCases := []struct {
+		name             string
+		noShapeForwarded bool
+		forwarded        bool
+		wantShaped       bool
+	}{{
+		name:             "forwarded",
+		noShapeForwarded: true,
+		forwarded:        true,
+		wantShaped:       false,
+	}, {
+		name:             "direct",
+		noShapeForwarded: true,
+		forwarded:        false,
+		wantShaped:       true,
+	}, {
+		name:             "forwarded_shaped",
+		noShapeForwarded: false,
+		forwarded:        true,
+		wantShaped:       true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := New(&Config{
+				BandwidthRules:   map[string]float64{rule: 1_000_000},
+				NoShapeForwarded: tc.noShapeForwarded,
+			})
+			require.NoError(t, err)
+
+			ctx := NewSNIContext("www.example.org", "www.example.org:443")
+			ctx.rules = p.rules.Load()
+			ctx.forwarded = tc.forwarded
+
+			dst, peer := tcpPipe(t)
+			go func() { _, _ = io.Copy(io.Discard, peer) }()
+
+			written, err := p.tunnel(ctx, dst, bytes.NewReader([]byte(payload)))
+			require.NoError(t, err)
+			require.EqualValues(t, len(payload), written)
+
+			// Only the shaped connections are counted by the bandwidth rules.
+			var want int64
+			if tc.wantShaped {
+				want = int64(len(payload))
+			}
+
+			assert.Equal(t, want, p.BandwidthStats()[rule])
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package sniproxy
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/proxy"
+)
+
+// sourcePortAttempts is the number of random source ports that are tried
+// before giving up when the chosen port is already in use.
+const sourcePortAttempts = 10
+
+// ParsePortRange parses a port range in the "min-max" format, e.g. the range
+// of [Config.DialSourcePortMin] and [Config.DialSourcePortMax].
+func ParsePortRange(s string) (min, max int, err error) {
+	minStr, maxStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected min-max")
+	}
+
+	if min, err = strconv.Atoi(minStr); err != nil {
+		return 0, 0, fmt.Errorf("invalid port %s: %w", minStr, err)
+	}
+	if max, err = strconv.Atoi(maxStr); err != nil {
+		return 0, 0, fmt.Errorf("invalid port %s: %w", maxStr, err)
+	}
+
+	if min < 1 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid range %d-%d", min, max)
+	}
+
+	return min, max, nil
+}
+
+// sourcePortDialer dials TCP connections from a random local port within the
+// configured range.
+type sourcePortDialer struct {
+	// dialer is the dialer that is used as a template for every connection.
+	dialer *net.Dialer
+
+	// min is the lower bound of the source port range.
+	min int
+
+	// max is the upper bound of the source port range, inclusive.
+	max int
+}
+
+// type check
+var _ proxy.Dialer = (*sourcePortDialer)(nil)
+
+// Dial implements the [proxy.Dialer] interface for *sourcePortDialer.
+func (d *sourcePortDialer) Dial(network, addr string) (conn net.Conn, err error) {
+	for i := 0; i < sourcePortAttempts; i++ {
+		dialer := *d.dialer
+		dialer.LocalAddr = &net.TCPAddr{
+			Port: d.min + rand.Intn(d.max-d.min+1),
+		}
+
+		conn, err = dialer.Dial(network, addr)
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return conn, err
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,36 @@
+package sniproxy
+
+// spanName is the name of the span that covers a single client connection.
+const spanName = "sniproxy.connection"
+
+// Values of the sniproxy.outcome span attribute.
+const (
+	outcomeTunneled = "tunneled"
+	outcomeRejected = "rejected"
+	outcomeError    = "error"
+)
+
+// startSpan starts the span of the connection if tracing is enabled.
+func (p *SNIProxy) startSpan(ctx *SNIContext) {
+	ctx.span = p.tracer.Start(spanName)
+	ctx.span.SetAttribute("sniproxy.connection_id", int64(ctx.ID))
+	ctx.span.SetAttribute("sniproxy.listener", ctx.Listener)
+	ctx.span.SetAttribute("client.address", ctx.ClientAddr.Addr().String())
+	ctx.span.SetAttribute("server.address", ctx.RemoteHost)
+}
+
+// finishSpan records the outcome of the connection and ends its span.  err is
+// the error that handling the connection ended with.
+func finishSpan(ctx *SNIContext, err error) {
+	outcome := outcomeRejected
+	if err != nil {
+		outcome = outcomeError
+	} else if ctx.BackendAddr != nil {
+		outcome = outcomeTunneled
+	}
+
+	ctx.span.SetAttribute("sniproxy.outcome", outcome)
+	ctx.span.SetAttribute("sniproxy.forwarded", ctx.forwarded)
+	ctx.span.SetError(err)
+	ctx.span.Finish()
+}
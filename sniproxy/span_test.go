@@ -0,0 +1,101 @@
+package sniproxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ameshkov/sniproxy/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memExporter is a [tracing.Exporter] that keeps the exported spans in
+// memory.
+type memExporter struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+// ExportSpans implements the [tracing.Exporter] interface for *memExporter.
+func (e *memExporter) ExportSpans(spans []*tracing.Span) (err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = append(e.spans, spans...)
+
+	return nil
+}
+
+// spanAttrs returns the attributes of s as a map.
+func spanAttrs(s *tracing.Span) (attrs map[string]any) {
+	attrs = map[string]any{}
+	for _, a := range s.Attributes {
+		attrs[a.Key] = a.Value
+	}
+
+	return attrs
+}
+
+func TestSNIProxy_span(t *testing.T) {
+	const resp = "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+
+	backend := startBackend(t, func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = conn.Read(make([]byte, 1024))
+		_, _ = io.WriteString(conn, resp)
+	})
+
+	p, err := New(&Config{
+		TLSListenAddr:  localAddr,
+		HTTPListenAddr: localAddr,
+		BlockRules:     []string{"blocked.example"},
+	})
+	require.NoError(t, err)
+
+	exp := &memExporter{}
+	p.tracer = tracing.New(exp)
+	require.NoError(t, p.Start())
+
+	for _, host := range []string{backend, "blocked.example"} {
+		conn := dialHTTP(t, p, host)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+		_, _ = io.ReadAll(conn)
+		require.NoError(t, conn.Close())
+	}
+
+	// Close waits for the handlers to finish and flushes the spans.
+	require.NoError(t, p.Close())
+	require.Len(t, exp.spans, 2)
+
+	backendHost, _, err := net.SplitHostPort(backend)
+	require.NoError(t, err)
+
+	// The spans are exported in the order they are finished, which is not
+	// necessarily the order of the connections.
+	byHost := map[any]map[string]any{}
+	for _, s := range exp.spans {
+		assert.Equal(t, spanName, s.Name)
+
+		attrs := spanAttrs(s)
+		byHost[attrs["server.address"]] = attrs
+	}
+
+	tunneled := byHost[backendHost]
+	require.NotNil(t, tunneled)
+	assert.Equal(t, "127.0.0.1", tunneled["client.address"])
+	assert.Equal(t, outcomeTunneled, tunneled["sniproxy.outcome"])
+	assert.Equal(t, false, tunneled["sniproxy.forwarded"])
+	assert.Equal(t, int64(len(resp)), tunneled["sniproxy.bytes_received"])
+	assert.NotZero(t, tunneled["sniproxy.bytes_sent"])
+
+	rejected := byHost["blocked.example"]
+	require.NotNil(t, rejected)
+	assert.Equal(t, outcomeRejected, rejected["sniproxy.outcome"])
+	assert.NotContains(t, rejected, "sniproxy.bytes_received")
+
+	assert.NotEqual(t, exp.spans[0].TraceID, exp.spans[1].TraceID)
+}
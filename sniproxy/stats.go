@@ -0,0 +1,99 @@
+package sniproxy
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// TunnelStats are the interim statistics of a tunnel that is still open.
+type TunnelStats struct {
+	// Context is the context of the connection.
+	Context *SNIContext
+
+	// Received is the number of bytes received from the remote host so far.
+	Received int64
+
+	// Sent is the number of bytes sent to the remote host so far.
+	Sent int64
+
+	// Elapsed is the time since the tunnel was established.
+	Elapsed time.Duration
+}
+
+// StatsFunc is called with the interim statistics of every open tunnel at
+// Config.StatsInterval.  Every tunnel reports its statistics from a separate
+// goroutine, so implementations must be safe for concurrent use.  They should
+// return quickly, as the next report of the tunnel waits for them.
+type StatsFunc func(s *TunnelStats)
+
+// tunnelCounters are the running byte counts of a tunnel.
+type tunnelCounters struct {
+	received atomic.Int64
+	sent     atomic.Int64
+}
+
+// countingReader is an io.Reader that adds the number of read bytes to the
+// counter.
+type countingReader struct {
+	reader  io.Reader
+	counter *atomic.Int64
+}
+
+// type check
+var _ io.Reader = (*countingReader)(nil)
+
+// Read implements the io.Reader interface for *countingReader.
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.counter.Add(int64(n))
+
+	return n, err
+}
+
+// reportStats reports the interim statistics of the tunnel every
+// p.statsInterval until done is closed.
+func (p *SNIProxy) reportStats(
+	ctx *SNIContext,
+	counters *tunnelCounters,
+	startTime time.Time,
+	done <-chan struct{},
+) {
+	ticker := time.NewTicker(p.statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			s := &TunnelStats{
+				Context:  ctx,
+				Received: counters.received.Load(),
+				Sent:     counters.sent.Load(),
+				Elapsed:  now.Sub(startTime),
+			}
+
+			if p.onStats != nil {
+				p.onStats(s)
+			} else {
+				logStats(s)
+			}
+		}
+	}
+}
+
+// logStats logs the interim statistics of a tunnel.
+func logStats(s *TunnelStats) {
+	log.Info(
+		"sniproxy: [%d] tunneling to %s: received %d, sent %d, elapsed: %v, rate (bytes/sec): %f",
+		s.Context.ID,
+		s.Context.RemoteAddr,
+		s.Received,
+		s.Sent,
+		s.Elapsed.Round(time.Second),
+		float64(s.Received+s.Sent)/s.Elapsed.Seconds(),
+	)
+}
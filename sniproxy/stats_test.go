@@ -0,0 +1,69 @@
+package sniproxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_reportStats(t *testing.T) {
+	// echoBackend keeps the connections open until the client closes them.
+	echoBackend := func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = io.Copy(conn, conn)
+	}
+
+	// The reports of different tunnels come from different goroutines.
+	var mu sync.Mutex
+	reports := map[uint64]*TunnelStats{}
+	onStats := func(s *TunnelStats) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		reports[s.Context.ID] = s
+	}
+
+	p := startProxy(t, &Config{
+		StatsInterval: 10 * time.Millisecond,
+		OnStats:       onStats,
+	})
+
+	const tunnels = 3
+
+	backend := startBackend(t, echoBackend)
+	for i := 0; i < tunnels; i++ {
+		_ = dialHTTP(t, p, backend)
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(reports) != tunnels {
+			return false
+		}
+
+		for _, s := range reports {
+			// The request is echoed back.
+			if s.Sent == 0 || s.Received != s.Sent {
+				return false
+			}
+		}
+
+		return true
+	}, testTimeout, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, s := range reports {
+		assert.Equal(t, backend, s.Context.RemoteAddr)
+		assert.Positive(t, s.Elapsed)
+	}
+}
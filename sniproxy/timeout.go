@@ -0,0 +1,74 @@
+package sniproxy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ameshkov/sniproxy/internal/filter"
+)
+
+// TimeoutRule overrides a tunnel timeout for the domains that match the
+// wildcard.
+type TimeoutRule struct {
+	// Wildcard is the wildcard that defines the domains the rule applies to.
+	Wildcard string
+
+	// Timeout is the timeout for the matching domains.  Zero means that the
+	// matching tunnels have no timeout.
+	Timeout time.Duration
+}
+
+// ParseTimeoutRule parses a timeout rule in the "wildcard=duration" format.
+func ParseTimeoutRule(s string) (r TimeoutRule, err error) {
+	w, d, ok := strings.Cut(s, "=")
+	if !ok || w == "" {
+		return r, fmt.Errorf("invalid timeout rule %q, expected wildcard=duration", s)
+	}
+
+	timeout, err := time.ParseDuration(d)
+	if err != nil || timeout < 0 {
+		return r, fmt.Errorf("invalid timeout rule %q: invalid duration %q", s, d)
+	}
+
+	return TimeoutRule{Wildcard: w, Timeout: timeout}, nil
+}
+
+// timeoutFor returns the timeout of the rule that matches the remote host of
+// the connection.  If several rules match, the most specific one wins, see
+// [filter.MoreSpecific].  If no rule matches, def is returned.  kind is the
+// kind of the rules for the rule stats.
+func (p *SNIProxy) timeoutFor(
+	ctx *SNIContext,
+	kind string,
+	rules []TimeoutRule,
+	def time.Duration,
+) (timeout time.Duration) {
+	var matched *TimeoutRule
+	for i, r := range rules {
+		if !filter.MatchWildcard(ctx.RemoteHost, r.Wildcard) {
+			continue
+		}
+
+		if matched == nil || filter.MoreSpecific(r.Wildcard, matched.Wildcard) {
+			matched = &rules[i]
+		}
+	}
+
+	if matched == nil {
+		return def
+	}
+
+	p.ruleStats.Inc(kind, matched.Wildcard)
+
+	return matched.Timeout
+}
+
+// timeoutRuleWildcards returns the wildcards of the rules.
+func timeoutRuleWildcards(rules []TimeoutRule) (wildcards []string) {
+	for _, r := range rules {
+		wildcards = append(wildcards, r.Wildcard)
+	}
+
+	return wildcards
+}
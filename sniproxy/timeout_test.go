@@ -0,0 +1,66 @@
+package sniproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ameshkov/sniproxy/internal/filter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSNIProxy_timeoutFor(t *testing.T) {
+	const def = time.Hour
+
+	testCases := []struct {
+		name  string
+		host  string
+		rules []TimeoutRule
+		want  time.Duration
+	}{{
+		name:  "no_rules",
+		host:  "www.example.org",
+		rules: nil,
+		want:  def,
+	}, {
+		name: "no_match",
+		host: "www.example.com",
+		rules: []TimeoutRule{
+			{Wildcard: "*.example.org", Timeout: time.Minute},
+		},
+		want: def,
+	}, {
+		name: "specific_last",
+		host: "video.example.org",
+		rules: []TimeoutRule{
+			{Wildcard: "*.example.org", Timeout: time.Minute},
+			{Wildcard: "video.example.org", Timeout: 0},
+		},
+		want: 0,
+	}, {
+		name: "specific_first",
+		host: "video.example.org",
+		rules: []TimeoutRule{
+			{Wildcard: "video.example.org", Timeout: 0},
+			{Wildcard: "*.example.org", Timeout: time.Minute},
+		},
+		want: 0,
+	}, {
+		name: "tie",
+		host: "video.example.org",
+		rules: []TimeoutRule{
+			{Wildcard: "video.example.or*", Timeout: time.Second},
+			{Wildcard: "video.example.*rg", Timeout: time.Minute},
+		},
+		want: time.Minute,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &SNIProxy{ruleStats: filter.NewStats()}
+			ctx := NewSNIContext(tc.host, tc.host+":443")
+
+			got := p.timeoutFor(ctx, ruleKindIdleTimeout, tc.rules, def)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
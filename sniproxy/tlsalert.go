@@ -0,0 +1,62 @@
+package sniproxy
+
+import (
+	"net"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// TLSAlert is the description of the TLS alert that is sent to the clients of
+// blocked TLS connections before closing them.
+type TLSAlert string
+
+const (
+	// TLSAlertNone makes the proxy close blocked connections without sending
+	// an alert.
+	TLSAlertNone TLSAlert = ""
+
+	// TLSAlertAccessDenied is the access_denied(49) alert.
+	TLSAlertAccessDenied TLSAlert = "access_denied"
+
+	// TLSAlertUnrecognizedName is the unrecognized_name(112) alert.
+	TLSAlertUnrecognizedName TLSAlert = "unrecognized_name"
+
+	// TLSAlertHandshakeFailure is the handshake_failure(40) alert.
+	TLSAlertHandshakeFailure TLSAlert = "handshake_failure"
+)
+
+// tlsAlertCodes are the codes of the supported TLS alert descriptions.
+var tlsAlertCodes = map[TLSAlert]byte{
+	TLSAlertAccessDenied:     49,
+	TLSAlertUnrecognizedName: 112,
+	TLSAlertHandshakeFailure: 40,
+}
+
+const (
+	// recordTypeAlert is the TLS alert record type.
+	recordTypeAlert = 0x15
+
+	// alertLevelFatal is the level of fatal TLS alerts.
+	alertLevelFatal = 2
+)
+
+// tlsAlertRecord returns a TLS record with a fatal alert.  The record version
+// is TLS 1.2 as the proxy has not negotiated any version with the client and
+// TLS 1.3 uses it for the records as well.
+func tlsAlertRecord(code byte) (rec []byte) {
+	return []byte{recordTypeAlert, 0x03, 0x03, 0x00, 0x02, alertLevelFatal, code}
+}
+
+// sendBlockAlert sends the configured TLS alert to the client of a blocked TLS
+// connection.
+func (p *SNIProxy) sendBlockAlert(ctx *SNIContext, clientConn net.Conn) {
+	code, ok := tlsAlertCodes[p.blockTLSAlert]
+	if !ok {
+		return
+	}
+
+	_, err := clientConn.Write(tlsAlertRecord(code))
+	if err != nil {
+		log.Debug("sniproxy: [%d] failed to send %s alert: %v", ctx.ID, p.blockTLSAlert, err)
+	}
+}
@@ -0,0 +1,60 @@
+package sniproxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_sendBlockAlert(t *testing.T) {
+	hello := clientHello(t, "example.org")
+
+	testCases := []struct {
+		name  string
+		alert TLSAlert
+		want  []byte
+	}{{
+		name:  "none",
+		alert: TLSAlertNone,
+		want:  []byte{},
+	}, {
+		name:  "access_denied",
+		alert: TLSAlertAccessDenied,
+		want:  []byte{0x15, 0x03, 0x03, 0x00, 0x02, 0x02, 49},
+	}, {
+		name:  "unrecognized_name",
+		alert: TLSAlertUnrecognizedName,
+		want:  []byte{0x15, 0x03, 0x03, 0x00, 0x02, 0x02, 112},
+	}, {
+		name:  "handshake_failure",
+		alert: TLSAlertHandshakeFailure,
+		want:  []byte{0x15, 0x03, 0x03, 0x00, 0x02, 0x02, 40},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := startProxy(t, &Config{
+				BlockRules:    []string{"example.org"},
+				BlockTLSAlert: tc.alert,
+			})
+
+			conn, err := net.Dial("tcp", p.sniListener.Addr().String())
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+
+			_, err = conn.Write(hello)
+			require.NoError(t, err)
+
+			// The proxy closes the connection right after the alert.
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(testTimeout)))
+			got, err := io.ReadAll(conn)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
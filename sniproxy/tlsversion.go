@@ -0,0 +1,123 @@
+package sniproxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/sniproxy/audit"
+	"github.com/ameshkov/sniproxy/internal/filter"
+)
+
+// MinTLSVersionRule defines the minimum TLS version clients must offer to the
+// matching domains.  The proxy does not terminate TLS, so it can only check
+// the versions offered in the ClientHello.
+type MinTLSVersionRule struct {
+	// Wildcard is the wildcard that defines the domains the rule applies to.
+	Wildcard string
+
+	// Version is the minimum TLS version, e.g. [tls.VersionTLS12].
+	Version uint16
+}
+
+// tlsVersions are the TLS versions that can be used in the minimum TLS version
+// rules.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseMinTLSVersionRule parses a minimum TLS version rule in the
+// "wildcard=version" format, e.g. "*.example.com=1.2".
+func ParseMinTLSVersionRule(s string) (r MinTLSVersionRule, err error) {
+	w, version, ok := strings.Cut(s, "=")
+	if !ok || w == "" {
+		return r, fmt.Errorf("expected wildcard=version")
+	}
+
+	r.Wildcard = w
+	r.Version, ok = tlsVersions[version]
+	if !ok {
+		return r, fmt.Errorf("unsupported TLS version %s, expected 1.0, 1.1, 1.2 or 1.3", version)
+	}
+
+	return r, nil
+}
+
+// tlsVersionNames are the names of the TLS versions.  tls.VersionName is not
+// used as it requires a newer Go version.
+var tlsVersionNames = map[uint16]string{
+	tls.VersionSSL30: "SSLv3",
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// tlsVersionName returns the name of the TLS version.
+func tlsVersionName(v uint16) (name string) {
+	if name, ok := tlsVersionNames[v]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// maxOfferedVersion returns the highest TLS version offered in the
+// ClientHello.  GREASE and other unknown values are ignored.
+func maxOfferedVersion(hello *tls.ClientHelloInfo) (v uint16) {
+	for _, sv := range hello.SupportedVersions {
+		if _, ok := tlsVersionNames[sv]; ok && sv > v {
+			v = sv
+		}
+	}
+
+	return v
+}
+
+// blocksLegacyTLS checks if the client offers a TLS version lower than the
+// minimum version for the remote host, logs it, and returns true if the
+// connection must be blocked.
+func (p *SNIProxy) blocksLegacyTLS(ctx *SNIContext, hello *tls.ClientHelloInfo) (ok bool) {
+	for _, r := range p.minTLSVersionRules {
+		if !filter.MatchWildcard(ctx.RemoteHost, r.Wildcard) {
+			continue
+		}
+
+		p.ruleStats.Inc(ruleKindMinTLSVersion, r.Wildcard)
+
+		offered := maxOfferedVersion(hello)
+		if offered >= r.Version {
+			return false
+		}
+
+		action := "legacy TLS"
+		if p.minTLSVersionMode == PolicyModeBlock {
+			action = "blocked legacy TLS"
+		}
+
+		log.Info(
+			"sniproxy: [%d] %s connection from %s to %s: offered %s, minimum is %s by rule %s",
+			ctx.ID,
+			action,
+			ctx.ClientAddr,
+			ctx.RemoteHost,
+			tlsVersionName(offered),
+			tlsVersionName(r.Version),
+			r.Wildcard,
+		)
+
+		if p.minTLSVersionMode != PolicyModeBlock {
+			return false
+		}
+
+		p.audit(ctx, audit.ActionBlock, r.Wildcard, "legacy tls")
+
+		return true
+	}
+
+	return false
+}
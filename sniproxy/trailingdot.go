@@ -0,0 +1,173 @@
+package sniproxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+)
+
+// Constants of the ClientHello format, see RFC 8446, Section 4.1.2.
+const (
+	recordHeaderLen      = 5
+	handshakeHeaderLen   = 4
+	handshakeClientHello = 1
+	extensionServerName  = 0
+	serverNameTypeHost   = 0
+
+	// clientHelloFixedLen is the length of the legacy version and the random.
+	clientHelloFixedLen = 2 + 32
+)
+
+// readTrailingDotClientHello parses the ClientHello from data, the TLS records
+// that crypto/tls failed to parse with parseErr, if the failure is caused by
+// a trailing dot in the SNI.  crypto/tls rejects such ClientHello as RFC 6066
+// forbids it, but some clients send the fully-qualified hostname anyway.  The
+// dot is replaced in a copy of data so that the rest of the ClientHello is
+// parsed by crypto/tls as usual, the returned ServerName has no trailing dot.
+// If the SNI has no trailing dot, parseErr is returned.
+func readTrailingDotClientHello(
+	data []byte,
+	parseErr error,
+) (hello *tls.ClientHelloInfo, err error) {
+	off, ok := trailingDotOffset(data)
+	if !ok {
+		return nil, parseErr
+	}
+
+	patched := bytes.Clone(data)
+	patched[off] = 'x'
+
+	hello, err = readClientHello(bytes.NewReader(patched))
+	if err != nil {
+		return nil, parseErr
+	}
+
+	hello.ServerName = hello.ServerName[:len(hello.ServerName)-1]
+
+	return hello, nil
+}
+
+// trailingDotOffset returns the offset within data of the trailing dot of the
+// SNI in the ClientHello.  data are the TLS records with the ClientHello
+// which may be split across several of them.  ok is false if the SNI doesn't
+// end with a dot or the ClientHello cannot be parsed.
+func trailingDotOffset(data []byte) (off int, ok bool) {
+	// fragment is a part of the handshake message in a single record.
+	type fragment struct {
+		// msgOff is the offset of the fragment within the message.
+		msgOff int
+
+		// dataOff is the offset of the fragment within data.
+		dataOff int
+	}
+
+	var msg []byte
+	var fragments []fragment
+	for pos := 0; pos+recordHeaderLen <= len(data) && data[pos] == recordTypeHandshake; {
+		end := pos + recordHeaderLen + int(binary.BigEndian.Uint16(data[pos+3:]))
+		if end > len(data) {
+			end = len(data)
+		}
+
+		fragments = append(fragments, fragment{msgOff: len(msg), dataOff: pos + recordHeaderLen})
+		msg = append(msg, data[pos+recordHeaderLen:end]...)
+		pos = end
+	}
+
+	i, ok := sniEnd(msg)
+	if !ok || msg[i] != '.' {
+		return 0, false
+	}
+
+	for j := len(fragments) - 1; j >= 0; j-- {
+		if f := fragments[j]; i >= f.msgOff {
+			return f.dataOff + i - f.msgOff, true
+		}
+	}
+
+	return 0, false
+}
+
+// sniEnd returns the index of the last byte of the first hostname in the SNI
+// extension of the ClientHello handshake message msg.
+func sniEnd(msg []byte) (i int, ok bool) {
+	if len(msg) < handshakeHeaderLen || msg[0] != handshakeClientHello {
+		return 0, false
+	}
+
+	s := &byteReader{b: msg, pos: handshakeHeaderLen}
+	s.skip(clientHelloFixedLen)
+	s.skip(s.uint8())
+	s.skip(s.uint16())
+	s.skip(s.uint8())
+
+	extEnd := s.uint16() + s.pos
+	for !s.failed && s.pos < extEnd {
+		typ, length := s.uint16(), s.uint16()
+		if typ != extensionServerName {
+			s.skip(length)
+
+			continue
+		}
+
+		listEnd := s.uint16() + s.pos
+		for !s.failed && s.pos < listEnd {
+			nameType, nameLen := s.uint8(), s.uint16()
+			if nameType == serverNameTypeHost && nameLen > 1 {
+				s.skip(nameLen)
+
+				return s.pos - 1, !s.failed
+			}
+
+			s.skip(nameLen)
+		}
+
+		return 0, false
+	}
+
+	return 0, false
+}
+
+// byteReader reads big-endian integers from a byte slice.  Once a read is out
+// of bounds, failed is true and all the following reads return zero.
+type byteReader struct {
+	b      []byte
+	pos    int
+	failed bool
+}
+
+// read returns the next n bytes.
+func (r *byteReader) read(n int) (b []byte) {
+	if r.pos < 0 || r.pos+n > len(r.b) {
+		r.pos = len(r.b)
+		r.failed = true
+
+		return nil
+	}
+
+	b = r.b[r.pos : r.pos+n]
+	r.pos += n
+
+	return b
+}
+
+// skip skips the next n bytes.
+func (r *byteReader) skip(n int) { r.read(n) }
+
+// uint8 reads a single byte.
+func (r *byteReader) uint8() (v int) {
+	if b := r.read(1); b != nil {
+		return int(b[0])
+	}
+
+	return 0
+}
+
+// uint16 reads a big-endian 16-bit integer.
+func (r *byteReader) uint16() (v int) {
+	if b := r.read(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+
+	return 0
+}
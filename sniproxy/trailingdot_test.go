@@ -0,0 +1,144 @@
+package sniproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fqdnClientHello returns the TLS record with the ClientHello for
+// "example.org." since crypto/tls never sends the trailing dot itself.
+func fqdnClientHello(t *testing.T) (b []byte) {
+	t.Helper()
+
+	hello := clientHello(t, "example.orgx")
+
+	return bytes.Replace(hello, []byte("example.orgx"), []byte("example.org."), 1)
+}
+
+// splitRecord splits the TLS record rec into two records, the first one with
+// n bytes of the payload.
+func splitRecord(rec []byte, n int) (b []byte) {
+	hdr, body := rec[:recordHeaderLen], rec[recordHeaderLen:]
+
+	first := append(bytes.Clone(hdr), body[:n]...)
+	binary.BigEndian.PutUint16(first[3:], uint16(n))
+
+	second := append(bytes.Clone(hdr), body[n:]...)
+	binary.BigEndian.PutUint16(second[3:], uint16(len(body)-n))
+
+	return append(first, second...)
+}
+
+func TestPeekClientHello_trailingDot(t *testing.T) {
+	fqdn := fqdnClientHello(t)
+	dot := bytes.Index(fqdn, []byte("example.org.")) + len("example.org") - recordHeaderLen
+
+	testCases := []struct {
+		name string
+		data []byte
+		want string
+	}{{
+		name: "no_dot",
+		data: clientHello(t, "example.org"),
+		want: "example.org",
+	}, {
+		name: "dot",
+		data: fqdn,
+		want: "example.org",
+	}, {
+		name: "dot_in_second_record",
+		data: splitRecord(fqdn, dot),
+		want: "example.org",
+	}, {
+		name: "dot_in_first_record",
+		data: splitRecord(fqdn, dot+1),
+		want: "example.org",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hello, newReader, err := peekClientHello(bytes.NewReader(tc.data))
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, hello.ServerName)
+
+			// The data must be passed to the remote host unmodified.
+			data, err := io.ReadAll(newReader)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.data, data)
+		})
+	}
+}
+
+func TestReadTrailingDotClientHello_invalid(t *testing.T) {
+	parseErr := io.ErrUnexpectedEOF
+
+	fqdn := fqdnClientHello(t)
+
+	testCases := []struct {
+		name string
+		data []byte
+	}{{
+		name: "empty",
+		data: nil,
+	}, {
+		name: "no_dot",
+		data: clientHello(t, "example.org"),
+	}, {
+		name: "truncated",
+		data: fqdn[:len(fqdn)/2],
+	}, {
+		name: "not_handshake",
+		data: append([]byte{0x17}, fqdn[1:]...),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hello, err := readTrailingDotClientHello(tc.data, parseErr)
+			assert.Nil(t, hello)
+			assert.Same(t, parseErr, err)
+		})
+	}
+}
+
+func TestSplitServerName_trailingDot(t *testing.T) {
+	testCases := []struct {
+		name       string
+		serverName string
+		plainHTTP  bool
+		wantHost   string
+		wantPort   int
+	}{{
+		name:       "tls",
+		serverName: "example.org.",
+		plainHTTP:  false,
+		wantHost:   "example.org",
+		wantPort:   443,
+	}, {
+		name:       "http",
+		serverName: "example.org.",
+		plainHTTP:  true,
+		wantHost:   "example.org",
+		wantPort:   80,
+	}, {
+		name:       "with_port",
+		serverName: "example.org.:8080",
+		plainHTTP:  true,
+		wantHost:   "example.org",
+		wantPort:   8080,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			host, port := splitServerName(tc.serverName, tc.plainHTTP)
+			assert.Equal(t, tc.wantHost, host)
+			assert.Equal(t, tc.wantPort, port)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package sniproxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// redirectedDst returns the original destination of a connection that was
+// redirected to the proxy.  It returns an error if the original destination
+// cannot be read or if the connection was not redirected at all.
+func redirectedDst(conn net.Conn) (dst netip.AddrPort, err error) {
+	dst, err = originalDst(conn)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	// The connection was not redirected and was sent to the proxy directly,
+	// tunneling it to the original destination would create a loop.
+	if dst == addrPortFromNetAddr(conn.LocalAddr()) {
+		return netip.AddrPort{}, fmt.Errorf(
+			"sniproxy: connection to %s was not redirected, original destination is the proxy itself",
+			dst,
+		)
+	}
+
+	return dst, nil
+}
+
+// passthroughInfo is called when the first bytes of the connection could not be
+// parsed.  It returns the info that makes the proxy tunnel the connection as is
+// to its original destination.  parseErr is returned if the original
+// destination is not available.
+func passthroughInfo(conn net.Conn, parseErr error) (info *peekInfo, err error) {
+	dst, err := redirectedDst(conn)
+	if err != nil {
+		return nil, fmt.Errorf("%w (passthrough failed: %v)", parseErr, err)
+	}
+
+	log.Debug(
+		"sniproxy: passing through connection from %s to %s: %v",
+		conn.RemoteAddr(),
+		dst,
+		parseErr,
+	)
+
+	return &peekInfo{
+		serverName:  dst.String(),
+		originalDst: dst,
+	}, nil
+}
+
+// needsOriginalDst checks if the parsed server name is not enough to tunnel
+// the connection in the transparent mode, i.e. when it is empty or when it is
+// an IP address.
+func needsOriginalDst(info *peekInfo) (ok bool) {
+	if info.serverName == "" {
+		return true
+	}
+
+	host, err := netutil.SplitHost(info.serverName)
+	if err != nil {
+		host = strings.Trim(info.serverName, "[]")
+	}
+
+	_, err = netip.ParseAddr(host)
+
+	return err == nil
+}
+
+// useOriginalDst makes the connection be tunneled to its original
+// destination.
+func useOriginalDst(conn net.Conn, info *peekInfo) (err error) {
+	dst, err := redirectedDst(conn)
+	if err != nil {
+		return fmt.Errorf("cannot use original destination for server name %q: %w", info.serverName, err)
+	}
+
+	log.Debug(
+		"sniproxy: using original destination %s instead of server name %q",
+		dst,
+		info.serverName,
+	)
+
+	info.serverName = dst.String()
+	info.originalDst = dst
+
+	return nil
+}
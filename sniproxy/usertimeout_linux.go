@@ -0,0 +1,38 @@
+//go:build linux
+
+package sniproxy
+
+import (
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// userTimeoutSupported is true if TCP_USER_TIMEOUT is supported on this
+// platform.
+const userTimeoutSupported = true
+
+// userTimeoutControl returns a [net.Dialer.Control] and
+// [net.ListenConfig.Control] function that sets TCP_USER_TIMEOUT on the
+// socket.  The connections accepted by a listening socket inherit the option.
+func userTimeoutControl(
+	timeout time.Duration,
+) (control func(network, address string, c syscall.RawConn) (err error)) {
+	return func(_, _ string, c syscall.RawConn) (err error) {
+		var sockErr error
+		err = c.Control(func(fd uintptr) {
+			sockErr = unix.SetsockoptInt(
+				int(fd),
+				unix.IPPROTO_TCP,
+				unix.TCP_USER_TIMEOUT,
+				int(timeout.Milliseconds()),
+			)
+		})
+		if err != nil {
+			return err
+		}
+
+		return sockErr
+	}
+}
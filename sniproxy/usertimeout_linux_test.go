@@ -0,0 +1,73 @@
+//go:build linux
+
+package sniproxy
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// getUserTimeout returns TCP_USER_TIMEOUT of c in milliseconds.
+func getUserTimeout(t *testing.T, c syscall.Conn) (v int) {
+	t.Helper()
+
+	rc, err := c.SyscallConn()
+	require.NoError(t, err)
+
+	var optErr error
+	err = rc.Control(func(fd uintptr) {
+		v, optErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT)
+	})
+	require.NoError(t, err)
+	require.NoError(t, optErr)
+
+	return v
+}
+
+func TestSNIProxy_tcpUserTimeout(t *testing.T) {
+	testCases := []struct {
+		name    string
+		timeout time.Duration
+		want    int
+	}{{
+		name:    "default",
+		timeout: 0,
+		want:    0,
+	}, {
+		name:    "set",
+		timeout: 3 * time.Second,
+		want:    3000,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := startProxy(t, &Config{TCPUserTimeout: tc.timeout})
+
+			assert.Equal(t, tc.want, getUserTimeout(t, p.sniListener.(*net.TCPListener)))
+			assert.Equal(t, tc.want, getUserTimeout(t, p.plainListener.(*net.TCPListener)))
+
+			// The connections to the remote hosts have the option too.
+			backend := startBackend(t, func(conn net.Conn) { _ = conn.Close() })
+			conn, err := p.dialer.Dial("tcp", backend)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+
+			assert.Equal(t, tc.want, getUserTimeout(t, conn.(*net.TCPConn)))
+		})
+	}
+}
+
+func TestNew_tcpUserTimeout_negative(t *testing.T) {
+	_, err := New(&Config{
+		TLSListenAddr:  localAddr,
+		HTTPListenAddr: localAddr,
+		TCPUserTimeout: -time.Second,
+	})
+	assert.ErrorContains(t, err, "negative tcp user timeout")
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package sniproxy
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// userTimeoutSupported is true if TCP_USER_TIMEOUT is supported on this
+// platform.
+const userTimeoutSupported = false
+
+// userTimeoutControl returns a [net.Dialer.Control] and
+// [net.ListenConfig.Control] function that sets TCP_USER_TIMEOUT on the
+// socket.  It is only supported on Linux.
+func userTimeoutControl(
+	_ time.Duration,
+) (control func(network, address string, c syscall.RawConn) (err error)) {
+	return func(_, _ string, _ syscall.RawConn) (err error) {
+		return errors.New("sniproxy: tcp user timeout is only supported on linux")
+	}
+}
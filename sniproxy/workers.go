@@ -0,0 +1,112 @@
+package sniproxy
+
+import (
+	"net"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// acceptedConn is a client connection accepted by one of the listeners.
+type acceptedConn struct {
+	conn net.Conn
+
+	// label is the label of the listener that accepted the connection.
+	label string
+
+	// plainHTTP is true if the connection was accepted by the HTTP listener.
+	plainHTTP bool
+}
+
+// workerPool is a fixed set of goroutines that handle the accepted
+// connections.  It saves the goroutine creation under high connection churn.
+// As a worker is busy for the whole lifetime of a tunnel, the number of
+// workers also limits the number of simultaneous connections.
+type workerPool struct {
+	// conns is the queue of the accepted connections waiting for a free
+	// worker.  It is bounded by the number of workers, the connections that
+	// don't fit are refused so that the accept loops never wait.
+	conns chan acceptedConn
+
+	// done is closed when the proxy is shutting down.
+	done chan struct{}
+
+	// size is the number of workers.
+	size int
+}
+
+// newWorkerPool creates a new *workerPool with size workers.  It returns nil if
+// size is zero, i.e. a new goroutine is started for every connection.
+func newWorkerPool(size int) (wp *workerPool) {
+	if size <= 0 {
+		return nil
+	}
+
+	return &workerPool{
+		conns: make(chan acceptedConn, size),
+		done:  make(chan struct{}),
+		size:  size,
+	}
+}
+
+// startWorkers starts the workers of the pool if it is enabled.
+func (p *SNIProxy) startWorkers() {
+	if p.workers == nil {
+		return
+	}
+
+	for i := 0; i < p.workers.size; i++ {
+		go p.worker()
+	}
+}
+
+// stopWorkers makes the workers exit.  It must only be called once, after all
+// the handlers are finished, so that no connection is left in the queue.
+func (p *SNIProxy) stopWorkers() {
+	if p.workers != nil {
+		close(p.workers.done)
+	}
+}
+
+// worker handles the accepted connections until the proxy is shutting down.
+func (p *SNIProxy) worker() {
+	for {
+		select {
+		case c := <-p.workers.conns:
+			p.serve(c)
+		case <-p.workers.done:
+			return
+		}
+	}
+}
+
+// dispatch passes the accepted connection to a worker.  If the worker pool is
+// disabled, a new goroutine is started instead.  It never blocks the accept
+// loop: if all the workers are busy and the queue is full, the connection is
+// refused.
+func (p *SNIProxy) dispatch(c acceptedConn) {
+	if p.workers == nil {
+		go p.serve(c)
+
+		return
+	}
+
+	select {
+	case p.workers.conns <- c:
+	default:
+		log.Debug("sniproxy: refusing connection from %s as all workers are busy", c.conn.RemoteAddr())
+		p.totals.finished(OutcomeBusy)
+		log.OnCloserError(c.conn, log.DEBUG)
+		p.handlers.done(c.conn)
+	}
+}
+
+// serve handles the accepted connection and unregisters its handler.
+func (p *SNIProxy) serve(c acceptedConn) {
+	defer p.handlers.done(c.conn)
+
+	err := p.handleConnection(c.conn, c.plainHTTP, c.label)
+	if err != nil {
+		p.totals.finished(OutcomeError)
+		log.Debug("sniproxy: error handling connection: %v", err)
+	}
+}
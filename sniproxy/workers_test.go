@@ -0,0 +1,107 @@
+package sniproxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIProxy_dispatch_busy(t *testing.T) {
+	// echoBackend keeps the connections open until the client closes them.
+	echoBackend := func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = io.Copy(conn, conn)
+	}
+
+	p := startProxy(t, &Config{Workers: 1})
+	backend := startBackend(t, echoBackend)
+
+	// The only worker is busy with the tunnel.
+	_ = dialHTTP(t, p, backend)
+	require.Eventually(t, func() bool {
+		return len(p.conns.list()) == 1
+	}, testTimeout, 10*time.Millisecond)
+
+	// The next connection waits in the queue.
+	_ = dialHTTP(t, p, backend)
+	require.Eventually(t, func() bool {
+		return activeHandlers(p) == 2
+	}, testTimeout, 10*time.Millisecond)
+
+	// The queue is full, so the connection is refused right away.  It may be
+	// reset as the request is never read.
+	refused := dialHTTP(t, p, backend)
+	require.NoError(t, refused.SetReadDeadline(time.Now().Add(testTimeout)))
+	_, err := io.ReadAll(refused)
+	assert.False(t, isTimeout(err))
+
+	assert.Equal(t, 2, activeHandlers(p))
+	assert.Equal(t, uint64(1), p.Summary(0).Outcomes[OutcomeBusy])
+}
+
+// activeHandlers returns the number of the client connections that are being
+// handled or wait for a free worker.
+func activeHandlers(p *SNIProxy) (n int) {
+	p.handlers.mu.Lock()
+	defer p.handlers.mu.Unlock()
+
+	return len(p.handlers.conns)
+}
+
+func BenchmarkSNIProxy_acceptLoop(b *testing.B) {
+	// closeBackend answers the request and closes the connection.
+	closeBackend := func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		_, _ = conn.Read(make([]byte, 1024))
+		_, _ = io.WriteString(conn, "HTTP/1.1 204 No Content\r\n\r\n")
+	}
+
+	testCases := []struct {
+		name    string
+		workers int
+	}{{
+		name:    "goroutine_per_conn",
+		workers: 0,
+	}, {
+		name:    "pool",
+		workers: 256,
+	}}
+
+	for _, tc := range testCases {
+		b.Run(tc.name, func(b *testing.B) {
+			p := startProxy(b, &Config{Workers: tc.workers})
+			backend := startBackend(b, closeBackend)
+			req := fmt.Sprintf("GET / HTTP/1.1\r\nHost: %s\r\n\r\n", backend)
+			addr := p.plainListener.Addr().String()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					conn, err := net.Dial("tcp", addr)
+					if err != nil {
+						b.Error(err)
+
+						return
+					}
+
+					_, _ = io.WriteString(conn, req)
+					_, _ = io.Copy(io.Discard, conn)
+					_ = conn.Close()
+				}
+			})
+
+			b.StopTimer()
+			busy := p.Summary(0).Outcomes[OutcomeBusy]
+			b.ReportMetric(float64(busy)/float64(b.N), "busy/op")
+		})
+	}
+}
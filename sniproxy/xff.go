@@ -0,0 +1,72 @@
+package sniproxy
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// realClientAddr returns the address of the client with the IP replaced by
+// the real one from the headers of the request, see [SNIProxy.realClientIP].
+// The port is kept as is, since the headers don't have the client port.  ok
+// is false if the headers are not trusted or there is no valid address in
+// them.
+func (p *SNIProxy) realClientAddr(
+	peer netip.AddrPort,
+	h http.Header,
+) (addr netip.AddrPort, ok bool) {
+	ip, ok := p.realClientIP(peer.Addr(), h)
+	if !ok {
+		return peer, false
+	}
+
+	return netip.AddrPortFrom(ip, peer.Port()), true
+}
+
+// realClientIP returns the real IP address of the client from the
+// X-Forwarded-For or X-Real-IP headers of the request.  The headers are only
+// trusted if peer, the address the connection came from, belongs to one of
+// the trusted proxies.  In X-Forwarded-For, the rightmost address that is not
+// a trusted proxy is the client.  ok is false if the headers are not trusted
+// or there is no valid address in them.
+func (p *SNIProxy) realClientIP(peer netip.Addr, h http.Header) (ip netip.Addr, ok bool) {
+	if !p.trustedProxy(peer) {
+		return netip.Addr{}, false
+	}
+
+	if xff := h.Values("X-Forwarded-For"); len(xff) > 0 {
+		addrs := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip, err := netip.ParseAddr(strings.TrimSpace(addrs[i]))
+			if err != nil {
+				// The chain is broken, the addresses to the left of the
+				// invalid one cannot be trusted.
+				return netip.Addr{}, false
+			}
+
+			ip = ip.Unmap()
+			if i == 0 || !p.trustedProxy(ip) {
+				return ip, true
+			}
+		}
+	}
+
+	ip, err := netip.ParseAddr(strings.TrimSpace(h.Get("X-Real-IP")))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return ip.Unmap(), true
+}
+
+// trustedProxy checks if ip belongs to one of the trusted proxies.
+func (p *SNIProxy) trustedProxy(ip netip.Addr) (ok bool) {
+	ip = ip.Unmap()
+	for _, prefix := range p.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
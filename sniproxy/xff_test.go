@@ -0,0 +1,64 @@
+package sniproxy
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSNIProxy_realClientAddr(t *testing.T) {
+	p := &SNIProxy{
+		trustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}
+
+	trusted := netip.MustParseAddrPort("10.0.0.1:54321")
+	untrusted := netip.MustParseAddrPort("192.0.2.1:54321")
+
+	testCases := []struct {
+		header http.Header
+		peer   netip.AddrPort
+		want   netip.AddrPort
+		name   string
+		wantOK bool
+	}{{
+		header: http.Header{"X-Forwarded-For": {"203.0.113.1"}},
+		peer:   trusted,
+		want:   netip.MustParseAddrPort("203.0.113.1:54321"),
+		name:   "xff",
+		wantOK: true,
+	}, {
+		header: http.Header{"X-Forwarded-For": {"203.0.113.1, 10.0.0.2"}},
+		peer:   trusted,
+		want:   netip.MustParseAddrPort("203.0.113.1:54321"),
+		name:   "xff_chain",
+		wantOK: true,
+	}, {
+		header: http.Header{"X-Real-Ip": {"2001:db8::1"}},
+		peer:   trusted,
+		want:   netip.MustParseAddrPort("[2001:db8::1]:54321"),
+		name:   "real_ip",
+		wantOK: true,
+	}, {
+		header: http.Header{"X-Forwarded-For": {"203.0.113.1"}},
+		peer:   untrusted,
+		want:   untrusted,
+		name:   "untrusted_peer",
+		wantOK: false,
+	}, {
+		header: http.Header{"X-Forwarded-For": {"bad"}},
+		peer:   trusted,
+		want:   trusted,
+		name:   "invalid",
+		wantOK: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, ok := p.realClientAddr(tc.peer, tc.header)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.want, addr)
+		})
+	}
+}